@@ -0,0 +1,121 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrorAckTimeout = errors.New("Timed out waiting for acknowledgement")
+	ErrorNack       = errors.New("File was not acknowledged")
+)
+
+// An Ack is handed to the handler of an HTTPReceiver created with
+// NewHTTPAckReceiver, one for each File.  The handler resolves it with Ack
+// once the File has been durably handled, or with Nack to fail the POST.  The
+// Ack may be resolved after the handler has returned, such as after a
+// background fsync, and the reply to the POST is held until every Ack in the
+// POST has been resolved or the AckTimeout has passed.
+type Ack struct {
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func newAck() *Ack {
+	return &Ack{done: make(chan struct{})}
+}
+
+// Ack marks the File as successfully handled.
+func (a *Ack) Ack() {
+	a.once.Do(func() { close(a.done) })
+}
+
+// Nack marks the File as failed, the POST is replied to with a 406 unless the
+// error is a RejectError, in which case its StatusCode and Reason are used.
+func (a *Ack) Nack(err error) {
+	if err == nil {
+		err = ErrorNack
+	}
+	a.once.Do(func() {
+		a.err = err
+		close(a.done)
+	})
+}
+
+// Done returns a channel which is closed once the Ack has been resolved.
+func (a *Ack) Done() <-chan struct{} { return a.done }
+
+// Err returns the error given to Nack, or nil.
+func (a *Ack) Err() error {
+	select {
+	case <-a.done:
+		return a.err
+	default:
+		return nil
+	}
+}
+
+// NewHTTPAckReceiver interfaces with the built-in HTTP Handler and sends each
+// FlowFile to a handler along with an Ack.  The content of the File must be
+// read before the handler returns, but the Ack may be resolved later.  The
+// POST is replied to with a 200 only once all the Files have been acked.
+//
+//   ffReceiver := flowfile.NewHTTPAckReceiver(func(f *flowfile.File, ack *flowfile.Ack, r *http.Request) {
+//     if err := f.Save(dir); err != nil {
+//       ack.Nack(err)
+//       return
+//     }
+//     go func() { syncDir(dir); ack.Ack() }()
+//   })
+//   ffReceiver.AckTimeout = 30 * time.Second
+func NewHTTPAckReceiver(handler func(*File, *Ack, *http.Request)) *HTTPReceiver {
+	hr := &HTTPReceiver{Metrics: NewMetrics()}
+	hr.handler = func(s *Scanner, w http.ResponseWriter, r *http.Request) {
+		var acks []*Ack
+		for s.Scan() {
+			ack := newAck()
+			acks = append(acks, ack)
+			handler(s.File(), ack, r)
+			if ack.Err() != nil {
+				break // No need to continue, the POST has failed
+			}
+		}
+		if err := s.Err(); err != nil && err != io.EOF {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var timeout <-chan time.Time
+		if hr.AckTimeout > 0 {
+			t := hr.clock().NewTimer(hr.AckTimeout)
+			defer t.Stop()
+			timeout = t.C()
+		}
+		for _, ack := range acks {
+			select {
+			case <-ack.done:
+				if ack.err != nil {
+					var rej *RejectError
+					if !errors.As(ack.err, &rej) {
+						rej = &RejectError{StatusCode: http.StatusNotAcceptable, Reason: "nack", Err: ack.err}
+					}
+					s.err = rej
+					w.WriteHeader(rej.StatusCode)
+					return
+				}
+			case <-timeout:
+				s.err = &RejectError{StatusCode: http.StatusServiceUnavailable, Reason: "ack-timeout", Err: ErrorAckTimeout}
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return hr
+}
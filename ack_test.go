@@ -0,0 +1,88 @@
+package flowfile_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/flowfiletest"
+)
+
+func TestAckReceiver(t *testing.T) {
+	acks := make(chan *flowfile.Ack, 4)
+	rcv := flowfile.NewHTTPAckReceiver(func(f *flowfile.File, ack *flowfile.Ack, r *http.Request) {
+		io.Copy(io.Discard, f)
+		acks <- ack
+	})
+	clk := flowfiletest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	rcv.Clock = clk
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+
+	post := func() chan *http.Response {
+		done := make(chan *http.Response, 1)
+		go func() { done <- postRaw(t, ts.URL, stringFiles("abc", "def")...) }()
+		return done
+	}
+
+	// The reply is held until every File is acked
+	done := post()
+	first, second := <-acks, <-acks
+	first.Ack()
+	select {
+	case res := <-done:
+		t.Fatalf("replied with %d before the second ack", res.StatusCode)
+	case <-time.After(20 * time.Millisecond):
+	}
+	second.Ack()
+	if res := <-done; res.StatusCode != http.StatusOK {
+		t.Errorf("expecting a 200 once acked, got %d", res.StatusCode)
+	}
+
+	// A nack fails the POST, with the status of a RejectError if given
+	for _, tc := range []struct {
+		err    error
+		code   int
+		reason string
+	}{
+		{nil, http.StatusNotAcceptable, "nack"},
+		{errors.New("disk full"), http.StatusNotAcceptable, "nack"},
+		{&flowfile.RejectError{StatusCode: http.StatusConflict, Reason: "duplicate"}, http.StatusConflict, "duplicate"},
+	} {
+		done = post()
+		ack := <-acks
+		<-acks // Left unresolved
+		ack.Nack(tc.err)
+		if ack.Err() == nil {
+			t.Errorf("expecting the nack error kept")
+		}
+		expectReject(t, <-done, tc.code, tc.reason)
+	}
+
+	// The acks not given within the AckTimeout fail the POST
+	rcv.AckTimeout = time.Minute
+	done = post()
+	<-acks
+	<-acks
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	expectReject(t, <-done, http.StatusServiceUnavailable, "ack-timeout")
+}
+
+func TestAckReceiverStopsOnNack(t *testing.T) {
+	var calls int
+	rcv := flowfile.NewHTTPAckReceiver(func(f *flowfile.File, ack *flowfile.Ack, r *http.Request) {
+		calls++
+		ack.Nack(nil)
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+	expectReject(t, postRaw(t, ts.URL, stringFiles("abc", "def")...), http.StatusNotAcceptable, "nack")
+	if calls != 1 {
+		t.Errorf("expecting the handler called once, got %d", calls)
+	}
+}
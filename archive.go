@@ -0,0 +1,245 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An ArchiveWriter appends Files as FlowFile-v3 records into rolling archive
+// files, so a receiver can durably capture the raw traffic for replay or
+// audit.  Each archive is a stream of records which a Scanner reads as is,
+// with an index next to it, a JSON-lines file of where each File is and its
+// attributes, so a single File can be found and read back without scanning.
+//
+//   archive := flowfile.NewArchiveWriter("/data/archive")
+//   rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+//     e, err := archive.Append(f)
+//     if err != nil {
+//       return err
+//     }
+//     f, err = e.File() // Read the content back from the archive
+//     ...
+//   })
+//
+// An archive is rotated to a new one once it reaches the MaxSize or has been
+// open for the MaxAge, checked as each File is appended.
+type ArchiveWriter struct {
+	Dir    string
+	Prefix string // Start of the archive names, defaults to "flowfiles"
+
+	// Rotate once the archive reaches this size or has been open this long,
+	// zero for no limit.
+	MaxSize int64
+	MaxAge  time.Duration
+
+	// Sync the archive and index after each File, so the File is on disk once
+	// Append returns, otherwise they are synced as the archive is closed.
+	Sync bool
+
+	// Called with the name of each archive as it is closed, such as to hand
+	// it off for shipping.
+	OnRotate func(archive string)
+
+	// The source of time for the MaxAge and the archive names, defaults to
+	// the DefaultClock.
+	Clock Clock
+
+	mu     sync.Mutex
+	fh     *os.File
+	idx    *os.File
+	name   string
+	size   int64
+	opened time.Time
+	seq    int
+}
+
+// An ArchiveEntry is the index record of a File within an archive.
+type ArchiveEntry struct {
+	Archive  string     `json:"-"`       // Name of the archive holding the File
+	Offset   int64      `json:"offset"`  // Start of the record
+	Content  int64      `json:"content"` // Start of the content
+	Size     int64      `json:"size"`
+	UUID     string     `json:"uuid,omitempty"`
+	Attrs    Attributes `json:"attrs"`
+	Received time.Time  `json:"received"`
+}
+
+// The extensions of the archive and the index
+const (
+	ArchiveExtension      = ".flowfile"
+	ArchiveIndexExtension = ".index"
+)
+
+// Create a new ArchiveWriter placing the archives into the directory, which
+// rotates at 1GB or hourly.
+func NewArchiveWriter(dir string) *ArchiveWriter {
+	return &ArchiveWriter{
+		Dir:     dir,
+		MaxSize: 1 << 30,
+		MaxAge:  time.Hour,
+	}
+}
+
+// Append the File to the archive, consuming the content.  A File which fails
+// part way is cut back out so the archive stays whole.
+func (a *ArchiveWriter) Append(f *File) (*ArchiveEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.clock().Now()
+	if a.fh != nil && (a.MaxSize > 0 && a.size >= a.MaxSize ||
+		a.MaxAge > 0 && now.Sub(a.opened) >= a.MaxAge) {
+		if err := a.close(); err != nil {
+			return nil, err
+		}
+	}
+	if a.fh == nil {
+		if err := a.open(now); err != nil {
+			return nil, err
+		}
+	}
+
+	start := a.size
+	n, err := NewWriter(a.fh).Write(f)
+	if err != nil {
+		// Cut out the partial record
+		a.fh.Truncate(start)
+		a.fh.Seek(start, io.SeekStart)
+		return nil, err
+	}
+	a.size += n
+
+	e := &ArchiveEntry{
+		Archive:  a.name,
+		Offset:   start,
+		Content:  a.size - f.Size,
+		Size:     f.Size,
+		UUID:     f.Attrs.Get("uuid"),
+		Attrs:    f.Attrs.Clone(),
+		Received: now,
+	}
+	dat, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = a.idx.Write(append(dat, '\n')); err != nil {
+		return nil, err
+	}
+	if a.Sync {
+		if err = a.fh.Sync(); err == nil {
+			err = a.idx.Sync()
+		}
+	}
+	return e, err
+}
+
+// Rotate closes the current archive, the next File appended starts a new one.
+func (a *ArchiveWriter) Rotate() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.close()
+}
+
+// Close the current archive.
+func (a *ArchiveWriter) Close() error {
+	return a.Rotate()
+}
+
+func (a *ArchiveWriter) clock() Clock {
+	return clockOr(a.Clock)
+}
+
+func (a *ArchiveWriter) open(now time.Time) (err error) {
+	prefix := a.Prefix
+	if prefix == "" {
+		prefix = "flowfiles"
+	}
+	if err = os.MkdirAll(a.Dir, 0755); err != nil {
+		return
+	}
+	a.seq++
+	name := filepath.Join(a.Dir, fmt.Sprintf("%s-%s-%d%s",
+		prefix, now.UTC().Format("20060102T150405Z"), a.seq, ArchiveExtension))
+	if a.fh, err = os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); err != nil {
+		return
+	}
+	if a.idx, err = os.OpenFile(archiveIndex(name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); err != nil {
+		a.fh.Close()
+		a.fh = nil
+		os.Remove(name)
+		return
+	}
+	a.name, a.size, a.opened = name, 0, now
+	return
+}
+
+func (a *ArchiveWriter) close() error {
+	if a.fh == nil {
+		return nil
+	}
+	err := a.fh.Sync()
+	if cerr := a.fh.Close(); err == nil {
+		err = cerr
+	}
+	if serr := a.idx.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := a.idx.Close(); err == nil {
+		err = cerr
+	}
+	name := a.name
+	a.fh, a.idx, a.name = nil, nil, ""
+	if err == nil && a.OnRotate != nil {
+		a.OnRotate(name)
+	}
+	return err
+}
+
+func archiveIndex(archive string) string {
+	return strings.TrimSuffix(archive, ArchiveExtension) + ArchiveIndexExtension
+}
+
+// ReadArchiveIndex returns the entries of the Files within an archive, in the
+// order they were appended.
+func ReadArchiveIndex(archive string) (entries []*ArchiveEntry, err error) {
+	fh, err := os.Open(archiveIndex(archive))
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		e := &ArchiveEntry{Archive: archive}
+		if json.Unmarshal(scanner.Bytes(), e) != nil {
+			continue // A torn final line from a crash
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// File reads the File of the entry back from the archive.
+func (e *ArchiveEntry) File() (*File, error) {
+	fi, err := os.Stat(e.Archive)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < e.Content+e.Size {
+		return nil, ErrorShortRead
+	}
+	return &File{
+		filePath: e.Archive,
+		fileInfo: fi,
+		i:        e.Content,
+		n:        e.Size,
+		Size:     e.Size,
+		Attrs:    e.Attrs.Clone(),
+	}, nil
+}
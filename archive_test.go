@@ -0,0 +1,84 @@
+package flowfile_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/flowfiletest"
+)
+
+func TestArchiveWriter(t *testing.T) {
+	clk := flowfiletest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	a := flowfile.NewArchiveWriter(t.TempDir())
+	a.Clock = clk
+	a.MaxSize = 60
+	var rotated []string
+	a.OnRotate = func(name string) { rotated = append(rotated, name) }
+
+	ff := stringFiles("abc", "defgh")
+	if _, err := a.Append(ff[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// A File failing part way is cut back out
+	bad := flowfile.New(io.MultiReader(strings.NewReader("xy"), iotest.ErrReader(errors.New("broken"))), 5)
+	if _, err := a.Append(bad); err == nil {
+		t.Fatal("expecting the broken File to fail")
+	}
+	e, err := a.Append(ff[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Past the MaxSize, the next File starts a new archive
+	if _, err = a.Append(stringFiles("ij")[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) != 1 || rotated[0] != e.Archive {
+		t.Fatalf("expecting the first archive rotated, got %q", rotated)
+	}
+
+	// The archive is a stream of records
+	fh, err := os.Open(e.Archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	var got []string
+	for s := flowfile.NewScanner(fh); s.Scan(); {
+		dat, _ := io.ReadAll(s.File())
+		got = append(got, string(dat))
+	}
+	if strings.Join(got, ",") != "abc,defgh" {
+		t.Errorf("expecting the Files in the archive, got %q", got)
+	}
+
+	entries, err := flowfile.ReadArchiveIndex(e.Archive)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expecting 2 index entries, got %d %v", len(entries), err)
+	}
+	f, err := entries[1].File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dat, _ := io.ReadAll(f); string(dat) != "defgh" || f.Attrs.Get("filename") != "defgh.txt" {
+		t.Errorf("unexpected File from the index, %q %v", dat, f.Attrs)
+	}
+
+	// The MaxAge rotates too
+	a.MaxSize = 0
+	a.MaxAge = time.Hour
+	clk.Advance(time.Hour)
+	if _, err = a.Append(stringFiles("kl")[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Close(); err != nil || len(rotated) != 3 {
+		t.Errorf("expecting 3 archives, got %q %v", rotated, err)
+	}
+}
@@ -0,0 +1,349 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An Assembler writes segments of a File concurrently, each directly at its
+// offset, into a sparse output file which is preallocated to the original
+// size.  The ranges written are tracked per output file, and once all the
+// ranges have landed a final checksum pass is done over the whole file with
+// VerifyParent when the original checksum is known.
+//
+// Note: The progress is tracked in memory, so all the segments of a File must
+// be written through the same Assembler.
+type Assembler struct {
+	// Called once all the segments of an output file have been written, with
+	// the result of the final checksum pass.
+	OnComplete func(outputFile string, info *SegmentInfo, err error)
+
+	// When set, an output file which has not had a segment written for this
+	// long is given up on, its partial output is removed and OnExpire is
+	// called.  Stale output files are checked for on each write and on Expire.
+	Timeout  time.Duration
+	OnExpire func(outputFile string, info *SegmentInfo)
+
+	// Called when a segment arrives which was already written, such as from a
+	// retried POST.  The duplicate content is passed over.
+	OnDuplicate func(outputFile string, info *SegmentInfo)
+
+	// When set, the number of incomplete output files is recorded, along with
+	// the completed, expired and duplicate counts.
+	MetricsSink MetricsSink
+
+	// The source of time for the Timeout, defaults to the DefaultClock.
+	Clock Clock
+
+	mu    sync.Mutex
+	files map[assemblyKey]*assembly
+}
+
+type assemblyKey struct {
+	fs   any // see fsKey
+	name string
+}
+
+// The key a filesystem is known by.  A pointer is keyed by its address and a
+// comparable value by itself, as comparing a value holding a slice or map
+// panics.  Any other filesystem is keyed by its type alone, so such a
+// filesystem should be passed by pointer.
+func fsKey(fsys WritableFS) any {
+	v := reflect.ValueOf(fsys)
+	switch {
+	case !v.IsValid():
+		return nil
+	case v.Kind() == reflect.Pointer:
+		return fsPointer{v.Type(), v.Pointer()}
+	case v.Comparable():
+		return fsys
+	}
+	return v.Type()
+}
+
+type fsPointer struct {
+	t reflect.Type
+	p uintptr
+}
+
+// DefaultAssembler is used by Save for reassembling segments, set the
+// OnComplete to be notified when a segmented File has been fully saved and
+// verified.
+var DefaultAssembler = NewAssembler()
+
+type assembly struct {
+	size   int64
+	ranges []byteRange // sorted and merged
+
+	fsys             WritableFS
+	info             *SegmentInfo          // of the first segment
+	seen             map[int]bool          // fragment indexes written
+	writing          map[int]chan struct{} // fragment indexes being written, closed once done
+	started, updated time.Time
+}
+
+type byteRange struct{ start, end int64 }
+
+// Create a new Assembler for reassembling segmented Files.
+func NewAssembler() *Assembler {
+	return &Assembler{files: make(map[assemblyKey]*assembly)}
+}
+
+func (a *Assembler) clock() Clock {
+	return clockOr(a.Clock)
+}
+
+// WriteSegment writes the content of a segment into outputFile at the offset
+// given in its attributes.  The returned done is true for the write which
+// completed the output file, and err then includes the result of the final
+// checksum pass.  Segments may be written from many goroutines at once.
+func (a *Assembler) WriteSegment(f *File, outputFile string) (done bool, err error) {
+	return a.WriteSegmentFS(OSFS(""), f, outputFile)
+}
+
+// WriteSegmentFS is like WriteSegment with the output file within fsys.
+func (a *Assembler) WriteSegmentFS(fsys WritableFS, f *File, outputFile string) (done bool, err error) {
+	var seg *SegmentInfo
+	if seg, err = f.SegmentInfo(); err != nil {
+		return
+	}
+
+	a.Expire()
+
+	a.mu.Lock()
+	key := assemblyKey{fs: fsKey(fsys), name: outputFile}
+	var asm *assembly
+	for {
+		var ok bool
+		asm, ok = a.files[key]
+		if !ok || asm.info.Identifier != seg.Identifier {
+			// A new File, or a new transfer replacing the one in progress
+			now := a.clock().Now()
+			asm = &assembly{size: seg.OriginalSize, fsys: fsys, info: seg, seen: make(map[int]bool),
+				writing: make(map[int]chan struct{}), started: now}
+			a.files[key] = asm
+		}
+		ch, busy := asm.writing[seg.Index]
+		if !busy {
+			break
+		}
+		// Another copy of the segment is being written, wait for the outcome
+		a.mu.Unlock()
+		<-ch
+		a.mu.Lock()
+	}
+	asm.updated = a.clock().Now()
+	duplicate := asm.seen[seg.Index]
+	if !duplicate {
+		// Hold the segment until written, so a copy arriving in the mean time
+		// is not written too and the partial output is not expired
+		ch := make(chan struct{})
+		asm.writing[seg.Index] = ch
+		defer func() {
+			a.mu.Lock()
+			delete(asm.writing, seg.Index)
+			a.mu.Unlock()
+			close(ch)
+		}()
+	}
+	a.gauge()
+	a.mu.Unlock()
+
+	if duplicate {
+		sinkOrNop(a.MetricsSink).Counter("flowfiles_segments_duplicate_total", 1)
+		if a.OnDuplicate != nil {
+			a.OnDuplicate(outputFile, seg)
+		}
+		_, err = copyBuffer(io.Discard, f)
+		return
+	}
+
+	var fh WritableFile
+	if fh, err = fsys.OpenFile(outputFile, os.O_RDWR|os.O_CREATE, 0666); err != nil {
+		return
+	}
+	defer fh.Close()
+
+	// Preallocate as a sparse file of the original size
+	if stat, statErr := fh.Stat(); statErr != nil || stat.Size() != seg.OriginalSize {
+		if err = fh.Truncate(seg.OriginalSize); err != nil {
+			return
+		}
+	}
+
+	// Write out the segment contents at the offset
+	var n int64
+	var w io.Writer = &offsetWriter{w: fh, off: seg.Offset}
+	if holes := parseHoles(f.Attrs.Get("sparse.extents"), seg.OriginalSize); len(holes) > 0 {
+		w = &sparseWriter{w: fh, off: seg.Offset, holes: holes}
+	}
+	if n, err = copyBuffer(w, f); err != nil {
+		return
+	}
+	if n != f.Size {
+		err = ErrorShortRead
+		return
+	}
+	if f.cksumStatus == cksumInit {
+		if err = f.Verify(); err != nil {
+			return
+		}
+	}
+
+	a.mu.Lock()
+	asm.add(seg.Offset, seg.Offset+n)
+	asm.seen[seg.Index] = true
+	asm.updated = a.clock().Now()
+	done = asm.complete()
+	if done && a.files[key] == asm {
+		delete(a.files, key)
+	}
+	a.gauge()
+	a.mu.Unlock()
+
+	if done {
+		sinkOrNop(a.MetricsSink).Counter("flowfiles_assemblies_completed_total", 1)
+		if seg.OriginalChecksumType != "" {
+			err = f.verifyParent(fh, seg.OriginalSize)
+		}
+		if a.OnComplete != nil {
+			a.OnComplete(outputFile, seg, err)
+		}
+	}
+	return
+}
+
+// Progress returns the number of bytes written and the total size of an output
+// file which is still being assembled.
+func (a *Assembler) Progress(outputFile string) (written, size int64, ok bool) {
+	return a.ProgressFS(OSFS(""), outputFile)
+}
+
+// ProgressFS is like Progress with the output file within fsys.
+func (a *Assembler) ProgressFS(fsys WritableFS, outputFile string) (written, size int64, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	asm, ok := a.files[assemblyKey{fs: fsKey(fsys), name: outputFile}]
+	if !ok {
+		return
+	}
+	for _, r := range asm.ranges {
+		written += r.end - r.start
+	}
+	return written, asm.size, true
+}
+
+// An AssemblyStatus describes an output file which is still being assembled.
+type AssemblyStatus struct {
+	OutputFile string
+	Identifier string // fragment.identifier of the segments
+	Segments   int    // segments written so far
+	Count      int    // fragment.count
+	Written    int64  // bytes written so far
+	Size       int64  // size of the original File
+	Started    time.Time
+	Updated    time.Time // when a segment was last written
+}
+
+// Pending returns the status of the output files which are still being
+// assembled, oldest first.
+func (a *Assembler) Pending() (list []AssemblyStatus) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, asm := range a.files {
+		st := AssemblyStatus{
+			OutputFile: key.name,
+			Identifier: asm.info.Identifier,
+			Segments:   len(asm.seen),
+			Count:      asm.info.Count,
+			Size:       asm.size,
+			Started:    asm.started,
+			Updated:    asm.updated,
+		}
+		for _, r := range asm.ranges {
+			st.Written += r.end - r.start
+		}
+		list = append(list, st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Started.Before(list[j].Started) })
+	return
+}
+
+// Expire gives up on the output files which have not had a segment written
+// within the Timeout, removing the partial output and calling OnExpire.  This
+// is done on each write, and may also be called periodically so stalled
+// transfers are cleaned up when no new segments arrive.
+func (a *Assembler) Expire() {
+	if a.Timeout <= 0 {
+		return
+	}
+	type stale struct {
+		key  assemblyKey
+		fsys WritableFS
+		info *SegmentInfo
+	}
+	var expired []stale
+	now := a.clock().Now()
+	a.mu.Lock()
+	for key, asm := range a.files {
+		if len(asm.writing) == 0 && now.Sub(asm.updated) > a.Timeout {
+			expired = append(expired, stale{key, asm.fsys, asm.info})
+			delete(a.files, key)
+		}
+	}
+	if len(expired) > 0 {
+		a.gauge()
+	}
+	a.mu.Unlock()
+
+	for _, e := range expired {
+		e.fsys.Remove(e.key.name)
+		sinkOrNop(a.MetricsSink).Counter("flowfiles_assemblies_expired_total", 1)
+		if a.OnExpire != nil {
+			a.OnExpire(e.key.name, e.info)
+		}
+	}
+}
+
+// Record the number of incomplete output files, must hold the lock
+func (a *Assembler) gauge() {
+	sinkOrNop(a.MetricsSink).Gauge("flowfiles_assemblies_incomplete", float64(len(a.files)))
+}
+
+// Add a written range, merging it with any overlapping or adjacent ranges
+func (asm *assembly) add(start, end int64) {
+	ranges := append(asm.ranges, byteRange{start, end})
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.start <= last.end {
+			if r.end > last.end {
+				last.end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	asm.ranges = merged
+}
+
+func (asm *assembly) complete() bool {
+	return len(asm.ranges) == 1 && asm.ranges[0].start == 0 && asm.ranges[0].end >= asm.size
+}
+
+// offsetWriter turns a WriterAt into a Writer starting at an offset
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (n int, err error) {
+	n, err = o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return
+}
@@ -0,0 +1,261 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/flowfiletest"
+)
+
+// Segment the content of abc.txt into segments of size bytes
+func segments(t *testing.T, dat []byte, size int64) []*flowfile.File {
+	t.Helper()
+	f := flowfile.New(bytes.NewReader(dat), int64(len(dat)))
+	f.Attrs.Set("filename", "abc.txt")
+	f.Attrs.GenerateUUID()
+	f.AddChecksum("SHA256")
+	out, err := flowfile.SegmentBySize(f, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// A copy of the segment with the content given by a pipe, which blocks until
+// written to
+func pipedSegment(seg *flowfile.File, dat []byte) (*flowfile.File, *io.PipeWriter) {
+	pr, pw := io.Pipe()
+	f := flowfile.New(pr, seg.Size)
+	f.Attrs = seg.Attrs.Clone()
+	return f, pw
+}
+
+// A filesystem which cannot be compared, as it holds a slice
+type taggedFS struct {
+	*flowfile.MemFS
+	tags []string
+}
+
+func TestAssemblerUncomparableFS(t *testing.T) {
+	fsys := taggedFS{MemFS: flowfile.NewMemFS(), tags: []string{"a"}}
+	dat := []byte("abcdefghij")
+	a := flowfile.NewAssembler()
+	var done bool
+	for _, seg := range segments(t, dat, 4) {
+		var err error
+		if done, err = a.WriteSegmentFS(fsys, seg, "abc.txt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !done {
+		t.Fatal("expecting the last segment to complete the file")
+	}
+	if got, _ := fs.ReadFile(fsys, "abc.txt"); !bytes.Equal(got, dat) {
+		t.Errorf("expecting %q, got %q", dat, got)
+	}
+}
+
+func TestAssemblerConcurrentDuplicate(t *testing.T) {
+	fsys := flowfile.NewMemFS()
+	dat := []byte("abcdefghij")
+	segs := segments(t, dat, 4)
+
+	var mu sync.Mutex
+	var duplicates int
+	a := flowfile.NewAssembler()
+	a.OnDuplicate = func(string, *flowfile.SegmentInfo) {
+		mu.Lock()
+		duplicates++
+		mu.Unlock()
+	}
+
+	// The first copy holds up its write until the second has arrived
+	first, pw := pipedSegment(segs[0], dat[:4])
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if _, err := a.WriteSegmentFS(fsys, first, "abc.txt"); err != nil {
+			t.Error(err)
+		}
+	}()
+	for len(a.Pending()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second, pw2 := pipedSegment(segs[0], dat[:4])
+	go func() {
+		defer wg.Done()
+		if _, err := a.WriteSegmentFS(fsys, second, "abc.txt"); err != nil {
+			t.Error(err)
+		}
+	}()
+	go func() { pw2.Write(dat[:4]); pw2.Close() }() // Drained as a duplicate
+	time.Sleep(20 * time.Millisecond)
+	pw.Write(dat[:4])
+	pw.Close()
+	wg.Wait()
+
+	if duplicates != 1 {
+		t.Errorf("expecting one duplicate, got %d", duplicates)
+	}
+	for _, seg := range segs[1:] {
+		if _, err := a.WriteSegmentFS(fsys, seg, "abc.txt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := fs.ReadFile(fsys, "abc.txt"); !bytes.Equal(got, dat) {
+		t.Errorf("expecting %q, got %q", dat, got)
+	}
+}
+
+func TestAssemblerExpireDuringWrite(t *testing.T) {
+	clk := flowfiletest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	fsys := flowfile.NewMemFS()
+	dat := []byte("abcdefghij")
+	segs := segments(t, dat, 4)
+
+	var expired int
+	a := flowfile.NewAssembler()
+	a.Clock, a.Timeout = clk, time.Minute
+	a.OnExpire = func(string, *flowfile.SegmentInfo) { expired++ }
+
+	seg, pw := pipedSegment(segs[0], dat[:4])
+	errc := make(chan error)
+	go func() {
+		_, err := a.WriteSegmentFS(fsys, seg, "abc.txt")
+		errc <- err
+	}()
+	for len(a.Pending()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A slow segment is not expired while it is being written
+	clk.Advance(time.Hour)
+	a.Expire()
+	if expired != 0 || len(a.Pending()) != 1 {
+		t.Fatalf("expired while being written, %d pending", len(a.Pending()))
+	}
+	pw.Write(dat[:4])
+	pw.Close()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// Once idle past the Timeout, it is
+	clk.Advance(time.Hour)
+	a.Expire()
+	if expired != 1 || len(a.Pending()) != 0 {
+		t.Errorf("expecting the stalled file expired, %d pending", len(a.Pending()))
+	}
+	if _, err := fsys.Stat("abc.txt"); err == nil {
+		t.Errorf("expecting the partial output removed")
+	}
+}
+
+func TestAssemblerTracking(t *testing.T) {
+	fsys := flowfile.NewMemFS()
+	dat := []byte("abcdefghij")
+	segs := segments(t, dat, 4)
+
+	var duplicates, completed int
+	sink := flowfile.NewPrometheusSink()
+	a := flowfile.NewAssembler()
+	a.MetricsSink = sink
+	a.OnDuplicate = func(string, *flowfile.SegmentInfo) { duplicates++ }
+	a.OnComplete = func(_ string, _ *flowfile.SegmentInfo, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		completed++
+	}
+	write := func(seg *flowfile.File) bool {
+		t.Helper()
+		offset, _ := strconv.Atoi(seg.Attrs.Get("fragment.offset"))
+		f := flowfile.New(bytes.NewReader(dat[offset:][:seg.Size]), seg.Size)
+		f.Attrs = seg.Attrs.Clone()
+		done, err := a.WriteSegmentFS(fsys, f, "abc.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return done
+	}
+
+	// Out of order, with a duplicate
+	write(segs[2])
+	write(segs[0])
+	write(segs[0])
+	pending := a.Pending()
+	if len(pending) != 1 || pending[0].Segments != 2 || pending[0].Count != 3 || pending[0].Written != 6 || pending[0].Size != 10 {
+		t.Fatalf("unexpected pending assemblies, %+v", pending)
+	}
+	if written, size, ok := a.ProgressFS(fsys, "abc.txt"); !ok || written != 6 || size != 10 {
+		t.Errorf("unexpected progress %d of %d", written, size)
+	}
+
+	if !write(segs[1]) {
+		t.Fatal("expecting the last segment to complete the file")
+	}
+	if duplicates != 1 || completed != 1 || len(a.Pending()) != 0 {
+		t.Errorf("expecting one duplicate and one completed, got %d and %d", duplicates, completed)
+	}
+	if _, _, ok := a.ProgressFS(fsys, "abc.txt"); ok {
+		t.Errorf("expecting no progress once complete")
+	}
+	out := sink.String()
+	for _, line := range []string{
+		"flowfiles_segments_duplicate_total 1",
+		"flowfiles_assemblies_completed_total 1",
+		"flowfiles_assemblies_incomplete 0",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+}
+
+func TestAssemblerVerifyParent(t *testing.T) {
+	dat := []byte("abcdefghij")
+	for _, tc := range []struct {
+		name     string
+		checksum string
+		err      error
+	}{
+		{name: "verified"},
+		{name: "mismatch", checksum: "00", err: flowfile.ErrorChecksumMismatch},
+	} {
+		fsys := flowfile.NewMemFS()
+		var completed []error
+		a := flowfile.NewAssembler()
+		a.OnComplete = func(out string, info *flowfile.SegmentInfo, err error) {
+			if out != "abc.txt" || info.OriginalSize != int64(len(dat)) {
+				t.Errorf("%s: unexpected completion of %q, %+v", tc.name, out, info)
+			}
+			completed = append(completed, err)
+		}
+		segs := segments(t, dat, 4)
+		for i, seg := range segs {
+			if tc.checksum != "" {
+				seg.Attrs.Set("segment.original.checksum", tc.checksum)
+			}
+			done, err := a.WriteSegmentFS(fsys, seg, "abc.txt")
+			if last := i == len(segs)-1; done != last {
+				t.Fatalf("%s: segment %d done %v", tc.name, i, done)
+			} else if !last && (err != nil || len(completed) != 0) {
+				t.Fatalf("%s: segment %d verified ahead of the last, %v", tc.name, i, err)
+			} else if last && !errors.Is(err, tc.err) {
+				t.Errorf("%s: expecting %v, got %v", tc.name, tc.err, err)
+			}
+		}
+		if len(completed) != 1 || !errors.Is(completed[0], tc.err) {
+			t.Errorf("%s: expecting OnComplete once with %v, got %v", tc.name, tc.err, completed)
+		}
+	}
+}
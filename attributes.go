@@ -21,7 +21,12 @@ type Attribute struct {
 	Name, Value string
 }
 
-// A set of attributes in a FlowFile header
+// A set of attributes in a FlowFile header, kept in wire order.
+//
+// Set and Unset give a copy of the attributes its own storage when a name is
+// added or removed, but a value is changed in place, so Clone the attributes
+// before changing the values of a copy independently.  Lookups by name scan
+// the attributes, see AttributesBuilder for building up many at once.
 type Attributes []Attribute
 
 // Clone the attributes for ease of duplication
@@ -35,18 +40,29 @@ func (h Attributes) Clone() Attributes {
 	return Attributes(out)
 }
 
-// Returns the first attribute's value with specified name
+// Removes all the attributes with specified name, returning whether any were
+// found.
 func (h *Attributes) Unset(name string) (ok bool) {
-	out := []Attribute{}
-	for _, elm := range []Attribute(*h) {
-		if elm.Name == name {
-			ok = true
-		} else {
+	attrs := []Attribute(*h)
+	first := -1
+	for i := range attrs {
+		if attrs[i].Name == name {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return false // Nothing to remove, leave the storage untouched
+	}
+	out := make([]Attribute, first, len(attrs)-1)
+	copy(out, attrs[:first])
+	for _, elm := range attrs[first+1:] {
+		if elm.Name != name {
 			out = append(out, elm)
 		}
 	}
 	*h = Attributes(out)
-	return
+	return true
 }
 
 // Returns the first attribute's value with specified name
@@ -59,6 +75,30 @@ func (h *Attributes) Get(name string) string {
 	return ""
 }
 
+// Returns the values of all the attributes with specified name, in wire order.
+// Streams from systems which repeat attribute names keep all the values, as
+// ReadFrom and UnmarshalJSON preserve the repeats while Get and Set only act
+// on the first.
+func (h Attributes) GetAll(name string) (vals []string) {
+	for _, elm := range []Attribute(h) {
+		if elm.Name == name {
+			vals = append(vals, elm.Value)
+		}
+	}
+	return
+}
+
+// Returns the first attribute's value with specified name and whether it was
+// found, to tell apart a missing attribute from an empty value
+func (h Attributes) lookup(name string) (string, bool) {
+	for _, elm := range []Attribute(h) {
+		if elm.Name == name {
+			return elm.Value, true
+		}
+	}
+	return "", false
+}
+
 // Set a new UUID value for a FlowFile
 func (h *Attributes) GenerateUUID() string {
 	puuid := uuid.New().String()
@@ -73,6 +113,17 @@ func (h *Attributes) add(name, val string) {
 	*h = Attributes(attrs)
 }
 
+// Adds an attribute with the given value after any with the same name, so the
+// name may be repeated.  It returns the attributes for function stacking.
+func (h *Attributes) Add(name, val string) *Attributes {
+	if name == "filename" {
+		// Sanitize the filename to make sure malformed data is misused
+		_, val = path.Split(val)
+	}
+	h.add(name, val)
+	return h
+}
+
 // Sets the attribute with the given value, takes two inputs the first is the
 // attribute name and the second is the attribute value.  It returns the
 // attributes for function stacking.
@@ -88,6 +139,7 @@ func (h *Attributes) Set(name, val string) *Attributes {
 			return h
 		}
 	}
+	// Copy on append, so a copy of the attributes never sees the addition
 	out := make([]Attribute, len(attrs)+1)
 	copy(out, attrs)
 	out[len(attrs)] = Attribute{name, val}
@@ -120,56 +172,134 @@ const (
 
 var (
 	ErrorNoFlowFileHeader      = errors.New("No NiFiFF3 header found")
-	ErrorInvalidFlowFileHeader = errors.New("Invalid of incomplete FlowFile header")
+	ErrorInvalidFlowFileHeader = errors.New("Invalid of incomplete FlowFile header") // Deprecated: see ErrorTruncatedStream and ErrorShortAttribute
+
+	// Errors from parsing a stream which is malformed, as opposed to a failure
+	// of the transport, so a receiver can reply with a 400 rather than a 500.
+	ErrorBadMagic        = ErrorNoFlowFileHeader
+	ErrorTruncatedStream = errors.New("Truncated FlowFile stream")
+	ErrorShortAttribute  = errors.New("Short FlowFile attribute")
+	ErrorHeaderLimit     = errors.New("FlowFile header over limits")
+
+	// An attribute name or value, or the count of attributes, too large for
+	// the 16 bit length written by WriteTo, see MaxAttributeValueSize
+	ErrorAttributeSize = errors.New("FlowFile attribute too large")
 )
 
+// Limits on the FlowFile headers parsed by ReadFrom, so a hostile header
+// cannot run a receiver out of memory, zero disables the check.  A content
+// size too large for an int64 is always refused.
+var (
+	MaxAttributeCount       = 8192     // attributes per File
+	MaxHeaderSize     int64 = 16 << 20 // bytes of the magic, attributes and sizes
+	MaxContentSize    int64            // bytes of content per File
+)
+
+// Is the error from a malformed stream, rather than the transport
+func isMalformed(err error) bool {
+	return errors.Is(err, ErrorBadMagic) || errors.Is(err, ErrorTruncatedStream) ||
+		errors.Is(err, ErrorShortAttribute) || errors.Is(err, ErrorInvalidSidecar) ||
+		errors.Is(err, ErrorHeaderLimit)
+}
+
 // Parse the FlowFile attributes from binary Reader.
 func (h *Attributes) ReadFrom(in io.Reader) (err error) {
 	var new Attributes
 	{
 		hdr := make([]byte, 7)
-		if _, err = in.Read(hdr); err != nil {
-			if err == http.ErrBodyReadAfterClose || err == io.EOF {
+		if _, err = io.ReadFull(in, hdr); err != nil {
+			switch err {
+			case http.ErrBodyReadAfterClose, io.EOF:
 				return io.EOF
+			case io.ErrUnexpectedEOF:
+				return ErrorTruncatedStream
 			}
-			return ErrorInvalidFlowFileHeader
+			return
 		}
 		if string(hdr) == FlowFileEOF {
 			return io.EOF
 		} else if string(hdr) != FlowFile3Header {
-			return ErrorNoFlowFileHeader
+			return ErrorBadMagic
 		}
 	}
 
-	var attrCount, size uint16
-	if err = binary.Read(in, binary.BigEndian, &attrCount); err != nil {
-		return ErrorInvalidFlowFileHeader
+	// A length is a uint16, or 0xFFFF followed by a uint32 for the longer
+	// lengths, and is counted in the headerSize
+	var buf [4]byte
+	headerSize := int64(len(FlowFile3Header))
+	readSize := func(sentinel error) (int64, error) {
+		if _, err := io.ReadFull(in, buf[:2]); err != nil {
+			return 0, truncated(err, sentinel)
+		}
+		headerSize += 2
+		if size := binary.BigEndian.Uint16(buf[:2]); size < 0xFFFF {
+			return int64(size), nil
+		}
+		if _, err := io.ReadFull(in, buf[:]); err != nil {
+			return 0, truncated(err, sentinel)
+		}
+		headerSize += 4
+		return int64(binary.BigEndian.Uint32(buf[:])), nil
 	}
-	for i := uint16(0); i < attrCount; i++ {
-		if err = binary.Read(in, binary.BigEndian, &size); err != nil {
-			return ErrorInvalidFlowFileHeader
+
+	attrCount, err := readSize(ErrorTruncatedStream)
+	if err != nil {
+		return err
+	}
+	if MaxAttributeCount > 0 && attrCount > int64(MaxAttributeCount) {
+		return fmt.Errorf("%w: %d attributes", ErrorHeaderLimit, attrCount)
+	}
+	readString := func() (string, error) {
+		size, err := readSize(ErrorShortAttribute)
+		if err != nil {
+			return "", err
 		}
-		attrName := make([]byte, size)
-		if _, err = in.Read(attrName); err != nil {
-			return ErrorInvalidFlowFileHeader
+		if headerSize += size; MaxHeaderSize > 0 && headerSize > MaxHeaderSize {
+			return "", fmt.Errorf("%w: over %d bytes", ErrorHeaderLimit, MaxHeaderSize)
 		}
-		if err = binary.Read(in, binary.BigEndian, &size); err != nil {
-			return ErrorInvalidFlowFileHeader
+		b := make([]byte, size)
+		if _, err = io.ReadFull(in, b); err != nil {
+			return "", truncated(err, ErrorShortAttribute)
 		}
-		attrValue := make([]byte, size)
-		if _, err = in.Read(attrValue); err != nil {
-			return ErrorInvalidFlowFileHeader
+		return string(b), nil
+	}
+	if attrCount > 0 {
+		// An extended count is only trusted as far as the attributes are read
+		capacity := attrCount
+		if capacity > 0xFFFF {
+			capacity = 0xFFFF
+		}
+		new = make(Attributes, 0, capacity)
+	}
+	for i := int64(0); i < attrCount; i++ {
+		var name, value string
+		if name, err = readString(); err != nil {
+			return
+		}
+		if value, err = readString(); err != nil {
+			return
 		}
-		new = append(new, Attribute{string(attrName), string(attrValue)})
+		new = append(new, Attribute{name, value})
 	}
 	*h = new
 	return nil
 }
 
+// Map an end of stream to the sentinel, other errors are from the transport
+// and are passed through.
+func truncated(err, sentinel error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return sentinel
+	}
+	return err
+}
+
 // Parse the FlowFile attributes into binary slice.
 func (h Attributes) MarshalBinary() ([]byte, error) {
 	buf := bytes.NewBuffer([]byte{})
-	h.WriteTo(buf)
+	if err := h.WriteTo(buf); err != nil {
+		return nil, err
+	}
 	return buf.Bytes(), nil
 }
 
@@ -252,13 +382,24 @@ func (h *Attributes) Sort() {
 	*h = attrs
 }
 
-// Parse the FlowFile attributes into binary writer.
+// Parse the FlowFile attributes into binary writer.  A length of 0xFFFF
+// marks the extended 32 bit length, which is not written, so a longer name or
+// value is refused with ErrorAttributeSize rather than truncated.
 func (h *Attributes) WriteTo(out io.Writer) (err error) {
+	attrs := []Attribute(*h)
+	if len(attrs) >= 0xFFFF {
+		return fmt.Errorf("%w: %d attributes", ErrorAttributeSize, len(attrs))
+	}
+	for _, a := range attrs {
+		if len(a.Name) >= 0xFFFF || len(a.Value) >= 0xFFFF {
+			return fmt.Errorf("%w: %d byte name and %d byte value", ErrorAttributeSize, len(a.Name), len(a.Value))
+		}
+	}
+
 	if _, err = out.Write([]byte("NiFiFF3")); err != nil {
 		return fmt.Errorf("Error writing NiFiFF3 header: %s", err)
 	}
 	var (
-		attrs     = []Attribute(*h)
 		attrCount = uint16(len(attrs))
 		size      uint16
 	)
@@ -270,7 +411,7 @@ func (h *Attributes) WriteTo(out io.Writer) (err error) {
 		if err = binary.Write(out, binary.BigEndian, size); err != nil {
 			return fmt.Errorf("Error writing attrName size: %s", err)
 		}
-		if _, err = out.Write([]byte(attrs[i].Name[:size])); err != nil {
+		if _, err = out.Write([]byte(attrs[i].Name)); err != nil {
 			return fmt.Errorf("Error writing attrName: %s", err)
 		}
 
@@ -278,7 +419,7 @@ func (h *Attributes) WriteTo(out io.Writer) (err error) {
 		if err = binary.Write(out, binary.BigEndian, size); err != nil {
 			return fmt.Errorf("Error writing attrValue size: %s", err)
 		}
-		if _, err = out.Write([]byte(attrs[i].Value[:size])); err != nil {
+		if _, err = out.Write([]byte(attrs[i].Value)); err != nil {
 			return fmt.Errorf("Error writing attrValue: %s", err)
 		}
 	}
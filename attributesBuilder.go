@@ -0,0 +1,66 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import "path"
+
+// An AttributesBuilder builds up a set of Attributes with constant time
+// lookups by name, for relays setting many attributes on each File.  The wire
+// order is the order the names were first set.
+//
+//   b := flowfile.NewAttributesBuilder(len(f.Attrs) + 8)
+//   b.AddAll(f.Attrs)
+//   b.Set("filename", name).Set("path", dir)
+//   f.Attrs = b.Attributes()
+type AttributesBuilder struct {
+	attrs Attributes
+	index map[string]int
+}
+
+// Create an AttributesBuilder with room for size attributes.
+func NewAttributesBuilder(size int) *AttributesBuilder {
+	return &AttributesBuilder{
+		attrs: make(Attributes, 0, size),
+		index: make(map[string]int, size),
+	}
+}
+
+// Set the attribute with the given value, as with Attributes.Set.
+func (b *AttributesBuilder) Set(name, val string) *AttributesBuilder {
+	if name == "filename" {
+		// Sanitize the filename to make sure malformed data is misused
+		_, val = path.Split(val)
+	}
+	if i, ok := b.index[name]; ok {
+		b.attrs[i].Value = val
+		return b
+	}
+	b.index[name] = len(b.attrs)
+	b.attrs = append(b.attrs, Attribute{name, val})
+	return b
+}
+
+// AddAll sets each of the given attributes in order.
+func (b *AttributesBuilder) AddAll(h Attributes) *AttributesBuilder {
+	for _, kv := range h {
+		b.Set(kv.Name, kv.Value)
+	}
+	return b
+}
+
+// Get the value of the attribute with specified name.
+func (b *AttributesBuilder) Get(name string) string {
+	if i, ok := b.index[name]; ok {
+		return b.attrs[i].Value
+	}
+	return ""
+}
+
+// Len returns the number of attributes set.
+func (b *AttributesBuilder) Len() int { return len(b.attrs) }
+
+// Attributes returns the attributes built and resets the builder, so the
+// result can be used without being changed by further calls.
+func (b *AttributesBuilder) Attributes() Attributes {
+	out := b.attrs
+	b.attrs, b.index = nil, make(map[string]int)
+	return out
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"testing"
+	"time"
 
 	"github.com/pschou/go-flowfile"
 )
@@ -151,3 +153,145 @@ func ExampleAttributes_UnmarshalBinary() {
 	// Output:
 	// attributes: {"path":"./","filename":"abcd-efgh"}
 }
+
+// Validate attributes against a metadata contract given as a JSON Schema.
+func ExampleParseAttributeSchema() {
+	schema, err := flowfile.ParseAttributeSchema([]byte(`{
+		"required": ["filename", "classification"],
+		"properties": {
+			"classification": {"enum": ["public", "internal"]},
+			"priority": {"type": "integer", "minimum": 1, "maximum": 5}
+		}
+	}`))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var a flowfile.Attributes
+	a.Set("filename", "abc.txt")
+	a.Set("priority", "9")
+	for _, v := range schema.Validate(a) {
+		fmt.Println(v.Attribute, v.Keyword, v.Message)
+	}
+	// Output:
+	// classification required is required
+	// priority maximum is greater than 5
+}
+
+// Read back the hops of the custody chain for auditing.
+func ExampleParseCustodyChain() {
+	var a flowfile.Attributes
+	a.Set("custodyChain.1.time", "2023-02-21T10:00:00Z")
+	a.Set("custodyChain.1.source.host", "10.0.0.1")
+	a.Set("custodyChain.0.time", "2023-02-21T10:00:05Z")
+	a.Set("custodyChain.0.protocol", "HTTPS")
+
+	for _, e := range flowfile.ParseCustodyChain(a) {
+		fmt.Printf("%d %s %q %q\n", e.Hop, e.Time.Format(time.RFC3339), e.Protocol, e.SourceHost)
+	}
+	// Output:
+	// 0 2023-02-21T10:00:05Z "HTTPS" ""
+	// 1 2023-02-21T10:00:00Z "" "10.0.0.1"
+}
+
+// This show how to build up many attributes with the AttributesBuilder
+func ExampleNewAttributesBuilder() {
+	var a flowfile.Attributes
+	a.Set("path", "./")
+	a.Set("filename", "abcd-efgh")
+
+	b := flowfile.NewAttributesBuilder(len(a) + 2)
+	b.AddAll(a)
+	b.Set("filename", "dir/new-name").Set("mime.type", "text/plain")
+	fmt.Println("filename:", b.Get("filename"))
+	fmt.Println("attributes:", b.Attributes())
+	// Output:
+	// filename: new-name
+	// attributes: {"path":"./","filename":"new-name","mime.type":"text/plain"}
+}
+
+// This show how a repeated attribute name keeps all its values
+func ExampleAttributes_GetAll() {
+	var a flowfile.Attributes
+	a.Add("tag", "red").Add("tag", "blue")
+	a.Set("tag", "green") // Set changes the first
+
+	wire, _ := a.MarshalBinary()
+	var b flowfile.Attributes
+	b.UnmarshalBinary(wire)
+	fmt.Println("tags:", b.GetAll("tag"))
+	// Output:
+	// tags: [green blue]
+}
+
+func TestAttributesSetCopy(t *testing.T) {
+	a := make(flowfile.Attributes, 0, 8) // Room to append in place
+	a.Set("shared", "1")
+	b := a
+	b.Set("only-b", "x")
+	a.Set("only-a", "y")
+	if got := b.Get("only-a"); got != "" {
+		t.Errorf("copy sees an attribute set on the original: only-a=%q", got)
+	}
+	if got := b.Get("only-b"); got != "x" {
+		t.Errorf("copy lost its own attribute: only-b=%q", got)
+	}
+	if got := a.Get("only-b"); got != "" {
+		t.Errorf("original sees an attribute set on the copy: only-b=%q", got)
+	}
+
+	c := a
+	c.Unset("shared")
+	if got := a.Get("shared"); got != "1" {
+		t.Errorf("original lost an attribute unset on the copy: shared=%q", got)
+	}
+}
+
+// Names of a File passing through a relay with many attributes
+func benchmarkNames() []string {
+	names := make([]string, 40)
+	for i := range names {
+		names[i] = fmt.Sprintf("custodyChain.%d.time", i)
+	}
+	return names
+}
+
+func BenchmarkAttributesSet(b *testing.B) {
+	names := benchmarkNames()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var a flowfile.Attributes
+		for _, name := range names {
+			a.Set(name, "value")
+		}
+	}
+}
+
+func BenchmarkAttributesGet(b *testing.B) {
+	names := benchmarkNames()
+	var a flowfile.Attributes
+	for _, name := range names {
+		a.Set(name, "value")
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, name := range names {
+			if a.Get(name) == "" {
+				b.Fatal("missing", name)
+			}
+		}
+	}
+}
+
+func BenchmarkAttributesBuilderSet(b *testing.B) {
+	names := benchmarkNames()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ab := flowfile.NewAttributesBuilder(len(names))
+		for _, name := range names {
+			ab.Set(name, "value")
+		}
+		_ = ab.Attributes()
+	}
+}
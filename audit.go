@@ -0,0 +1,42 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"net/http"
+	"time"
+)
+
+// A TransferRecord summarizes one POST handled by an HTTPReceiver, as given to
+// the OnTransfer hook for writing audit or access logs.
+type TransferRecord struct {
+	Start         time.Time
+	Duration      time.Duration
+	RemoteAddr    string // as seen on the connection, see CustodyChainAddHTTP for proxies
+	UserDN        string // subject of the client certificate, if any
+	IssuerDN      string
+	RequestURI    string
+	UserAgent     string
+	TransactionID string // x-nifi-transaction-id of the sender
+
+	Files      int    // Files seen in the POST
+	Bytes      int64  // content bytes of the Files seen
+	StatusCode int    // status code replied with
+	Reason     string // reject reason, when a File was refused
+	Err        error  // error reading the stream, if any
+}
+
+// Fill in the request details of a TransferRecord
+func newTransferRecord(r *http.Request, start time.Time) *TransferRecord {
+	rec := &TransferRecord{
+		Start:         start,
+		RemoteAddr:    r.RemoteAddr,
+		RequestURI:    r.RequestURI,
+		UserAgent:     r.UserAgent(),
+		TransactionID: r.Header.Get("x-nifi-transaction-id"),
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		cert := r.TLS.PeerCertificates[0]
+		rec.UserDN = certPKIXString(cert.Subject, ",")
+		rec.IssuerDN = certPKIXString(cert.Issuer, ",")
+	}
+	return rec
+}
@@ -0,0 +1,93 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Headers carrying the number of Files and content bytes of a batch POST, the
+// receiver echoes the same headers in the reply with what it actually parsed.
+const (
+	BatchCountHeader = "x-flowfile-batch-count"
+	BatchBytesHeader = "x-flowfile-batch-bytes"
+)
+
+// A BatchMismatchError is returned when the Files or bytes parsed from a
+// batch POST are not what the sender said it sent, such as from a truncated
+// or mangled stream which still happened to end on a File boundary.
+type BatchMismatchError struct {
+	Count, Bytes                 int64 // as sent
+	ReceivedCount, ReceivedBytes int64 // as parsed by the receiver
+}
+
+func (e *BatchMismatchError) Error() string {
+	return fmt.Sprintf("Batch mismatch, sent %d Files of %d bytes, received %d Files of %d bytes",
+		e.Count, e.Bytes, e.ReceivedCount, e.ReceivedBytes)
+}
+
+// The count and content bytes of a batch
+type batchCount struct {
+	count, bytes int64
+}
+
+func newBatchCount(ff []*File) *batchCount {
+	b := &batchCount{count: int64(len(ff))}
+	for _, f := range ff {
+		b.bytes += f.Size
+	}
+	return b
+}
+
+func (b *batchCount) writeHeader(hdr http.Header) {
+	hdr.Set(BatchCountHeader, strconv.FormatInt(b.count, 10))
+	hdr.Set(BatchBytesHeader, strconv.FormatInt(b.bytes, 10))
+}
+
+// Parse the batch headers, ok is false when they are missing or invalid
+func parseBatchCount(hdr http.Header) (b *batchCount, ok bool) {
+	count, err := strconv.ParseInt(hdr.Get(BatchCountHeader), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	bytes, err := strconv.ParseInt(hdr.Get(BatchBytesHeader), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &batchCount{count: count, bytes: bytes}, true
+}
+
+// Compare what was sent with what was received
+func (b *batchCount) verify(got *batchCount) error {
+	if *b == *got {
+		return nil
+	}
+	return &BatchMismatchError{Count: b.count, Bytes: b.bytes,
+		ReceivedCount: got.count, ReceivedBytes: got.bytes}
+}
+
+// Verify the echo in the reply to a batch POST.  A reply without the echo is
+// from a receiver which does not check batches, such as NiFi, and is taken
+// on the status code alone.
+func (b *batchCount) verifyReply(resp *http.Response) error {
+	if b == nil {
+		return nil
+	}
+	got, ok := parseBatchCount(resp.Header)
+	if !ok {
+		return nil
+	}
+	return b.verify(got)
+}
+
+// Echo what was parsed from a batch POST in the reply headers, refusing the
+// POST when it is not what was sent
+func (f *HTTPReceiver) checkBatch(want *batchCount, transfer *TransferRecord, hdr http.Header) error {
+	got := &batchCount{count: int64(transfer.Files), bytes: transfer.Bytes}
+	got.writeHeader(hdr)
+	if err := want.verify(got); err != nil {
+		f.debugln("Refusing batch:", err)
+		return &RejectError{StatusCode: http.StatusBadRequest, Reason: "batch-mismatch", Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+// Files holding each of the strings
+func stringFiles(dat ...string) (out []*flowfile.File) {
+	for _, s := range dat {
+		f := flowfile.New(strings.NewReader(s), int64(len(s)))
+		f.Attrs.Set("filename", s+".txt")
+		out = append(out, f)
+	}
+	return
+}
+
+func TestBatchSend(t *testing.T) {
+	var got []string
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		dat, err := io.ReadAll(f)
+		got = append(got, string(dat))
+		return err
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.Batch = true
+	if err = hs.Send(stringFiles("abc", "defgh")...); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "abc,defgh" {
+		t.Errorf("received %q", got)
+	}
+}
+
+func TestBatchReceiverMismatch(t *testing.T) {
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+
+	// Two Files sent, but three said to be
+	var body bytes.Buffer
+	for _, f := range stringFiles("abc", "defgh") {
+		if _, err := flowfile.NewWriter(&body).Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, _ := http.NewRequest("POST", ts.URL, &body)
+	req.Header.Set("Content-Type", "application/flowfile-v3")
+	req.Header.Set(flowfile.BatchCountHeader, "3")
+	req.Header.Set(flowfile.BatchBytesHeader, "8")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest || res.Header.Get("x-flowfile-reject-reason") != "batch-mismatch" {
+		t.Errorf("expecting a 400 batch-mismatch, got %d %q", res.StatusCode, res.Header.Get("x-flowfile-reject-reason"))
+	}
+	if c, b := res.Header.Get(flowfile.BatchCountHeader), res.Header.Get(flowfile.BatchBytesHeader); c != "2" || b != "8" {
+		t.Errorf("expecting the echo of 2 Files of 8 bytes, got %s and %s", c, b)
+	}
+}
+
+func TestBatchSenderMismatch(t *testing.T) {
+	// A receiver which lost a File without noticing
+	echo := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept", "application/flowfile-v3")
+		w.Header().Set("x-nifi-transfer-protocol-version", "3")
+		if r.Method == "POST" && echo {
+			io.Copy(io.Discard, r.Body)
+			w.Header().Set(flowfile.BatchCountHeader, "1")
+			w.Header().Set(flowfile.BatchBytesHeader, "3")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.Batch = true
+	err = hs.Send(stringFiles("abc", "defgh")...)
+	var mismatch *flowfile.BatchMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expecting a BatchMismatchError, got %v", err)
+	}
+	if mismatch.Count != 2 || mismatch.Bytes != 8 || mismatch.ReceivedCount != 1 || mismatch.ReceivedBytes != 3 {
+		t.Errorf("unexpected %v", mismatch)
+	}
+
+	// A receiver without the echo, such as NiFi, is taken on the status
+	echo = false
+	if err = hs.Send(stringFiles("abc", "defgh")...); err != nil {
+		t.Errorf("expecting a batch without the echo to pass, got %v", err)
+	}
+}
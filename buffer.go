@@ -5,8 +5,92 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 )
 
+// A BufferPool hands out the byte slices used when copying and checksumming
+// payloads.  High-throughput users can replace DefaultBufferPool to tune the
+// buffer size or memory behavior of the package.
+type BufferPool interface {
+	Get() []byte
+	Put([]byte)
+}
+
+// The BufferPool used by the checksum, copy and save paths.
+var DefaultBufferPool BufferPool = NewBufferPool(32 * 1024)
+
+// NewBufferPool creates a sync.Pool backed BufferPool handing out buffers of
+// size bytes.
+func NewBufferPool(size int) BufferPool {
+	p := &syncBufferPool{size: size}
+	p.pool.New = func() any {
+		b := make([]byte, size)
+		return &b
+	}
+	return p
+}
+
+type syncBufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func (p *syncBufferPool) Get() []byte {
+	return *(p.pool.Get().(*[]byte))
+}
+
+func (p *syncBufferPool) Put(b []byte) {
+	if cap(b) < p.size {
+		return
+	}
+	b = b[:p.size]
+	p.pool.Put(&b)
+}
+
+// NewSlabBufferPool creates a BufferPool from one contiguous slab of count
+// buffers of size bytes, so the buffers are never collected.  If all the
+// buffers are in use, Get allocates a new buffer which is dropped on Put.
+func NewSlabBufferPool(size, count int) BufferPool {
+	p := &slabBufferPool{size: size, free: make(chan []byte, count)}
+	slab := make([]byte, size*count)
+	for i := 0; i < count; i++ {
+		p.free <- slab[i*size : (i+1)*size : (i+1)*size]
+	}
+	return p
+}
+
+type slabBufferPool struct {
+	size int
+	free chan []byte
+}
+
+func (p *slabBufferPool) Get() []byte {
+	select {
+	case b := <-p.free:
+		return b
+	default:
+		return make([]byte, p.size)
+	}
+}
+
+func (p *slabBufferPool) Put(b []byte) {
+	if cap(b) < p.size {
+		return
+	}
+	select {
+	case p.free <- b[:p.size]:
+	default:
+	}
+}
+
+// Copy from src to dst using a buffer from the DefaultBufferPool.
+func copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	buf := DefaultBufferPool.Get()
+	defer DefaultBufferPool.Put(buf)
+	// Hide any ReaderFrom or WriterTo so the pooled buffer is used
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, buf)
+}
+
 // Read the entire payload into a buffer, so as to complete the checksum and
 // enable the ability to reset the File for multiple reads.
 //
@@ -0,0 +1,48 @@
+package flowfile_test
+
+import (
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestBufferPool(t *testing.T) {
+	p := flowfile.NewBufferPool(16)
+	b := p.Get()
+	if len(b) != 16 {
+		t.Fatalf("expecting a buffer of 16 bytes, got %d", len(b))
+	}
+	p.Put(b[:4]) // Put back at the full size
+	if b = p.Get(); len(b) != 16 {
+		t.Errorf("expecting a buffer of 16 bytes, got %d", len(b))
+	}
+
+	// The slab hands out its own buffers first, then allocates
+	p = flowfile.NewSlabBufferPool(8, 2)
+	a, b, c := p.Get(), p.Get(), p.Get()
+	if len(a) != 8 || len(b) != 8 || len(c) != 8 || cap(a) != 8 {
+		t.Fatalf("expecting buffers of 8 bytes, got %d %d %d", len(a), len(b), len(c))
+	}
+	p.Put(make([]byte, 4)) // Too small to be kept
+	p.Put(a)
+	p.Put(c)
+	p.Put(b) // Dropped, the slab is full
+	if got := p.Get(); &got[0] != &a[0] {
+		t.Errorf("expecting the returned buffer handed out again")
+	}
+	if got := p.Get(); &got[0] != &c[0] {
+		t.Errorf("expecting the allocated buffer kept in place of the dropped one")
+	}
+
+	// The save path copies with the DefaultBufferPool
+	f := checksummed(t, []byte("abc"), "")
+	pool := &countingPool{BufferPool: flowfile.NewBufferPool(1024)}
+	defer func(old flowfile.BufferPool) { flowfile.DefaultBufferPool = old }(flowfile.DefaultBufferPool)
+	flowfile.DefaultBufferPool = pool
+	if _, err := f.Save(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if pool.gets == 0 {
+		t.Errorf("expecting the content saved with a pooled buffer")
+	}
+}
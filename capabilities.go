@@ -0,0 +1,139 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Capabilities are the non-standard features an HTTPReceiver advertises in
+// the reply to the handshake, and which an HTTPTransaction parses out so the
+// sender can make use of them.  Each is sent in its own header, so a NiFi
+// endpoint which knows none of these is treated as supporting none.
+type Capabilities struct {
+	// Checksum types verified by the receiver, in order of preference.  When
+	// empty and the HTTPReceiver has VerifyChecksum set, ChecksumTypes are
+	// advertised.
+	Checksums []string // x-flowfile-checksums
+
+	// Content codings accepted for the POST body, such as gzip, in order of
+	// preference.  When empty, the HTTPReceiver advertises every registered
+	// Codec, see RegisterCodec.
+	Codecs []string // x-flowfile-codecs
+
+	// The most Files the receiver accepts in one POST, the sender rolls over
+	// to a new POST when reached.
+	MaxFilesPerPost int // x-flowfile-max-files-per-post
+
+	// The receiver accepts segments of a File being sent again to complete a
+	// partial transfer.
+	Resume bool // x-flowfile-resume
+
+	// The receiver serves block signatures of the content it holds, so Files
+	// may be sent as deltas against it.  Set by the HTTPReceiver when it has a
+	// DeltaBasis and DeltaSignatures set.
+	Delta bool // x-flowfile-delta
+
+	// The receiver is paused and replies to each POST with a 503 until it is
+	// resumed.  Set by the HTTPReceiver while paused, see HTTPReceiver.Pause.
+	Paused bool // x-flowfile-paused
+}
+
+// ChecksumTypes are the checksum types a receiver verifying checksums
+// advertises when none are configured, in order of preference.
+var ChecksumTypes = []string{"SHA256", "SHA512", "SHA384", "SHA224", "SHA1", "MD5"}
+
+// The capabilities advertised by the receiver, with the defaults filled in
+func (f *HTTPReceiver) capabilities() Capabilities {
+	c := f.Capabilities
+	if len(c.Checksums) == 0 && f.VerifyChecksum {
+		for _, cksum := range ChecksumTypes {
+			if getChecksumFunc(cksum) != nil {
+				c.Checksums = append(c.Checksums, cksum)
+			}
+		}
+	}
+	if len(c.Codecs) == 0 {
+		c.Codecs = CodecNames()
+	}
+	c.Delta = f.offersDelta()
+	_, c.Paused = f.Paused()
+	return c
+}
+
+// Pick the checksum type to send with, the current one is kept when the
+// receiver advertises it or advertises none, otherwise it is the first one
+// advertised which is supported here.
+func (c Capabilities) selectChecksum(current string) string {
+	if len(c.Checksums) == 0 || (current != "" && c.HasChecksum(current)) {
+		return current
+	}
+	for _, cksum := range c.Checksums {
+		if getChecksumFunc(cksum) != nil {
+			return cksum
+		}
+	}
+	return current
+}
+
+// Write the capabilities to the reply headers
+func (c Capabilities) writeHeader(hdr http.Header) {
+	if len(c.Checksums) > 0 {
+		hdr.Set("x-flowfile-checksums", strings.Join(c.Checksums, ","))
+	}
+	if len(c.Codecs) > 0 {
+		hdr.Set("x-flowfile-codecs", strings.Join(c.Codecs, ","))
+	}
+	if c.MaxFilesPerPost > 0 {
+		hdr.Set("x-flowfile-max-files-per-post", strconv.Itoa(c.MaxFilesPerPost))
+	}
+	if c.Resume {
+		hdr.Set("x-flowfile-resume", "true")
+	}
+	if c.Delta {
+		hdr.Set("x-flowfile-delta", "true")
+	}
+	if c.Paused {
+		hdr.Set("x-flowfile-paused", "true")
+	}
+}
+
+// Parse the capabilities from the handshake reply headers
+func parseCapabilities(hdr http.Header) (c Capabilities) {
+	c.Checksums = splitList(hdr.Get("x-flowfile-checksums"))
+	c.Codecs = splitList(hdr.Get("x-flowfile-codecs"))
+	c.MaxFilesPerPost, _ = strconv.Atoi(hdr.Get("x-flowfile-max-files-per-post"))
+	c.Resume, _ = strconv.ParseBool(hdr.Get("x-flowfile-resume"))
+	c.Delta, _ = strconv.ParseBool(hdr.Get("x-flowfile-delta"))
+	c.Paused, _ = strconv.ParseBool(hdr.Get("x-flowfile-paused"))
+	return
+}
+
+// HasChecksum returns whether the checksum type is in the Checksums.
+func (c Capabilities) HasChecksum(cksum string) bool {
+	return hasFold(c.Checksums, cksum)
+}
+
+// HasCodec returns whether the content coding is in the Codecs.
+func (c Capabilities) HasCodec(codec string) bool {
+	return hasFold(c.Codecs, codec)
+}
+
+func hasFold(list []string, v string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, strings.TrimSpace(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Split a comma separated header value, dropping the empty entries
+func splitList(v string) (out []string) {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return
+}
@@ -0,0 +1,137 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestCapabilities(t *testing.T) {
+	var posts int32
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	rcv.Capabilities = flowfile.Capabilities{MaxFilesPerPost: 2, Resume: true}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			atomic.AddInt32(&posts, 1)
+		}
+		rcv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := hs.Capabilities
+	if c.MaxFilesPerPost != 2 || !c.Resume || c.Delta || c.Paused || len(c.Checksums) != 0 {
+		t.Errorf("unexpected capabilities, %+v", c)
+	}
+	if fmt.Sprint(c.Codecs) != fmt.Sprint(flowfile.CodecNames()) || !c.HasCodec(" GZIP") {
+		t.Errorf("expecting the registered codecs advertised, got %v", c.Codecs)
+	}
+
+	// The sender rolls over to a new POST at the most Files per POST
+	if err = hs.Send(stringFiles("a", "b", "c")...); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&posts); n != 2 {
+		t.Errorf("expecting 3 Files sent in 2 POSTs, got %d", n)
+	}
+
+	// A NiFi endpoint advertises nothing
+	nifi := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept", "application/flowfile-v3")
+		w.Header().Set("x-nifi-transfer-protocol-version", "3")
+	}))
+	defer nifi.Close()
+	if hs, err = flowfile.NewHTTPTransaction(nifi.URL, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c := hs.Capabilities; c.MaxFilesPerPost != 0 || c.Resume || len(c.Codecs) != 0 {
+		t.Errorf("expecting no capabilities from NiFi, got %+v", c)
+	}
+}
+
+// A receiver advertising the capabilities, with a sender handshaking with the
+// checksum type and compression set
+func handshake(t *testing.T, c flowfile.Capabilities, verify bool, cksum string, compression ...string) *flowfile.HTTPTransaction {
+	t.Helper()
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	rcv.Capabilities, rcv.VerifyChecksum = c, verify
+	ts := httptest.NewServer(rcv)
+	t.Cleanup(ts.Close)
+	hs := flowfile.NewHTTPTransactionNoHandshake(ts.URL, nil)
+	hs.CheckSumType, hs.Compression = cksum, compression
+	if err := hs.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	return hs
+}
+
+func TestChecksumNegotiation(t *testing.T) {
+	for _, tc := range []struct {
+		advertised []string
+		verify     bool
+		current    string
+		want       string
+	}{
+		{[]string{"SHA512", "MD5"}, false, "SHA256", "SHA512"}, // Not advertised
+		{[]string{"SHA512", "MD5"}, false, "md5", "md5"},       // Advertised
+		{[]string{"UNKNOWN", "MD5"}, false, "", "MD5"},         // Not supported here
+		{nil, false, "SHA1", "SHA1"},                           // None advertised
+		{nil, true, "", "SHA256"},                              // The ChecksumTypes
+	} {
+		hs := handshake(t, flowfile.Capabilities{Checksums: tc.advertised}, tc.verify, tc.current)
+		if hs.CheckSumType != tc.want {
+			t.Errorf("%v with %q: expecting %q, got %q", tc.advertised, tc.current, tc.want, hs.CheckSumType)
+		}
+	}
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	zstdOnly := flowfile.Capabilities{Codecs: []string{"zstd"}}
+	for _, tc := range []struct {
+		c           flowfile.Capabilities
+		compression []string
+		want        string
+	}{
+		{zstdOnly, []string{"gzip", "ZSTD"}, "zstd"},
+		{zstdOnly, []string{"gzip"}, ""},
+		{flowfile.Capabilities{}, []string{"unknown", "gzip"}, "gzip"},
+		{flowfile.Capabilities{}, nil, ""},
+	} {
+		hs := handshake(t, tc.c, false, "", tc.compression...)
+		if hs.ContentEncoding != tc.want {
+			t.Errorf("%v from %v: expecting %q, got %q", tc.compression, tc.c.Codecs, tc.want, hs.ContentEncoding)
+		}
+	}
+
+	// A POST in a coding not advertised is refused
+	rcv, ts := newReadingReceiver(t)
+	rcv.Capabilities = zstdOnly
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	flowfile.NewWriter(gz).Write(stringFiles("abc")[0])
+	gz.Close()
+	req, _ := http.NewRequest("POST", ts.URL, &buf)
+	req.Header.Set("Content-Type", "application/flowfile-v3")
+	req.Header.Set("Content-Encoding", "gzip")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("expecting a 415, got %d", res.StatusCode)
+	}
+}
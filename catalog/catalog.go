@@ -0,0 +1,240 @@
+// Package catalog records every File received into an embedded bbolt
+// database, with the attributes, size, checksum, where it was saved and the
+// verification result, so operators can answer "did we receive X?" without
+// grepping logs.
+//
+//   cat, err := catalog.Open("/var/lib/flowfile/catalog.db")
+//   rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+//     out, err := saver.Save(f)
+//     cat.Record(f, out, err)
+//     return err
+//   })
+//   ...
+//   entries, err := cat.ByUUID("3d5a...")
+package catalog // import "github.com/pschou/go-flowfile/catalog"
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The verification results of an Entry
+const (
+	Passed     = "passed"     // The checksum matched the content
+	Failed     = "failed"     // The checksum did not match the content
+	Unverified = "unverified" // There was no checksum to verify against
+)
+
+// An Entry is the record of a File received.  A File received more than once,
+// such as when resent after a failed POST, has an Entry for each time.
+type Entry struct {
+	ID        uint64              `json:"-"` // Sequence in the order recorded
+	UUID      string              `json:"uuid,omitempty"`
+	Filename  string              `json:"filename,omitempty"`
+	Path      string              `json:"path,omitempty"`
+	Size      int64               `json:"size"`
+	Checksum  string              `json:"checksum,omitempty"`
+	SavedPath string              `json:"saved,omitempty"`
+	Verified  string              `json:"verified"`
+	Error     string              `json:"error,omitempty"`
+	Received  time.Time           `json:"received"`
+	Attrs     flowfile.Attributes `json:"attrs"`
+}
+
+// A Catalog is an open catalog database.
+type Catalog struct {
+	db *bolt.DB
+}
+
+var (
+	bucketFiles    = []byte("files")
+	bucketUUID     = []byte("uuid")
+	bucketChecksum = []byte("checksum")
+	bucketFilename = []byte("filename")
+)
+
+// Open or create the catalog database at the path.
+func Open(path string) (*Catalog, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketFiles, bucketUUID, bucketChecksum, bucketFilename} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Catalog{db: db}, nil
+}
+
+// Close the catalog database.
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+// Record a File once it has been handled, with the path it was saved to and
+// the error from saving it, if any.  The verification result is taken from
+// the error, or the File itself when the error is not about the checksum.
+func (c *Catalog) Record(f *flowfile.File, savedPath string, err error) (*Entry, error) {
+	e := &Entry{
+		UUID:      f.Attrs.Get("uuid"),
+		Filename:  f.Attrs.Get("filename"),
+		Path:      f.Attrs.Get("path"),
+		Size:      f.Size,
+		Checksum:  f.Attrs.Get("checksum"),
+		SavedPath: savedPath,
+		Verified:  Passed,
+		Received:  flowfile.DefaultClock.Now(),
+		Attrs:     f.Attrs.Clone(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if !errors.Is(err, flowfile.ErrorChecksumMismatch) && !errors.Is(err, flowfile.ErrorChecksumMissing) {
+		err = f.Verify()
+	}
+	switch {
+	case errors.Is(err, flowfile.ErrorChecksumMismatch):
+		e.Verified = Failed
+	case errors.Is(err, flowfile.ErrorChecksumMissing):
+		e.Verified = Unverified
+	}
+
+	return e, c.db.Update(func(tx *bolt.Tx) error {
+		files := tx.Bucket(bucketFiles)
+		id, err := files.NextSequence()
+		if err != nil {
+			return err
+		}
+		e.ID = id
+		dat, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		key := idKey(id)
+		if err = files.Put(key, dat); err != nil {
+			return err
+		}
+		for _, ix := range []struct {
+			bucket []byte
+			value  string
+		}{
+			{bucketUUID, e.UUID},
+			{bucketChecksum, e.Checksum},
+			{bucketFilename, e.Filename},
+		} {
+			if ix.value == "" {
+				continue
+			}
+			if err = tx.Bucket(ix.bucket).Put(indexKey(ix.value, id), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ByUUID returns the Entries of the Files received with the uuid, oldest
+// first, or none when it was never received.
+func (c *Catalog) ByUUID(uuid string) ([]*Entry, error) {
+	return c.lookup(bucketUUID, uuid)
+}
+
+// ByChecksum returns the Entries of the Files received with the checksum,
+// oldest first.
+func (c *Catalog) ByChecksum(checksum string) ([]*Entry, error) {
+	return c.lookup(bucketChecksum, checksum)
+}
+
+// ByFilename returns the Entries of the Files received with the filename
+// attribute, oldest first.
+func (c *Catalog) ByFilename(filename string) ([]*Entry, error) {
+	return c.lookup(bucketFilename, filename)
+}
+
+// Since returns the Entries of the Files received at or after the time,
+// oldest first.
+func (c *Catalog) Since(t time.Time) (entries []*Entry, err error) {
+	err = c.db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(bucketFiles).Cursor()
+		for k, v := cur.Last(); k != nil; k, v = cur.Prev() {
+			e, err := decode(k, v)
+			if err != nil {
+				return err
+			}
+			if e.Received.Before(t) {
+				break
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return
+}
+
+// Walk calls fn with each Entry, oldest first, stopping at the first error
+// which is returned.
+func (c *Catalog) Walk(fn func(*Entry) error) error {
+	return c.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketFiles).ForEach(func(k, v []byte) error {
+			e, err := decode(k, v)
+			if err != nil {
+				return err
+			}
+			return fn(e)
+		})
+	})
+}
+
+// Return the Entries under the value in an index bucket
+func (c *Catalog) lookup(bucket []byte, value string) (entries []*Entry, err error) {
+	err = c.db.View(func(tx *bolt.Tx) error {
+		files := tx.Bucket(bucketFiles)
+		prefix := append([]byte(value), 0)
+		cur := tx.Bucket(bucket).Cursor()
+		for k, _ := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = cur.Next() {
+			key := k[len(prefix):]
+			e, err := decode(key, files.Get(key))
+			if err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return
+}
+
+func decode(key, dat []byte) (*Entry, error) {
+	e := &Entry{}
+	if err := json.Unmarshal(dat, e); err != nil {
+		return nil, err
+	}
+	e.ID = binary.BigEndian.Uint64(key)
+	return e, nil
+}
+
+// The keys sort in the order the Files were recorded
+func idKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
+
+func indexKey(value string, id uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(value), 0), id)
+}
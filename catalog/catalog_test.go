@@ -0,0 +1,108 @@
+package catalog_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/catalog"
+	"github.com/pschou/go-flowfile/flowfiletest"
+)
+
+// A File read through as by a handler, so its checksum is verified
+func received(t *testing.T, dat, uuid, checksum string) *flowfile.File {
+	t.Helper()
+	f := flowfile.New(strings.NewReader(dat), int64(len(dat)))
+	f.Attrs.Set("filename", uuid+".txt")
+	f.Attrs.Set("uuid", uuid)
+	if checksum != "" {
+		f.Attrs.Set("checksumType", "SHA256")
+		f.Attrs.Set("checksum", checksum)
+	}
+	f.ChecksumInit()
+	if _, err := io.ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestCatalog(t *testing.T) {
+	clk := flowfiletest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	defer func(old flowfile.Clock) { flowfile.DefaultClock = old }(flowfile.DefaultClock)
+	flowfile.DefaultClock = clk
+
+	path := filepath.Join(t.TempDir(), "catalog.db")
+	cat, err := catalog.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sumA := "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb" // SHA256 of "a"
+	for _, rec := range []struct {
+		f        *flowfile.File
+		saved    string
+		err      error
+		verified string
+	}{
+		{received(t, "a", "u0", sumA), "/data/u0.txt", nil, catalog.Passed},
+		{received(t, "b", "u1", ""), "/data/u1.txt", nil, catalog.Unverified},
+		{received(t, "a", "u0", sumA), "/data/u0.txt", nil, catalog.Passed}, // Resent
+		{received(t, "c", "u2", sumA), "", flowfile.ErrorChecksumMismatch, catalog.Failed},
+	} {
+		clk.Advance(time.Minute)
+		e, err := cat.Record(rec.f, rec.saved, rec.err)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Verified != rec.verified || e.SavedPath != rec.saved || (rec.err != nil) != (e.Error != "") {
+			t.Errorf("%s: unexpected entry %+v", e.UUID, e)
+		}
+	}
+	cat.Close()
+
+	// The entries are kept when reopened
+	if cat, err = catalog.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer cat.Close()
+	ids := func(entries []*catalog.Entry, err error) (out []uint64) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			out = append(out, e.ID)
+		}
+		return
+	}
+	for _, tc := range []struct {
+		name string
+		got  []uint64
+		want []uint64
+	}{
+		{"uuid", ids(cat.ByUUID("u0")), []uint64{1, 3}},
+		{"unknown uuid", ids(cat.ByUUID("u")), nil},
+		{"checksum", ids(cat.ByChecksum(sumA)), []uint64{1, 3, 4}},
+		{"filename", ids(cat.ByFilename("u1.txt")), []uint64{2}},
+		{"since", ids(cat.Since(time.Date(2024, 1, 1, 0, 3, 0, 0, time.UTC))), []uint64{3, 4}},
+		{"since now", ids(cat.Since(clk.Now().Add(time.Second))), nil},
+	} {
+		if fmt.Sprint(tc.got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: expecting %v, got %v", tc.name, tc.want, tc.got)
+		}
+	}
+
+	stop := errors.New("stop")
+	var walked int
+	if err = cat.Walk(func(e *catalog.Entry) error {
+		if walked++; walked == 2 {
+			return stop
+		}
+		return nil
+	}); err != stop || walked != 2 {
+		t.Errorf("expecting the Walk stopped by the error, got %v after %d", err, walked)
+	}
+}
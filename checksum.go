@@ -9,10 +9,8 @@ import (
 	"fmt"
 	"hash"
 	"io"
-	"log"
 	"os"
 	"strings"
-	"sync"
 )
 
 const (
@@ -45,9 +43,7 @@ func (l *File) Verify() error {
 			return nil
 		}
 		l.cksumStatus = cksumFailed
-		if Debug {
-			log.Println("checksum:", fmt.Sprintf("%0x", hashval), "!= attr:", l.Attrs.Get("checksum"))
-		}
+		l.debug.debugln("checksum:", fmt.Sprintf("%0x", hashval), "!= attr:", l.Attrs.Get("checksum"))
 		return ErrorChecksumMismatch
 	case cksumPassed:
 		return nil
@@ -99,23 +95,47 @@ func (l *File) VerifyDetails() string {
 
 // Verify the file sent was complete and accurate
 func (l *File) VerifyParent(fp string) error {
+	fh, err := openFile(fp)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	var fileSize int64
+	if stat, err := os.Stat(fp); err == nil {
+		fileSize = stat.Size()
+	}
+	return l.verifyParent(fh, fileSize)
+}
+
+// Verify the original checksum over the reassembled content in ra
+func (l *File) verifyParent(ra io.ReaderAt, fileSize int64) error {
 	if ct := l.Attrs.Get("segment.original.checksumType"); ct != "" {
+		if err := fipsChecksum(ct); err != nil {
+			return err
+		}
 		new := getChecksumFunc(ct)
 		if new == nil {
-			return fmt.Errorf("Invalid original checksumType")
+			return fmt.Errorf("%w: invalid original checksumType %q", ErrorChecksumType, ct)
 		}
-		cksum := new()
-		if fh, err := os.Open(fp); err != nil {
-			return err
+		var sum []byte
+		if chunkNew, size := parseChunkedChecksum(ct); chunkNew != nil {
+			// Chunked checksums can be verified in parallel
+			var err error
+			if sum, err = parallelChecksum(ra, 0, fileSize, chunkNew, size, 0); err != nil {
+				return err
+			}
 		} else {
-			io.Copy(cksum, fh)
-			fh.Close()
+			cksum := new()
+			if _, err := copyBuffer(cksum, io.NewSectionReader(ra, 0, fileSize)); err != nil {
+				return err
+			}
+			sum = cksum.Sum(nil)
 		}
 
 		p_ck := l.Attrs.Get("segment.original.checksum")
-		ck := fmt.Sprintf("%0x", cksum.Sum(nil))
+		ck := fmt.Sprintf("%0x", sum)
 		if p_ck != ck {
-			return fmt.Errorf("Original checksum mismatch %q != %q", p_ck, ck)
+			return fmt.Errorf("Original %w %q != %q", ErrorChecksumMismatch, p_ck, ck)
 		}
 
 		// All is well now!
@@ -137,9 +157,7 @@ func (h Attributes) NewChecksumHash() hash.Hash {
 
 // Function called before a file is read for setting up the hashing function.
 func (l *File) ChecksumInit() error {
-	if Debug {
-		log.Println("Checksum init for", l.Attrs.Get("filename"))
-	}
+	l.debug.debugln("Checksum init for", l.Attrs.Get("filename"))
 	if l.Size > 0 {
 		if ct := l.Attrs.Get("checksumType"); ct != "" {
 			new := getChecksumFunc(ct)
@@ -150,12 +168,14 @@ func (l *File) ChecksumInit() error {
 			}
 		}
 		l.cksumStatus = cksumUnverified
-		return errors.New("Unable to find matching checksum type")
+		return ErrorChecksumType
 	}
 	return nil
 }
 
 // Add checksum to flowfile, requires a ReadAt interface in the flowfile context.
+// Checksums of files on disk are remembered in the DefaultChecksumCache when
+// set.
 //
 // Note: The checksums cannot be added to a streamed File (io.Reader) as the
 // header would have already been sent and could not be placed in the header as
@@ -166,11 +186,18 @@ func (f *File) AddChecksum(cksum string) error {
 	if f.Size == 0 {
 		return nil // Don't add checksum for empty files
 	}
+	if err := fipsChecksum(cksum); err != nil {
+		return err
+	}
 	new := getChecksumFunc(cksum)
 	if new == nil {
-		return fmt.Errorf("Unable to find checksum type: %q", cksum)
+		return fmt.Errorf("%w: %q", ErrorChecksumType, cksum)
 	}
+	return DefaultChecksumCache.compute(f, cksum, func() error { return f.addChecksum(cksum, new) })
+}
 
+// Compute the checksum by reading the content
+func (f *File) addChecksum(cksum string, new func() hash.Hash) error {
 	var ra io.ReaderAt
 	if f.ra != nil {
 		ra = f.ra
@@ -178,17 +205,13 @@ func (f *File) AddChecksum(cksum string) error {
 
 	// Case where the file is not currently open, open and do the checksum and close
 	if ra == nil && f.filePath != "" {
-		if Debug {
-			log.Println("Opening file for checksum", f.filePath)
-		}
-		if fh, err := os.Open(f.filePath); err != nil {
+		f.debug.debugln("Opening file for checksum", f.filePath)
+		if fh, err := f.openFile(); err != nil {
 			return err
 		} else {
 			ra = fh
 			defer func() {
-				if Debug {
-					log.Println("Closing file after checksum", f.filePath)
-				}
+				f.debug.debugln("Closing file after checksum", f.filePath)
 				fh.Close()
 			}()
 		}
@@ -196,9 +219,9 @@ func (f *File) AddChecksum(cksum string) error {
 
 	if ra != nil {
 		// We have a ReadAt reader, do the checksum!
-		bufp := bufPool.Get().(*[]byte)
-		defer bufPool.Put(bufp)
-		buf := *bufp
+		bufp := DefaultBufferPool.Get()
+		defer DefaultBufferPool.Put(bufp)
+		buf := bufp
 		h := new()
 		n := f.n
 		i := f.i
@@ -222,19 +245,20 @@ func (f *File) AddChecksum(cksum string) error {
 				if err == io.EOF {
 					return nil
 				} else {
-					if Debug {
-						log.Println("Reading for checksum ran into error", err)
-					}
+					f.debug.debugln("Reading for checksum ran into error", err)
 					return err
 				}
 			}
 		}
 	}
-	return fmt.Errorf("Reader must implement a ReadAt interface")
+	return ErrorNeedReadAt
 }
 
 // Hash builder function
 func getChecksumFunc(cksum string) func() hash.Hash {
+	if fipsChecksum(cksum) != nil {
+		return nil
+	}
 	switch strings.TrimSpace(strings.ToUpper(cksum)) {
 	case "MD5":
 		return md5.New
@@ -249,12 +273,8 @@ func getChecksumFunc(cksum string) func() hash.Hash {
 	case "SHA512":
 		return sha512.New
 	}
+	if new, size := parseChunkedChecksum(cksum); new != nil {
+		return func() hash.Hash { return newChunkedHash(new, size) }
+	}
 	return nil
 }
-
-var bufPool = sync.Pool{
-	New: func() any {
-		b := make([]byte, 32*1024)
-		return &b
-	},
-}
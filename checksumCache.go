@@ -0,0 +1,121 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultChecksumCache, when set, is used by AddChecksum and
+// AddChecksumParallel to remember the checksums of files on disk, so sending
+// the same files again, such as on a retry or to mirrored destinations, does
+// not read them again.
+//
+//   flowfile.DefaultChecksumCache = flowfile.NewChecksumCache(10000)
+var DefaultChecksumCache *ChecksumCache
+
+// A ChecksumCache remembers the checksums computed for Files made with
+// NewFromDisk, keyed by the path, size and modification time of the file, along
+// with the range of the content and the checksum type.  A file which changes
+// size or modification time is read again.
+type ChecksumCache struct {
+	maxEntries int
+	mu         sync.Mutex
+	entries    map[checksumKey]*list.Element
+	lru        *list.List
+
+	// Debug output for the checksums computed through this cache, including
+	// the chunked checksums of AddChecksumParallel
+	DebugLog
+}
+
+type checksumKey struct {
+	path          string
+	size          int64
+	modTime       time.Time
+	offset, count int64
+	cksum         string
+}
+
+type checksumEntry struct {
+	key checksumKey
+	sum string
+}
+
+// NewChecksumCache creates a ChecksumCache holding up to maxEntries checksums,
+// dropping the least recently used ones beyond that, or unbounded when zero.
+func NewChecksumCache(maxEntries int) *ChecksumCache {
+	return &ChecksumCache{
+		maxEntries: maxEntries,
+		entries:    make(map[checksumKey]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// The debug output of the cache, nil when there is no cache so only the package
+// wide Debug applies
+func (c *ChecksumCache) debugLog() *DebugLog {
+	if c == nil {
+		return nil
+	}
+	return &c.DebugLog
+}
+
+// Len returns the number of checksums held.
+func (c *ChecksumCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Build the key for a File, false when it is not a file on disk
+func (c *ChecksumCache) key(f *File, cksum string) (checksumKey, bool) {
+	if c == nil || f.filePath == "" || f.fsys != nil {
+		return checksumKey{}, false
+	}
+	fi, err := os.Stat(f.filePath)
+	if err != nil || !fi.Mode().IsRegular() {
+		return checksumKey{}, false
+	}
+	return checksumKey{path: f.filePath, size: fi.Size(), modTime: fi.ModTime(),
+		offset: f.i, count: f.n, cksum: cksum}, true
+}
+
+// Set the checksum from the cache, or compute and remember it
+func (c *ChecksumCache) compute(f *File, cksum string, compute func() error) error {
+	k, ok := c.key(f, cksum)
+	if !ok {
+		return compute()
+	}
+
+	c.mu.Lock()
+	if el, hit := c.entries[k]; hit {
+		c.lru.MoveToFront(el)
+		sum := el.Value.(*checksumEntry).sum
+		c.mu.Unlock()
+		f.Attrs.Set("checksumType", cksum)
+		f.Attrs.Set("checksum", sum)
+		return nil
+	}
+	c.mu.Unlock()
+
+	if err := compute(); err != nil {
+		return err
+	}
+	if after, ok := c.key(f, cksum); !ok || after != k {
+		return nil // Changed while being read, don't trust it for next time
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, hit := c.entries[k]; !hit {
+		c.entries[k] = c.lru.PushFront(&checksumEntry{key: k, sum: f.Attrs.Get("checksum")})
+		for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+			el := c.lru.Back()
+			c.lru.Remove(el)
+			delete(c.entries, el.Value.(*checksumEntry).key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package flowfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestChecksumCache(t *testing.T) {
+	defer func(old *flowfile.ChecksumCache) { flowfile.DefaultChecksumCache = old }(flowfile.DefaultChecksumCache)
+	flowfile.DefaultChecksumCache = flowfile.NewChecksumCache(1)
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.txt")
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	write := func(name, dat string, mtime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(name, []byte(dat), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checksum := func(name string) string {
+		t.Helper()
+		f, err := flowfile.NewFromDisk(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = f.AddChecksum("SHA256"); err != nil {
+			t.Fatal(err)
+		}
+		return f.Attrs.Get("checksum")
+	}
+
+	write(name, "abc", mtime)
+	first := checksum(name)
+	if n := flowfile.DefaultChecksumCache.Len(); n != 1 {
+		t.Fatalf("expecting the checksum cached, got %d", n)
+	}
+
+	// The same path, size and modification time is not read again
+	write(name, "xyz", mtime)
+	if got := checksum(name); got != first {
+		t.Errorf("expecting the cached checksum, got %s", got)
+	}
+	write(name, "xyz", mtime.Add(time.Second))
+	if got := checksum(name); got == first {
+		t.Errorf("expecting the changed file read again")
+	}
+
+	// Beyond the maxEntries the least recently used is dropped
+	other := filepath.Join(dir, "b.txt")
+	write(other, "def", mtime)
+	checksum(other)
+	if n := flowfile.DefaultChecksumCache.Len(); n != 1 {
+		t.Errorf("expecting one checksum cached, got %d", n)
+	}
+}
@@ -0,0 +1,229 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"encoding"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Chunked checksums split the payload into fixed size chunks, hash each chunk
+// independently, and then hash the concatenated chunk digests.  As the chunks
+// are independent they can be hashed in parallel, so very large files don't
+// serialize on one core before sending.  The checksumType is the base hash
+// type with the chunk size appended, such as "SHA256-CHUNK-8M", and can be
+// verified on the receiving side while streaming like any other checksum.
+
+// MinChecksumChunkSize is the smallest chunk size accepted in a chunked
+// checksum type, so a sender cannot pick a size making the chunk digests
+// outweigh the content.
+var MinChecksumChunkSize int64 = 64 << 10
+
+// Parse a chunked checksum type into the base hash function and chunk size.
+func parseChunkedChecksum(cksum string) (new func() hash.Hash, size int64) {
+	parts := strings.SplitN(strings.TrimSpace(strings.ToUpper(cksum)), "-CHUNK-", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, 0
+	}
+	num, mult := parts[1], int64(1)
+	switch num[len(num)-1] {
+	case 'K':
+		mult = 1 << 10
+	case 'M':
+		mult = 1 << 20
+	case 'G':
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		num = num[:len(num)-1]
+	}
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || v <= 0 || v > math.MaxInt64/mult || v*mult < MinChecksumChunkSize {
+		return nil, 0
+	}
+	if new = getChecksumFunc(parts[0]); new == nil {
+		return nil, 0
+	}
+	return new, v * mult
+}
+
+// chunkedHash computes a chunked checksum sequentially, for verifying a
+// streamed payload.  Each chunk digest is written to the outer hash as the
+// chunk completes, so the memory used does not grow with the payload.
+type chunkedHash struct {
+	new    func() hash.Hash
+	size   int64
+	cur    hash.Hash
+	n      int64 // bytes in the current chunk
+	outer  hash.Hash
+	chunks int // chunk digests written to the outer hash
+}
+
+func newChunkedHash(new func() hash.Hash, size int64) *chunkedHash {
+	return &chunkedHash{new: new, size: size, cur: new(), outer: new()}
+}
+
+func (c *chunkedHash) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		take := c.size - c.n
+		if int64(len(p)) < take {
+			take = int64(len(p))
+		}
+		c.cur.Write(p[:take])
+		c.n += take
+		p = p[take:]
+		if c.n == c.size {
+			c.outer.Write(c.cur.Sum(nil))
+			c.chunks++
+			c.cur.Reset()
+			c.n = 0
+		}
+	}
+	return total, nil
+}
+
+func (c *chunkedHash) Sum(b []byte) []byte {
+	if c.n == 0 && c.chunks > 0 {
+		return c.outer.Sum(b)
+	}
+	// The partial chunk goes into a copy of the outer hash, so the state is
+	// left as is
+	outer := c.outer
+	if m, ok := outer.(encoding.BinaryMarshaler); ok {
+		if state, err := m.MarshalBinary(); err == nil {
+			outer = c.new()
+			if err = outer.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+				outer = c.outer
+			}
+		}
+	}
+	outer.Write(c.cur.Sum(nil))
+	return outer.Sum(b)
+}
+
+func (c *chunkedHash) Reset() {
+	c.cur.Reset()
+	c.outer.Reset()
+	c.n, c.chunks = 0, 0
+}
+
+func (c *chunkedHash) Size() int      { return c.cur.Size() }
+func (c *chunkedHash) BlockSize() int { return c.cur.BlockSize() }
+
+// AddChecksumParallel is like AddChecksum, but the chunks of a chunked
+// checksum type (such as "SHA256-CHUNK-8M") are hashed with a number of
+// workers concurrently.  When workers is 0, one worker per CPU is used.
+func (f *File) AddChecksumParallel(cksum string, workers int) error {
+	if f.Size == 0 {
+		return nil // Don't add checksum for empty files
+	}
+	if err := fipsChecksum(cksum); err != nil {
+		return err
+	}
+	new, size := parseChunkedChecksum(cksum)
+	if new == nil {
+		return fmt.Errorf("%w: %q", ErrorChecksumType, cksum)
+	}
+	return DefaultChecksumCache.compute(f, cksum, func() error { return f.addChecksumParallel(cksum, new, size, workers) })
+}
+
+// Compute the chunked checksum by reading the content
+func (f *File) addChecksumParallel(cksum string, new func() hash.Hash, size int64, workers int) error {
+	ra := f.ra
+	if ra == nil && f.filePath != "" {
+		fh, err := f.openFile()
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		ra = fh
+	}
+	if ra == nil {
+		return ErrorNeedReadAt
+	}
+
+	sum, err := parallelChecksum(ra, f.i, f.n, new, size, workers)
+	if err != nil {
+		DefaultChecksumCache.debugLog().debugln("Reading for checksum ran into error", err)
+		return err
+	}
+	f.Attrs.Set("checksumType", cksum)
+	f.Attrs.Set("checksum", fmt.Sprintf("%0x", sum))
+	return nil
+}
+
+// The most chunk digests held at once while computing a chunked checksum in
+// parallel, they are written to the outer hash in batches of this size.
+var chunkBatch = 1024
+
+// Compute a chunked checksum over n bytes starting at offset using a number of
+// concurrent workers.
+func parallelChecksum(ra io.ReaderAt, offset, n int64, new func() hash.Hash, size int64, workers int) ([]byte, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	count := int((n + size - 1) / size)
+	if count == 0 {
+		count = 1
+	}
+	batch := count
+	if batch > chunkBatch {
+		batch = chunkBatch
+	}
+	digests := make([][]byte, batch)
+	outer := new()
+
+	for first := 0; first < count; first += batch {
+		last := first + batch
+		if last > count {
+			last = count
+		}
+		var (
+			wg      sync.WaitGroup
+			errOnce sync.Once
+			err     error
+			next    = make(chan int)
+		)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				buf := DefaultBufferPool.Get()
+				defer DefaultBufferPool.Put(buf)
+				h := new()
+				for idx := range next {
+					h.Reset()
+					st := int64(idx) * size
+					en := st + size
+					if en > n {
+						en = n
+					}
+					if _, e := io.CopyBuffer(h, io.NewSectionReader(ra, offset+st, en-st), buf); e != nil {
+						errOnce.Do(func() { err = e })
+						continue
+					}
+					digests[idx-first] = h.Sum(digests[idx-first][:0])
+				}
+			}()
+		}
+		for idx := first; idx < last; idx++ {
+			next <- idx
+		}
+		close(next)
+		wg.Wait()
+
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range digests[:last-first] {
+			outer.Write(d)
+		}
+	}
+	return outer.Sum(nil), nil
+}
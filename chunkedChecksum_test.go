@@ -0,0 +1,134 @@
+package flowfile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestParseChunkedChecksum(t *testing.T) {
+	for cksum, want := range map[string]int64{
+		"SHA256-CHUNK-8M":             8 << 20,
+		"sha256-chunk-64k":            64 << 10,
+		"SHA1-CHUNK-1G":               1 << 30,
+		"SHA256-CHUNK-65536":          65536,
+		"SHA256-CHUNK-8MK":            0,
+		"SHA256-CHUNK-8KKK":           0,
+		"SHA256-CHUNK-K":              0,
+		"SHA256-CHUNK-":               0,
+		"SHA256-CHUNK-0":              0,
+		"SHA256-CHUNK--1M":            0,
+		"SHA256-CHUNK-1":              0, // Below MinChecksumChunkSize
+		"SHA256-CHUNK-9999999999999G": 0, // Overflows an int64
+		"FOO-CHUNK-1M":                0,
+		"SHA256":                      0,
+	} {
+		new, size := parseChunkedChecksum(cksum)
+		if size != want || (new != nil) != (want != 0) {
+			t.Errorf("%q: expecting size %d, got %d", cksum, want, size)
+		}
+	}
+}
+
+// The chunked checksum built by hand, the hash of the chunk digests, where
+// an empty payload is one empty chunk
+func chunkedSum(dat []byte, size int) []byte {
+	outer := sha256.New()
+	for {
+		n := size
+		if n > len(dat) {
+			n = len(dat)
+		}
+		sum := sha256.Sum256(dat[:n])
+		outer.Write(sum[:])
+		if dat = dat[n:]; len(dat) == 0 {
+			return outer.Sum(nil)
+		}
+	}
+}
+
+func TestChunkedChecksum(t *testing.T) {
+	defer func(old int) { chunkBatch = old }(chunkBatch)
+	chunkBatch = 2 // Cross several batches
+
+	const size = 64 << 10
+	dat := make([]byte, 5*size+100)
+	rand.New(rand.NewSource(1)).Read(dat)
+	want := chunkedSum(dat, size)
+
+	got, err := parallelChecksum(bytes.NewReader(dat), 0, int64(len(dat)), sha256.New, size, 3)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("parallel: expecting %x, got %x %v", want, got, err)
+	}
+
+	// Streamed in writes not lined up with the chunks
+	h := newChunkedHash(sha256.New, size)
+	for p := dat; len(p) > 0; {
+		n := 1000
+		if n > len(p) {
+			n = len(p)
+		}
+		h.Write(p[:n])
+		p = p[n:]
+	}
+	if got = h.Sum(nil); !bytes.Equal(got, want) {
+		t.Errorf("streamed: expecting %x, got %x", want, got)
+	}
+	if again := h.Sum(nil); !bytes.Equal(again, got) {
+		t.Errorf("Sum changed the state: %x then %x", got, again)
+	}
+
+	// Whole chunks only, and the empty payload
+	h.Reset()
+	h.Write(dat[:2*size])
+	if got, want = h.Sum(nil), chunkedSum(dat[:2*size], size); !bytes.Equal(got, want) {
+		t.Errorf("whole chunks: expecting %x, got %x", want, got)
+	}
+	h.Reset()
+	if got, want = h.Sum(nil), chunkedSum(nil, size); !bytes.Equal(got, want) {
+		t.Errorf("empty: expecting %x, got %x", want, got)
+	}
+}
+
+func TestChunkedChecksumVerify(t *testing.T) {
+	dat := make([]byte, 200<<10)
+	rand.New(rand.NewSource(2)).Read(dat)
+
+	f := New(bytes.NewReader(dat), int64(len(dat)))
+	if err := f.AddChecksumParallel("SHA256-CHUNK-64K", 2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.Attrs.Get("checksum"), fmt.Sprintf("%x", chunkedSum(dat, 64<<10)); got != want {
+		t.Fatalf("expecting checksum %s, got %s", want, got)
+	}
+
+	// Received as a stream, verified while the content is read
+	g := New(io.MultiReader(bytes.NewReader(dat)), int64(len(dat)))
+	g.Attrs = f.Attrs.Clone()
+	g.ChecksumInit()
+	io.Copy(io.Discard, g)
+	if err := g.Verify(); err != nil {
+		t.Errorf("streamed verify: %v", err)
+	}
+}
+
+type failingReaderAt struct{}
+
+var errFailingRead = errors.New("read failed")
+
+func (failingReaderAt) ReadAt(p []byte, off int64) (int, error) { return 0, errFailingRead }
+
+func TestVerifyParentReadError(t *testing.T) {
+	for _, ct := range []string{"SHA256", "SHA256-CHUNK-64K"} {
+		f := New(bytes.NewReader(nil), 0)
+		f.Attrs.Set("segment.original.checksumType", ct)
+		f.Attrs.Set("segment.original.checksum", "00")
+		if err := f.verifyParent(failingReaderAt{}, 100); !errors.Is(err, errFailingRead) {
+			t.Errorf("%s: expecting the read error, got %v", ct, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import "time"
+
+// A Clock is the source of time for the library, the custody chain stamps,
+// retry and hold off timers, expiry checks, metrics timestamps and flush
+// tickers all go through one, so tests can be deterministic and the backoff
+// and expiry logic can be simulated, such as with the flowfiletest.Clock.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// A Timer from a Clock, as with time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// A Ticker from a Clock, as with time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the Clock of the time package.
+var SystemClock Clock = systemClock{}
+
+// DefaultClock is used where no Clock is set, such as on an HTTPTransaction
+// or HTTPReceiver, and by the functions which have none to be set.
+var DefaultClock = SystemClock
+
+// Return the Clock, the DefaultClock when nil
+func clockOr(c Clock) Clock {
+	if c == nil {
+		return DefaultClock
+	}
+	return c
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                   { return time.Now() }
+func (systemClock) NewTimer(d time.Duration) Timer   { return systemTimer{time.NewTimer(d)} }
+func (systemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.t.C }
+func (t systemTimer) Stop() bool          { return t.t.Stop() }
+
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+func (t systemTicker) Stop()               { t.t.Stop() }
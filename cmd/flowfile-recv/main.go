@@ -0,0 +1,165 @@
+// Command flowfile-recv listens for FlowFiles, as sent by a NiFi
+// PostHTTP/InvokeHTTP or flowfile-send, and saves them into a directory,
+// recreating the path of each File and verifying the checksums.
+//
+//   flowfile-recv -listen :8080 -dir /data/incoming
+//   flowfile-recv -listen :8443 -cert server.pem -key server.key -ca clients.pem -dir /data/incoming
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/catalog"
+)
+
+var (
+	listen          = flag.String("listen", ":8080", "Address to listen on")
+	listenPath      = flag.String("path", "/contentListener", "Path of the flowfile-v3 endpoint")
+	dir             = flag.String("dir", "", "Directory to save the Files into (required)")
+	quarantineDir   = flag.String("quarantine", "", "Directory for Files failing verification, they are removed when empty")
+	requireChecksum = flag.Bool("require-checksum", false, "Reject Files without a checksum")
+	certFile        = flag.String("cert", "", "PEM certificate to serve TLS with")
+	keyFile         = flag.String("key", "", "PEM key to serve TLS with")
+	caFile          = flag.String("ca", "", "PEM file of the CAs clients must present a certificate from")
+	maxConnections  = flag.Int("max-connections", 0, "Most POSTs handled at once, zero for no limit")
+	delta           = flag.Bool("delta", false, "Offer delta transfers against the Files already saved")
+	archiveDir      = flag.String("archive", "", "Directory to capture the received Files into, in rolling archives, ahead of saving them")
+	ownersFile      = flag.String("owners", "", "JSON file mapping the sender identity to the owner of the saved Files, when run as root")
+	catalogFile     = flag.String("catalog", "", "Database file to record each File received into")
+	dedupDir        = flag.String("dedup", "", "Directory to keep one copy of each content, duplicates are hard linked so it must be on the filesystem of -dir but not within it")
+	events          = flag.Bool("events", false, "Serve a feed of the Files received at /events, as server-sent events")
+	debug           = flag.Bool("debug", false, "Debug output")
+)
+
+func main() {
+	flag.Parse()
+	if *dir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	flowfile.Debug = *debug
+
+	saver := flowfile.NewSaver(*dir)
+	saver.QuarantineDir = *quarantineDir
+	saver.RejectSymlinks = true
+	if *ownersFile != "" {
+		dat, err := os.ReadFile(*ownersFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if saver.Owners, err = flowfile.ParseOwnerMap(dat); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *dedupDir != "" {
+		// A store within the tree the senders write into could have its
+		// content replaced by a File saved over it
+		if within(*dir, *dedupDir) {
+			log.Fatalf("-dedup %q must not be within -dir %q", *dedupDir, *dir)
+		}
+		store, err := flowfile.OpenContentStore(nil, *dedupDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer store.Close()
+		saver.ContentStore = store
+	}
+
+	var archive *flowfile.ArchiveWriter
+	if *archiveDir != "" {
+		archive = flowfile.NewArchiveWriter(*archiveDir)
+		defer archive.Close()
+	}
+
+	var cat *catalog.Catalog
+	if *catalogFile != "" {
+		var err error
+		if cat, err = catalog.Open(*catalogFile); err != nil {
+			log.Fatal(err)
+		}
+		defer cat.Close()
+	}
+
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		if archive != nil {
+			e, err := archive.Append(f)
+			if err != nil {
+				log.Println("Failed to archive", err)
+				return err
+			}
+			if f, err = e.File(); err != nil {
+				return err
+			}
+			defer f.Close()
+		}
+		out, err := saver.Save(f)
+		if cat != nil {
+			if _, cerr := cat.Record(f, out, err); cerr != nil {
+				log.Println("Failed to catalog", out, cerr)
+			}
+		}
+		switch {
+		case errors.Is(err, flowfile.ErrorChecksumMissing) && !*requireChecksum:
+			log.Println("Saved without a checksum", out)
+			return nil
+		case err != nil:
+			log.Println("Failed to save", out, err)
+			return err
+		}
+		if *debug {
+			log.Println("Saved", out)
+		}
+		return nil
+	})
+	rcv.VerifyChecksum = true // Advertise the checksum types in the handshake
+	rcv.MaxConnections = *maxConnections
+	rcv.StampAttributes = saver.Owners != nil // For the user.dn the owner is mapped from
+	if *delta {
+		rcv.DeltaBasis, rcv.DeltaSignatures = saver.DeltaBasis, true
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(*listenPath, rcv)
+	mux.Handle("/metrics", rcv.MetricsHandler())
+	mux.Handle("/healthz", rcv.HealthHandler())
+	if *events {
+		mux.Handle("/events", rcv.EventsHandler())
+	}
+	srv := &http.Server{Addr: *listen, Handler: mux}
+
+	log.Println("Listening on", *listen, *listenPath, "saving to", *dir)
+	if *certFile == "" {
+		log.Fatal(srv.ListenAndServe())
+	}
+	srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if *caFile != "" {
+		pem, err := os.ReadFile(*caFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv.TLSConfig.ClientCAs = x509.NewCertPool()
+		if !srv.TLSConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			log.Fatal(fmt.Errorf("no certificates found in %s", *caFile))
+		}
+		srv.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	log.Fatal(srv.ListenAndServeTLS(*certFile, *keyFile))
+}
+
+// Is the target the directory or within it
+func within(dir, target string) bool {
+	dir, _ = filepath.Abs(dir)
+	target, _ = filepath.Abs(target)
+	rel, err := filepath.Rel(dir, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(filepath.ToSlash(rel), "../")
+}
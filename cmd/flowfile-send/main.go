@@ -0,0 +1,176 @@
+// Command flowfile-send sends files, directory trees, or stdin to a NiFi
+// ListenHTTP endpoint, or any other flowfile-v3 receiver, as FlowFiles.
+//
+//   flowfile-send -url http://nifi:8080/contentListener -attr project=A data/ report.csv
+//   tar c data | flowfile-send -url https://nifi:8443/contentListener -ca ca.pem -name data.tar -
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+)
+
+var (
+	url        = flag.String("url", "", "URL of the flowfile-v3 endpoint (required)")
+	caFile     = flag.String("ca", "", "PEM file of the CAs to trust for the endpoint")
+	certFile   = flag.String("cert", "", "PEM certificate for client authentication")
+	keyFile    = flag.String("key", "", "PEM key for client authentication")
+	checksum   = flag.String("checksum", "", "Checksum type to send, negotiated with the endpoint when empty")
+	segment    = flag.Int64("segment", 0, "Send files larger than this many bytes as segments")
+	gzip       = flag.Bool("gzip", false, "Compress the POSTs when the endpoint supports it")
+	delta      = flag.Bool("delta", false, "Send only the changed blocks of files the endpoint already holds")
+	retries    = flag.Int("retries", 3, "Retries for each failed send")
+	retryDelay = flag.Duration("retry-delay", 5*time.Second, "Delay between retries")
+	name       = flag.String("name", "stdin", "Filename for the content read from stdin")
+	debug      = flag.Bool("debug", false, "Debug output")
+
+	attrs flowfile.Attributes
+)
+
+func main() {
+	flag.Func("attr", "Attribute to add to every File as name=value, may be repeated", func(s string) error {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("expecting name=value, got %q", s)
+		}
+		attrs.Add(k, v)
+		return nil
+	})
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] path... (- for stdin)\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *url == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	flowfile.Debug = *debug
+
+	tlsConfig, err := loadTLS()
+	if err != nil {
+		log.Fatal(err)
+	}
+	hs := flowfile.NewHTTPTransactionNoHandshake(*url, tlsConfig)
+	defer hs.Close()
+	hs.CheckSumType = *checksum
+	if *gzip {
+		hs.Compression = []string{"gzip"}
+	}
+	hs.RetryCount, hs.RetryDelay = *retries, *retryDelay
+	hs.Delta = *delta
+	if err = hs.Handshake(); err != nil {
+		log.Fatal(err)
+	}
+
+	var failed int
+	for _, arg := range flag.Args() {
+		if arg == "-" {
+			a := attrs.Clone()
+			a.Set("filename", *name)
+			if err := hs.SendStream(os.Stdin, a, *segment); err != nil {
+				log.Println("Failed to send stdin:", err)
+				failed++
+			}
+			continue
+		}
+		failed += sendTree(hs, arg)
+	}
+	if failed > 0 {
+		log.Fatalf("%d files failed to send", failed)
+	}
+}
+
+// Send a file, or a directory and everything under it, with the path
+// attribute relative to the parent of root.  Returns the number of failures.
+func sendTree(hs *flowfile.HTTPTransaction, root string) (failed int) {
+	parent := filepath.Dir(filepath.Clean(root))
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Println("Skipping", p, err)
+			failed++
+			return nil
+		}
+		f, err := flowfile.NewFromDisk(p)
+		if err != nil {
+			log.Println("Skipping", p, err)
+			failed++
+			return nil
+		}
+		rel, _ := filepath.Rel(parent, p)
+		f.Attrs.Set("path", path.Dir(filepath.ToSlash(rel))+"/")
+		for _, kv := range attrs {
+			f.Attrs.Add(kv.Name, kv.Value)
+		}
+		if err = send(hs, f); err != nil {
+			log.Println("Failed to send", p, err)
+			failed++
+		} else if *debug {
+			log.Println("Sent", p)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		failed++
+	}
+	return
+}
+
+// Send a File, as segments when over the segment size
+func send(hs *flowfile.HTTPTransaction, f *flowfile.File) error {
+	ff := []*flowfile.File{f}
+	if *segment > 0 && f.Size > *segment {
+		if hs.CheckSumType != "" {
+			if err := f.AddChecksum(hs.CheckSumType); err != nil {
+				return err
+			}
+		}
+		var err error
+		if ff, err = flowfile.SegmentBySize(f, *segment); err != nil {
+			return err
+		}
+	}
+	for _, s := range ff {
+		if err := hs.Send(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadTLS() (*tls.Config, error) {
+	if *caFile == "" && *certFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if *caFile != "" {
+		pem, err := os.ReadFile(*caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", *caFile)
+		}
+	}
+	if *certFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
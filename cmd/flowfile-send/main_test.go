@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pschou/go-flowfile/flowfiletest"
+)
+
+func TestSendTree(t *testing.T) {
+	srv := flowfiletest.NewServer()
+	defer srv.Close()
+	hs, err := srv.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(t.TempDir(), "data")
+	os.MkdirAll(filepath.Join(root, "sub"), 0755)
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("abc"), 0644)
+	os.WriteFile(filepath.Join(root, "sub", "b.txt"), []byte("0123456789"), 0644)
+
+	defer func(old int64) { *segment = old }(*segment)
+	*segment = 4
+	attrs.Set("project", "A")
+	defer func() { attrs = nil }()
+
+	if failed := sendTree(hs, root); failed != 0 {
+		t.Fatalf("expecting no failures, got %d", failed)
+	}
+	got := make(map[string]string)
+	var segments int
+	for _, f := range srv.Files() {
+		if f.Attrs.Get("project") != "A" {
+			t.Errorf("expecting the -attr on every File, got %v", f.Attrs)
+		}
+		if f.Attrs.Get("fragment.identifier") != "" {
+			segments++
+		}
+		got[f.Attrs.Get("filename")] = f.Attrs.Get("path")
+	}
+	if got["a.txt"] != "data/" || got["b.txt"] != "data/sub/" {
+		t.Errorf("expecting the paths relative to the parent of the root, got %v", got)
+	}
+	if segments != 3 {
+		t.Errorf("expecting b.txt sent as 3 segments, got %d", segments)
+	}
+}
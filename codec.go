@@ -0,0 +1,90 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+	"sync"
+)
+
+// A Codec compresses the body of a POST, named by the HTTP content coding it
+// is sent under in the Content-Encoding header.  The same codecs compress the
+// content of a File with CompressContent, which marks the File with the
+// MimeType and adds the Extension to the filename.
+type Codec struct {
+	Name      string
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+
+	MimeType  string // such as "application/gzip"
+	Extension string // such as ".gz"
+}
+
+var (
+	codecLock  sync.RWMutex
+	codecs     = make(map[string]*Codec)
+	codecOrder []string
+)
+
+func init() {
+	RegisterCodec(Codec{
+		Name:      "gzip",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		NewReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		MimeType:  "application/gzip",
+		Extension: ".gz",
+	})
+}
+
+// RegisterCodec adds a codec which can be negotiated in the handshake,
+// replacing any already registered under the same name.  Receivers advertise
+// the registered codecs in the order they were registered, unless
+// Capabilities.Codecs is set.
+func RegisterCodec(c Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	name := strings.ToLower(c.Name)
+	if _, ok := codecs[name]; !ok {
+		codecOrder = append(codecOrder, name)
+	}
+	codecs[name] = &c
+}
+
+// LookupCodec returns the codec registered under the content coding name, or
+// nil when there is none.
+func LookupCodec(name string) *Codec {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	return codecs[strings.ToLower(strings.TrimSpace(name))]
+}
+
+// Find the codec by the MIME type it marks compressed content with
+func lookupCodecMimeType(mimeType string) *Codec {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	for _, name := range codecOrder {
+		if c := codecs[name]; c.MimeType != "" && strings.EqualFold(c.MimeType, mimeType) {
+			return c
+		}
+	}
+	return nil
+}
+
+// CodecNames returns the names of the registered codecs, in the order they
+// were registered.
+func CodecNames() []string {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	return append([]string{}, codecOrder...)
+}
+
+// Pick the content coding to send with, the first of the preferred ones which
+// the receiver advertises and is registered here, or "" to send uncompressed.
+func (c Capabilities) selectCodec(preferred []string) string {
+	for _, name := range preferred {
+		if c.HasCodec(name) && LookupCodec(name) != nil {
+			return strings.ToLower(strings.TrimSpace(name))
+		}
+	}
+	return ""
+}
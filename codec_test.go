@@ -0,0 +1,116 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestCodecSend(t *testing.T) {
+	dat := strings.Repeat("compressible content ", 1000)
+	for _, name := range []string{"lz4", "snappy", "zstd"} {
+		t.Run(name, func(t *testing.T) {
+			var encoding string
+			var got []byte
+			rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+				encoding = r.Header.Get("Content-Encoding")
+				var err error
+				got, err = io.ReadAll(f)
+				return err
+			})
+			ts := httptest.NewServer(rcv)
+			defer ts.Close()
+
+			hs := flowfile.NewHTTPTransactionNoHandshake(ts.URL, nil)
+			hs.Compression = []string{"unknown", name}
+			if err := hs.Handshake(); err != nil {
+				t.Fatal(err)
+			}
+			if hs.ContentEncoding != name {
+				t.Fatalf("expecting %s picked, got %q from %v", name, hs.ContentEncoding, hs.Capabilities.Codecs)
+			}
+			if err := hs.Send(stringFiles(dat)...); err != nil {
+				t.Fatal(err)
+			}
+			if encoding != name || !bytes.Equal(got, []byte(dat)) {
+				t.Errorf("expecting the content sent with %s, got %q and %d bytes", name, encoding, len(got))
+			}
+		})
+	}
+}
+
+func TestPostWriterCompression(t *testing.T) {
+	var encoding string
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		encoding = r.Header.Get("Content-Encoding")
+		_, err := io.Copy(io.Discard, f)
+		return err
+	})
+	rcv.Capabilities.Codecs = []string{"gzip", "zstd"}
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+
+	hs := flowfile.NewHTTPTransactionNoHandshake(ts.URL, nil)
+	hs.Compression = []string{"gzip"}
+	if err := hs.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ compression, want string }{
+		{"", "gzip"},     // The transaction default
+		{"zstd", "zstd"}, // Advertised by the receiver
+		{"lz4", ""},      // Registered but not advertised
+		{"identity", ""}, // Uncompressed
+	} {
+		w := hs.NewHTTPPostWriter()
+		w.Compression = tc.compression
+		if _, err := w.Write(stringFiles("abc")[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if encoding != tc.want {
+			t.Errorf("%q: expecting the POST encoded with %q, got %q", tc.compression, tc.want, encoding)
+		}
+	}
+}
+
+func TestCodecLazyHandshake(t *testing.T) {
+	dat := strings.Repeat("compressible content ", 1000)
+	var encodings []string
+	var got []string
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		b, err := io.ReadAll(f)
+		got = append(got, string(b))
+		return err
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+
+	hs := flowfile.NewHTTPTransactionNoHandshake(ts.URL, nil)
+	hs.Compression = []string{"zstd"}
+	w := hs.NewHTTPPostWriter()
+	w.MaxFilesPerPost = 1
+	for _, f := range stringFiles(dat, dat) {
+		if _, err := w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first POST was started before the handshake, so it goes out as written
+	if len(encodings) != 2 || encodings[0] != "" || encodings[1] != "zstd" || got[0] != dat || got[1] != dat {
+		t.Errorf("expecting the first POST plain and the next with zstd, got %q", encodings)
+	}
+	if hs.ContentEncoding != "" {
+		t.Errorf("expecting the lazy handshake to leave the transaction as set, got %q", hs.ContentEncoding)
+	}
+}
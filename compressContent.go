@@ -0,0 +1,79 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CompressContent compresses the content of the File with the named codec,
+// such as "zstd", as with the CompressContent processor of NiFi.  The File is
+// marked with the MimeType of the codec and the Extension is added to the
+// filename, see SetContent for how the other attributes are updated.  The
+// compressed content is spooled as with SpoolStream, so the cleanup function,
+// when not nil, must be called once the File is no longer needed.
+//
+//   cleanup, err := f.CompressContent("zstd")
+//   if cleanup != nil {
+//     defer cleanup()
+//   }
+func (f *File) CompressContent(codec string) (cleanup func() error, err error) {
+	c := LookupCodec(codec)
+	if c == nil {
+		return nil, fmt.Errorf("%w %q", ErrorUnknownCodec, codec)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		cw, err := c.NewWriter(pw)
+		if err == nil {
+			if _, err = copyBuffer(cw, f); err == nil {
+				err = cw.Close()
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	content, size, cleanup, err := spoolAll(pr, SpoolMemoryLimit, "")
+	pr.CloseWithError(err) // Make sure the compressing side does not hang
+	if err != nil {
+		return nil, err
+	}
+
+	if err = f.SetContent(content, size, c.MimeType); err == nil && c.Extension != "" {
+		if name := f.Attrs.Get("filename"); name != "" {
+			f.Attrs.Set("filename", name+c.Extension)
+		}
+	}
+	return
+}
+
+// DecompressContent decompresses the content of the File with the codec its
+// mime.type attribute names, undoing CompressContent.  The mime.type is
+// removed and the Extension of the codec taken off the filename.  The
+// content is spooled as with SpoolStream, so the cleanup function, when not
+// nil, must be called once the File is no longer needed.
+func (f *File) DecompressContent() (cleanup func() error, err error) {
+	mimeType := f.Attrs.Get("mime.type")
+	c := lookupCodecMimeType(mimeType)
+	if c == nil {
+		return nil, fmt.Errorf("%w for mime.type %q", ErrorUnknownCodec, mimeType)
+	}
+
+	rc, err := c.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	content, size, cleanup, err := spoolAll(rc, SpoolMemoryLimit, "")
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = f.SetContent(content, size, ""); err == nil {
+		f.Attrs.Unset("mime.type")
+		if name := f.Attrs.Get("filename"); c.Extension != "" && strings.HasSuffix(name, c.Extension) {
+			f.Attrs.Set("filename", strings.TrimSuffix(name, c.Extension))
+		}
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestCompressContent(t *testing.T) {
+	dat := strings.Repeat("compressible content ", 1000)
+	f := stringFiles(dat)[0]
+	f.Attrs.Set("filename", "abc.txt")
+	if _, err := f.CompressContent("unknown"); !errors.Is(err, flowfile.ErrorUnknownCodec) {
+		t.Errorf("expecting ErrorUnknownCodec, got %v", err)
+	}
+
+	cleanup, err := f.CompressContent("zstd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+	if f.Size >= int64(len(dat)) || f.Attrs.Get("filename") != "abc.txt.zst" || f.Attrs.Get("mime.type") != "application/zstd" {
+		t.Fatalf("unexpected compressed File of %d bytes, %v", f.Size, f.Attrs)
+	}
+
+	if cleanup, err = f.DecompressContent(); err != nil {
+		t.Fatal(err)
+	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+	got, _ := io.ReadAll(f)
+	if string(got) != dat || f.Attrs.Get("filename") != "abc.txt" || f.Attrs.Get("mime.type") != "" {
+		t.Errorf("unexpected decompressed File of %d bytes, %v", len(got), f.Attrs)
+	}
+	if _, err = f.DecompressContent(); !errors.Is(err, flowfile.ErrorUnknownCodec) {
+		t.Errorf("expecting ErrorUnknownCodec without a mime.type, got %v", err)
+	}
+}
+
+func TestZstdCodec(t *testing.T) {
+	dat := []byte(strings.Repeat("compressible content ", 1000))
+	c := flowfile.NewZstdCodec(19, nil)
+	var buf bytes.Buffer
+	w, err := c.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(dat)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := flowfile.LookupCodec("ZSTD").NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, dat) {
+		t.Errorf("expecting the content back, got %d bytes %v", len(got), err)
+	}
+}
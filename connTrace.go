@@ -0,0 +1,63 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"time"
+)
+
+// ConnTrace describes the connection used for a handshake or POST, for
+// diagnosing connection reuse and where time is spent before the first byte
+// is sent.  Durations are zero when the step did not happen, such as when a
+// pooled connection was reused.
+type ConnTrace struct {
+	Method     string // HEAD for handshakes or POST for sends
+	RemoteAddr string
+	Reused     bool          // The connection was taken from the idle pool
+	WasIdle    bool          // The connection was idle before being reused
+	IdleTime   time.Duration // How long the connection sat idle
+	DNS        time.Duration // DNS lookup time
+	Connect    time.Duration // TCP connect time
+	TLS        time.Duration // TLS handshake time
+	GetConn    time.Duration // Time spent waiting for a connection
+	FirstByte  time.Duration // Time from start until the first response byte
+	Total      time.Duration // Time from start until the response headers
+	Err        error
+
+	start, dnsStart, connStart, tlsStart time.Time
+}
+
+// Attach an httptrace to the context which fills in the ConnTrace.
+func newConnTrace(ctx context.Context, method string) (context.Context, *ConnTrace) {
+	t := &ConnTrace{Method: method, start: DefaultClock.Now()}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.GetConn = DefaultClock.Now().Sub(t.start)
+			t.Reused, t.WasIdle, t.IdleTime = info.Reused, info.WasIdle, info.IdleTime
+			if info.Conn != nil {
+				t.RemoteAddr = info.Conn.RemoteAddr().String()
+			}
+		},
+		DNSStart: func(httptrace.DNSStartInfo) { t.dnsStart = DefaultClock.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.DNS = DefaultClock.Now().Sub(t.dnsStart) },
+		ConnectStart: func(string, string) {
+			if t.connStart.IsZero() {
+				t.connStart = DefaultClock.Now()
+			}
+		},
+		ConnectDone:          func(string, string, error) { t.Connect = DefaultClock.Now().Sub(t.connStart) },
+		TLSHandshakeStart:    func() { t.tlsStart = DefaultClock.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.TLS = DefaultClock.Now().Sub(t.tlsStart) },
+		GotFirstResponseByte: func() { t.FirstByte = DefaultClock.Now().Sub(t.start) },
+	}), t
+}
+
+// Close out the trace and hand it to the OnTrace callback.
+func (hs *HTTPTransaction) doneConnTrace(t *ConnTrace, err error) {
+	if t == nil || hs.OnTrace == nil {
+		return
+	}
+	t.Total, t.Err = DefaultClock.Now().Sub(t.start), err
+	hs.OnTrace(t)
+}
@@ -0,0 +1,34 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// DebugLog holds the debug settings of a component, such as an
+// HTTPTransaction, HTTPReceiver or Scanner, so one component can be debugged
+// without turning on the package wide Debug for every other flow.
+type DebugLog struct {
+	// Log the details of this component, the package wide Debug turns this on
+	// for every component.
+	Debug bool
+
+	// Where the debug output goes, log.Printf when nil.
+	Logf func(format string, v ...interface{})
+}
+
+func (d *DebugLog) debugf(format string, v ...interface{}) {
+	if !Debug && (d == nil || !d.Debug) {
+		return
+	}
+	if d != nil && d.Logf != nil {
+		d.Logf(format, v...)
+		return
+	}
+	log.Printf(format, v...)
+}
+
+func (d *DebugLog) debugln(v ...interface{}) {
+	d.debugf("%s", strings.TrimSuffix(fmt.Sprintln(v...), "\n"))
+}
@@ -0,0 +1,368 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A ContentStore keeps a single copy of each content saved by a Saver, keyed
+// by the checksum, for destinations which receive many duplicate payloads.  A
+// File with a checksum already in the store has the content read and verified
+// but not written again, the output is hard linked to the stored copy, or a
+// copy made from it when the filesystem has no hard links.
+//
+// An index maps the logical paths saved to the content they hold, so the
+// stored copy is removed once no path refers to it.  The index is a JSON-lines
+// file in the store directory which is compacted when opened.
+//
+//   store, err := flowfile.OpenContentStore(nil, "/data/.content")
+//   saver := flowfile.NewSaver("/data")
+//   saver.ContentStore = store
+//
+// Hard links share the owner, permissions and modification time, so the last
+// File saved with some content sets them for every path holding it.  The store
+// must be on the same filesystem as the paths for the links to be made.
+type ContentStore struct {
+	fsys  WritableFS
+	dir   string
+	mu    sync.Mutex
+	index WritableFile
+	paths map[string]ContentKey
+	refs  map[ContentKey]int
+
+	// The source of time for the temporary link names, defaults to the
+	// DefaultClock.
+	Clock Clock
+
+	// Debug output for this content store
+	DebugLog
+}
+
+// A ContentKey identifies content by the checksum type and checksum.
+type ContentKey struct {
+	Type     string `json:"checksumType"`
+	Checksum string `json:"checksum"`
+}
+
+// Return the key for the checksum in the attributes, ok is false when the
+// checksum is missing or the type is unknown
+func contentKey(attrs Attributes) (key ContentKey, ok bool) {
+	key = ContentKey{
+		Type:     strings.ToUpper(attrs.Get("checksumType")),
+		Checksum: strings.ToLower(attrs.Get("checksum")),
+	}
+	if len(key.Checksum) < 2 || strings.ContainsAny(key.Checksum, "/.") ||
+		getChecksumFunc(key.Type) == nil {
+		return ContentKey{}, false
+	}
+	return key, true
+}
+
+type contentRecord struct {
+	Path string      `json:"path"`
+	Key  *ContentKey `json:"content,omitempty"` // nil when the path is released
+}
+
+// Open or create a content store in the directory within fsys, loading the
+// index.  A nil fsys is the OS filesystem.
+func OpenContentStore(fsys WritableFS, dir string) (*ContentStore, error) {
+	if fsys == nil {
+		fsys = OSFS("")
+	}
+	c := &ContentStore{fsys: fsys, dir: dir,
+		paths: make(map[string]ContentKey), refs: make(map[ContentKey]int)}
+	if err := mkdirAllFS(fsys, dir, 0755); err != nil {
+		return nil, err
+	}
+	name := path.Join(dir, "index")
+	if fh, err := fsys.OpenFile(name, os.O_RDONLY, 0); err == nil {
+		scanner := bufio.NewScanner(fh)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var rec contentRecord
+			if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+				continue // A torn final line from a crash
+			}
+			if rec.Key == nil {
+				delete(c.paths, rec.Path)
+			} else {
+				c.paths[rec.Path] = *rec.Key
+			}
+		}
+		fh.Close()
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Compact the index down to the paths held
+	tmp := name + ".tmp"
+	fh, err := fsys.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for p := range c.paths {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	for _, p := range names {
+		key := c.paths[p]
+		c.refs[key]++
+		if err = c.write(fh, contentRecord{Path: p, Key: &key}); err != nil {
+			fh.Close()
+			return nil, err
+		}
+	}
+	if err = fsys.Rename(tmp, name); err != nil {
+		fh.Close()
+		return nil, err
+	}
+	c.index = fh
+	return c, nil
+}
+
+// Close the index, the stored content remains.
+func (c *ContentStore) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.index.Close()
+}
+
+func (c *ContentStore) clock() Clock {
+	return clockOr(c.Clock)
+}
+
+// Lookup returns the content held by a path saved through the store.
+func (c *ContentStore) Lookup(name string) (key ContentKey, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok = c.paths[name]
+	return
+}
+
+// Refs returns the number of paths holding the content.
+func (c *ContentStore) Refs(key ContentKey) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refs[key]
+}
+
+// Remove a path saved through the store, along with the stored content when
+// it was the last path holding it.
+func (c *ContentStore) Remove(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.paths[name]; !ok {
+		return c.fsys.Remove(name)
+	}
+	if err := c.fsys.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return c.record(name, nil)
+}
+
+// The name of the stored copy of the content
+func (c *ContentStore) object(key ContentKey) string {
+	return path.Join(c.dir, strings.ToLower(key.Type), key.Checksum[:2], key.Checksum)
+}
+
+func (c *ContentStore) has(key ContentKey) bool {
+	fi, err := c.fsys.Stat(c.object(key))
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// Whether the stored copy of the content is there and still of the size and
+// checksum, so a File is never linked to content changed in the store.  A
+// stored copy which does not match is removed.
+func (c *ContentStore) verified(key ContentKey, size int64) bool {
+	obj := c.object(key)
+	fh, err := c.fsys.OpenFile(obj, os.O_RDONLY, 0)
+	if err != nil {
+		return false
+	}
+	defer fh.Close()
+	fi, err := fh.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	if fi.Size() == size {
+		h := getChecksumFunc(key.Type)()
+		if _, err = copyBuffer(h, fh); err != nil {
+			return false
+		}
+		if fmt.Sprintf("%0x", h.Sum(nil)) == key.Checksum {
+			return true
+		}
+	}
+	c.debugln("Content store removing", obj, "as it does not match the checksum")
+	c.fsys.Remove(obj)
+	return false
+}
+
+// Whether the name is the store directory or within it, where a File saved
+// would replace the stored content.  Names are compared as the filesystem
+// resolves them.
+func (c *ContentStore) holds(name string) bool {
+	return withinDir(fsAbs(c.fsys, c.dir), fsAbs(c.fsys, name))
+}
+
+// Point the path at the content in the index, releasing what it held before.
+// Must be called with the lock held.
+func (c *ContentStore) record(name string, key *ContentKey) error {
+	old, held := c.paths[name]
+	if held && key != nil && old == *key {
+		return nil
+	}
+	if err := c.write(c.index, contentRecord{Path: name, Key: key}); err != nil {
+		return err
+	}
+	if held {
+		delete(c.paths, name)
+		if c.refs[old]--; c.refs[old] <= 0 {
+			delete(c.refs, old)
+			c.fsys.Remove(c.object(old))
+		}
+	}
+	if key != nil {
+		c.paths[name] = *key
+		c.refs[*key]++
+	}
+	return nil
+}
+
+func (c *ContentStore) write(fh WritableFile, rec contentRecord) error {
+	dat, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(append(dat, '\n'))
+	return err
+}
+
+// Hard link oldname to newname, or copy the content when the filesystem does
+// not support links or they cannot be made, such as across devices
+func (c *ContentStore) link(oldname, newname string) error {
+	if l, ok := c.fsys.(linkFS); ok {
+		if err := l.Link(oldname, newname); err == nil {
+			return nil
+		}
+	}
+	src, err := c.fsys.OpenFile(oldname, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := c.fsys.OpenFile(newname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = copyBuffer(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Add a verified output file to the store, the content is linked in when the
+// store does not already hold it
+func (c *ContentStore) add(name string, key ContentKey) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if obj := c.object(key); !c.has(key) {
+		if err := mkdirAllFS(c.fsys, path.Dir(obj), 0755); err != nil {
+			return err
+		}
+		if err := c.link(name, obj); err != nil {
+			c.fsys.Remove(obj)
+			return err
+		}
+	}
+	return c.record(name, &key)
+}
+
+// Release a path about to be written in place, such as by the Assembler, so
+// the stored content it is linked to is not written over
+func (c *ContentStore) release(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.paths[name]; !ok {
+		return nil
+	}
+	if err := c.fsys.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return c.record(name, nil)
+}
+
+// Note a path saved without going through the store, so the index no longer
+// points it at the content it held
+func (c *ContentStore) forget(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.paths[name]; !ok {
+		return nil
+	}
+	return c.record(name, nil)
+}
+
+// Save a File whose content is already in the store.  The content is read and
+// verified against the checksum, then the output is linked to the stored copy
+// by way of a temporary file so it replaces any existing output in one step.
+// Content failing the checksum has not been kept, so there is nothing to
+// quarantine and final is false.
+func (s *Saver) saveStored(f *File, key ContentKey, outputFile string) (final bool, tmp string, err error) {
+	h := f.Attrs.NewChecksumHash()
+	if _, err = copyBuffer(h, f); err != nil {
+		return
+	}
+	if fmt.Sprintf("%0x", h.Sum(nil)) != key.Checksum {
+		f.cksum, f.cksumStatus = h, cksumFailed
+		return false, "", ErrorChecksumMismatch
+	}
+	f.cksum, f.cksumStatus = h, cksumPassed
+
+	c := s.ContentStore
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tmp = partialPath(outputFile, c.clock().Now())
+	if err = c.link(c.object(key), tmp); err != nil {
+		return true, tmp, err
+	}
+	if err = s.fsys().Rename(tmp, outputFile); err != nil {
+		return true, tmp, err
+	}
+	if err = c.record(outputFile, &key); err != nil {
+		c.debugln("Content store index err:", err)
+	}
+	return true, "", nil
+}
+
+// Update the store with an output file saved by writing out the content
+func (s *Saver) storeContent(f *File, outputFile string) {
+	c := s.ContentStore
+	if c == nil {
+		return
+	}
+	var err error
+	if key, ok := contentKey(f.Attrs); ok && f.cksumStatus == cksumPassed {
+		err = c.add(outputFile, key)
+	} else {
+		err = c.forget(outputFile)
+	}
+	if err != nil {
+		c.debugln("Content store err:", err)
+	}
+}
@@ -0,0 +1,118 @@
+package flowfile_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestContentStore(t *testing.T) {
+	fsys := flowfile.NewMemFS()
+	store, err := flowfile.OpenContentStore(fsys, "data/.content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saver := flowfile.NewSaver("data")
+	saver.FS = fsys
+	saver.ContentStore = store
+
+	dat := []byte("abcdefghij")
+	save := func(name, checksum string) (string, error) {
+		f := checksummed(t, dat, checksum)
+		f.Attrs.Set("filename", name)
+		return saver.Save(f)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err = save(name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key, ok := store.Lookup("data/b.txt")
+	if !ok || store.Refs(key) != 2 {
+		t.Fatalf("expecting the content held by both paths, got %d", store.Refs(key))
+	}
+	stored := "data/.content/sha256/" + key.Checksum[:2] + "/" + key.Checksum
+	for _, name := range []string{"data/a.txt", "data/b.txt", stored} {
+		if got, err := fs.ReadFile(fsys, name); err != nil || string(got) != string(dat) {
+			t.Errorf("%s: expecting the content, got %q %v", name, got, err)
+		}
+	}
+
+	// Content not matching the stored checksum is refused
+	if _, err = save("c.txt", key.Checksum); err != nil {
+		t.Fatal(err)
+	}
+	dat = []byte("0123456789")
+	if _, err = save("d.txt", key.Checksum); !errors.Is(err, flowfile.ErrorChecksumMismatch) {
+		t.Errorf("expecting ErrorChecksumMismatch, got %v", err)
+	}
+	if _, err = fsys.Stat("data/d.txt"); err == nil {
+		t.Errorf("expecting the mismatched content not kept")
+	}
+
+	// The index is replayed when the store is reopened
+	store.Close()
+	if store, err = flowfile.OpenContentStore(fsys, "data/.content"); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.Refs(key); n != 3 {
+		t.Errorf("expecting 3 paths after reopening, got %d", n)
+	}
+	for _, name := range []string{"data/a.txt", "data/b.txt", "data/c.txt"} {
+		if err = store.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = fsys.Stat(stored); err == nil || store.Refs(key) != 0 {
+		t.Errorf("expecting the stored content removed with the last path")
+	}
+}
+
+func TestContentStorePoison(t *testing.T) {
+	fsys := flowfile.NewMemFS()
+	store, err := flowfile.OpenContentStore(fsys, "data/.content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saver := flowfile.NewSaver("data")
+	saver.FS = fsys
+	saver.ContentStore = store
+
+	good := []byte("good")
+	if _, err = saver.Save(checksummed(t, good, "")); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := store.Lookup("data/abc.txt")
+	stored := "data/.content/sha256/" + key.Checksum[:2] + "/" + key.Checksum
+
+	// A File cannot be saved over the stored content
+	evil := checksummed(t, []byte("evil"), "")
+	evil.Attrs.Set("path", ".content/sha256/"+key.Checksum[:2]+"/")
+	evil.Attrs.Set("filename", key.Checksum)
+	if _, err = saver.Save(evil); !errors.Is(err, flowfile.ErrorInvalidPath) {
+		t.Errorf("expecting ErrorInvalidPath, got %v", err)
+	}
+	if got, _ := fs.ReadFile(fsys, stored); string(got) != "good" {
+		t.Errorf("expecting the stored content kept, got %q", got)
+	}
+
+	// Stored content changed some other way is not linked to
+	fsys.Remove(stored)
+	if fh, err := fsys.OpenFile(stored, os.O_WRONLY|os.O_CREATE, 0644); err == nil {
+		fh.Write([]byte("evil"))
+		fh.Close()
+	}
+	f := checksummed(t, good, "")
+	f.Attrs.Set("filename", "again.txt")
+	if _, err = saver.Save(f); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"data/again.txt", stored} {
+		if got, _ := fs.ReadFile(fsys, name); string(got) != "good" {
+			t.Errorf("%s: expecting the good content, got %q", name, got)
+		}
+	}
+}
@@ -0,0 +1,526 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Delta transfers send only the blocks of a File which differ from the
+// content the receiver already holds for it, in the manner of rsync.  The
+// receiver advertises the x-flowfile-delta capability when it has a
+// DeltaBasis, the sender fetches the block signatures of the basis with a GET
+// carrying the DeltaSignatureHeader, and sends the File with its content
+// replaced by copy and literal operations and the DeltaSizeAttribute set.  The
+// receiver rebuilds the content from the basis before the handler sees the
+// File, and verifies it against the checksum of the whole File.
+const (
+	DeltaSizeAttribute          = "delta.size"
+	DeltaSignatureHeader        = "x-flowfile-delta-signature"
+	deltaSignatureType          = "application/x-flowfile-delta-signature"
+	deltaMaxLiteral             = 64 << 10
+	deltaOpCopy, deltaOpLiteral = 'C', 'L'
+)
+
+var (
+	// Block sizes used for signatures, a DeltaBlockSize of 0 picks the square
+	// root of the basis size bounded by these.
+	DeltaMinBlockSize = 2 << 10
+	DeltaMaxBlockSize = 1 << 20
+
+	// Files smaller than this are always sent whole, when the DeltaMinSize of
+	// the HTTPTransaction is 0.
+	DefaultDeltaMinSize int64 = 1 << 20
+)
+
+// A DeltaBasisFile is the content a receiver holds for a File, as opened by
+// the DeltaBasis of an HTTPReceiver, such as an *os.File.
+type DeltaBasisFile interface {
+	io.ReaderAt
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// DeltaBasis opens the file a File would be saved to, for use as the
+// HTTPReceiver DeltaBasis so delta transfers are made against the last copy
+// saved.
+//
+//   saver := flowfile.NewSaver("/data")
+//   ffReceiver.DeltaBasis = saver.DeltaBasis
+func (s *Saver) DeltaBasis(attrs Attributes) (DeltaBasisFile, error) {
+	if kind := attrs.Get("kind"); kind != "" && kind != "file" {
+		return nil, fs.ErrNotExist
+	}
+	if _, ok := attrs.lookup("segment.original.size"); ok {
+		return nil, fs.ErrNotExist // Segments are written in place
+	}
+	_, outputFile, err := s.outputPath(attrs)
+	if err != nil {
+		return nil, err
+	}
+	return s.fsys().OpenFile(outputFile, os.O_RDONLY, 0)
+}
+
+// The block signatures of a basis
+type deltaSignature struct {
+	blockSize int64
+	size      int64
+	weak      map[uint32][]int
+	strong    [][16]byte
+}
+
+// The rsync rolling checksum of a block
+func weakSum(p []byte) (a, b uint32) {
+	l := uint32(len(p))
+	for i, c := range p {
+		a += uint32(c)
+		b += (l - uint32(i)) * uint32(c)
+	}
+	return a & 0xffff, b & 0xffff
+}
+
+func strongSum(p []byte) (s [16]byte) {
+	sum := sha256.Sum256(p)
+	copy(s[:], sum[:])
+	return
+}
+
+// Write the signatures of the full blocks of the basis
+func writeSignature(w io.Writer, basis io.ReaderAt, size, blockSize int64) error {
+	if blockSize <= 0 {
+		blockSize = int64(math.Sqrt(float64(size)))
+		if blockSize < int64(DeltaMinBlockSize) {
+			blockSize = int64(DeltaMinBlockSize)
+		} else if blockSize > int64(DeltaMaxBlockSize) {
+			blockSize = int64(DeltaMaxBlockSize)
+		}
+	}
+	bw := bufio.NewWriter(w)
+	binary.Write(bw, binary.BigEndian, uint32(blockSize))
+	binary.Write(bw, binary.BigEndian, uint64(size))
+	buf := make([]byte, blockSize)
+	for off := int64(0); off+blockSize <= size; off += blockSize {
+		if _, err := basis.ReadAt(buf, off); err != nil {
+			return err
+		}
+		a, b := weakSum(buf)
+		binary.Write(bw, binary.BigEndian, a|b<<16)
+		s := strongSum(buf)
+		bw.Write(s[:])
+	}
+	return bw.Flush()
+}
+
+func readSignature(r io.Reader) (*deltaSignature, error) {
+	br := bufio.NewReader(r)
+	var hdr struct {
+		BlockSize uint32
+		Size      uint64
+	}
+	if err := binary.Read(br, binary.BigEndian, &hdr); err != nil {
+		return nil, err
+	}
+	if hdr.BlockSize == 0 {
+		return nil, fmt.Errorf("%w, zero block size", ErrorInvalidDelta)
+	}
+	sig := &deltaSignature{blockSize: int64(hdr.BlockSize), size: int64(hdr.Size), weak: make(map[uint32][]int)}
+	for i := int64(0); i < sig.size/sig.blockSize; i++ {
+		var weak uint32
+		var strong [16]byte
+		if err := binary.Read(br, binary.BigEndian, &weak); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(br, strong[:]); err != nil {
+			return nil, err
+		}
+		sig.weak[weak] = append(sig.weak[weak], len(sig.strong))
+		sig.strong = append(sig.strong, strong)
+	}
+	return sig, nil
+}
+
+// Write the operations rebuilding the content of r from the basis, returning
+// the bytes matched in the basis
+func writeDelta(w io.Writer, r io.Reader, sig *deltaSignature) (matched int64, err error) {
+	bw := bufio.NewWriter(w)
+	br := bufio.NewReaderSize(r, 256<<10)
+	L := int(sig.blockSize)
+
+	var copyOff, copyLen int64
+	flushCopy := func() {
+		if copyLen > 0 {
+			bw.WriteByte(deltaOpCopy)
+			binary.Write(bw, binary.BigEndian, uint64(copyOff))
+			binary.Write(bw, binary.BigEndian, uint64(copyLen))
+			matched += copyLen
+			copyLen = 0
+		}
+	}
+	flushLiteral := func(p []byte) {
+		if len(p) == 0 {
+			return
+		}
+		flushCopy()
+		bw.WriteByte(deltaOpLiteral)
+		binary.Write(bw, binary.BigEndian, uint32(len(p)))
+		bw.Write(p)
+	}
+
+	// The pending literal is buf[:pos] and the window is buf[pos:pos+L]
+	buf := make([]byte, 0, 2*deltaMaxLiteral+L)
+	pos := 0
+	var a, b uint32
+	summed := false
+	for {
+		for len(buf)-pos < L {
+			c, rerr := br.ReadByte()
+			if rerr == io.EOF {
+				break
+			} else if rerr != nil {
+				return matched, rerr
+			}
+			buf = append(buf, c)
+		}
+		if len(buf)-pos < L {
+			flushLiteral(buf) // The tail is shorter than a block
+			flushCopy()
+			return matched, bw.Flush()
+		}
+
+		if !summed {
+			a, b = weakSum(buf[pos : pos+L])
+			summed = true
+		}
+		if blocks, ok := sig.weak[a|b<<16]; ok {
+			strong := strongSum(buf[pos : pos+L])
+			for _, i := range blocks {
+				if sig.strong[i] != strong {
+					continue
+				}
+				flushLiteral(buf[:pos])
+				off := int64(i) * sig.blockSize
+				if copyLen == 0 || copyOff+copyLen != off {
+					flushCopy()
+					copyOff = off
+				}
+				copyLen += sig.blockSize
+				buf, pos, summed = buf[:copy(buf, buf[pos+L:])], 0, false
+				break
+			}
+			if !summed {
+				continue
+			}
+		}
+
+		// No match, roll the window on by a byte
+		c, rerr := br.ReadByte()
+		if rerr == io.EOF {
+			flushLiteral(buf)
+			flushCopy()
+			return matched, bw.Flush()
+		} else if rerr != nil {
+			return matched, rerr
+		}
+		buf = append(buf, c)
+		out := uint32(buf[pos])
+		a = (a - out + uint32(c)) & 0xffff
+		b = (b - uint32(L)*out + a) & 0xffff
+		if pos++; pos >= deltaMaxLiteral {
+			flushLiteral(buf[:pos])
+			buf, pos = buf[:copy(buf, buf[pos:])], 0
+		}
+	}
+}
+
+// Fetch the signatures of the content the receiver holds for the File, nil
+// when it holds none
+func (hs *HTTPTransaction) fetchSignature(ctx context.Context, s *handshakeResult, attrs Attributes) (*deltaSignature, error) {
+	// The receiver checks the attributes as those of the File to be sent
+	js, err := json.Marshal(attrs)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(DeltaSignatureHeader, string(js))
+	req.Header.Set("x-nifi-transaction-id", s.transactionID)
+	req.Header.Set("User-Agent", hs.userAgent())
+	if hs.Signer != nil {
+		if err = hs.Signer.Sign(req); err != nil {
+			return nil, err
+		}
+	}
+	res, err := hs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != deltaSignatureType:
+		return nil, &SendError{URL: s.url, TransactionID: s.transactionID, StatusCode: res.StatusCode,
+			Header: res.Header, Body: readErrorBody(res.Body)}
+	}
+	return readSignature(res.Body)
+}
+
+// Make a delta of the File when the receiver offers them, nil when the File
+// is to be sent whole.  The cleanup function, when not nil, must be called
+// once the delta has been sent.
+func (hw *HTTPPostWriter) delta(f *File) (d *File, cleanup func() error) {
+	hs := hw.hs
+	minSize := hs.DeltaMinSize
+	if minSize <= 0 {
+		minSize = DefaultDeltaMinSize
+	}
+	if !hs.Delta || !hw.session.capabilities.Delta || hw.batch != nil || f.Size < minSize ||
+		(f.ra == nil && f.filePath == "") || f.n != f.Size {
+		return nil, nil
+	}
+	if _, err := f.SegmentInfo(); err != ErrorNotSegment {
+		return nil, nil
+	}
+	if f.Attrs.Get("checksumType") == "" {
+		// The receiver verifies the rebuilt content with the checksum
+		if err := f.AddChecksum("SHA256"); err != nil {
+			return nil, nil
+		}
+	}
+
+	sig, err := hs.fetchSignature(hw.context(), hw.session, f.Attrs)
+	if sig == nil {
+		if err != nil {
+			hs.debugln("Unable to fetch delta signature:", err)
+		}
+		return nil, nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := writeDelta(pw, f, sig)
+		pw.CloseWithError(err)
+	}()
+	content, size, cleanup, err := spoolAll(pr, SpoolMemoryLimit, "")
+	pr.CloseWithError(err)
+	if rerr := f.Reset(); err == nil {
+		err = rerr
+	}
+	if err != nil || size >= f.Size {
+		if cleanup != nil {
+			cleanup()
+		}
+		hs.debugln("Sending whole, delta of", f.Attrs.Get("filename"), "is", size, "bytes, err:", err)
+		return nil, nil
+	}
+
+	hs.debugln("Sending delta of", f.Attrs.Get("filename"), "as", size, "of", f.Size, "bytes")
+	sinkOrNop(hs.MetricsSink).Counter("flowfiles_delta_saved_bytes_total", float64(f.Size-size))
+	d = New(content, size)
+	d.Attrs = f.Attrs.Clone()
+	d.Attrs.Set(DeltaSizeAttribute, strconv.FormatInt(f.Size, 10))
+	return d, cleanup
+}
+
+// Does the receiver serve signatures for delta transfers
+func (f *HTTPReceiver) offersDelta() bool {
+	return f.DeltaBasis != nil && f.DeltaSignatures
+}
+
+// Reply with the signatures of the content held for the attributes in the
+// DeltaSignatureHeader, which are checked as those of a File in a POST
+func (f *HTTPReceiver) serveSignature(w http.ResponseWriter, r *http.Request) {
+	var attrs Attributes
+	if err := json.Unmarshal([]byte(r.Header.Get(DeltaSignatureHeader)), &attrs); err != nil {
+		http.Error(w, "400 invalid attributes", http.StatusBadRequest)
+		return
+	}
+	err := f.checkPolicy(attrs, r)
+	if err == nil {
+		err = f.checkRequired(attrs)
+	}
+	if err == nil {
+		err = f.checkSchema(attrs)
+	}
+	var rej *RejectError
+	if errors.As(err, &rej) {
+		w.Header().Set("x-flowfile-reject-reason", rej.Reason)
+		http.Error(w, fmt.Sprintf("%d %s", rej.StatusCode, rej), rej.StatusCode)
+		return
+	}
+	basis, err := f.DeltaBasis(attrs)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, "404 no basis", http.StatusNotFound)
+		} else {
+			f.debugln("Unable to open delta basis:", err)
+			http.Error(w, "500 unable to open basis", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer basis.Close()
+	fi, err := basis.Stat()
+	if err != nil {
+		http.Error(w, "500 unable to open basis", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", deltaSignatureType)
+	w.Header().Set("Server", f.server())
+	if err = writeSignature(w, basis, fi.Size(), int64(f.DeltaBlockSize)); err != nil {
+		f.debugln("Unable to write delta signature:", err)
+	}
+}
+
+// Replace the content of a delta File with the content rebuilt from the basis
+func (f *HTTPReceiver) applyDelta(ff *File) error {
+	size, err := strconv.ParseInt(ff.Attrs.Get(DeltaSizeAttribute), 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("%w, bad %s %q", ErrorInvalidDelta, DeltaSizeAttribute, ff.Attrs.Get(DeltaSizeAttribute))
+	}
+	if f.DeltaBasis == nil {
+		return fmt.Errorf("%w, delta transfers are not offered", ErrorInvalidDelta)
+	}
+	h := ff.Attrs.NewChecksumHash()
+	if h == nil {
+		return fmt.Errorf("%w, missing checksum", ErrorInvalidDelta)
+	}
+	ff.Attrs.Unset(DeltaSizeAttribute)
+	basis, err := f.DeltaBasis(ff.Attrs)
+	if err != nil {
+		return err
+	}
+	fi, err := basis.Stat()
+	if err != nil {
+		basis.Close()
+		return err
+	}
+
+	ops := &File{}
+	*ops = *ff
+	ff.r, ff.ra, ff.i, ff.n, ff.Size = &deltaReader{
+		ops:       ops,
+		basis:     basis,
+		basisSize: fi.Size(),
+		remain:    size,
+		hash:      h,
+		checksum:  ff.Attrs.Get("checksum"),
+	}, nil, 0, size, size
+	return nil
+}
+
+// Rebuild content from the delta operations and the basis
+type deltaReader struct {
+	ops       *File
+	basis     DeltaBasisFile
+	basisSize int64
+	remain    int64 // bytes left to rebuild
+
+	op       byte
+	off, n   int64 // offset into the basis and bytes left of the operation
+	hash     hash.Hash
+	checksum string
+	err      error
+}
+
+func (d *deltaReader) Read(p []byte) (n int, err error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.remain <= 0 {
+		return 0, io.EOF
+	}
+	if d.n == 0 {
+		if err = d.next(); err != nil {
+			return 0, d.fail(err)
+		}
+	}
+	if int64(len(p)) > d.n {
+		p = p[:d.n]
+	}
+	if int64(len(p)) > d.remain {
+		return 0, d.fail(fmt.Errorf("%w, operations run past %s", ErrorInvalidDelta, DeltaSizeAttribute))
+	}
+	if d.op == deltaOpCopy {
+		n, err = d.basis.ReadAt(p, d.off)
+		d.off += int64(n)
+	} else {
+		n, err = d.ops.Read(p)
+	}
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	d.hash.Write(p[:n])
+	d.n -= int64(n)
+	d.remain -= int64(n)
+	if err != nil {
+		return n, d.fail(truncated(err, ErrorTruncatedStream))
+	}
+	if d.remain == 0 {
+		d.release()
+		if cerr := d.ops.Close(); cerr != nil {
+			return n, d.fail(cerr)
+		}
+		if fmt.Sprintf("%0x", d.hash.Sum(nil)) != d.checksum {
+			return n, d.fail(fmt.Errorf("%w, rebuilt from delta", ErrorChecksumMismatch))
+		}
+	}
+	return
+}
+
+// Read the next operation
+func (d *deltaReader) next() error {
+	var op [1]byte
+	if _, err := io.ReadFull(d.ops, op[:]); err != nil {
+		return truncated(err, ErrorTruncatedStream)
+	}
+	switch d.op = op[0]; d.op {
+	case deltaOpCopy:
+		var c struct{ Off, N uint64 }
+		if err := binary.Read(d.ops, binary.BigEndian, &c); err != nil {
+			return truncated(err, ErrorTruncatedStream)
+		}
+		if c.N == 0 || c.Off+c.N > uint64(d.basisSize) {
+			return fmt.Errorf("%w, copy of %d bytes at %d is outside the basis", ErrorInvalidDelta, c.N, c.Off)
+		}
+		d.off, d.n = int64(c.Off), int64(c.N)
+	case deltaOpLiteral:
+		var n uint32
+		if err := binary.Read(d.ops, binary.BigEndian, &n); err != nil {
+			return truncated(err, ErrorTruncatedStream)
+		}
+		if n == 0 {
+			return fmt.Errorf("%w, empty literal", ErrorInvalidDelta)
+		}
+		d.n = int64(n)
+	default:
+		return fmt.Errorf("%w, unknown operation %q", ErrorInvalidDelta, d.op)
+	}
+	return nil
+}
+
+func (d *deltaReader) fail(err error) error {
+	d.release()
+	d.err = err
+	return err
+}
+
+// Close the basis once it is no longer needed
+func (d *deltaReader) release() {
+	if d.basis != nil {
+		d.basis.Close()
+		d.basis = nil
+	}
+}
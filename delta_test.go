@@ -0,0 +1,146 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+// A receiver saving into a MemFS and offering deltas against what it saved
+func newDeltaReceiver(t *testing.T) (*flowfile.HTTPReceiver, *flowfile.MemFS, *httptest.Server) {
+	fsys := flowfile.NewMemFS()
+	saver := flowfile.NewSaver("data")
+	saver.FS = fsys
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		_, err := saver.Save(f)
+		return err
+	})
+	rcv.VerifyChecksum = true
+	rcv.DeltaBasis = saver.DeltaBasis
+	rcv.DeltaSignatures = true
+	ts := httptest.NewServer(rcv)
+	t.Cleanup(ts.Close)
+	return rcv, fsys, ts
+}
+
+// Send the content as a.bin in one POST, returning the bytes written for it
+func sendDelta(t *testing.T, url string, dat []byte) int64 {
+	t.Helper()
+	hs, err := flowfile.NewHTTPTransaction(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.Delta, hs.DeltaMinSize = true, 1
+	f := flowfile.New(bytes.NewReader(dat), int64(len(dat)))
+	f.Attrs.Set("filename", "a.bin")
+	f.Attrs.Set("project", "A")
+	w := hs.NewHTTPPostWriter()
+	n, err := w.Write(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDeltaTransfer(t *testing.T) {
+	_, fsys, ts := newDeltaReceiver(t)
+
+	v1 := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(v1)
+	if n := sendDelta(t, ts.URL, v1); n < int64(len(v1)) {
+		t.Fatalf("first copy sent as %d bytes, expecting it whole", n)
+	}
+
+	// Change a few bytes, insert some and cut some
+	v2 := append([]byte{}, v1[:1000]...)
+	v2 = append(v2, []byte("inserted")...)
+	v2 = append(v2, v1[1000:100<<10]...)
+	v2 = append(v2, v1[110<<10:]...)
+	copy(v2[200<<10:], "changed")
+
+	n := sendDelta(t, ts.URL, v2)
+	if n >= int64(len(v2))/4 {
+		t.Errorf("delta sent as %d bytes of %d", n, len(v2))
+	}
+	got, err := fs.ReadFile(fsys, "data/a.bin")
+	if err != nil || !bytes.Equal(got, v2) {
+		t.Errorf("rebuilt content does not match, %d of %d bytes, %v", len(got), len(v2), err)
+	}
+}
+
+// Request the signatures of the content held for the attributes
+func getSignature(t *testing.T, url string, attrs flowfile.Attributes) *http.Response {
+	t.Helper()
+	js, _ := json.Marshal(attrs)
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set(flowfile.DeltaSignatureHeader, string(js))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestDeltaSignatureOptIn(t *testing.T) {
+	rcv, _, ts := newDeltaReceiver(t)
+	sendDelta(t, ts.URL, make([]byte, 64<<10))
+
+	var attrs flowfile.Attributes
+	attrs.Set("filename", "a.bin")
+	attrs.Set("project", "A")
+	if res := getSignature(t, ts.URL, attrs); res.StatusCode != http.StatusOK {
+		t.Fatalf("expecting the signature with DeltaSignatures set, got %d", res.StatusCode)
+	}
+
+	rcv.DeltaSignatures = false
+	if res := getSignature(t, ts.URL, attrs); res.Header.Get("Content-Type") == "application/x-flowfile-delta-signature" {
+		t.Errorf("signature served without DeltaSignatures set")
+	}
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hs.Capabilities.Delta {
+		t.Errorf("delta advertised without DeltaSignatures set")
+	}
+}
+
+func TestDeltaSignatureChecks(t *testing.T) {
+	rcv, _, ts := newDeltaReceiver(t)
+	sendDelta(t, ts.URL, make([]byte, 64<<10))
+
+	var attrs flowfile.Attributes
+	attrs.Set("filename", "a.bin")
+	attrs.Set("project", "A")
+
+	rule, err := flowfile.NewPolicyRule("projectA", flowfile.PolicyAllow, "", "project=^A$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcv.Policy = &flowfile.Policy{Rules: []flowfile.PolicyRule{rule}}
+	if res := getSignature(t, ts.URL, attrs); res.StatusCode != http.StatusOK {
+		t.Errorf("expecting the signature allowed by the Policy, got %d", res.StatusCode)
+	}
+	other := attrs.Clone()
+	other.Set("project", "B")
+	if res := getSignature(t, ts.URL, other); res.StatusCode != http.StatusForbidden {
+		t.Errorf("expecting a 403 from the Policy, got %d", res.StatusCode)
+	}
+
+	rcv.Policy = nil
+	rcv.Require("classification", "")
+	if res := getSignature(t, ts.URL, attrs); res.StatusCode != http.StatusNotAcceptable ||
+		res.Header.Get("x-flowfile-reject-reason") != "required-attribute" {
+		t.Errorf("expecting a 406 for the missing attribute, got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,156 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// A Dispatcher drains a Scanner and hands each File off to a bounded pool of
+// workers.  As the Scanner must advance past the current File before the next
+// one can be read, the content of each File is buffered in memory, or spooled
+// to a temporary file when larger than MemoryLimit, before being handed off.
+//
+// The File given to the handler is resettable, and if a checksum was provided
+// by the sender it has already been verified, so the handler can call Verify
+// to get the result.
+type Dispatcher struct {
+	Workers     int    // Number of concurrent handlers
+	MemoryLimit int64  // Files larger than this are spooled to disk
+	TempDir     string // Directory for spooling, os.TempDir() when empty
+
+	// Debug output for this dispatcher
+	DebugLog
+}
+
+// Create a new Dispatcher with a given number of workers and a default
+// in-memory limit of 1MB per File.
+func NewDispatcher(workers int) *Dispatcher {
+	return &Dispatcher{
+		Workers:     workers,
+		MemoryLimit: 1 << 20,
+	}
+}
+
+// Dispatch scans all the Files from the Scanner and calls the handler for each
+// one concurrently.  The first error returned by a handler stops the
+// dispatching of further Files and is returned once all the running handlers
+// have finished.
+func (d *Dispatcher) Dispatch(s *Scanner, handler func(*File) error) (err error) {
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		failed  = make(chan struct{})
+		sem     = make(chan struct{}, workers)
+	)
+	setErr := func(e error) {
+		errOnce.Do(func() {
+			err = e
+			close(failed)
+		})
+	}
+
+scan:
+	for s.Scan() {
+		// Wait for a free worker before reading in more content
+		select {
+		case sem <- struct{}{}:
+		case <-failed:
+			break scan
+		}
+
+		f, cleanup, spoolErr := d.spoolFile(s.File())
+		if spoolErr != nil {
+			<-sem
+			setErr(spoolErr)
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				if cleanup != nil {
+					cleanup()
+				}
+				<-sem
+				wg.Done()
+			}()
+			if e := handler(f); e != nil {
+				setErr(e)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err == nil {
+		err = s.Err()
+	}
+	return
+}
+
+// Read in the whole payload of a File so the stream can advance.
+func (d *Dispatcher) spoolFile(in *File) (f *File, cleanup func() error, err error) {
+	var ra io.ReaderAt
+	if ra, cleanup, err = spool(in, in.Size, d.MemoryLimit, d.TempDir); err != nil {
+		return
+	}
+
+	// Finish the checksum while the content is fresh
+	if in.cksumStatus == cksumInit {
+		if err := in.Verify(); err != nil {
+			d.debugln("Dispatch verify failed for", in.Attrs.Get("filename"), err)
+		}
+	}
+
+	f = &File{
+		Attrs:       in.Attrs,
+		Size:        in.Size,
+		n:           in.Size,
+		ra:          ra,
+		cksumStatus: in.cksumStatus,
+		cksum:       in.cksum,
+	}
+	return
+}
+
+// Read size bytes from a reader into memory, or into a temporary file if the
+// size is over the limit.  The cleanup function must be called to release the
+// temporary file when done.
+func spool(r io.Reader, size, limit int64, dir string) (ra io.ReaderAt, cleanup func() error, err error) {
+	if size < 0 {
+		return nil, nil, fmt.Errorf("%w: size of %d bytes", ErrorInvalidFile, size)
+	}
+	if size <= limit {
+		buf := make([]byte, size)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return
+		}
+		return bytes.NewReader(buf), nil, nil
+	}
+
+	var fh *os.File
+	if fh, err = os.CreateTemp(dir, "flowfile-spool-*"); err != nil {
+		return
+	}
+	cleanup = func() error {
+		fh.Close()
+		return os.Remove(fh.Name())
+	}
+
+	var n int64
+	if n, err = copyBuffer(fh, io.LimitReader(r, size)); err == nil && n != size {
+		err = fmt.Errorf("%w while spooling, %d of %d bytes", ErrorShortRead, n, size)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return fh, cleanup, nil
+}
@@ -0,0 +1,109 @@
+package flowfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSpoolNegativeSize(t *testing.T) {
+	for _, limit := range []int64{0, 1 << 20} {
+		ra, cleanup, err := spool(strings.NewReader("abc"), -1, limit, t.TempDir())
+		if !errors.Is(err, ErrorInvalidFile) || ra != nil || cleanup != nil {
+			t.Errorf("limit %d: expecting ErrorInvalidFile, got %v", limit, err)
+		}
+	}
+}
+
+// A stream of Files named by their content
+func dispatchStream(t *testing.T, contents ...string) *Scanner {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, c := range contents {
+		f := New(strings.NewReader(c), int64(len(c)))
+		f.Attrs.Set("filename", c[:1])
+		if err := f.AddChecksum("SHA256"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewScanner(&buf)
+}
+
+func TestDispatcher(t *testing.T) {
+	dir := t.TempDir()
+	d := NewDispatcher(2)
+	d.MemoryLimit, d.TempDir = 5, dir
+
+	contents := []string{"abc", "bcdefghij", "cd", "defghijklmnop"}
+	var mu sync.Mutex
+	got := map[string]string{}
+	var running, most int
+	both := make(chan struct{})
+	var once sync.Once
+	err := d.Dispatch(dispatchStream(t, contents...), func(f *File) error {
+		mu.Lock()
+		if running++; running > most {
+			most = running
+		}
+		if running == 2 {
+			once.Do(func() { close(both) })
+		}
+		mu.Unlock()
+		select { // Hold the first handlers until both workers are busy
+		case <-both:
+		case <-time.After(time.Second):
+		}
+
+		dat, err := io.ReadAll(f)
+		if err == nil {
+			err = f.Reset() // The content is held for reading again
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		running--
+		got[f.Attrs.Get("filename")] = string(dat)
+		if err == nil {
+			err = f.Verify()
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if most != 2 {
+		t.Errorf("expecting the two workers run at once, got %d", most)
+	}
+	for _, c := range contents {
+		if got[c[:1]] != c {
+			t.Errorf("expecting %q handled, got %q", c, got[c[:1]])
+		}
+	}
+	if spooled, _ := os.ReadDir(dir); len(spooled) != 0 {
+		t.Errorf("expecting the spooled Files removed, got %d", len(spooled))
+	}
+
+	// A failed handler stops the dispatch
+	fail := errors.New("fail")
+	many := make([]string, 50)
+	for i := range many {
+		many[i] = "abc"
+	}
+	var handled atomic.Int32
+	err = NewDispatcher(1).Dispatch(dispatchStream(t, many...), func(f *File) error {
+		handled.Add(1)
+		return fail
+	})
+	if !errors.Is(err, fail) || handled.Load() == int32(len(many)) {
+		t.Errorf("expecting the dispatch stopped at the first error, got %d %v", handled.Load(), err)
+	}
+}
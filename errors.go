@@ -0,0 +1,121 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors which can be tested for with errors.Is to build error handling
+// policies.
+var (
+	ErrorNoResponse        = errors.New("File did not send, no response")
+	ErrorUnexpectedStatus  = errors.New("Unexpected status code")
+	ErrorMethodNotAllowed  = errors.New("Method not allowed, make sure the remote server accepts flowfile-v3")
+	ErrorNoFlowFileSupport = errors.New("Server does not support flowfile-v3")
+	ErrorProtocolVersion   = errors.New("Unknown NiFi TransferVersion")
+	ErrorTransactionClosed = errors.New("HTTPTransaction Closed")
+	ErrorPostTerminated    = errors.New("Post Terminated")
+	ErrorPostIncomplete    = errors.New("POST did not complete")
+	ErrorSignerCredentials = errors.New("Missing signing credentials")
+	ErrorPinMismatch       = errors.New("Certificate does not match a pinned key")
+	ErrorInvalidPin        = errors.New("Invalid pin, expecting a SHA-256 hash")
+
+	ErrorChecksumType  = errors.New("Unable to find checksum type")
+	ErrorNeedReadAt    = errors.New("Reader must implement a ReadAt interface")
+	ErrorMissingReader = errors.New("Missing underlying reader")
+	ErrorShortRead     = errors.New("Short read")
+	ErrorInvalidPath   = errors.New("Invalid path")
+	ErrorInvalidFile   = errors.New("Invalid file")
+	ErrorUnknownKind   = errors.New("Unknown kind")
+	ErrorSymlink       = errors.New("Symlink not allowed")
+	ErrorExpired       = errors.New("File expired")
+	ErrorSuppressed    = errors.New("File recently sent")
+
+	ErrorNotFIPSApproved     = errors.New("Not a FIPS approved algorithm")
+	ErrorUnsupportedEncoding = errors.New("Unsupported content encoding")
+	ErrorSchedulerClosed     = errors.New("Scheduler closed")
+	ErrorUnknownCodec        = errors.New("Unknown codec")
+	ErrorInvalidDelta        = errors.New("Invalid delta")
+	ErrorNoOwner             = errors.New("No owner mapped for File")
+	ErrorInvalidTime         = errors.New("Invalid time")
+)
+
+// A HandshakeError is returned when the remote server replies to the
+// handshake with an unexpected status code.
+type HandshakeError struct {
+	URL        string
+	StatusCode int
+	Err        error // ErrorMethodNotAllowed or ErrorUnexpectedStatus
+}
+
+func (e *HandshakeError) Error() string {
+	if e.Err == ErrorUnexpectedStatus {
+		return fmt.Sprintf("Unexpected status code %d", e.StatusCode)
+	}
+	return e.Err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error { return e.Err }
+
+// A SendError is returned when the remote server replies to a POST with a
+// status code other than 200, all the Files in the POST should be considered
+// as not sent.  The Header and the start of the Body of the reply are kept so
+// the reason given by the server can be logged.
+type SendError struct {
+	URL           string
+	TransactionID string
+	StatusCode    int
+	Header        http.Header
+	Body          []byte // up to MaxErrorBodySize bytes of the reply
+}
+
+// The most of a failed reply body kept in a SendError
+var MaxErrorBodySize int64 = 4 << 10
+
+func (e *SendError) Error() string {
+	if msg := strings.TrimSpace(string(e.Body)); msg != "" {
+		if i := strings.IndexByte(msg, '\n'); i >= 0 {
+			msg = strings.TrimSpace(msg[:i])
+		}
+		return fmt.Sprintf("File did not send successfully, code %d: %s", e.StatusCode, msg)
+	}
+	return fmt.Sprintf("File did not send successfully, code %d", e.StatusCode)
+}
+
+// RetryAfter returns the delay asked for by the Retry-After header of the
+// reply, or zero when none was given.
+func (e *SendError) RetryAfter() time.Duration {
+	return e.retryAfter(DefaultClock.Now())
+}
+
+func (e *SendError) retryAfter(now time.Time) time.Duration {
+	v := e.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// Read the start of a reply body for a SendError
+func readErrorBody(r io.Reader) []byte {
+	if r == nil || MaxErrorBodySize <= 0 {
+		return nil
+	}
+	b, _ := io.ReadAll(io.LimitReader(r, MaxErrorBodySize))
+	return b
+}
+
+func (e *SendError) Unwrap() error { return ErrorUnexpectedStatus }
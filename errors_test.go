@@ -0,0 +1,89 @@
+package flowfile_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestHandshakeErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		status  int
+		accept  string
+		version string
+		err     error
+		code    int
+	}{
+		{"not allowed", http.StatusMethodNotAllowed, "", "", flowfile.ErrorMethodNotAllowed, 405},
+		{"server error", http.StatusInternalServerError, "", "", flowfile.ErrorUnexpectedStatus, 500},
+		{"no flowfile", http.StatusOK, "text/plain", "3", flowfile.ErrorNoFlowFileSupport, 0},
+		{"version", http.StatusOK, "application/flowfile-v3", "2", flowfile.ErrorProtocolVersion, 0},
+	} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Accept", tc.accept)
+			w.Header().Set("x-nifi-transfer-protocol-version", tc.version)
+			w.WriteHeader(tc.status)
+		}))
+		_, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+		ts.Close()
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: expecting %v, got %v", tc.name, tc.err, err)
+		}
+		var he *flowfile.HandshakeError
+		if errors.As(err, &he) != (tc.code != 0) || tc.code != 0 && (he.StatusCode != tc.code || he.URL != ts.URL) {
+			t.Errorf("%s: expecting a HandshakeError with code %d, got %#v", tc.name, tc.code, err)
+		}
+	}
+}
+
+func TestSendErrorStatus(t *testing.T) {
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		io.Copy(io.Discard, f)
+		return errors.New("disk full")
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = hs.Send(stringFiles("abc")...)
+	var se *flowfile.SendError
+	if !errors.As(err, &se) || !errors.Is(err, flowfile.ErrorUnexpectedStatus) {
+		t.Fatalf("expecting a SendError, got %#v", err)
+	}
+	if se.StatusCode != http.StatusNotAcceptable || se.URL != ts.URL || se.TransactionID != hs.TransactionID {
+		t.Errorf("expecting the details of the refused POST, got %+v", se)
+	}
+}
+
+func TestErrorSentinels(t *testing.T) {
+	f := flowfile.New(io.MultiReader(strings.NewReader("abc")), 3) // Without ReadAt
+	if err := f.AddChecksum("CRC7"); !errors.Is(err, flowfile.ErrorChecksumType) {
+		t.Errorf("expecting ErrorChecksumType, got %v", err)
+	}
+	if err := f.AddChecksum("SHA256"); !errors.Is(err, flowfile.ErrorNeedReadAt) {
+		t.Errorf("expecting ErrorNeedReadAt, got %v", err)
+	}
+	if _, err := flowfile.SegmentBySize(f, 1); !errors.Is(err, flowfile.ErrorNeedReadAt) {
+		t.Errorf("expecting ErrorNeedReadAt to segment, got %v", err)
+	}
+
+	f = stringFiles("abc")[0]
+	f.Attrs.Set("path", "../../etc/")
+	if _, err := f.Save(t.TempDir()); !errors.Is(err, flowfile.ErrorInvalidPath) {
+		t.Errorf("expecting ErrorInvalidPath, got %v", err)
+	}
+	f = stringFiles("abc")[0]
+	f.Attrs.Set("kind", "socket")
+	if _, err := f.Save(t.TempDir()); !errors.Is(err, flowfile.ErrorUnknownKind) {
+		t.Errorf("expecting ErrorUnknownKind, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A FileEvent is the summary of a File received by an HTTPReceiver, as
+// streamed to the clients of the EventsHandler.
+type FileEvent struct {
+	ID       uint64    `json:"id"` // Sequence in the order received
+	UUID     string    `json:"uuid,omitempty"`
+	Filename string    `json:"filename,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Size     int64     `json:"size"`
+	Verified string    `json:"verified"`           // passed, failed or unverified
+	Rejected string    `json:"rejected,omitempty"` // The reason the File was refused
+	Received time.Time `json:"received"`
+}
+
+// The number of events held for a client of the EventsHandler, a client
+// falling further behind misses the events which do not fit.
+var EventsBuffer = 64
+
+// How often a comment is sent to the clients of the EventsHandler when there
+// are no events, so idle connections are not closed by proxies.
+var EventsKeepAlive = 15 * time.Second
+
+// EventsHandler streams a FileEvent for each File the receiver takes in, as
+// server-sent events, so dashboards and downstream triggers can react to
+// Files as they arrive without polling the metrics.  Each File is sent as an
+// event named file with the FileEvent in JSON as the data.
+//
+//   http.Handle("/events", ffReceiver.EventsHandler())
+//
+// A browser can then follow the feed with:
+//
+//   new EventSource("/events").addEventListener("file", e => console.log(JSON.parse(e.data)))
+func (f *HTTPReceiver) EventsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "500 streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		ch := f.events.subscribe()
+		defer f.events.unsubscribe(ch)
+
+		hdr := w.Header()
+		hdr.Set("Content-Type", "text/event-stream")
+		hdr.Set("Cache-Control", "no-store")
+		hdr.Set("X-Accel-Buffering", "no") // Keep nginx from holding the events
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := f.clock().NewTicker(EventsKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C():
+				if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case ev := <-ch:
+				dat, err := json.Marshal(ev)
+				if err != nil {
+					return
+				}
+				if _, err = fmt.Fprintf(w, "id: %d\nevent: file\ndata: %s\n\n", ev.ID, dat); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// Send the event of a File done with to the clients of the EventsHandler
+func (f *HTTPReceiver) fileEvent(ff *File, err error) {
+	if !f.events.listening() {
+		return
+	}
+	ev := FileEvent{
+		UUID:     ff.Attrs.Get("uuid"),
+		Filename: ff.Attrs.Get("filename"),
+		Path:     ff.Attrs.Get("path"),
+		Size:     ff.Size,
+		Verified: "unverified",
+		Received: f.clock().Now(),
+	}
+	switch {
+	case ff.cksumStatus == cksumPassed:
+		ev.Verified = "passed"
+	case ff.cksumStatus == cksumFailed:
+		ev.Verified = "failed"
+	}
+	var rej *RejectError
+	if errors.As(err, &rej) {
+		ev.Rejected = rej.Reason
+	} else if err != nil {
+		ev.Rejected = err.Error()
+	}
+	f.events.publish(ev)
+}
+
+// The clients of the EventsHandler
+type eventHub struct {
+	mu   sync.Mutex
+	seq  uint64
+	subs map[chan FileEvent]struct{}
+}
+
+func (h *eventHub) subscribe() chan FileEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[chan FileEvent]struct{})
+	}
+	ch := make(chan FileEvent, EventsBuffer)
+	h.subs[ch] = struct{}{}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan FileEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, ch)
+}
+
+func (h *eventHub) listening() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs) > 0
+}
+
+// Hand the event to each client, never blocking on a slow one
+func (h *eventHub) publish(ev FileEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	ev.ID = h.seq
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
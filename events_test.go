@@ -0,0 +1,61 @@
+package flowfile_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestEventsHandler(t *testing.T) {
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	rcv.VerifyChecksum = true
+	rcv.Require("filename", "^ok")
+	mux := http.NewServeMux()
+	mux.Handle("/contentListener", rcv)
+	mux.Handle("/events", rcv.EventsHandler())
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// The client is subscribed once the reply headers are in
+	res, err := http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expecting an event stream, got %q", ct)
+	}
+
+	ff := stringFiles("ok1", "bad")
+	ff[0].AddChecksum("SHA256")
+	postRaw(t, ts.URL+"/contentListener", ff[0])
+	postRaw(t, ts.URL+"/contentListener", ff[1])
+
+	var events []flowfile.FileEvent
+	sc := bufio.NewScanner(res.Body)
+	for len(events) < 2 && sc.Scan() {
+		if dat, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+			var ev flowfile.FileEvent
+			if err = json.Unmarshal([]byte(dat), &ev); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, ev)
+		}
+	}
+	if len(events) != 2 {
+		t.Fatalf("expecting 2 events, got %d, %v", len(events), sc.Err())
+	}
+	if ev := events[0]; ev.ID != 1 || ev.Filename != "ok1.txt" || ev.Verified != "passed" || ev.Rejected != "" || ev.Size != 3 {
+		t.Errorf("unexpected event for the accepted File, %+v", ev)
+	}
+	if ev := events[1]; ev.ID != 2 || ev.Filename != "bad.txt" || ev.Rejected != "required-attribute" {
+		t.Errorf("unexpected event for the rejected File, %+v", ev)
+	}
+}
@@ -0,0 +1,38 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import "time"
+
+// ExpiryAttribute holds the time after which a File is stale, in RFC3339.
+// Senders pass over expired Files and receivers reject them, so stale data is
+// not carried along a long chain of retries and relays.
+const ExpiryAttribute = "flowfile.expiry"
+
+// SetExpiry sets the time after which the File is stale.
+func (h *Attributes) SetExpiry(t time.Time) *Attributes {
+	return h.Set(ExpiryAttribute, t.UTC().Format(time.RFC3339))
+}
+
+// SetTTL sets the File to go stale after d from now, by the DefaultClock, use
+// SetExpiry for the time of another Clock.
+func (h *Attributes) SetTTL(d time.Duration) *Attributes {
+	return h.SetExpiry(DefaultClock.Now().Add(d))
+}
+
+// Expiry returns the time after which the File is stale, false when it has no
+// expiry or the expiry cannot be parsed.
+func (h Attributes) Expiry() (time.Time, bool) {
+	return h.GetTime(ExpiryAttribute)
+}
+
+// Expired returns whether the File is past its expiry by the DefaultClock, a
+// File without one never expires.
+func (f *File) Expired() bool {
+	return f.ExpiredAt(DefaultClock.Now())
+}
+
+// ExpiredAt returns whether the File is past its expiry at the time, such as
+// the time of a Clock.
+func (f *File) ExpiredAt(now time.Time) bool {
+	t, ok := f.Attrs.Expiry()
+	return ok && now.After(t)
+}
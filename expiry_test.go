@@ -0,0 +1,62 @@
+package flowfile_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestExpiryReceiver(t *testing.T) {
+	rcv, ts := newReadingReceiver(t)
+	var expired []string
+	rcv.OnExpired = func(f *flowfile.File, r *http.Request) { expired = append(expired, f.Attrs.Get("filename")) }
+
+	ff := stringFiles("stale", "fresh")
+	ff[0].Attrs.SetExpiry(time.Now().Add(-time.Minute))
+	ff[1].Attrs.SetTTL(time.Hour)
+	expectReject(t, postRaw(t, ts.URL, ff[0]), http.StatusGone, "expired")
+	if res := postRaw(t, ts.URL, ff[1]); res.StatusCode != http.StatusOK {
+		t.Errorf("expecting a 200 for a File within its TTL, got %d", res.StatusCode)
+	}
+	if len(expired) != 1 || expired[0] != "stale.txt" {
+		t.Errorf("expecting OnExpired for the stale File, got %q", expired)
+	}
+}
+
+func TestExpirySender(t *testing.T) {
+	var got []string
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		got = append(got, f.Attrs.Get("filename"))
+		return nil
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var passed []string
+	hs.OnExpired = func(f *flowfile.File) { passed = append(passed, f.Attrs.Get("filename")) }
+
+	ff := stringFiles("stale", "fresh")
+	ff[0].Attrs.SetExpiry(time.Now().Add(-time.Minute))
+	if err = hs.Send(ff...); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "fresh.txt" || len(passed) != 1 || passed[0] != "stale.txt" {
+		t.Errorf("expecting the stale File passed over, sent %q and passed over %q", got, passed)
+	}
+
+	// The writer refuses the File outright
+	ff = stringFiles("stale")
+	ff[0].Attrs.SetExpiry(time.Now().Add(-time.Minute))
+	w := hs.NewHTTPPostWriter()
+	if _, err = w.Write(ff[0]); !errors.Is(err, flowfile.ErrorExpired) {
+		t.Errorf("expecting ErrorExpired, got %v", err)
+	}
+	w.Close()
+}
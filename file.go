@@ -88,18 +88,22 @@
 package flowfile // import "github.com/pschou/go-flowfile"
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"io/fs"
 	"io/ioutil"
-	"log"
 	"os"
 )
 
+// The default identification strings, used by an HTTPTransaction without a
+// UserAgent and an HTTPReceiver without a Server.
 var (
 	UserAgent   = "NiFi FlowFile Client (github.com/pschou/go-flowfile)"
 	AboutString = "NiFi FlowFile Server (github.com/pschou/go-flowfile)"
-	Debug       = false
+	Debug       = false // Debug output from every component, see DebugLog
 )
 
 // A File is a handler for either an incoming datafeed or outgoing datafeed
@@ -120,18 +124,27 @@ type File struct {
 
 	// If a ReadFile is called
 	filePath     string      // path to file on disk
+	fsys         fs.FS       // filesystem holding filePath, the OS when nil
 	fileInfo     os.FileInfo // information about the file
 	fileAutoOpen bool
 
 	// Checksum holder for post-stream checksum verification
 	cksumStatus int8
 	cksum       hash.Hash
+
+	debug *DebugLog // of the Scanner which read the File, if any
 }
 
 // Create a new File struct from an io.Reader with size.  One should add
 // attributes before writing it to a stream.
+//
+// A bytes.Buffer is read in place, without draining the buffer, so the File
+// can be Reset for retries.
 func New(r io.Reader, size int64) *File {
 	f := &File{n: size, Size: size}
+	if b, ok := r.(*bytes.Buffer); ok {
+		r = bytes.NewReader(b.Bytes())
+	}
 	if rs, ok := r.(io.ReadSeeker); ok {
 		f.i, _ = rs.Seek(0, io.SeekCurrent)
 	}
@@ -143,19 +156,63 @@ func New(r io.Reader, size int64) *File {
 	return f
 }
 
-// If the flowfile has a ReaderAt interface, one can reset the
-// reader to the start for reading again
+// SetContent replaces the content of the File with size bytes from r, keeping
+// the attributes, so transform pipelines don't need to build a new File.  The
+// attributes describing the old content are updated: when mimeType is not
+// empty the mime.type is set, and a checksum is recomputed with the same
+// checksumType when r is a ReaderAt, or otherwise removed as it can no longer
+// be verified.
+func (f *File) SetContent(r io.Reader, size int64, mimeType string) error {
+	if f.fileAutoOpen { // Make sure the old file is closed if auto opened
+		f.fileAutoOpen = false
+		f.ra.(io.Closer).Close()
+	}
+	nf := New(r, size)
+	f.r, f.ra, f.i, f.n, f.Size = nf.r, nf.ra, nf.i, nf.n, nf.Size
+	f.filePath, f.fsys, f.fileInfo = "", nil, nil
+	f.cksumStatus, f.cksum = cksumPreinit, nil
+
+	f.Attrs.Unset("sparse.extents")
+	if mimeType != "" {
+		f.Attrs.Set("mime.type", mimeType)
+	}
+	ct := f.Attrs.Get("checksumType")
+	f.Attrs.Unset("checksumType")
+	f.Attrs.Unset("checksum")
+	if ct != "" && f.ra != nil {
+		return f.AddChecksum(ct)
+	}
+	return nil
+}
+
+// ErrorNotResettable is returned by Reset when the content has already been
+// read from a stream and cannot be read again, so retry logic can tell apart
+// a payload which cannot be retried from a transient failure.
+var ErrorNotResettable = errors.New("Unable to Reset a non-ReadAt reader")
+
+// Reset moves the reader back to the start of the content for reading again.
+// This works for Files with a ReaderAt (such as files on disk, buffered or
+// spooled content) and Files with a Seeker.  A streamed File cannot be read
+// again, so ErrorNotResettable is returned even before any content is read.
 func (f *File) Reset() error {
 	if f.Size == 0 {
 		return nil
-	} else if f.ra != nil || f.filePath != "" {
-		f.i, f.n = f.i+f.n-f.Size, f.Size
-		return nil
 	}
-	if Debug {
-		fmt.Printf("Reset called on %#v\n", f.Attrs.Get("filename"))
+	switch rs, ok := f.r.(io.Seeker); {
+	case f.ra != nil || f.filePath != "":
+	case ok:
+		if _, err := rs.Seek(f.n-f.Size, io.SeekCurrent); err != nil {
+			return fmt.Errorf("%w: %s", ErrorNotResettable, err)
+		}
+	default:
+		f.debug.debugf("Reset called on %q", f.Attrs.Get("filename"))
+		return ErrorNotResettable
+	}
+	f.i, f.n = f.i+f.n-f.Size, f.Size
+	if f.cksumStatus == cksumInit {
+		f.cksum.Reset() // The content will be hashed again
 	}
-	return fmt.Errorf("Unable to Reset a non-ReadAt reader")
+	return nil
 }
 
 // Read will read the content from a FlowFile
@@ -163,14 +220,14 @@ func (l *File) Read(p []byte) (n int, err error) {
 	if l.n <= 0 || l.Size == 0 {
 		if l.fileAutoOpen { // Make sure the file is closed if auto opened
 			l.fileAutoOpen = false
-			fh := l.ra.(*os.File)
+			fh := l.ra.(io.Closer)
 			l.ra = nil
 			fh.Close()
 		}
 		return 0, io.EOF
 	}
 	if l.filePath != "" && l.ra == nil && l.n > 0 {
-		fh, err := os.Open(l.filePath)
+		fh, err := l.openFile()
 		if err != nil {
 			return 0, err
 		}
@@ -187,16 +244,17 @@ func (l *File) Read(p []byte) (n int, err error) {
 	l.n -= int64(n)
 	l.i += int64(n)
 	if l.cksumStatus == cksumInit {
-		var n2 int
-		n2, err = l.cksum.Write(p[:n])
-		if err != nil || n != n2 {
-			log.Println("checksum write error", err)
+		if n2, cerr := l.cksum.Write(p[:n]); cerr != nil || n != n2 {
+			l.debug.debugln("checksum write error", cerr)
 		}
 	}
+	if err == io.EOF && l.n > 0 {
+		err = ErrorTruncatedStream // The content ended before the stated size
+	}
 	if (err == nil || err == io.EOF) && l.n <= 0 {
 		if l.fileAutoOpen { // Make sure the file is closed if auto opened
 			l.fileAutoOpen = false
-			fh := l.ra.(*os.File)
+			fh := l.ra.(io.Closer)
 			l.ra = nil
 			fh.Close()
 		}
@@ -213,22 +271,27 @@ func (l *File) Read(p []byte) (n int, err error) {
 func (l *File) Close() (err error) {
 	if l.fileAutoOpen { // Make sure the file is closed if auto opened
 		l.fileAutoOpen = false
-		fh := l.ra.(*os.File)
+		fh := l.ra.(io.Closer)
 		l.ra = nil
 		return fh.Close()
 	}
 
-	switch {
+	switch rs, ok := l.r.(io.Seeker); {
+	case l.r != nil && ok:
+		// Seek the pointer to the next reading position, a stream read with
+		// ReadAt has not moved so it is placed after the content
+		if l.ra != nil {
+			_, err = rs.Seek(l.i+l.n, io.SeekStart)
+		} else {
+			_, err = rs.Seek(l.n, io.SeekCurrent)
+		}
 	case l.ra != nil:
 	case l.r != nil:
-		if rs, ok := l.ra.(io.ReadSeeker); ok {
-			// Seek the pointer to the next reading position
-			rs.Seek(l.n, io.SeekCurrent)
-		} else {
-			_, err = io.CopyN(ioutil.Discard, l.r, l.n)
+		if _, err = io.CopyN(ioutil.Discard, l.r, l.n); err == io.EOF {
+			err = ErrorTruncatedStream // The content ended before the stated size
 		}
 	default:
-		return fmt.Errorf("Missing underlying reader")
+		return ErrorMissingReader
 	}
 	// Adjust the counters
 	l.n, l.i = 0, l.i+l.n
@@ -2,9 +2,15 @@ package flowfile_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
 
 	"github.com/pschou/go-flowfile"
 )
@@ -84,3 +90,142 @@ func ExampleNew() {
 	f.Attrs.GenerateUUID()                 // Set a unique identifier to this file
 
 }
+
+// Segment a File with the attributes expected by the NiFi MergeContent
+// Defragment strategy.
+func ExampleSegmentBySizeProfile() {
+	f := flowfile.New(bytes.NewReader([]byte("abcdefghij")), 10)
+	f.Attrs.Set("filename", "abc.txt")
+	f.Attrs.Set("uuid", "11111111-2222-3333-4444-555555555555")
+
+	segments, err := flowfile.SegmentBySizeProfile(f, 4, flowfile.SegmentProfileNiFi)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, s := range segments {
+		fmt.Println(s.Size, s.Attrs.Get("fragment.identifier"),
+			s.Attrs.Get("fragment.index"), s.Attrs.Get("fragment.count"),
+			s.Attrs.Get("segment.original.filename"), s.Attrs.Get("merge.reason") == "")
+	}
+	// Output:
+	// 4 11111111-2222-3333-4444-555555555555 1 3 abc.txt true
+	// 4 11111111-2222-3333-4444-555555555555 2 3 abc.txt true
+	// 2 11111111-2222-3333-4444-555555555555 3 3 abc.txt true
+}
+
+// Save a File into an in-memory filesystem and read it back.
+func ExampleNewMemFS() {
+	m := flowfile.NewMemFS()
+	s := flowfile.NewSaver("out")
+	s.FS = m
+
+	f := flowfile.New(bytes.NewReader([]byte("hello")), 5)
+	f.Attrs.Set("path", "a/b/")
+	f.Attrs.Set("filename", "hello.txt")
+	f.AddChecksum("SHA256")
+	f.ChecksumInit() // Verify the content as it is saved
+	outputFile, err := s.Save(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dat, _ := fs.ReadFile(m, outputFile)
+	fmt.Println(outputFile, string(dat))
+	// Output:
+	// out/a/b/hello.txt hello
+}
+
+// Build a File from a file within a fs.FS, such as an embed.FS.
+func ExampleNewFromFS() {
+	fsys := fstest.MapFS{
+		"docs/readme.txt": &fstest.MapFile{Data: []byte("read me"), Mode: 0644},
+	}
+	f, err := flowfile.NewFromFS(fsys, "docs/readme.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dat, _ := io.ReadAll(f)
+	fmt.Println(f.Attrs.Get("path"), f.Attrs.Get("filename"), f.Attrs.Get("file.permissions"), string(dat))
+	// Output:
+	// docs/ readme.txt rw-r--r-- read me
+}
+
+// Replace the content of a File in a transform, keeping the attributes.
+func ExampleFile_SetContent() {
+	f := flowfile.New(bytes.NewReader([]byte("hello")), 5)
+	f.Attrs.Set("filename", "greeting.txt")
+	f.AddChecksum("SHA256")
+
+	upper := []byte("HELLO WORLD")
+	f.SetContent(bytes.NewReader(upper), int64(len(upper)), "text/plain")
+	fmt.Println("size:", f.Size)
+	fmt.Println("attributes:", f.Attrs)
+	// Output:
+	// size: 11
+	// attributes: {"filename":"greeting.txt","mime.type":"text/plain","checksumType":"SHA256","checksum":"787ec76dcafd20c1908eb0936a12f91edd105ab5cd7ecc2b1ae2032648345dff"}
+}
+
+// Hand Files to a Scanner in process, such as for testing a handler.
+func ExamplePipe() {
+	s, w := flowfile.Pipe()
+	go func() {
+		for _, name := range []string{"a.txt", "b.txt"} {
+			f := flowfile.New(bytes.NewReader([]byte("hello")), 5)
+			f.Attrs.Set("filename", name)
+			w.Write(f)
+		}
+		w.Close()
+	}()
+
+	for s.Scan() {
+		f := s.File()
+		dat, _ := io.ReadAll(f)
+		fmt.Printf("%s: %q\n", f.Attrs.Get("filename"), dat)
+	}
+	fmt.Println("Check for errors:", s.Err())
+	// Output:
+	// a.txt: "hello"
+	// b.txt: "hello"
+	// Check for errors: <nil>
+}
+
+func TestFileReset(t *testing.T) {
+	type seekOnly struct{ io.ReadSeeker } // Hides the ReadAt
+	for _, tc := range []struct {
+		name string
+		r    func() io.Reader
+		read int
+		err  error
+	}{
+		{"ReaderAt", func() io.Reader { return strings.NewReader("abcdef") }, 2, nil},
+		{"buffer", func() io.Reader { return bytes.NewBufferString("abcdef") }, 2, nil},
+		{"seeker", func() io.Reader { return seekOnly{strings.NewReader("abcdef")} }, 2, nil},
+		{"unread stream", func() io.Reader { return io.MultiReader(strings.NewReader("abcdef")) }, 0, flowfile.ErrorNotResettable},
+		{"read stream", func() io.Reader { return io.MultiReader(strings.NewReader("abcdef")) }, 2, flowfile.ErrorNotResettable},
+	} {
+		f := flowfile.New(tc.r(), 6)
+		if tc.read > 0 {
+			if _, err := io.ReadFull(f, make([]byte, tc.read)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := f.Reset()
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: expecting %v, got %v", tc.name, tc.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if dat, err := io.ReadAll(f); err != nil || string(dat) != "abcdef" {
+			t.Errorf("%s: expecting the content read again, got %q %v", tc.name, dat, err)
+		}
+	}
+
+	// The buffer is read in place, not drained
+	buf := bytes.NewBufferString("abc")
+	io.ReadAll(flowfile.New(buf, 3))
+	if buf.String() != "abc" {
+		t.Errorf("expecting the buffer kept, got %q", buf)
+	}
+}
@@ -0,0 +1,74 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// When FIPSMode is set, only FIPS approved algorithms are used.  Checksum
+// types based on MD5 or SHA1 are refused with ErrorNotFIPSApproved, both when
+// adding checksums and when verifying them, the TLS configs built by the
+// HTTPTransaction constructors are constrained with FIPSTLSConfig, and a
+// handshake over a connection with a non-approved cipher suite fails.
+//
+// TLS 1.3 cipher suites are chosen by the Go runtime and are only restricted
+// when built with a FIPS validated crypto module.
+var FIPSMode bool
+
+// The FIPS approved TLS 1.2 cipher suites
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// FIPSTLSConfig returns a copy of the TLS config limited to TLS 1.2 and above,
+// the FIPS approved cipher suites, and the NIST curves.  This can also be used
+// for the http.Server of an HTTPReceiver.
+func FIPSTLSConfig(cfg *tls.Config) *tls.Config {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	} else {
+		cfg = cfg.Clone()
+	}
+	if cfg.MinVersion < tls.VersionTLS12 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	cfg.CipherSuites = fipsCipherSuites
+	cfg.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+	return cfg
+}
+
+// Refuse the checksum types not approved in FIPSMode
+func fipsChecksum(cksum string) error {
+	if !FIPSMode {
+		return nil
+	}
+	base := strings.SplitN(strings.TrimSpace(strings.ToUpper(cksum)), "-CHUNK-", 2)[0]
+	switch base {
+	case "MD5", "SHA1", "SHA":
+		return fmt.Errorf("%w: %q", ErrorNotFIPSApproved, cksum)
+	}
+	return nil
+}
+
+// Refuse a connection with a cipher suite not approved in FIPSMode
+func fipsConnection(cs *tls.ConnectionState) error {
+	if !FIPSMode || cs == nil {
+		return nil
+	}
+	switch cs.CipherSuite {
+	case tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384:
+		return nil
+	}
+	for _, c := range fipsCipherSuites {
+		if c == cs.CipherSuite {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: cipher suite %s", ErrorNotFIPSApproved, tls.CipherSuiteName(cs.CipherSuite))
+}
@@ -0,0 +1,104 @@
+package flowfile_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestFIPSChecksum(t *testing.T) {
+	defer func(old bool) { flowfile.FIPSMode = old }(flowfile.FIPSMode)
+
+	// Checksummed before FIPSMode was turned on
+	flowfile.FIPSMode = false
+	md5File := stringFiles("abc")[0]
+	if err := md5File.AddChecksum("MD5"); err != nil {
+		t.Fatal(err)
+	}
+
+	flowfile.FIPSMode = true
+	for _, cksum := range []string{"MD5", "sha1", "SHA", "SHA1-CHUNK-1M"} {
+		f := stringFiles("abc")[0]
+		if err := f.AddChecksum(cksum); !errors.Is(err, flowfile.ErrorNotFIPSApproved) {
+			t.Errorf("%s: expecting ErrorNotFIPSApproved, got %v", cksum, err)
+		}
+		if err := f.AddChecksumParallel(cksum, 2); !errors.Is(err, flowfile.ErrorNotFIPSApproved) {
+			t.Errorf("%s: expecting ErrorNotFIPSApproved in parallel, got %v", cksum, err)
+		}
+	}
+	for _, cksum := range []string{"SHA256", "SHA512", "SHA256-CHUNK-1M"} {
+		if err := stringFiles("abc")[0].AddChecksum(cksum); err != nil {
+			t.Errorf("%s: expecting an approved checksum, got %v", cksum, err)
+		}
+	}
+
+	// The receiver refuses to verify with a non-approved checksum
+	rcv, ts := newReadingReceiver(t)
+	rcv.VerifyChecksum = true
+	expectReject(t, postRaw(t, ts.URL, md5File), http.StatusNotAcceptable, "checksum")
+	if res := postRaw(t, ts.URL, checksummed(t, []byte("abc"), "")); res.StatusCode != http.StatusOK {
+		t.Errorf("expecting a SHA256 File accepted, got %d", res.StatusCode)
+	}
+}
+
+func TestFIPSTLSConfig(t *testing.T) {
+	cfg := flowfile.FIPSTLSConfig(nil)
+	if cfg.MinVersion != tls.VersionTLS12 || len(cfg.CipherSuites) == 0 || len(cfg.CurvePreferences) == 0 {
+		t.Errorf("expecting a constrained config, got %+v", cfg)
+	}
+	for _, c := range cfg.CipherSuites {
+		if c == tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305 || c == tls.TLS_RSA_WITH_AES_128_CBC_SHA {
+			t.Errorf("non-approved cipher suite %s", tls.CipherSuiteName(c))
+		}
+	}
+	for _, c := range cfg.CurvePreferences {
+		if c == tls.X25519 {
+			t.Errorf("non-approved curve %v", c)
+		}
+	}
+
+	// The config given is copied, and a higher minimum kept
+	orig := &tls.Config{MinVersion: tls.VersionTLS13, ServerName: "example"}
+	cfg = flowfile.FIPSTLSConfig(orig)
+	if cfg == orig || orig.CipherSuites != nil || cfg.MinVersion != tls.VersionTLS13 || cfg.ServerName != "example" {
+		t.Errorf("expecting a constrained copy, got %+v", cfg)
+	}
+	orig.MinVersion = tls.VersionTLS10
+	if cfg = flowfile.FIPSTLSConfig(orig); cfg.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expecting the minimum raised to TLS 1.2, got %x", cfg.MinVersion)
+	}
+}
+
+func TestFIPSConnection(t *testing.T) {
+	defer func(old bool) { flowfile.FIPSMode = old }(flowfile.FIPSMode)
+	flowfile.FIPSMode = true
+
+	for _, tc := range []struct {
+		suite uint16
+		ok    bool
+	}{
+		{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, true},
+		{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305, false},
+	} {
+		_, rts := newReadingReceiver(t)
+		ts := httptest.NewUnstartedServer(rts.Config.Handler)
+		ts.TLS = &tls.Config{MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{tc.suite}}
+		ts.Config.ErrorLog = log.New(io.Discard, "", 0) // The refused handshake is expected
+		ts.StartTLS()
+		pool := x509.NewCertPool()
+		pool.AddCert(ts.Certificate())
+
+		_, err := flowfile.NewHTTPTransaction(ts.URL, &tls.Config{RootCAs: pool})
+		if tc.ok != (err == nil) {
+			t.Errorf("%s: expecting ok %v, got %v", tls.CipherSuiteName(tc.suite), tc.ok, err)
+		}
+		ts.Close()
+	}
+}
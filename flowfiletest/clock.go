@@ -0,0 +1,141 @@
+package flowfiletest // import "github.com/pschou/go-flowfile/flowfiletest"
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+)
+
+// A Clock is a flowfile.Clock which only moves when told to, so the retries,
+// hold offs, expiry and flushes can be tested without waiting on them.  The
+// timers and tickers fire as Advance moves the time past them.
+//
+//   clk := flowfiletest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+//   hs.Clock = clk
+//   go hs.Send(ff)
+//   clk.BlockUntil(1) // The retry is waiting on its hold off
+//   clk.Advance(hs.RetryDelay)
+type Clock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*clockTimer
+}
+
+// NewClock creates a Clock stopped at the start time.
+func NewClock(start time.Time) *Clock {
+	c := &Clock{now: start}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the time of the Clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the Clock forward, firing the timers and tickers which come
+// due in the order they are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves the Clock to the time, firing the timers and tickers which come
+// due.  Setting the Clock back fires none.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		sort.Slice(c.waiters, func(i, j int) bool { return c.waiters[i].when.Before(c.waiters[j].when) })
+		if len(c.waiters) == 0 || c.waiters[0].when.After(t) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.when
+		select {
+		case w.ch <- w.when:
+		default: // As with a time.Ticker, a slow reader misses ticks
+		}
+		if w.period > 0 {
+			w.when = w.when.Add(w.period)
+		} else {
+			c.waiters = c.waiters[1:]
+		}
+	}
+	if t.After(c.now) {
+		c.now = t
+	}
+}
+
+// Waiters returns the number of timers and tickers waiting to fire.
+func (c *Clock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// BlockUntil waits for at least n timers and tickers to be waiting to fire,
+// such as for the code under test to reach its hold off before the Clock is
+// advanced.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// NewTimer creates a timer firing once the Clock is advanced by d.
+func (c *Clock) NewTimer(d time.Duration) flowfile.Timer {
+	return c.add(d, 0)
+}
+
+// NewTicker creates a ticker firing each time the Clock is advanced by d.
+func (c *Clock) NewTicker(d time.Duration) flowfile.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return clockTicker{c.add(d, d)}
+}
+
+func (c *Clock) add(d, period time.Duration) *clockTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := &clockTimer{c: c, ch: make(chan time.Time, 1), when: c.now.Add(d), period: period}
+	if d <= 0 {
+		w.ch <- c.now
+		return w
+	}
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+	return w
+}
+
+type clockTimer struct {
+	c      *Clock
+	ch     chan time.Time
+	when   time.Time
+	period time.Duration
+}
+
+func (w *clockTimer) C() <-chan time.Time { return w.ch }
+
+func (w *clockTimer) Stop() bool {
+	w.c.mu.Lock()
+	defer w.c.mu.Unlock()
+	for i, other := range w.c.waiters {
+		if other == w {
+			w.c.waiters = append(w.c.waiters[:i], w.c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type clockTicker struct{ *clockTimer }
+
+func (t clockTicker) Stop() { t.clockTimer.Stop() }
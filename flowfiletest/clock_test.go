@@ -0,0 +1,80 @@
+package flowfiletest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile/flowfiletest"
+)
+
+// The time on the channel, or the zero time when nothing has fired
+func fired(c <-chan time.Time) time.Time {
+	select {
+	case t := <-c:
+		return t
+	default:
+		return time.Time{}
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := flowfiletest.NewClock(start)
+
+	late, early := clk.NewTimer(2*time.Second), clk.NewTimer(time.Second)
+	stopped := clk.NewTimer(time.Second)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Errorf("expecting Stop to report the timer was pending once")
+	}
+	tick := clk.NewTicker(time.Second)
+	if n := clk.Waiters(); n != 3 {
+		t.Fatalf("expecting 3 waiters, got %d", n)
+	}
+
+	clk.Advance(1500 * time.Millisecond)
+	if got := fired(early.C()); !got.Equal(start.Add(time.Second)) {
+		t.Errorf("expecting the early timer fired when due, got %v", got)
+	}
+	if got := fired(late.C()); !got.IsZero() {
+		t.Errorf("late timer fired early, at %v", got)
+	}
+	if got := fired(tick.C()); !got.Equal(start.Add(time.Second)) {
+		t.Errorf("expecting a tick, got %v", got)
+	}
+	if now := clk.Now(); !now.Equal(start.Add(1500 * time.Millisecond)) {
+		t.Errorf("expecting the Clock advanced, at %v", now)
+	}
+
+	// Setting the Clock back fires nothing
+	clk.Set(start)
+	if !fired(late.C()).IsZero() || !fired(tick.C()).IsZero() {
+		t.Errorf("fired on setting the Clock back")
+	}
+
+	clk.Set(start.Add(3 * time.Second))
+	if got := fired(late.C()); !got.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("expecting the late timer fired when due, got %v", got)
+	}
+	if fired(tick.C()).IsZero() {
+		t.Errorf("expecting the ticker to keep ticking")
+	}
+	tick.Stop()
+	if n := clk.Waiters(); n != 0 {
+		t.Errorf("expecting no waiters, got %d", n)
+	}
+	if now := fired(clk.NewTimer(0).C()); !now.Equal(clk.Now()) {
+		t.Errorf("expecting a zero timer fired at once, got %v", now)
+	}
+}
+
+func TestClockBlockUntil(t *testing.T) {
+	clk := flowfiletest.NewClock(time.Unix(0, 0))
+	done := make(chan struct{})
+	go func() {
+		<-clk.NewTimer(time.Minute).C()
+		close(done)
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	<-done
+}
@@ -0,0 +1,194 @@
+// Package flowfiletest provides a fake NiFi contentListener for testing
+// FlowFile senders without a running NiFi.  The Server records every File it
+// receives, can be told to fail or delay POSTs, and has helpers to assert on
+// what was received.
+//
+//   srv := flowfiletest.NewServer()
+//   defer srv.Close()
+//   hs, _ := srv.NewTransaction()
+//   hs.Send(ff)
+//   srv.AssertReceived(t, 1)
+//   srv.AssertAttribute(t, 0, "filename", "report.csv")
+package flowfiletest // import "github.com/pschou/go-flowfile/flowfiletest"
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+)
+
+// A Received File, with the content read into memory.
+type Received struct {
+	Attrs   flowfile.Attributes
+	Content []byte
+	Post    int         // Index of the POST the File arrived in, starting at 0
+	Header  http.Header // Headers of the POST
+}
+
+// A Server is a fake contentListener, serving the handshake and accepting
+// POSTs of FlowFiles with an HTTPReceiver which verifies checksums.
+type Server struct {
+	*httptest.Server
+
+	// The receiver serving the endpoint, which can be configured further, such
+	// as with Capabilities, MaxPartitionSize or RequiredAttributes.
+	Receiver *flowfile.HTTPReceiver
+
+	// Time to wait before handling each POST, to simulate a slow endpoint.
+	Latency time.Duration
+
+	// When set, called with each POST before it is handled, a non-zero status
+	// code is replied with in place of accepting the Files.
+	Fail func(r *http.Request) int
+
+	mu       sync.Mutex
+	files    []Received
+	posts    int
+	failNext []int
+}
+
+// NewServer starts a Server listening on a local port, to be closed with
+// Close when the test is done.
+func NewServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewServer(s.Receiver)
+	return s
+}
+
+// NewTLSServer starts a Server serving TLS, the Client and NewTransaction
+// trust its certificate.
+func NewTLSServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewTLSServer(s.Receiver)
+	return s
+}
+
+func newServer() *Server {
+	s := &Server{}
+	s.Receiver = flowfile.NewHTTPReceiver(s.handle)
+	s.Receiver.VerifyChecksum = true
+	return s
+}
+
+// NewTransaction creates an HTTPTransaction to the Server, through the
+// transport of its Client.
+func (s *Server) NewTransaction() (*flowfile.HTTPTransaction, error) {
+	return flowfile.NewHTTPTransactionWithRoundTripper(s.URL, s.Client().Transport)
+}
+
+// FailNext replies to the next n POSTs with the status code, such as 503,
+// before any Files are accepted.
+func (s *Server) FailNext(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failNext = append(s.failNext, status)
+	}
+}
+
+// Files returns a copy of the list of Files received, in order.
+func (s *Server) Files() []Received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Received{}, s.files...)
+}
+
+// Posts returns the number of POSTs made to the Server, including failed ones.
+func (s *Server) Posts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.posts
+}
+
+// Reset forgets the Files and POSTs received and any pending failures.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files, s.posts, s.failNext = nil, 0, nil
+}
+
+// AssertReceived fails the test unless exactly n Files have been received.
+func (s *Server) AssertReceived(t testing.TB, n int) {
+	t.Helper()
+	if got := len(s.Files()); got != n {
+		t.Errorf("received %d files, expected %d", got, n)
+	}
+}
+
+// AssertAttribute fails the test unless the i-th File received has the
+// attribute set to want.
+func (s *Server) AssertAttribute(t testing.TB, i int, name, want string) {
+	t.Helper()
+	files := s.Files()
+	if i >= len(files) {
+		t.Errorf("file %d not received, only %d files", i, len(files))
+		return
+	}
+	if got := files[i].Attrs.Get(name); got != want {
+		t.Errorf("file %d attribute %q is %q, expected %q", i, name, got, want)
+	}
+}
+
+// AssertContent fails the test unless the i-th File received has the content.
+func (s *Server) AssertContent(t testing.TB, i int, want string) {
+	t.Helper()
+	files := s.Files()
+	if i >= len(files) {
+		t.Errorf("file %d not received, only %d files", i, len(files))
+		return
+	}
+	if got := string(files[i].Content); got != want {
+		t.Errorf("file %d content is %q, expected %q", i, got, want)
+	}
+}
+
+// Take the next failure to inject, if any
+func (s *Server) failure(r *http.Request) (post, status int) {
+	s.mu.Lock()
+	post = s.posts
+	s.posts++
+	if len(s.failNext) > 0 {
+		status, s.failNext = s.failNext[0], s.failNext[1:]
+	}
+	s.mu.Unlock()
+	if status == 0 && s.Fail != nil {
+		status = s.Fail(r)
+	}
+	return
+}
+
+func (s *Server) handle(scn *flowfile.Scanner, w http.ResponseWriter, r *http.Request) {
+	post, status := s.failure(r)
+	if s.Latency > 0 {
+		select {
+		case <-time.After(s.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+		return
+	}
+
+	for scn.Scan() {
+		f := scn.File()
+		content, err := io.ReadAll(f)
+		if err != nil {
+			break
+		}
+		s.mu.Lock()
+		s.files = append(s.files, Received{Attrs: f.Attrs.Clone(), Content: content, Post: post, Header: r.Header.Clone()})
+		s.mu.Unlock()
+	}
+	if err := scn.Err(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
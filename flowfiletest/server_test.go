@@ -0,0 +1,96 @@
+package flowfiletest_test
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/flowfiletest"
+)
+
+// Test a sender against a fake endpoint which fails the first POST.
+func ExampleNewServer() {
+	srv := flowfiletest.NewServer()
+	defer srv.Close()
+	srv.FailNext(1, 503)
+
+	hs, err := srv.NewTransaction()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	hs.RetryCount = 1
+
+	f := flowfile.New(strings.NewReader("hello"), 5)
+	f.Attrs.Set("filename", "greeting.txt")
+	fmt.Println("send:", hs.Send(f))
+
+	for _, r := range srv.Files() {
+		fmt.Printf("post %d: %s %q\n", r.Post, r.Attrs.Get("filename"), r.Content)
+	}
+	fmt.Println("posts:", srv.Posts())
+	// Output:
+	// send: <nil>
+	// post 1: greeting.txt "hello"
+	// posts: 2
+}
+
+// A testing.TB recording the failures rather than failing the test
+type recordTB struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordTB) Helper() {}
+func (r *recordTB) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestServer(t *testing.T) {
+	srv := flowfiletest.NewTLSServer()
+	defer srv.Close()
+	srv.Fail = func(r *http.Request) int {
+		if r.Header.Get("x-fail") != "" {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}
+
+	hs, err := srv.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := flowfile.New(strings.NewReader("hello"), 5)
+	f.Attrs.Set("filename", "greeting.txt")
+	if err = hs.Send(f); err != nil {
+		t.Fatal(err)
+	}
+	srv.AssertReceived(t, 1)
+	srv.AssertAttribute(t, 0, "filename", "greeting.txt")
+	srv.AssertContent(t, 0, "hello")
+
+	// The assertions report what does not match
+	rec := &recordTB{TB: t}
+	srv.AssertReceived(rec, 2)
+	srv.AssertAttribute(rec, 0, "filename", "other.txt")
+	srv.AssertContent(rec, 1, "hello")
+	if len(rec.errors) != 3 {
+		t.Errorf("expecting 3 failures, got %q", rec.errors)
+	}
+
+	w := hs.NewHTTPPostWriter()
+	w.Header.Set("x-fail", "1")
+	w.Write(flowfile.New(strings.NewReader("hello"), 5))
+	if err = w.Close(); err == nil {
+		t.Errorf("expecting the POST failed by Fail")
+	}
+	if srv.Posts() != 2 || len(srv.Files()) != 1 {
+		t.Errorf("expecting the failed POST counted, got %d posts", srv.Posts())
+	}
+	srv.Reset()
+	if srv.Posts() != 0 || len(srv.Files()) != 0 {
+		t.Errorf("expecting nothing after Reset")
+	}
+}
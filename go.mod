@@ -1,14 +1,20 @@
 module github.com/pschou/go-flowfile
 
-go 1.18
+go 1.22
 
-require github.com/google/uuid v1.3.0
+require (
+	github.com/djherbis/times v1.5.0
+	github.com/google/uuid v1.3.0
+	github.com/klauspost/compress v1.18.0
+	github.com/pierrec/lz4/v4 v4.1.22
+	github.com/pschou/go-sorting/numstr v0.0.0-20230218015952-a2a98f172ba3
+	github.com/pschou/go-unixmode v0.0.0-20230220191411-3828898b2c82
+	github.com/relvacode/iso8601 v1.3.0
+	go.etcd.io/bbolt v1.3.11
+)
 
 require (
-	github.com/djherbis/times v1.5.0 // indirect
 	github.com/hashicorp/go-version v1.6.0 // indirect
 	github.com/pschou/go-numstr v0.0.0-20230217202549-c04767600335 // indirect
-	github.com/pschou/go-sorting/numstr v0.0.0-20230218015952-a2a98f172ba3 // indirect
-	github.com/pschou/go-unixmode v0.0.0-20230220191411-3828898b2c82 // indirect
-	github.com/relvacode/iso8601 v1.3.0 // indirect
+	golang.org/x/sys v0.4.0 // indirect
 )
@@ -0,0 +1,154 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A HealthChecker reports on the health of a part of a flow, such as an
+// HTTPTransaction to a downstream or an HTTPReceiver.
+type HealthChecker interface {
+	Health() Health
+}
+
+// Health is a point in time report on the ability of a transaction or
+// receiver to move Files, as served by a HealthHandler.
+type Health struct {
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"` // why it is not ready
+
+	// Downstream details of an HTTPTransaction
+	URL              string     `json:"url,omitempty"`
+	TransactionID    string     `json:"transactionId,omitempty"`
+	Server           string     `json:"server,omitempty"`
+	LastHandshake    *time.Time `json:"lastHandshake,omitempty"`
+	HandshakeLatency float64    `json:"handshakeLatencySeconds,omitempty"`
+
+	// Queue depths of an HTTPReceiver
+	Connections    int   `json:"connections,omitempty"`
+	MaxConnections int   `json:"maxConnections,omitempty"`
+	Active         int64 `json:"active,omitempty"`
+	Queued         int64 `json:"queued,omitempty"`
+
+	LastSuccess   *time.Time `json:"lastSuccess,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorTime *time.Time `json:"lastErrorTime,omitempty"`
+}
+
+// HealthHandler replies with the Health of each checker as a JSON list, with a
+// 200 when all are ready and a 503 otherwise, so orchestrators can gate traffic
+// on the flow actually moving rather than on the process being alive.
+//
+//   http.Handle("/healthz", flowfile.HealthHandler(hs, ffReceiver))
+func HealthHandler(checks ...HealthChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		list := make([]Health, len(checks))
+		for i, c := range checks {
+			if list[i] = c.Health(); !list[i].Ready {
+				status = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		if r.Method != "HEAD" {
+			json.NewEncoder(w).Encode(list)
+		}
+	})
+}
+
+// HealthHandler replies with the Health of the transaction, see HealthHandler.
+func (hs *HTTPTransaction) HealthHandler() http.Handler { return HealthHandler(hs) }
+
+// HealthHandler replies with the Health of the receiver, see HealthHandler.
+func (f *HTTPReceiver) HealthHandler() http.Handler { return HealthHandler(f) }
+
+// Health of the transaction, which is ready once a handshake has been made
+// and the last handshake or POST did not fail.
+func (hs *HTTPTransaction) Health() Health {
+	s := hs.session()
+	h := Health{
+		URL:              s.url,
+		TransactionID:    s.transactionID,
+		Server:           s.server,
+		HandshakeLatency: s.latency.Seconds(),
+	}
+	failing := hs.health.fill(&h)
+	switch {
+	case hs.isClosed():
+		h.Reason = "closed"
+	case s.transactionID == "":
+		h.Reason = "no handshake"
+	case failing:
+		h.Reason = "last send failed"
+	default:
+		h.Ready = true
+	}
+	return h
+}
+
+// Health of the receiver, which is ready while it is below MaxConnections.
+// The last error is from the last POST which failed with a 5xx reply or a
+// broken stream.
+func (f *HTTPReceiver) Health() Health {
+	h := Health{
+		Connections:    f.connections,
+		MaxConnections: f.MaxConnections,
+	}
+	if f.Metrics != nil {
+		h.Active, h.Queued = f.Metrics.MetricsThreadsActive, f.Metrics.MetricsThreadsQueued
+	}
+	f.health.fill(&h)
+	switch {
+	case f.handler == nil:
+		h.Reason = "no handler"
+	case f.MaxConnections > 0 && f.connections >= f.MaxConnections:
+		h.Reason = "too busy"
+	default:
+		h.Ready = true
+	}
+	return h
+}
+
+// The outcomes tracked for a Health report
+type healthState struct {
+	mu                           sync.Mutex
+	lastHandshake, lastOK, errAt time.Time
+	lastErr                      error
+}
+
+// Record the outcome of a handshake or transfer
+func (s *healthState) record(handshake bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := DefaultClock.Now()
+	if err != nil {
+		s.lastErr, s.errAt = err, now
+		return
+	}
+	if handshake {
+		s.lastHandshake = now
+	}
+	s.lastOK = now
+}
+
+// Fill in the times and last error, returning true when the last outcome was
+// a failure
+func (s *healthState) fill(h *Health) (failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	timePtr := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+	h.LastHandshake, h.LastSuccess = timePtr(s.lastHandshake), timePtr(s.lastOK)
+	if s.lastErr != nil {
+		h.LastError, h.LastErrorTime = s.lastErr.Error(), timePtr(s.errAt)
+	}
+	return s.lastErr != nil && !s.errAt.Before(s.lastOK)
+}
@@ -0,0 +1,119 @@
+package flowfile_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+// Fetch the Health list from the handler
+func getHealth(t *testing.T, h http.Handler) (int, []flowfile.Health) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	var list []flowfile.Health
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, list
+}
+
+func TestTransactionHealth(t *testing.T) {
+	var fail bool
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		if fail {
+			return errors.New("disk full")
+		}
+		_, err := io.Copy(io.Discard, f)
+		return err
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+
+	hs := flowfile.NewHTTPTransactionNoHandshake(ts.URL, nil)
+	if h := hs.Health(); h.Ready || h.Reason != "no handshake" || h.URL != ts.URL {
+		t.Errorf("expecting not ready before the handshake, got %+v", h)
+	}
+	if err := hs.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if h := hs.Health(); !h.Ready || h.TransactionID == "" || h.LastHandshake == nil {
+		t.Errorf("expecting ready after the handshake, got %+v", h)
+	}
+
+	fail = true
+	hs.Send(stringFiles("abc")...)
+	code, list := getHealth(t, flowfile.HealthHandler(hs, rcv))
+	if code != http.StatusServiceUnavailable || len(list) != 2 {
+		t.Fatalf("expecting a 503 with two reports, got %d %+v", code, list)
+	}
+	if h := list[0]; h.Ready || h.Reason != "last send failed" || h.LastError == "" || h.LastErrorTime == nil {
+		t.Errorf("expecting the failed send reported, got %+v", h)
+	}
+	if h := list[1]; !h.Ready || h.LastError != "" {
+		t.Errorf("expecting the receiver ready as the File was refused, got %+v", h)
+	}
+
+	// A later success clears the failure
+	fail = false
+	if err := hs.Send(stringFiles("abc")...); err != nil {
+		t.Fatal(err)
+	}
+	if code, list = getHealth(t, hs.HealthHandler()); code != http.StatusOK || !list[0].Ready || list[0].LastSuccess == nil {
+		t.Errorf("expecting ready after a success, got %d %+v", code, list)
+	}
+
+	hs.Close()
+	if h := hs.Health(); h.Ready || h.Reason != "closed" {
+		t.Errorf("expecting not ready once closed, got %+v", h)
+	}
+}
+
+func TestReceiverHealth(t *testing.T) {
+	rcv, ts := newReadingReceiver(t)
+	rcv.MaxConnections = 1
+	if code, list := getHealth(t, rcv.HealthHandler()); code != http.StatusOK || !list[0].Ready || list[0].MaxConnections != 1 {
+		t.Errorf("expecting an idle receiver ready, got %d %+v", code, list)
+	}
+	postRaw(t, ts.URL, stringFiles("abc")...)
+	if h := rcv.Health(); h.LastError != "Replied with 503 Service Unavailable" || h.LastErrorTime == nil {
+		t.Errorf("expecting the refused POST reported, got %+v", h)
+	}
+
+	// While receiving, the connection counts towards MaxConnections
+	var idle, full flowfile.Health
+	var busy *flowfile.HTTPReceiver
+	busy = flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		idle = busy.Health()
+		busy.MaxConnections = 1
+		full = busy.Health()
+		_, err := io.Copy(io.Discard, f)
+		return err
+	})
+	busy.MaxConnections = 2
+	bts := httptest.NewServer(busy)
+	defer bts.Close()
+	postRaw(t, bts.URL, stringFiles("abc")...)
+	if !idle.Ready || idle.Connections != 1 || idle.Active != 1 {
+		t.Errorf("expecting ready with one connection, got %+v", idle)
+	}
+	if full.Ready || full.Reason != "too busy" {
+		t.Errorf("expecting too busy at MaxConnections, got %+v", full)
+	}
+	busy.MaxConnections = 2
+	if h := busy.Health(); !h.Ready || h.Connections != 0 || h.LastSuccess == nil {
+		t.Errorf("expecting ready once done, got %+v", h)
+	}
+
+	// HEAD replies with the status alone
+	rec := httptest.NewRecorder()
+	rcv.HealthHandler().ServeHTTP(rec, httptest.NewRequest("HEAD", "/healthz", nil))
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("expecting a bare 200 to HEAD, got %d %q", rec.Code, rec.Body)
+	}
+}
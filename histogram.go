@@ -0,0 +1,72 @@
+package flowfile
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultDurationBuckets are the upper bounds, in seconds, used for transfer
+// duration histograms.
+var DefaultDurationBuckets = []float64{
+	.005, .01, .025, .05, .1, .25, .5,
+	1, 2.5, 5, 10, 30, 60, 300, 900}
+
+// A Histogram counts observations into buckets by their upper bound, much like
+// the byte buckets of the transfer metrics, but for float values such as
+// durations in seconds.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []int64 // always one larger than buckets, the last is overflow
+	sum     float64
+	count   int64
+
+	// The source of time for ObserveDuration, defaults to the DefaultClock.
+	Clock Clock
+}
+
+// Create a new Histogram with the given upper bounds, which must be sorted.
+// When no buckets are given DefaultDurationBuckets is used.
+func NewHistogram(buckets ...float64) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultDurationBuckets
+	}
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]int64, len(buckets)+1),
+	}
+}
+
+// Observe records one value into the histogram.
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	idx := 0
+	for ; idx < len(h.buckets) && h.buckets[idx] < v; idx++ {
+	}
+	h.counts[idx]++
+	h.sum += v
+	h.count++
+}
+
+// ObserveDuration records the time since start in seconds.
+func (h *Histogram) ObserveDuration(start time.Time) {
+	h.Observe(clockOr(h.Clock).Now().Sub(start).Seconds())
+}
+
+// Snapshot returns the bucket upper bounds, the cumulative count for each
+// bucket (with the last being +Inf), the sum, and the count of observations.
+func (h *Histogram) Snapshot() (buckets []float64, cumulative []int64, sum float64, count int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	cumulative = make([]int64, len(h.counts))
+	var total int64
+	for i, c := range h.counts {
+		total += c
+		cumulative[i] = total
+	}
+	return h.buckets, cumulative, h.sum, h.count
+}
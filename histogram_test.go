@@ -0,0 +1,45 @@
+package flowfile_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestHistogram(t *testing.T) {
+	h := flowfile.NewHistogram(1, 2)
+	for _, v := range []float64{.5, 1, 1.5, 3} {
+		h.Observe(v)
+	}
+	buckets, cumulative, sum, count := h.Snapshot()
+	if !reflect.DeepEqual(buckets, []float64{1, 2}) || !reflect.DeepEqual(cumulative, []int64{2, 3, 4}) || sum != 6 || count != 4 {
+		t.Errorf("unexpected snapshot %v %v %g %d", buckets, cumulative, sum, count)
+	}
+	if buckets, _, _, _ = flowfile.NewHistogram().Snapshot(); !reflect.DeepEqual(buckets, flowfile.DefaultDurationBuckets) {
+		t.Errorf("expecting the DefaultDurationBuckets, got %v", buckets)
+	}
+	var none *flowfile.Histogram
+	none.Observe(1) // Not recorded, and no panic
+}
+
+func TestTransferDurations(t *testing.T) {
+	rcv, ts := newReadingReceiver(t)
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.Metrics = flowfile.NewMetrics()
+	if err = hs.Send(stringFiles("abc", "def", "ghi")...); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, m := range map[string]*flowfile.Metrics{"sender": hs.Metrics, "receiver": rcv.Metrics} {
+		if _, _, _, count := m.MetricsPostDuration.Snapshot(); count != 1 {
+			t.Errorf("%s: expecting one POST timed, got %d", name, count)
+		}
+		if _, _, _, count := m.MetricsFileDuration.Snapshot(); count != 3 {
+			t.Errorf("%s: expecting three Files timed, got %d", name, count)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrorJournalNoContent is returned when rebuilding a File from a journal
+// entry which has no content reference, such as a File which was streamed.
+var ErrorJournalNoContent = errors.New("Journal entry has no content reference")
+
+// A Journal records which Files are in the middle of being sent so a process
+// which is restarted after a crash can find exactly the Files which were not
+// acknowledged by the remote side and send them again, rather than re-scanning
+// the source.  Files are recorded with Begin when they are written to a POST
+// and removed with Commit once the POST has been accepted.
+//
+// The journal is a JSON-lines file which is compacted down to the pending
+// entries when opened.
+//
+//   j, err := flowfile.OpenJournal("/var/lib/sender/journal")
+//   for _, e := range j.Pending() {
+//     f, err := e.File()
+//     ...  // re-queue f
+//   }
+//   hs.Journal = j
+type Journal struct {
+	path    string
+	mu      sync.Mutex
+	fh      *os.File
+	pending map[string]*JournalEntry
+}
+
+// A JournalEntry is a File which was started but not acknowledged.
+type JournalEntry struct {
+	UUID    string     `json:"uuid"`
+	Path    string     `json:"path,omitempty"`   // Content reference for Files from disk
+	Offset  int64      `json:"offset,omitempty"` // Start of the content within Path
+	Size    int64      `json:"size"`
+	Attrs   Attributes `json:"attrs"`
+	Started time.Time  `json:"started"`
+}
+
+type journalRecord struct {
+	Op    string        `json:"op"` // begin or commit
+	UUID  string        `json:"uuid"`
+	Entry *JournalEntry `json:"entry,omitempty"`
+}
+
+// Open or create a journal file, loading any entries which are still pending.
+func OpenJournal(path string) (*Journal, error) {
+	j := &Journal{path: path, pending: make(map[string]*JournalEntry)}
+	if fh, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(fh)
+		scanner.Buffer(nil, 1<<24)
+		for scanner.Scan() {
+			var rec journalRecord
+			if json.Unmarshal(scanner.Bytes(), &rec) != nil {
+				continue // A torn final line from a crash
+			}
+			switch rec.Op {
+			case "begin":
+				if rec.Entry != nil {
+					j.pending[rec.UUID] = rec.Entry
+				}
+			case "commit":
+				delete(j.pending, rec.UUID)
+			}
+		}
+		fh.Close()
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Compact the journal down to the pending entries
+	tmp := path + ".tmp"
+	fh, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range j.Pending() {
+		if err = j.write(fh, journalRecord{Op: "begin", UUID: e.UUID, Entry: e}); err != nil {
+			fh.Close()
+			return nil, err
+		}
+	}
+	if err = fh.Sync(); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	j.fh = fh
+	return j, nil
+}
+
+// Begin records the Files as in flight.  A uuid is generated for any File
+// without one.
+func (j *Journal) Begin(ff ...*File) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := DefaultClock.Now()
+	for _, f := range ff {
+		id := f.Attrs.Get("uuid")
+		if id == "" {
+			id = f.Attrs.GenerateUUID()
+		}
+		path := f.filePath
+		if f.fsys != nil {
+			path = "" // Not on disk, so cannot be reopened after a restart
+		}
+		e := &JournalEntry{
+			UUID:    id,
+			Path:    path,
+			Offset:  f.i + f.n - f.Size,
+			Size:    f.Size,
+			Attrs:   f.Attrs.Clone(),
+			Started: now,
+		}
+		if err := j.write(j.fh, journalRecord{Op: "begin", UUID: id, Entry: e}); err != nil {
+			return err
+		}
+		j.pending[id] = e
+	}
+	return j.fh.Sync()
+}
+
+// Commit removes the Files from the journal as they have been acknowledged.
+func (j *Journal) Commit(ff ...*File) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, f := range ff {
+		id := f.Attrs.Get("uuid")
+		if _, ok := j.pending[id]; !ok {
+			continue
+		}
+		if err := j.write(j.fh, journalRecord{Op: "commit", UUID: id}); err != nil {
+			return err
+		}
+		delete(j.pending, id)
+	}
+	return j.fh.Sync()
+}
+
+// Pending returns the entries which have been started but not committed,
+// oldest first.
+func (j *Journal) Pending() (out []*JournalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, e := range j.pending {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Started.Before(out[b].Started) })
+	return
+}
+
+// Close the journal file, the pending entries remain on disk.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.fh.Close()
+}
+
+func (j *Journal) write(fh *os.File, rec journalRecord) error {
+	dat, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(append(dat, '\n'))
+	return err
+}
+
+// File rebuilds the File referenced by the journal entry so it can be sent
+// again.
+func (e *JournalEntry) File() (*File, error) {
+	if e.Path == "" {
+		return nil, ErrorJournalNoContent
+	}
+	fi, err := os.Stat(e.Path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < e.Offset+e.Size {
+		return nil, ErrorShortRead
+	}
+	return &File{
+		filePath: e.Path,
+		fileInfo: fi,
+		i:        e.Offset,
+		n:        e.Size,
+		Size:     e.Size,
+		Attrs:    e.Attrs.Clone(),
+	}, nil
+}
@@ -0,0 +1,120 @@
+package flowfile_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestJournalRecover(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "abc.txt")
+	if err := os.WriteFile(src, []byte("abcdefghij"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "journal")
+	j, err := flowfile.OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := flowfile.NewFromDisk(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamed := flowfile.New(strings.NewReader("xyz"), 3)
+	if err = j.Begin(f, streamed); err != nil {
+		t.Fatal(err)
+	}
+	if err = j.Commit(streamed); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	// A line torn by a crash is passed over
+	fh, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	fh.WriteString(`{"op":"commit","uu`)
+	fh.Close()
+
+	if j, err = flowfile.OpenJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	pending := j.Pending()
+	if len(pending) != 1 || pending[0].UUID != f.Attrs.Get("uuid") {
+		t.Fatalf("expecting the uncommitted File pending, got %d entries", len(pending))
+	}
+	rf, err := pending[0].File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dat, _ := io.ReadAll(rf); string(dat) != "abcdefghij" {
+		t.Errorf("rebuilt File reads %q", dat)
+	}
+	if err = j.Commit(rf); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	// Compacted on open, with nothing left
+	if j, err = flowfile.OpenJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if n := len(j.Pending()); n != 0 {
+		t.Errorf("expecting nothing pending, got %d", n)
+	}
+	if fi, _ := os.Stat(path); fi.Size() != 0 {
+		t.Errorf("expecting the journal compacted, %d bytes", fi.Size())
+	}
+
+	if _, err = (&flowfile.JournalEntry{Size: 3}).File(); !errors.Is(err, flowfile.ErrorJournalNoContent) {
+		t.Errorf("expecting ErrorJournalNoContent, got %v", err)
+	}
+}
+
+func TestJournalSend(t *testing.T) {
+	fail := true
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		if fail {
+			return errors.New("disk full")
+		}
+		return nil
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+
+	j, err := flowfile.OpenJournal(filepath.Join(t.TempDir(), "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.Journal = j
+
+	f := flowfile.New(strings.NewReader("abc"), 3)
+	if err = hs.Send(f); err == nil {
+		t.Fatal("expecting the send to fail")
+	}
+	if n := len(j.Pending()); n != 1 {
+		t.Fatalf("expecting the failed File pending, got %d", n)
+	}
+
+	fail = false
+	f = flowfile.New(strings.NewReader("abc"), 3)
+	f.Attrs = j.Pending()[0].Attrs.Clone()
+	if err = hs.Send(f); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(j.Pending()); n != 0 {
+		t.Errorf("expecting the File committed once accepted, %d pending", n)
+	}
+}
@@ -0,0 +1,149 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// A Limiter is a token bucket shaping the bytes sent, which can be shared by
+// any number of HTTPTransactions and HTTPPostWriters so an application wide
+// egress budget is kept across all the concurrent flows.  The bytes are
+// counted as they go on the wire, after any compression.
+//
+//   egress := flowfile.NewLimiter(50<<20, 1<<20) // 50MB/s shared
+//   hs1.Limiter, hs2.Limiter = egress, egress
+type Limiter struct {
+	// The source of time for the refills and waits, defaults to the
+	// DefaultClock.
+	Clock Clock
+
+	mu     sync.Mutex
+	rate   float64 // bytes per second, zero for no limit
+	burst  int64
+	tokens float64
+	last   time.Time // of the last refill, zero before the first
+}
+
+// NewLimiter creates a Limiter allowing bytesPerSecond with bursts of up to
+// burst bytes, when burst is zero one second worth of bytes is allowed.
+func NewLimiter(bytesPerSecond, burst int64) *Limiter {
+	l := &Limiter{}
+	l.SetLimit(bytesPerSecond, burst)
+	l.tokens = float64(l.burst)
+	return l
+}
+
+// SetLimit changes the rate and burst, a rate of zero removes the limit.
+func (l *Limiter) SetLimit(bytesPerSecond, burst int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.refill(l.clock().Now())
+	}
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	l.rate, l.burst = float64(bytesPerSecond), burst
+	if l.tokens > float64(burst) {
+		l.tokens = float64(burst)
+	}
+}
+
+// Add the tokens accrued since the last refill, the first only starts the
+// count, must hold the lock
+func (l *Limiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+	}
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+}
+
+func (l *Limiter) clock() Clock {
+	return clockOr(l.Clock)
+}
+
+// WaitN blocks until n bytes may be sent, or the context is done.  Callers
+// waiting together are let through in turn.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.refill(l.clock().Now())
+	l.tokens -= float64(n) // Reserve the bytes, going into debt if need be
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	t := l.clock().NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens += float64(n) // Hand back the reservation
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Writes through one or more Limiters, in pieces of at most the burst size
+type limitWriter struct {
+	w        io.WriteCloser
+	ctx      context.Context
+	limiters []*Limiter
+}
+
+// Wrap the writer with the limiters which are set
+func newLimitWriter(ctx context.Context, w io.WriteCloser, limiters ...*Limiter) io.WriteCloser {
+	lw := &limitWriter{w: w, ctx: ctx}
+	for _, l := range limiters {
+		if l != nil {
+			lw.limiters = append(lw.limiters, l)
+		}
+	}
+	if len(lw.limiters) == 0 {
+		return w
+	}
+	return lw
+}
+
+func (lw *limitWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		for _, l := range lw.limiters {
+			l.mu.Lock()
+			if b := int(l.burst); b > 0 && len(chunk) > b {
+				chunk = chunk[:b]
+			}
+			l.mu.Unlock()
+		}
+		for _, l := range lw.limiters {
+			if err = l.WaitN(lw.ctx, len(chunk)); err != nil {
+				return
+			}
+		}
+		var m int
+		m, err = lw.w.Write(chunk)
+		n += m
+		if err != nil {
+			return
+		}
+		p = p[m:]
+	}
+	return
+}
+
+func (lw *limitWriter) Close() error { return lw.w.Close() }
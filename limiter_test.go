@@ -0,0 +1,104 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/flowfiletest"
+)
+
+func TestLimiter(t *testing.T) {
+	clk := flowfiletest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+
+	l := flowfile.NewLimiter(100, 100)
+	l.Clock = clk
+	if err := l.WaitN(ctx, 100); err != nil {
+		t.Fatal(err) // The burst is let through at once
+	}
+
+	// Half a second of bytes waits half a second
+	done := make(chan error, 1)
+	go func() { done <- l.WaitN(ctx, 50) }()
+	clk.BlockUntil(1)
+	clk.Advance(400 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("let through before the tokens accrued")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clk.Advance(100 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// A waiter giving up hands back what it reserved
+	cctx, cancel := context.WithCancel(ctx)
+	go func() { done <- l.WaitN(cctx, 50) }()
+	clk.BlockUntil(1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("expecting context.Canceled, got %v", err)
+	}
+	clk.Advance(500 * time.Millisecond)
+	if err := waitNow(l, 50); err != nil {
+		t.Errorf("expecting the handed back tokens available, %v", err)
+	}
+
+	// Without a rate, or a Limiter, nothing waits
+	l.SetLimit(0, 0)
+	if err := waitNow(l, 1<<30); err != nil {
+		t.Errorf("expecting no limit, %v", err)
+	}
+	if err := waitNow(nil, 1<<30); err != nil {
+		t.Errorf("expecting no limit from a nil Limiter, %v", err)
+	}
+}
+
+func TestLimiterShared(t *testing.T) {
+	egress := flowfile.NewLimiter(1<<20, 100<<10)
+
+	// Two transactions sending 300 KiB each share the one MiB/s budget
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		_, ts := newTestReceiver(t) // A receiver each, only the Limiter is shared
+		hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hs.Limiter = egress
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dat := make([]byte, 300<<10)
+			if err := hs.Send(flowfile.New(bytes.NewReader(dat), int64(len(dat)))); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d < 400*time.Millisecond {
+		t.Errorf("600 KiB sent in %v, over the shared limit", d)
+	}
+}
+
+// Wait for the bytes, failing if this would block
+func waitNow(l *flowfile.Limiter, n int) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- l.WaitN(ctx, n) }()
+	select {
+	case err := <-done:
+		cancel()
+		return err
+	case <-time.After(50 * time.Millisecond):
+		cancel()
+		<-done
+		return context.DeadlineExceeded
+	}
+}
@@ -0,0 +1,33 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"io"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+func init() {
+	RegisterCodec(NewLZ4Codec())
+}
+
+// NewLZ4Codec creates a Codec for the LZ4 frame format, which like Snappy
+// keeps the CPU cost low for latency sensitive flows while still saving on the
+// wire.  Blocks are kept to 64KB so a flushed POST is not held up building
+// large blocks.
+func NewLZ4Codec() Codec {
+	return Codec{
+		Name: "lz4",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			zw := lz4.NewWriter(w)
+			if err := zw.Apply(lz4.BlockSizeOption(lz4.Block64Kb), lz4.ConcurrencyOption(1)); err != nil {
+				return nil, err
+			}
+			return zw, nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(lz4.NewReader(r)), nil
+		},
+		MimeType:  "application/x-lz4-framed",
+		Extension: ".lz4",
+	}
+}
@@ -0,0 +1,60 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// MaxManifestSize limits the size of a manifest read from the reply to a POST.
+var MaxManifestSize int64 = 16 << 20
+
+// A Manifest lists the Files an HTTPReceiver processed from a POST, in the
+// order they were handled.  It is replied with in a JSON body when the
+// receiver has Manifest set, and the HTTPPostWriter marks the Files listed as
+// Accepted in its Transcript.
+//
+//   {"files":[{"uuid":"3d5a...","size":1024},{"uuid":"9b1c...","size":12}]}
+type Manifest struct {
+	Files []ManifestEntry `json:"files"`
+}
+
+// A ManifestEntry is a File processed by the receiver and the content bytes
+// it was given.
+type ManifestEntry struct {
+	UUID string `json:"uuid"`
+	Size int64  `json:"size"`
+}
+
+func (m *Manifest) add(f *File) {
+	m.Files = append(m.Files, ManifestEntry{UUID: f.Attrs.Get("uuid"), Size: f.Size})
+}
+
+// Read a manifest from the reply to a POST, nil when the reply has none
+func readManifest(resp *http.Response) *Manifest {
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != "application/json" {
+		return nil
+	}
+	m := &Manifest{}
+	if json.NewDecoder(io.LimitReader(resp.Body, MaxManifestSize)).Decode(m) != nil {
+		return nil
+	}
+	return m
+}
+
+// Mark the transcript entries of the POST which are listed in the manifest.
+// Both are in the order the Files were sent, so Files without a uuid are
+// matched by their place in the POST.
+func (m *Manifest) accept(transcript []TranscriptEntry, post int) {
+	i := 0
+	for _, me := range m.Files {
+		for ; i < len(transcript); i++ {
+			if e := &transcript[i]; e.Post == post && e.Err == nil && e.UUID == me.UUID {
+				e.Accepted, e.AcceptedSize = true, me.Size
+				i++
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package flowfile_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+// Write the Files in one POST, returning the writer once closed
+func postFiles(t *testing.T, url string, ff ...*flowfile.File) *flowfile.HTTPPostWriter {
+	t.Helper()
+	hs, err := flowfile.NewHTTPTransaction(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := hs.NewHTTPPostWriter()
+	for _, f := range ff {
+		if _, err = w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestManifestReceiver(t *testing.T) {
+	_, ts := newTestReceiver(t)
+	ff := stringFiles("abc", "defgh")
+	for _, f := range ff {
+		f.Attrs.GenerateUUID()
+	}
+	w := postFiles(t, ts.URL, ff...)
+
+	if w.Manifest == nil || len(w.Manifest.Files) != 2 {
+		t.Fatalf("expecting a manifest of 2 Files, got %+v", w.Manifest)
+	}
+	for i, e := range w.Transcript() {
+		if !e.Accepted || e.AcceptedSize != ff[i].Size || w.Manifest.Files[i].UUID != e.UUID {
+			t.Errorf("entry %d: not accepted as listed, %+v", i, e)
+		}
+	}
+}
+
+func TestManifestPartial(t *testing.T) {
+	// A receiver listing only some of the Files
+	var reply flowfile.Manifest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept", "application/flowfile-v3")
+		w.Header().Set("x-nifi-transfer-protocol-version", "3")
+		if r.Method != "POST" {
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		if reply.Files == nil {
+			return // Replied to as NiFi would, without a manifest
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(reply)
+	}))
+	defer ts.Close()
+
+	ff := stringFiles("abc", "defgh", "ij")
+	for _, f := range ff {
+		f.Attrs.GenerateUUID()
+	}
+	reply.Files = []flowfile.ManifestEntry{
+		{UUID: ff[0].Attrs.Get("uuid"), Size: 3},
+		{UUID: ff[2].Attrs.Get("uuid"), Size: 1}, // cut short
+	}
+	w := postFiles(t, ts.URL, ff...)
+	tr := w.Transcript()
+	if !tr[0].Accepted || tr[1].Accepted || !tr[2].Accepted || tr[2].AcceptedSize != 1 {
+		t.Errorf("expecting the first and last accepted, got %+v", tr)
+	}
+
+	reply.Files = nil
+	w = postFiles(t, ts.URL, stringFiles("abc")...)
+	if w.Manifest != nil || w.Transcript()[0].Accepted {
+		t.Errorf("expecting no manifest from a reply without one")
+	}
+}
@@ -6,33 +6,87 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
+	"time"
 )
 
 type Writer struct {
 	w io.Writer
+
+	// When set, the bytes written and the time taken to copy each File are
+	// recorded, along with the throughput of the last File.
+	MetricsSink MetricsSink
+
+	// Debug output for this writer
+	DebugLog
 }
 
 func NewWriter(w io.Writer) *Writer {
 	return &Writer{w: w}
 }
 
-// Encode a flowfile into an io.Writer
+// Encode a flowfile into an io.Writer, the reader returns the error when the
+// attributes cannot be encoded.
 func (f *File) EncodedReader() (rdr io.Reader) {
+	rdr, err := f.encodedReader()
+	if err != nil {
+		return errorReader{err}
+	}
+	return
+}
+
+func (f *File) encodedReader() (io.Reader, error) {
 	header := bytes.NewBuffer([]byte{})
-	f.Attrs.WriteTo(header)
+	if err := f.Attrs.WriteTo(header); err != nil {
+		return nil, err
+	}
 	binary.Write(header, binary.BigEndian, uint64(f.Size))
 	if f.Size == 0 {
-		return header
+		return header, nil
 	}
-	return io.MultiReader(header, f)
+	return io.MultiReader(header, f), nil
 }
 
-// Encode a flowfile into an io.Writer
+// A reader which only returns the error
+type errorReader struct{ err error }
+
+func (r errorReader) Read([]byte) (int, error) { return 0, r.err }
+
+// Encode a flowfile into an io.Writer, the content is copied with a buffer from
+// the DefaultBufferPool.
 func (e *Writer) Write(f *File) (n int64, err error) {
-	n, err = io.Copy(e.w, f.EncodedReader())
-	if Debug && err != nil {
-		log.Println("Failed to send contents", err)
+	if e.MetricsSink != nil {
+		defer func(start time.Time) {
+			secs := DefaultClock.Now().Sub(start).Seconds()
+			e.MetricsSink.Counter("flowfiles_write_bytes_total", float64(n))
+			e.MetricsSink.Observe("flowfiles_write_duration_seconds", secs)
+			if secs > 0 {
+				e.MetricsSink.Gauge("flowfiles_write_throughput_bytes_per_second", float64(n)/secs)
+			}
+		}(DefaultClock.Now())
+	}
+
+	var rdr io.Reader
+	if sidecar, attrs := splitSidecar(f.Attrs); sidecar != nil {
+		// Send the oversized attributes ahead of the File
+		orig := f.Attrs
+		f.Attrs = attrs
+		rdr, err = f.encodedReader()
+		f.Attrs = orig
+		if err != nil {
+			return
+		}
+		if n, err = copyBuffer(e.w, sidecar.EncodedReader()); err != nil {
+			return
+		}
+	} else if rdr, err = f.encodedReader(); err != nil {
+		return
+	}
+	var m int64
+	m, err = copyBuffer(e.w, rdr)
+	n += m
+	if err != nil {
+		e.debugln("Failed to send contents", err)
 	}
 	return
 }
@@ -57,7 +111,11 @@ func parseOne(in io.Reader) (f *File, err error) {
 	}
 	var N uint64
 	if err = binary.Read(in, binary.BigEndian, &N); err != nil {
-		return nil, fmt.Errorf("Error parsing file size: %s", err)
+		return nil, fmt.Errorf("Error parsing file size: %w", truncated(err, ErrorTruncatedStream))
+	}
+
+	if N > math.MaxInt64 || (MaxContentSize > 0 && int64(N) > MaxContentSize) {
+		return nil, fmt.Errorf("%w: content of %d bytes", ErrorHeaderLimit, N)
 	}
 
 	f = &File{Size: int64(N), n: int64(N), Attrs: a}
@@ -65,8 +123,10 @@ func parseOne(in io.Reader) (f *File, err error) {
 	if ra, ok := in.(io.ReaderAt); ok {
 		if rs, ok := in.(io.ReadSeeker); ok {
 			// If a read seeker is implemented, get our current position so we can
-			// make sure we stay in the right place or enable resetting.
+			// make sure we stay in the right place or enable resetting.  The
+			// stream is kept so Close can move it past the content.
 			f.i, _ = rs.Seek(0, io.SeekCurrent)
+			f.r = in
 		}
 		f.ra = ra
 	} else {
@@ -0,0 +1,199 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+// A FlowFile with no attributes claiming the content size given
+func rawHeader(size uint64) []byte {
+	buf := []byte("NiFiFF3\x00\x00")
+	return binary.BigEndian.AppendUint64(buf, size)
+}
+
+func TestScanContentSizeOverflow(t *testing.T) {
+	for _, size := range []uint64{math.MaxInt64 + 1, math.MaxUint64} {
+		// A MultiReader hides the ReaderAt so the Scanner buffers the content
+		s := flowfile.NewScanner(io.MultiReader(bytes.NewReader(rawHeader(size))))
+		s.BufferThreshold = 1 << 20
+		if s.Scan() {
+			t.Fatalf("size %d: scanned a File with size %d", size, s.File().Size)
+		}
+		if err := s.Err(); !errors.Is(err, flowfile.ErrorHeaderLimit) {
+			t.Errorf("size %d: expecting ErrorHeaderLimit, got %v", size, err)
+		}
+	}
+}
+
+func TestScanMaxContentSize(t *testing.T) {
+	defer func(old int64) { flowfile.MaxContentSize = old }(flowfile.MaxContentSize)
+	flowfile.MaxContentSize = 10
+
+	dat := append(rawHeader(11), make([]byte, 11)...)
+	var f flowfile.File
+	if err := f.UnmarshalBinary(dat); !errors.Is(err, flowfile.ErrorHeaderLimit) {
+		t.Errorf("expecting ErrorHeaderLimit over MaxContentSize, got %v", err)
+	}
+
+	dat = append(rawHeader(10), make([]byte, 10)...)
+	if err := f.UnmarshalBinary(dat); err != nil {
+		t.Errorf("expecting a File at MaxContentSize, got %v", err)
+	}
+}
+
+func TestReceiverContentSizeOverflow(t *testing.T) {
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		t.Errorf("handler called with a File of size %d", f.Size)
+		return nil
+	})
+	rcv.BufferThreshold = 1 << 20
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+
+	res, err := http.Post(ts.URL, "application/flowfile-v3", bytes.NewReader(rawHeader(math.MaxUint64)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expecting a 400, got %d", res.StatusCode)
+	}
+}
+
+// Attributes in the FlowFile v3 encoding, without the magic
+func rawAttrs(kv ...string) []byte {
+	buf := binary.BigEndian.AppendUint16(nil, uint16(len(kv)/2))
+	for _, s := range kv {
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+		buf = append(buf, s...)
+	}
+	return buf
+}
+
+func TestParseLimits(t *testing.T) {
+	defer func(count int, header int64) {
+		flowfile.MaxAttributeCount, flowfile.MaxHeaderSize = count, header
+	}(flowfile.MaxAttributeCount, flowfile.MaxHeaderSize)
+	flowfile.MaxAttributeCount, flowfile.MaxHeaderSize = 2, 64
+
+	magic := []byte("NiFiFF3")
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	whole := join(magic, rawAttrs("a", "1"), rawHeader(0)[9:])
+
+	for _, tc := range []struct {
+		name string
+		dat  []byte
+		err  error
+	}{
+		{"whole", whole, nil},
+		{"bad magic", join([]byte("NiFiFF2"), rawAttrs(), rawHeader(0)[9:]), flowfile.ErrorBadMagic},
+		{"short magic", magic[:4], flowfile.ErrorTruncatedStream},
+		{"no attribute count", magic, flowfile.ErrorTruncatedStream},
+		{"short attribute", whole[:len(whole)-12], flowfile.ErrorShortAttribute},
+		{"no content size", whole[:len(whole)-8], flowfile.ErrorTruncatedStream},
+		{"short content size", whole[:len(whole)-3], flowfile.ErrorTruncatedStream},
+		{"attribute count", join(magic, rawAttrs("a", "1", "b", "2", "c", "3"), rawHeader(0)[9:]), flowfile.ErrorHeaderLimit},
+		{"header size", join(magic, rawAttrs("a", string(make([]byte, 60))), rawHeader(0)[9:]), flowfile.ErrorHeaderLimit},
+	} {
+		s := flowfile.NewScanner(bytes.NewReader(tc.dat))
+		s.Scan()
+		if err := s.Err(); !errors.Is(err, tc.err) || (tc.err == nil) != (err == nil) {
+			t.Errorf("%s: expecting %v, got %v", tc.name, tc.err, err)
+		}
+	}
+
+	// The limits can be turned off
+	flowfile.MaxAttributeCount, flowfile.MaxHeaderSize = 0, 0
+	s := flowfile.NewScanner(bytes.NewReader(join(magic, rawAttrs("a", string(make([]byte, 60)), "b", "2", "c", "3"), rawHeader(0)[9:])))
+	if !s.Scan() || len(s.File().Attrs) != 3 {
+		t.Errorf("expecting the File without limits, got %v", s.Err())
+	}
+}
+
+// A length in the extended form, 0xFFFF followed by a uint32
+func extendedLength(n int) []byte {
+	return binary.BigEndian.AppendUint32([]byte{0xFF, 0xFF}, uint32(n))
+}
+
+func TestParseExtendedLength(t *testing.T) {
+	magic := []byte("NiFiFF3")
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	big := strings.Repeat("x", 0x10000)
+
+	// As NiFi writes the values of 0xFFFF bytes and over, the count and
+	// shorter lengths may also be extended
+	dat := join(magic, extendedLength(2),
+		rawAttrs("a", "1")[2:],
+		extendedLength(3), []byte("big"), extendedLength(len(big)), []byte(big),
+		rawHeader(0)[9:])
+	s := flowfile.NewScanner(bytes.NewReader(dat))
+	if !s.Scan() {
+		t.Fatal(s.Err())
+	}
+	if a := s.File().Attrs; len(a) != 2 || a.Get("a") != "1" || a.Get("big") != big {
+		t.Errorf("expecting the extended lengths read, got %d attributes and %d bytes", len(a), len(a.Get("big")))
+	}
+
+	for _, tc := range []struct {
+		name string
+		dat  []byte
+		err  error
+	}{
+		{"over the header size", join(magic, []byte{0, 1}, extendedLength(math.MaxUint32)), flowfile.ErrorHeaderLimit},
+		{"over the attribute count", join(magic, extendedLength(math.MaxUint32)), flowfile.ErrorHeaderLimit},
+		{"short extended length", join(magic, []byte{0, 1, 0xFF, 0xFF, 0, 0}), flowfile.ErrorShortAttribute},
+	} {
+		s := flowfile.NewScanner(bytes.NewReader(tc.dat))
+		s.Scan()
+		if err := s.Err(); !errors.Is(err, tc.err) {
+			t.Errorf("%s: expecting %v, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+// A BufferPool counting the buffers handed out
+type countingPool struct {
+	flowfile.BufferPool
+	gets int
+}
+
+func (p *countingPool) Get() []byte { p.gets++; return p.BufferPool.Get() }
+
+func TestWriterMetrics(t *testing.T) {
+	pool := &countingPool{BufferPool: flowfile.NewBufferPool(1024)}
+	defer func(old flowfile.BufferPool) { flowfile.DefaultBufferPool = old }(flowfile.DefaultBufferPool)
+	flowfile.DefaultBufferPool = pool
+
+	sink := flowfile.NewPrometheusSink()
+	var buf bytes.Buffer
+	w := flowfile.NewWriter(&buf)
+	w.MetricsSink = sink
+	dat := strings.Repeat("x", 5000)
+	n, err := w.Write(stringFiles(dat)[0])
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("expecting %d bytes written, got %d %v", buf.Len(), n, err)
+	}
+	if pool.gets == 0 {
+		t.Errorf("expecting the content copied with a pooled buffer")
+	}
+
+	out := sink.String()
+	for _, line := range []string{
+		fmt.Sprintf("flowfiles_write_bytes_total %d", n),
+		"flowfiles_write_duration_seconds_count 1",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+}
@@ -7,31 +7,62 @@ import (
 	"time"
 )
 
+// maxLatencyWriter buffers writes and flushes them once the writes have gone
+// idle, or the oldest buffered data has waited the max latency.  This keeps
+// latency low for small files while avoiding excessive flushes under
+// sustained throughput.
 type maxLatencyWriter struct {
 	dst     *bufio.Writer
 	c       io.Closer
-	latency time.Duration
+	latency time.Duration // longest time data may sit in the buffer
+	idle    time.Duration // flush when no writes have been seen for this long
 
-	mu   sync.Mutex // protects Write + Flush
-	done chan bool
+	mu      sync.Mutex // protects Write + Flush
+	done    chan bool
+	pending bool      // unflushed data is in the buffer
+	first   time.Time // when the oldest unflushed data was written
+	last    time.Time // when the latest write was done
+	clock   Clock
 }
 
-func (m *maxLatencyWriter) Write(p []byte) (int, error) {
+func (m *maxLatencyWriter) Write(p []byte) (n int, err error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	return m.dst.Write(p)
+	n, err = m.dst.Write(p)
+	if m.dst.Buffered() == 0 {
+		// The buffer filled up and was flushed out
+		m.pending = false
+		return
+	}
+	now := m.clock.Now()
+	if !m.pending {
+		m.pending, m.first = true, now
+	}
+	m.last = now
+	return
 }
 
 func (m *maxLatencyWriter) flushLoop() {
-	t := time.NewTicker(m.latency)
+	tick := m.idle
+	if tick <= 0 || (m.latency > 0 && m.latency < tick) {
+		tick = m.latency
+	}
+	if tick <= 0 {
+		tick = 400 * time.Millisecond
+	}
+	t := m.clock.NewTicker(tick)
 	defer t.Stop()
 	for {
 		select {
 		case <-m.done:
 			return
-		case <-t.C:
+		case now := <-t.C():
 			m.mu.Lock()
-			m.dst.Flush()
+			if m.pending && ((m.idle > 0 && now.Sub(m.last) >= m.idle) ||
+				now.Sub(m.first) >= m.latency) {
+				m.dst.Flush()
+				m.pending = false
+			}
 			m.mu.Unlock()
 		}
 	}
@@ -0,0 +1,338 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemFS is an in-memory WritableFS, useful for tests.  It also implements
+// fs.FS so the saved content can be read back, such as with fs.ReadFile.
+type MemFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode
+}
+
+type memNode struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+	target  string // for symlinks
+}
+
+// Create a new empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{nodes: map[string]*memNode{
+		".": {mode: fs.ModeDir | 0755, modTime: DefaultClock.Now()},
+	}}
+}
+
+func memName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (m *MemFS) node(name string) (string, *memNode, error) {
+	name = memName(name)
+	if name == "" {
+		name = "."
+	}
+	n, ok := m.nodes[name]
+	if !ok {
+		return name, nil, fs.ErrNotExist
+	}
+	return name, n, nil
+}
+
+// Make sure the parent directory exists
+func (m *MemFS) parent(name string) error {
+	dir := path.Dir(name)
+	if dir == "" {
+		dir = "."
+	}
+	if p, ok := m.nodes[dir]; !ok || !p.mode.IsDir() {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+func (m *MemFS) OpenFile(name string, flag int, perm os.FileMode) (WritableFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, n, err := m.node(name)
+	switch {
+	case err != nil && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	case err != nil:
+		if err = m.parent(name); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		n = &memNode{mode: perm.Perm(), modTime: DefaultClock.Now()}
+		m.nodes[name] = n
+	case flag&os.O_EXCL != 0 && flag&os.O_CREATE != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case n.mode.IsDir() && flag&(os.O_WRONLY|os.O_RDWR) != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if flag&os.O_TRUNC != 0 {
+		n.data = nil
+	}
+	f := &memFile{fs: m, name: name, n: n}
+	if flag&os.O_APPEND != 0 {
+		f.off = int64(len(n.data))
+	}
+	return f, nil
+}
+
+// Open implements fs.FS for reading back the content.
+func (m *MemFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := m.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return f.(*memFile), nil
+}
+
+func (m *MemFS) Mkdir(name string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, _, err := m.node(name)
+	if err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if err = m.parent(name); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	m.nodes[name] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: DefaultClock.Now()}
+	return nil
+}
+
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := 0; i < 40; i++ {
+		full, n, err := m.node(name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+		}
+		if n.mode&fs.ModeSymlink == 0 {
+			return memInfo{name: path.Base(full), n: n}, nil
+		}
+		name = n.target
+		if !path.IsAbs(name) {
+			name = path.Join(path.Dir(full), name)
+		}
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+}
+
+func (m *MemFS) Lstat(name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	full, n, err := m.node(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return memInfo{name: path.Base(full), n: n}, nil
+}
+
+func (m *MemFS) Chmod(name string, mode os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.node(name)
+	if err != nil {
+		return &fs.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	n.mode = n.mode.Type() | mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)
+	return nil
+}
+
+func (m *MemFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.node(name)
+	if err != nil {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	n.modTime = mtime
+	return nil
+}
+
+func (m *MemFS) Rename(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldname, n, err := m.node(oldname)
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	newname = memName(newname)
+	if err = m.parent(newname); err != nil {
+		return &fs.PathError{Op: "rename", Path: newname, Err: err}
+	}
+	delete(m.nodes, oldname)
+	m.nodes[newname] = n
+	if n.mode.IsDir() { // Move the children along
+		for name, child := range m.nodes {
+			if strings.HasPrefix(name, oldname+"/") {
+				delete(m.nodes, name)
+				m.nodes[newname+name[len(oldname):]] = child
+			}
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, _, err := m.node(name)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	for other := range m.nodes {
+		if strings.HasPrefix(other, name+"/") {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+		}
+	}
+	delete(m.nodes, name)
+	return nil
+}
+
+func (m *MemFS) Symlink(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	newname, _, err := m.node(newname)
+	if err == nil {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	}
+	if err = m.parent(newname); err != nil {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: err}
+	}
+	m.nodes[newname] = &memNode{mode: fs.ModeSymlink | 0777, modTime: DefaultClock.Now(), target: oldname}
+	return nil
+}
+
+// Link makes newname a hard link to oldname, the two share the content.
+func (m *MemFS) Link(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldname, n, err := m.node(oldname)
+	if err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	if n.mode.IsDir() {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrInvalid}
+	}
+	newname, _, err = m.node(newname)
+	if err == nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+	if err = m.parent(newname); err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	m.nodes[newname] = n
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, n, err := m.node(name)
+	if err != nil || !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	var out []fs.DirEntry
+	for other, child := range m.nodes {
+		if other != "." && strings.HasPrefix(other, prefix) && !strings.Contains(other[len(prefix):], "/") {
+			out = append(out, fs.FileInfoToDirEntry(memInfo{name: path.Base(other), n: child}))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, nil
+}
+
+type memFile struct {
+	fs   *MemFS
+	name string
+	n    *memNode
+	off  int64
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if off >= int64(len(f.n.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.n.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	n, err := f.WriteAt(p, f.off)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if end := off + int64(len(p)); end > int64(len(f.n.data)) {
+		f.n.data = append(f.n.data, make([]byte, end-int64(len(f.n.data)))...)
+	}
+	copy(f.n.data[off:], p)
+	f.n.modTime = DefaultClock.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if size <= int64(len(f.n.data)) {
+		f.n.data = f.n.data[:size]
+	} else {
+		f.n.data = append(f.n.data, make([]byte, size-int64(len(f.n.data)))...)
+	}
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return memInfo{name: path.Base(f.name), n: f.n}, nil
+}
+
+func (f *memFile) Close() error { return nil }
+
+type memInfo struct {
+	name string
+	n    *memNode
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return int64(len(i.n.data)) }
+func (i memInfo) Mode() os.FileMode  { return i.n.mode }
+func (i memInfo) ModTime() time.Time { return i.n.modTime }
+func (i memInfo) IsDir() bool        { return i.n.mode.IsDir() }
+func (i memInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,248 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A Merger bins incoming Files and emits them as fewer, larger, concatenated
+// Files, much like the NiFi MergeContent processor.  Files are binned by the
+// value of the CorrelationAttribute and a bin is emitted once any of the
+// MaxCount, MaxBytes, or MaxAge thresholds has been reached.
+//
+// The merged File keeps only the attributes which are common to all the
+// binned Files and adds the merge.count, merge.bin.age, and merge.reason
+// attributes.
+//
+// Note: The content of each binned File is held in memory until the bin is
+// emitted, so the thresholds should be kept to sane values.
+type Merger struct {
+	CorrelationAttribute string        // Attribute used to sort Files into bins
+	MaxCount             int           // Emit a bin when it holds this many Files
+	MaxBytes             int64         // Emit a bin when its content reaches this size
+	MaxAge               time.Duration // Emit a bin when it has been open this long
+
+	// Optional bytes to place before, after, and between the merged content
+	Header, Footer, Demarcator []byte
+
+	// The source of time for the MaxAge and merge.bin.age, defaults to the
+	// DefaultClock.
+	Clock Clock
+
+	out  func(*File) error
+	mu   sync.Mutex
+	bins map[string]*mergeBin
+}
+
+type mergeBin struct {
+	created time.Time
+	buf     *bytes.Buffer
+	attrs   Attributes
+	count   int
+	size    int64 // content size, not counting header, footer or demarcators
+}
+
+// Create a new Merger which will call out with each merged File.  The out
+// function is called without the Merger locked, so it may Add to or Flush the
+// Merger, and it may be called concurrently when Files are added
+// concurrently.  When it returns an error the bin is kept, to be emitted again
+// with the next threshold or Flush.
+func NewMerger(out func(*File) error) *Merger {
+	return &Merger{
+		out:  out,
+		bins: make(map[string]*mergeBin),
+	}
+}
+
+// A bin taken out of the Merger to be emitted
+type mergeReady struct {
+	key, reason string
+	b           *mergeBin
+}
+
+// Add reads the content of the File into the matching bin, emitting the bin
+// if a threshold has been met.  Any expired bins are also emitted.  When the
+// content cannot be read, the bin is left as it was.
+func (m *Merger) Add(f *File) (err error) {
+	m.mu.Lock()
+	ready, err := m.add(f)
+	ready = append(ready, m.expired()...)
+	m.mu.Unlock()
+
+	if eerr := m.emit(ready); err == nil {
+		err = eerr
+	}
+	return
+}
+
+// Read the File into its bin and take out the bins which are due, must be
+// called with the lock held.
+func (m *Merger) add(f *File) (ready []mergeReady, err error) {
+	key := ""
+	if m.CorrelationAttribute != "" {
+		key = f.Attrs.Get(m.CorrelationAttribute)
+	}
+
+	// Make room in the bin if this File would push it over the size limit
+	b, ok := m.bins[key]
+	if ok && m.MaxBytes > 0 && b.size+f.Size > m.MaxBytes {
+		ready = append(ready, m.detach(key, "MAX_BYTES_THRESHOLD_REACHED"))
+		ok = false
+	}
+
+	if !ok {
+		b = &mergeBin{
+			created: m.clock().Now(),
+			buf:     bytes.NewBuffer(nil),
+			attrs:   f.Attrs.Clone(),
+		}
+		b.buf.Write(m.Header)
+	}
+	mark := b.buf.Len()
+	if ok {
+		b.buf.Write(m.Demarcator)
+	}
+
+	var n int64
+	n, err = io.Copy(b.buf, f)
+	if err == nil && n != f.Size {
+		err = fmt.Errorf("%w merging file, %d of %d bytes", ErrorShortRead, n, f.Size)
+	}
+	if err != nil {
+		// Drop the partial content, a new bin is not kept
+		b.buf.Truncate(mark)
+		return
+	}
+	if ok {
+		b.attrs = commonAttributes(b.attrs, f.Attrs)
+	}
+	b.count++
+	b.size += n
+	m.bins[key] = b
+
+	switch {
+	case m.MaxCount > 0 && b.count >= m.MaxCount:
+		ready = append(ready, m.detach(key, "MAX_ENTRIES_THRESHOLD_REACHED"))
+	case m.MaxBytes > 0 && b.size >= m.MaxBytes:
+		ready = append(ready, m.detach(key, "MAX_BYTES_THRESHOLD_REACHED"))
+	}
+	return
+}
+
+// FlushExpired emits any bins which have been open longer than MaxAge.  This
+// should be called periodically when the incoming Files are sparse.
+func (m *Merger) FlushExpired() error {
+	m.mu.Lock()
+	ready := m.expired()
+	m.mu.Unlock()
+	return m.emit(ready)
+}
+
+// Flush emits all the bins regardless of the thresholds.
+func (m *Merger) Flush() error {
+	m.mu.Lock()
+	var ready []mergeReady
+	for key := range m.bins {
+		ready = append(ready, m.detach(key, "MIN_THRESHOLDS_REACHED"))
+	}
+	m.mu.Unlock()
+	return m.emit(ready)
+}
+
+// Take out the bins which have been open longer than MaxAge, must be called
+// with the lock held.
+func (m *Merger) expired() (ready []mergeReady) {
+	if m.MaxAge <= 0 {
+		return
+	}
+	now := m.clock().Now()
+	for key, b := range m.bins {
+		if now.Sub(b.created) >= m.MaxAge {
+			ready = append(ready, m.detach(key, "TIMEOUT"))
+		}
+	}
+	return
+}
+
+// Take the bin out of the Merger, must be called with the lock held.
+func (m *Merger) detach(key, reason string) mergeReady {
+	b := m.bins[key]
+	delete(m.bins, key)
+	return mergeReady{key: key, reason: reason, b: b}
+}
+
+// Send out the merged Files, the bins which fail are put back and the first
+// error is returned.  Must be called without the lock held.
+func (m *Merger) emit(ready []mergeReady) (err error) {
+	if m.out == nil {
+		return
+	}
+	for _, r := range ready {
+		if oerr := m.out(m.merged(r)); oerr != nil {
+			m.restore(r.key, r.b)
+			if err == nil {
+				err = oerr
+			}
+		}
+	}
+	return
+}
+
+// Build the merged File of a bin, leaving the bin as it was so it can be put
+// back
+func (m *Merger) merged(r mergeReady) *File {
+	b := r.b
+	// The capacity is capped so the Footer is not written into the bin
+	dat := b.buf.Bytes()
+	dat = append(dat[:len(dat):len(dat)], m.Footer...)
+	f := New(bytes.NewReader(dat), int64(len(dat)))
+	f.Attrs = b.attrs.Clone()
+	f.Attrs.Unset("checksum")
+	f.Attrs.Unset("checksumType")
+	f.Attrs.Set("merge.count", fmt.Sprintf("%d", b.count))
+	f.Attrs.Set("merge.bin.age", fmt.Sprintf("%d", m.clock().Now().Sub(b.created).Milliseconds()))
+	f.Attrs.Set("merge.reason", r.reason)
+	id := f.Attrs.GenerateUUID()
+	if f.Attrs.Get("filename") == "" {
+		f.Attrs.Set("filename", id)
+	}
+	return f
+}
+
+// Put back a bin which could not be sent out, ahead of the content of any bin
+// opened for the key since
+func (m *Merger) restore(key string, b *mergeBin) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if newer, ok := m.bins[key]; ok {
+		b.buf.Write(m.Demarcator)
+		b.buf.Write(newer.buf.Bytes()[len(m.Header):])
+		b.attrs = commonAttributes(b.attrs, newer.attrs)
+		b.count += newer.count
+		b.size += newer.size
+	}
+	m.bins[key] = b
+}
+
+func (m *Merger) clock() Clock {
+	return clockOr(m.Clock)
+}
+
+// Return only the attributes which are the same in both sets
+func commonAttributes(a, b Attributes) Attributes {
+	out := Attributes{}
+	for _, attr := range a {
+		for _, other := range b {
+			if attr.Name == other.Name {
+				if attr.Value == other.Value {
+					out = append(out, attr)
+				}
+				break
+			}
+		}
+	}
+	return out
+}
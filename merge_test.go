@@ -0,0 +1,211 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/flowfiletest"
+)
+
+// This example shows how to merge small files into larger files
+func ExampleNewMerger() {
+	m := flowfile.NewMerger(func(f *flowfile.File) error {
+		buf := bytes.NewBuffer([]byte{})
+		buf.ReadFrom(f)
+		fmt.Printf("merged %s files for %s: %q\n", f.Attrs.Get("merge.count"),
+			f.Attrs.Get("project"), buf.String())
+		return nil
+	})
+	m.CorrelationAttribute = "project"
+	m.MaxCount = 2
+	m.Demarcator = []byte("\n")
+
+	for i, p := range []string{"a", "b", "a", "b"} {
+		f := flowfile.New(strings.NewReader(fmt.Sprintf("line %d", i)), 6)
+		f.Attrs.Set("project", p)
+		m.Add(f)
+	}
+
+	// Output:
+	// merged 2 files for a: "line 0\nline 2"
+	// merged 2 files for b: "line 1\nline 3"
+}
+
+// A merged File as it was sent out
+type merged struct {
+	content string
+	attrs   flowfile.Attributes
+}
+
+func collectMerged(t *testing.T) (*[]merged, func(*flowfile.File) error) {
+	var out []merged
+	return &out, func(f *flowfile.File) error {
+		dat, err := io.ReadAll(f)
+		if err != nil {
+			t.Error(err)
+		}
+		out = append(out, merged{string(dat), f.Attrs})
+		return nil
+	}
+}
+
+func TestMergerMaxBytes(t *testing.T) {
+	got, out := collectMerged(t)
+	m := flowfile.NewMerger(out)
+	m.MaxBytes = 10
+	m.Header, m.Footer, m.Demarcator = []byte("["), []byte("]"), []byte(",")
+	for _, f := range stringFiles("abcd", "efgh", "ijkl", "mnopqr") {
+		if err := m.Add(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The third File would go over, the fourth brings the bin to the limit
+	want := []merged{{content: "[abcd,efgh]"}, {content: "[ijkl,mnopqr]"}}
+	if len(*got) != len(want) {
+		t.Fatalf("expecting %d merged Files, got %v", len(want), *got)
+	}
+	for i, w := range want {
+		g := (*got)[i]
+		if g.content != w.content || g.attrs.Get("merge.count") != "2" ||
+			g.attrs.Get("merge.reason") != "MAX_BYTES_THRESHOLD_REACHED" {
+			t.Errorf("expecting %q from 2 Files, got %q %v", w.content, g.content, g.attrs)
+		}
+	}
+}
+
+func TestMergerMaxAge(t *testing.T) {
+	clk := flowfiletest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	got, out := collectMerged(t)
+	m := flowfile.NewMerger(out)
+	m.Clock = clk
+	m.MaxAge = time.Minute
+	if err := m.Add(stringFiles("abc")[0]); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(30 * time.Second)
+	if err := m.FlushExpired(); err != nil || len(*got) != 0 {
+		t.Fatalf("expecting the bin kept before MaxAge, got %v %v", *got, err)
+	}
+	clk.Advance(30 * time.Second)
+	if err := m.FlushExpired(); err != nil || len(*got) != 1 {
+		t.Fatalf("expecting the bin emitted at MaxAge, got %v %v", *got, err)
+	}
+	if a := (*got)[0].attrs; (*got)[0].content != "abc" || a.Get("merge.reason") != "TIMEOUT" ||
+		a.Get("merge.bin.age") != "60000" {
+		t.Errorf("expecting the timed out bin, got %v", (*got)[0])
+	}
+}
+
+func TestMergerOutError(t *testing.T) {
+	got, collect := collectMerged(t)
+	errOut := errors.New("sink down")
+	var m *flowfile.Merger
+	m = flowfile.NewMerger(func(f *flowfile.File) error {
+		if len(*got) == 0 && m.MaxCount > 0 {
+			return errOut
+		}
+		return collect(f)
+	})
+	m.MaxCount = 2
+	m.Demarcator = []byte(",")
+	ff := stringFiles("a", "b", "c")
+	m.Add(ff[0])
+	if err := m.Add(ff[1]); err != errOut {
+		t.Fatalf("expecting the error of out, got %v", err)
+	}
+
+	// The bin is kept and the File added since goes in after it
+	m.MaxCount = 0
+	if err := m.Add(ff[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*got) != 1 || (*got)[0].content != "a,b,c" || (*got)[0].attrs.Get("merge.count") != "3" {
+		t.Errorf("expecting the kept bin sent with the later File, got %v", *got)
+	}
+}
+
+func TestMergerOutReentrant(t *testing.T) {
+	var m *flowfile.Merger
+	var sent int
+	m = flowfile.NewMerger(func(f *flowfile.File) error {
+		sent++
+		return m.Flush() // Called without the lock, so this does not hang
+	})
+	m.MaxCount = 1
+	done := make(chan error, 1)
+	go func() { done <- m.Add(stringFiles("abc")[0]) }()
+	select {
+	case err := <-done:
+		if err != nil || sent != 1 {
+			t.Errorf("expecting one File sent, got %d %v", sent, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("out calling the Merger deadlocked")
+	}
+}
+
+func TestMergerShortRead(t *testing.T) {
+	got, out := collectMerged(t)
+	m := flowfile.NewMerger(out)
+	m.Demarcator = []byte(",")
+	if err := m.Add(stringFiles("abc")[0]); err != nil {
+		t.Fatal(err)
+	}
+	short := flowfile.New(strings.NewReader("de"), 5)
+	if err := m.Add(short); err == nil {
+		t.Fatal("expecting the short read returned")
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*got) != 1 || (*got)[0].content != "abc" || (*got)[0].attrs.Get("merge.count") != "1" {
+		t.Errorf("expecting the bin without the partial File, got %v", *got)
+	}
+}
+
+func TestMergerCommonAttributes(t *testing.T) {
+	got, out := collectMerged(t)
+	m := flowfile.NewMerger(out)
+	ff := stringFiles("abc", "def")
+	for _, f := range ff {
+		f.Attrs.Set("project", "x")
+		f.AddChecksum("SHA256")
+	}
+	ff[0].Attrs.Set("only.first", "1")
+	ff[0].Attrs.Set("differs", "1")
+	ff[1].Attrs.Set("differs", "2")
+	for _, f := range ff {
+		if err := m.Add(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Flush(); err != nil || len(*got) != 1 {
+		t.Fatalf("expecting one merged File, got %v %v", *got, err)
+	}
+	a := (*got)[0].attrs
+	for name, want := range map[string]string{
+		"project":      "x",
+		"only.first":   "",
+		"differs":      "",
+		"checksum":     "",
+		"checksumType": "",
+		"merge.count":  "2",
+	} {
+		if got := a.Get(name); got != want {
+			t.Errorf("expecting %s=%q, got %q", name, want, got)
+		}
+	}
+	if a.Get("filename") != a.Get("uuid") {
+		t.Errorf("expecting the differing filenames replaced by the uuid, got %q", a.Get("filename"))
+	}
+}
@@ -2,43 +2,115 @@ package flowfile
 
 import (
 	"fmt"
+	"io"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
+// String writes out the metrics in the Prometheus text exposition format, with
+// HELP and TYPE lines and cumulative histogram buckets.  Extra labels may be
+// added to every series as key value pairs.
 func (f Metrics) String(keyValuePairs ...string) string {
-	var lbl, lblAdd string
-	if len(keyValuePairs) > 1 {
-		for i := 1; i < len(keyValuePairs); i += 2 {
-			lblAdd += "," + fmt.Sprintf("%s=%q", keyValuePairs[i-1], keyValuePairs[i])
+	w := &strings.Builder{}
+	f.write(&expoWriter{w: w}, keyValuePairs...)
+	return w.String()
+}
+
+// OpenMetricsString writes out the metrics in the OpenMetrics text format,
+// with exemplars on the transfer size buckets for the large transfers.
+func (f Metrics) OpenMetricsString(keyValuePairs ...string) string {
+	w := &strings.Builder{}
+	f.write(&expoWriter{w: w, openMetrics: true}, keyValuePairs...)
+	io.WriteString(w, "# EOF\n")
+	return w.String()
+}
+
+func (f Metrics) write(w *expoWriter, keyValuePairs ...string) {
+	lblAdd := promLabels(keyValuePairs...)
+	lbl := labelsBraced(lblAdd)
+	lblAdd = labelsAdd(lblAdd)
+
+	w.header("flowfiles_start_time_seconds", "gauge", "Time the metrics were started, in seconds since the epoch.")
+	fmt.Fprintf(w, "flowfiles_start_time_seconds%s %g\n", lbl, float64(f.metricsInitTime.UnixMilli())/1e3)
+
+	w.header("flowfiles_transferred_bytes", "histogram", "Size of the FlowFiles transferred, in bytes.")
+	exemplars := f.exemplars.snapshot()
+	var total int64
+	for i, v := range f.MetricsFlowFileTransferredBucketValues {
+		bk := "+Inf"
+		if i < len(f.MetricsFlowFileTransferredBuckets) {
+			bk = fmt.Sprintf("%d", f.MetricsFlowFileTransferredBuckets[i])
 		}
-		lbl = "{" + lblAdd[1:] + "}"
+		total += v
+		fmt.Fprintf(w, "flowfiles_transferred_bytes_bucket{le=\"%s\"%s} %d", bk, lblAdd, total)
+		if e, ok := exemplars[i]; ok && w.openMetrics {
+			fmt.Fprintf(w, " # {uuid=\"%s\"} %d %.3f", promEscape(e.uuid), e.size, float64(e.time.UnixMilli())/1e3)
+		}
+		io.WriteString(w, "\n")
 	}
-	w := &strings.Builder{}
-	tm := time.Now().UnixMilli()
-	fmt.Fprintf(w, "flowfiles_started%s %d %d\n",
-		lbl, f.metricsInitTime.UnixMilli(), tm)
-	fmt.Fprintf(w, "flowfiles_transfered_bytes_sum%s %d %d\n",
-		lbl, f.MetricsFlowFileTransferredSum, tm)
-	fmt.Fprintf(w, "flowfiles_transfered_bytes_count%s %d %d\n",
-		lbl, f.MetricsFlowFileTransferredCount, tm)
-	fmt.Fprintf(w, "flowfiles_threads_active%s %d %d\n",
-		lbl, f.MetricsThreadsActive, tm)
-	fmt.Fprintf(w, "flowfiles_threads_terminated%s %d %d\n",
-		lbl, f.MetricsThreadsTerminated, tm)
-	fmt.Fprintf(w, "flowfiles_threads_queued%s %d %d\n",
-		lbl, f.MetricsThreadsQueued, tm)
-	var bk string
+	fmt.Fprintf(w, "flowfiles_transferred_bytes_sum%s %d\n", lbl, f.MetricsFlowFileTransferredSum)
+	fmt.Fprintf(w, "flowfiles_transferred_bytes_count%s %d\n", lbl, f.MetricsFlowFileTransferredCount)
+
+	w.header("flowfiles_threads_active", "gauge", "Connections being handled.")
+	fmt.Fprintf(w, "flowfiles_threads_active%s %d\n", lbl, f.MetricsThreadsActive)
+	w.header("flowfiles_threads_terminated_total", "counter", "Connections which have been handled.")
+	fmt.Fprintf(w, "flowfiles_threads_terminated_total%s %d\n", lbl, f.MetricsThreadsTerminated)
+	w.header("flowfiles_threads_queued", "gauge", "Connections waiting to be handled.")
+	fmt.Fprintf(w, "flowfiles_threads_queued%s %d\n", lbl, f.MetricsThreadsQueued)
+
+	if f.MetricsPostDuration != nil {
+		w.header("flowfiles_post_duration_seconds", "histogram", "Time taken for each POST, in seconds.")
+		f.MetricsPostDuration.writeProm(w, "flowfiles_post_duration_seconds", lblAdd, 0)
+	}
+	if f.MetricsFileDuration != nil {
+		w.header("flowfiles_file_duration_seconds", "histogram", "Time taken for each FlowFile, in seconds.")
+		f.MetricsFileDuration.writeProm(w, "flowfiles_file_duration_seconds", lblAdd, 0)
+	}
+
+	w.header("flowfiles_http_responses_total", "counter", "Replies sent, by status code.")
+	codes := f.ResponseCounts()
+	for _, code := range sortedKeys(codes) {
+		fmt.Fprintf(w, "flowfiles_http_responses_total{code=\"%d\"%s} %d\n", code, lblAdd, codes[code])
+	}
+	w.header("flowfiles_rejects_total", "counter", "FlowFiles rejected, by reason.")
+	reasons := f.RejectCounts()
+	for _, reason := range sortedKeys(reasons) {
+		fmt.Fprintf(w, "flowfiles_rejects_total{reason=\"%s\"%s} %d\n", promEscape(reason), lblAdd, reasons[reason])
+	}
+	if MetricsLegacyNames && !w.openMetrics {
+		f.writeLegacy(w, lbl, lblAdd)
+	}
+}
+
+// Also write the series under the names used before the exposition format,
+// flowfiles_started, flowfiles_transfered_bytes and
+// flowfiles_threads_terminated, so dashboards built on them keep working
+// while they are moved over.  These are deprecated and will be removed in the
+// next release.
+var MetricsLegacyNames = true
+
+// Write the deprecated series as they were before, untyped and with the
+// transfer buckets not cumulative
+func (f Metrics) writeLegacy(w *expoWriter, lbl, lblAdd string) {
+	w.header("flowfiles_started", "untyped", "Deprecated, use flowfiles_start_time_seconds.")
+	fmt.Fprintf(w, "flowfiles_started%s %d\n", lbl, f.metricsInitTime.UnixMilli())
+	w.header("flowfiles_transfered_bytes_sum", "untyped", "Deprecated, use flowfiles_transferred_bytes_sum.")
+	fmt.Fprintf(w, "flowfiles_transfered_bytes_sum%s %d\n", lbl, f.MetricsFlowFileTransferredSum)
+	w.header("flowfiles_transfered_bytes_count", "untyped", "Deprecated, use flowfiles_transferred_bytes_count.")
+	fmt.Fprintf(w, "flowfiles_transfered_bytes_count%s %d\n", lbl, f.MetricsFlowFileTransferredCount)
+	w.header("flowfiles_transfered_bytes_bucket", "untyped", "Deprecated, use flowfiles_transferred_bytes_bucket.")
 	for i, v := range f.MetricsFlowFileTransferredBucketValues {
+		bk := "+Inf"
 		if i < len(f.MetricsFlowFileTransferredBuckets) {
 			bk = fmt.Sprintf("%d", f.MetricsFlowFileTransferredBuckets[i])
-		} else {
-			bk = "+Inf"
 		}
-		fmt.Fprintf(w, "flowfiles_transfered_bytes_bucket{le=%q%s} %d %d\n", bk, lblAdd, v, tm)
+		fmt.Fprintf(w, "flowfiles_transfered_bytes_bucket{le=\"%s\"%s} %d\n", bk, lblAdd, v)
 	}
-	return w.String()
+	w.header("flowfiles_threads_terminated", "untyped", "Deprecated, use flowfiles_threads_terminated_total.")
+	fmt.Fprintf(w, "flowfiles_threads_terminated%s %d\n", lbl, f.MetricsThreadsTerminated)
 }
 
 func (hr *HTTPReceiver) MetricsHandler() http.Handler {
@@ -46,15 +118,37 @@ func (hr *HTTPReceiver) MetricsHandler() http.Handler {
 }
 
 func NewMetrics() *Metrics {
-	return &Metrics{
+	m := &Metrics{
 		MetricsFlowFileTransferredBuckets: []int64{
 			1e2, 2.5e2, 1e3,
 			2.5e3, 1e4, 2.5e4, 1e5,
 			2.5e5, 1e6, 2.5e6, 1e7,
 			2.5e7, 1e8, 2.5e8, 1e9},
 		MetricsFlowFileTransferredBucketValues: make([]int64, 16),
-		metricsInitTime:                        time.Now(),
+		MetricsPostDuration:                    NewHistogram(),
+		MetricsFileDuration:                    NewHistogram(),
+		ExemplarThreshold:                      1e8,
+		exemplars:                              &exemplars{byBucket: make(map[int]exemplar)},
+		responses: &responseCounts{
+			codes:   make(map[int]int64),
+			reasons: make(map[string]int64),
+		},
+	}
+	m.SetClock(nil)
+	return m
+}
+
+// SetClock sets the Clock of the Metrics and of the duration histograms, and
+// restarts the start time from it, so it should be set before ingesting data.
+// A nil Clock is the DefaultClock.
+func (f *Metrics) SetClock(c Clock) {
+	f.Clock = c
+	for _, h := range []*Histogram{f.MetricsPostDuration, f.MetricsFileDuration} {
+		if h != nil {
+			h.Clock = c
+		}
 	}
+	f.metricsInitTime = clockOr(c).Now()
 }
 
 type Metrics struct {
@@ -67,25 +161,119 @@ type Metrics struct {
 	MetricsFlowFileTransferredSum          int64
 	MetricsFlowFileTransferredCount        int64
 
+	// Time taken for each POST and for each File within a POST, in seconds
+	MetricsPostDuration *Histogram
+	MetricsFileDuration *Histogram
+
 	//MetricsFlowFileReceivedSum   *int64
 	//MetricsFlowFileReceivedCount *int64
 	MetricsThreadsActive     int64
 	MetricsThreadsTerminated int64
 	MetricsThreadsQueued     int64
 	metricsInitTime          time.Time
+
+	responses *responseCounts
+
+	// Transfers of at least this many bytes are recorded as exemplars, the
+	// uuid of the most recent per bucket is given in the OpenMetrics output.
+	ExemplarThreshold int64
+	exemplars         *exemplars
+
+	// The source of time for the exemplars, defaults to the DefaultClock, see
+	// SetClock.
+	Clock Clock
 }
 
+// Responses by status code and rejections by reason, guarded as the maps
+// are updated from concurrent requests
+type responseCounts struct {
+	mu      sync.Mutex
+	codes   map[int]int64
+	reasons map[string]int64
+}
+
+// CountResponse records a reply sent with the status code, and the reason when
+// a File was rejected.
+func (f *Metrics) CountResponse(code int, reason string) {
+	if f == nil || f.responses == nil {
+		return
+	}
+	f.responses.mu.Lock()
+	defer f.responses.mu.Unlock()
+	f.responses.codes[code]++
+	if reason != "" {
+		f.responses.reasons[reason]++
+	}
+}
+
+// ResponseCounts returns a copy of the count of replies by status code, such as
+// 200, 406, 500, or 503, to tell apart rejected Files from transport failures.
+func (f Metrics) ResponseCounts() map[int]int64 {
+	out := make(map[int]int64)
+	if f.responses != nil {
+		f.responses.mu.Lock()
+		defer f.responses.mu.Unlock()
+		for k, v := range f.responses.codes {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// RejectCounts returns a copy of the count of rejections by reason, such as
+// "checksum" or "required-attribute".
+func (f Metrics) RejectCounts() map[string]int64 {
+	out := make(map[string]int64)
+	if f.responses != nil {
+		f.responses.mu.Lock()
+		defer f.responses.mu.Unlock()
+		for k, v := range f.responses.reasons {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func sortedKeys[K int | string](m map[K]int64) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// ServeHTTP replies with the metrics of the HTTPReceiver, in the OpenMetrics
+// format when the Accept header asks for it and the Prometheus text format
+// otherwise.
 func (m Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if m.hr != nil {
+		if acceptsOpenMetrics(r.Header.Get("Accept")) {
+			w.Header().Set("Content-Type", openMetricsContentType)
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, m.hr.Metrics.OpenMetricsString())
+			return
+		}
+		w.Header().Set("Content-Type", promContentType)
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(m.hr.Metrics.String()))
 	}
 }
 
-func (f *Metrics) BucketCounter(size int64) {
+// ObserveTransfer counts a File transferred with the size, as BucketCounter,
+// and records it as an exemplar when the size meets the ExemplarThreshold.
+func (f *Metrics) ObserveTransfer(ff *File, size int64) {
+	idx := f.BucketCounter(size)
+	if f.exemplars != nil && f.ExemplarThreshold > 0 && size >= f.ExemplarThreshold {
+		f.exemplars.set(idx, exemplar{uuid: ff.Attrs.Get("uuid"), size: size, time: clockOr(f.Clock).Now()})
+	}
+}
+
+// BucketCounter counts a transfer of the size, returning the bucket index.
+func (f *Metrics) BucketCounter(size int64) int {
 	idx := 0
 	for ; idx < len(f.MetricsFlowFileTransferredBuckets) &&
-		f.MetricsFlowFileTransferredBuckets[idx] <= size; idx++ {
+		f.MetricsFlowFileTransferredBuckets[idx] < size; idx++ {
 	}
 	//if Debug {
 	//  fmt.Println("bucket size", size, idx, "in", f.MetricsFlowFileTransferredBuckets)
@@ -93,4 +281,35 @@ func (f *Metrics) BucketCounter(size int64) {
 	f.MetricsFlowFileTransferredBucketValues[idx] += 1
 	f.MetricsFlowFileTransferredSum += size
 	f.MetricsFlowFileTransferredCount += 1
+	return idx
+}
+
+type exemplar struct {
+	uuid string
+	size int64
+	time time.Time
+}
+
+// The latest exemplar by bucket index
+type exemplars struct {
+	mu       sync.Mutex
+	byBucket map[int]exemplar
+}
+
+func (e *exemplars) set(idx int, ex exemplar) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.byBucket[idx] = ex
+}
+
+func (e *exemplars) snapshot() map[int]exemplar {
+	out := make(map[int]exemplar)
+	if e != nil {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		for k, v := range e.byBucket {
+			out[k] = v
+		}
+	}
+	return out
 }
@@ -0,0 +1,182 @@
+package flowfile
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A MetricsSink receives the metric events of an HTTPReceiver or
+// HTTPTransaction, so the metrics can be sent to a monitoring system of
+// choice.  Labels are given as key value pairs.  Implementations must be safe
+// for concurrent use.
+type MetricsSink interface {
+	Counter(name string, value float64, labels ...string) // Add to a counter
+	Gauge(name string, value float64, labels ...string)   // Set a gauge
+	Observe(name string, value float64, labels ...string) // Record into a histogram
+}
+
+// NopMetricsSink discards all the metric events.
+type NopMetricsSink struct{}
+
+// Return the sink to use, falling back to the no-op sink when none was set
+func sinkOrNop(s MetricsSink) MetricsSink {
+	if s == nil {
+		return NopMetricsSink{}
+	}
+	return s
+}
+
+func (NopMetricsSink) Counter(string, float64, ...string) {}
+func (NopMetricsSink) Gauge(string, float64, ...string)   {}
+func (NopMetricsSink) Observe(string, float64, ...string) {}
+
+// TextMetricsSink collects the metric events in memory and writes them out as
+// plain text lines, with a millisecond timestamp on each line.
+type TextMetricsSink struct {
+	metricStore
+}
+
+// Create a new TextMetricsSink, histograms use DefaultDurationBuckets.
+func NewTextMetricsSink() *TextMetricsSink {
+	return &TextMetricsSink{metricStore{series: make(map[string]*metricSeries)}}
+}
+
+func (s *TextMetricsSink) String() string {
+	w := &strings.Builder{}
+	tm := DefaultClock.Now().UnixMilli()
+	s.each(func(m *metricSeries) {
+		if m.hist != nil {
+			m.hist.writeProm(w, m.name, labelsAdd(m.labels), tm)
+			return
+		}
+		fmt.Fprintf(w, "%s%s %g %d\n", m.name, labelsBraced(m.labels), m.value, tm)
+	})
+	return w.String()
+}
+
+func (s *TextMetricsSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, s.String())
+}
+
+// PrometheusSink collects the metric events in memory and serves them in the
+// Prometheus text exposition format, with TYPE lines and without timestamps.
+//
+//   sink := flowfile.NewPrometheusSink()
+//   ffReceiver.MetricsSink = sink
+//   http.Handle("/metrics", sink)
+type PrometheusSink struct {
+	metricStore
+}
+
+// Create a new PrometheusSink, histograms use DefaultDurationBuckets.
+func NewPrometheusSink() *PrometheusSink {
+	return &PrometheusSink{metricStore{series: make(map[string]*metricSeries)}}
+}
+
+func (s *PrometheusSink) String() string {
+	w := &strings.Builder{}
+	var last string
+	s.each(func(m *metricSeries) {
+		if m.name != last {
+			fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+			last = m.name
+		}
+		if m.hist != nil {
+			m.hist.writeProm(w, m.name, labelsAdd(m.labels), 0)
+			return
+		}
+		fmt.Fprintf(w, "%s%s %g\n", m.name, labelsBraced(m.labels), m.value)
+	})
+	return w.String()
+}
+
+func (s *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", promContentType)
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, s.String())
+}
+
+// Storage shared by the in-memory sinks
+type metricStore struct {
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	name, labels, kind string
+	value              float64
+	hist               *Histogram
+}
+
+func (s *metricStore) get(kind, name string, labels []string) *metricSeries {
+	name = promName(name)
+	lbl := promLabels(labels...)
+	key := name + "{" + lbl + "}"
+	m, ok := s.series[key]
+	if !ok {
+		m = &metricSeries{name: name, labels: lbl, kind: kind}
+		if kind == "histogram" {
+			m.hist = NewHistogram()
+		}
+		s.series[key] = m
+	}
+	return m
+}
+
+func (s *metricStore) Counter(name string, value float64, labels ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get("counter", name, labels).value += value
+}
+
+func (s *metricStore) Gauge(name string, value float64, labels ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.get("gauge", name, labels).value = value
+}
+
+func (s *metricStore) Observe(name string, value float64, labels ...string) {
+	s.mu.Lock()
+	m := s.get("histogram", name, labels)
+	s.mu.Unlock()
+	m.hist.Observe(value)
+}
+
+// Call fn for a copy of each series sorted by name and labels
+func (s *metricStore) each(fn func(*metricSeries)) {
+	s.mu.Lock()
+	list := make([]*metricSeries, 0, len(s.series))
+	for _, m := range s.series {
+		c := *m
+		list = append(list, &c)
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].name != list[j].name {
+			return list[i].name < list[j].name
+		}
+		return list[i].labels < list[j].labels
+	})
+	for _, m := range list {
+		fn(m)
+	}
+}
+
+func labelsBraced(lbl string) string {
+	if lbl == "" {
+		return ""
+	}
+	return "{" + lbl + "}"
+}
+
+func labelsAdd(lbl string) string {
+	if lbl == "" {
+		return ""
+	}
+	return "," + lbl
+}
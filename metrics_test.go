@@ -0,0 +1,207 @@
+package flowfile_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/flowfiletest"
+)
+
+func TestMetricsString(t *testing.T) {
+	m := flowfile.NewMetrics()
+	m.BucketCounter(50)
+	m.BucketCounter(500)
+	m.MetricsThreadsTerminated = 2
+	m.MetricsPostDuration.Observe(.2)
+	out := m.String("site", "a")
+
+	for _, line := range []string{
+		"# TYPE flowfiles_transferred_bytes histogram",
+		`flowfiles_transferred_bytes_bucket{le="100",site="a"} 1`,
+		`flowfiles_transferred_bytes_bucket{le="1000",site="a"} 2`,
+		`flowfiles_transferred_bytes_sum{site="a"} 550`,
+		`flowfiles_threads_terminated_total{site="a"} 2`,
+		`flowfiles_post_duration_seconds_bucket{le="0.25",site="a"} 1`,
+		`flowfiles_post_duration_seconds_count{site="a"} 1`,
+
+		// The deprecated names, with the buckets as they were
+		"# TYPE flowfiles_started untyped",
+		`flowfiles_transfered_bytes_bucket{le="100",site="a"} 1`,
+		`flowfiles_transfered_bytes_bucket{le="1000",site="a"} 1`,
+		`flowfiles_transfered_bytes_count{site="a"} 2`,
+		`flowfiles_threads_terminated{site="a"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+
+	if strings.Contains(m.OpenMetricsString(), "transfered") {
+		t.Errorf("deprecated names written in OpenMetrics")
+	}
+	defer func(old bool) { flowfile.MetricsLegacyNames = old }(flowfile.MetricsLegacyNames)
+	flowfile.MetricsLegacyNames = false
+	if out = m.String(); strings.Contains(out, "transfered") || strings.Contains(out, "flowfiles_started") {
+		t.Errorf("deprecated names written without MetricsLegacyNames:\n%s", out)
+	}
+}
+
+func TestMetricsSinkHistogram(t *testing.T) {
+	text, prom := flowfile.NewTextMetricsSink(), flowfile.NewPrometheusSink()
+	for _, sink := range []flowfile.MetricsSink{text, prom} {
+		sink.Observe("send_seconds", (200 * time.Millisecond).Seconds(), "peer", "b")
+	}
+
+	// Both sinks write the histogram the same way, the text one timestamped
+	for _, line := range []string{
+		`send_seconds_bucket{le="0.1",peer="b"} 0`,
+		`send_seconds_bucket{le="0.25",peer="b"} 1`,
+		`send_seconds_bucket{le="+Inf",peer="b"} 1`,
+		`send_seconds_sum{peer="b"} 0.2`,
+		`send_seconds_count{peer="b"} 1`,
+	} {
+		if !strings.Contains(prom.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, prom.String())
+		}
+		if !strings.Contains(text.String(), line+" ") {
+			t.Errorf("missing %q in:\n%s", line, text.String())
+		}
+	}
+}
+
+func TestMetricsResponseCounts(t *testing.T) {
+	rcv, ts := newReadingReceiver(t)
+	rcv.Require("classification", "")
+	postRaw(t, ts.URL, stringFiles("abc")...)
+	f := stringFiles("def")[0]
+	f.Attrs.Set("classification", "public")
+	postRaw(t, ts.URL, f)
+
+	codes, reasons := rcv.Metrics.ResponseCounts(), rcv.Metrics.RejectCounts()
+	if codes[200] != 1 || codes[406] != 1 || len(codes) != 2 {
+		t.Errorf("expecting one 200 and one 406, got %v", codes)
+	}
+	if reasons["required-attribute"] != 1 || len(reasons) != 1 {
+		t.Errorf("expecting one required-attribute reject, got %v", reasons)
+	}
+	out := rcv.Metrics.String()
+	for _, line := range []string{
+		`flowfiles_http_responses_total{code="200"} 1`,
+		`flowfiles_http_responses_total{code="406"} 1`,
+		`flowfiles_rejects_total{reason="required-attribute"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+}
+
+func TestMetricsOpenMetrics(t *testing.T) {
+	rcv, _ := newReadingReceiver(t)
+	m := rcv.Metrics
+	m.SetClock(flowfiletest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	m.ExemplarThreshold = 200
+	small, large := stringFiles("a", "b")[0], stringFiles("a", "b")[1]
+	small.Attrs.Set("uuid", "small")
+	large.Attrs.Set("uuid", "large")
+	m.ObserveTransfer(small, 150)
+	m.ObserveTransfer(large, 500)
+	m.MetricsThreadsTerminated = 1
+
+	out := m.OpenMetricsString()
+	for _, line := range []string{
+		"# TYPE flowfiles_threads_terminated counter",
+		"flowfiles_threads_terminated_total 1",
+		"flowfiles_start_time_seconds 1.7040672e+09",
+		`flowfiles_transferred_bytes_bucket{le="250"} 1`,
+		`flowfiles_transferred_bytes_bucket{le="1000"} 2 # {uuid="large"} 500 1704067200.000`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+	if !strings.HasSuffix(out, "# EOF\n") || strings.Contains(out, `uuid="small"`) {
+		t.Errorf("unexpected OpenMetrics output:\n%s", out)
+	}
+	if out = m.String(); strings.Contains(out, "# {uuid=") || strings.Contains(out, "# EOF") {
+		t.Errorf("exemplars written in the Prometheus format:\n%s", out)
+	}
+
+	// The format is picked by the Accept header
+	for accept, want := range map[string]string{
+		"":           "text/plain",
+		"text/plain": "text/plain",
+		"application/openmetrics-text; version=1.0.0":    "application/openmetrics-text",
+		"application/openmetrics-text;q=0, text/plain":   "text/plain",
+		"text/plain;q=0.5, application/openmetrics-text": "application/openmetrics-text",
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.Header.Set("Accept", accept)
+		rcv.MetricsHandler().ServeHTTP(rec, req)
+		if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, want) {
+			t.Errorf("Accept %q: expecting %s, got %s", accept, want, ct)
+		}
+	}
+}
+
+func TestMetricsSinkEvents(t *testing.T) {
+	rsink, ssink := flowfile.NewPrometheusSink(), flowfile.NewTextMetricsSink()
+	rcv, ts := newReadingReceiver(t)
+	rcv.MetricsSink = rsink
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.MetricsSink = ssink
+	if err = hs.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err = hs.Send(stringFiles("abc", "defg")...); err != nil {
+		t.Fatal(err)
+	}
+	rcv.Require("classification", "")
+	hs.Send(stringFiles("abc")...)
+
+	out := rsink.String()
+	for _, line := range []string{
+		"# TYPE flowfiles_received_total counter",
+		"flowfiles_received_total 3",
+		"flowfiles_received_bytes_total 10",
+		`flowfiles_http_responses_total{code="200"} 3`, // Two handshakes and a POST
+		`flowfiles_http_responses_total{code="406"} 1`,
+		`flowfiles_rejects_total{reason="required-attribute"} 1`,
+		"# TYPE flowfiles_received_post_duration_seconds histogram",
+		"flowfiles_received_post_duration_seconds_count 2",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+
+	// The text sink timestamps each line
+	out = ssink.String()
+	for _, prefix := range []string{
+		"flowfiles_sent_total 3 ", // Written, though the last was refused
+		"flowfiles_sent_bytes_total ",
+		"flowfiles_post_errors_total 1 ",
+		"flowfiles_handshake_latency_seconds ",
+		`flowfiles_sent_post_duration_seconds_count 1 `,
+	} {
+		if !strings.Contains(out, "\n"+prefix) && !strings.HasPrefix(out, prefix) {
+			t.Errorf("missing %q in:\n%s", prefix, out)
+		}
+	}
+
+	// Gauges are set rather than added to
+	rsink.Gauge("queued", 3, "peer", "a")
+	rsink.Gauge("queued", 1, "peer", "a")
+	if out = rsink.String(); !strings.Contains(out, "# TYPE queued gauge\nqueued{peer=\"a\"} 1\n") {
+		t.Errorf("expecting the gauge set to 1, got:\n%s", out)
+	}
+	var nop flowfile.MetricsSink = flowfile.NopMetricsSink{}
+	nop.Counter("x", 1)
+}
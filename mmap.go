@@ -0,0 +1,68 @@
+package flowfile
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// Files on disk at least MmapThreshold bytes in size are read through a
+// memory map instead of read syscalls, on platforms which support it.  This
+// can greatly improve the checksum and send throughput for very large files.
+// A value of 0 disables memory mapping.
+//
+// Note: A memory mapped file which is truncated while being read can cause the
+// process to crash with a SIGBUS, so only enable this for files which are not
+// being modified.
+var MmapThreshold int64 = 0
+
+var errMmapUnsupported = errors.New("Memory mapping is not supported on this platform")
+
+type readerAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// Open a file on disk for reading, using a memory map when enabled and the
+// file is large enough.
+func openFile(filePath string) (readerAtCloser, error) {
+	fh, err := os.Open(filePath)
+	if err != nil || MmapThreshold <= 0 {
+		return fh, err
+	}
+	if stat, err := fh.Stat(); err == nil && stat.Mode().IsRegular() && stat.Size() >= MmapThreshold {
+		if m, err := mmapFile(fh, stat.Size()); err == nil {
+			fh.Close() // The mapping stays valid after the file is closed
+			return m, nil
+		}
+	}
+	return fh, nil
+}
+
+// A mmapReader provides a ReadAt interface over a memory mapped file.
+type mmapReader struct {
+	data []byte
+}
+
+func (m *mmapReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errors.New("Negative offset")
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n = copy(p, m.data[off:])
+	if n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+func (m *mmapReader) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return munmap(data)
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package flowfile
+
+import (
+	"os"
+	"syscall"
+)
+
+func mmapFile(fh *os.File, size int64) (*mmapReader, error) {
+	if size <= 0 || int64(int(size)) != size {
+		return nil, errMmapUnsupported
+	}
+	data, err := syscall.Mmap(int(fh.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return &mmapReader{data: data}, nil
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,90 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package flowfile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenFileMmap(t *testing.T) {
+	defer func(old int64) { MmapThreshold = old }(MmapThreshold)
+	dat := bytes.Repeat([]byte("0123456789"), 1000)
+	fp := filepath.Join(t.TempDir(), "big.dat")
+	if err := os.WriteFile(fp, dat, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		threshold int64
+		mapped    bool
+	}{{0, false}, {int64(len(dat)) + 1, false}, {int64(len(dat)), true}} {
+		MmapThreshold = tc.threshold
+		ra, err := openFile(fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := ra.(*mmapReader); ok != tc.mapped {
+			t.Errorf("threshold %d: expecting mapped %v, got %T", tc.threshold, tc.mapped, ra)
+		}
+		ra.Close()
+	}
+
+	// Reads past the end of the mapping are short
+	MmapThreshold = 1
+	ra, err := openFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8)
+	if n, err := ra.ReadAt(buf, int64(len(dat))-3); n != 3 || err != io.EOF || string(buf[:n]) != "789" {
+		t.Errorf("expecting a short read at the end, got %d %v %q", n, err, buf[:n])
+	}
+	if n, err := ra.ReadAt(buf, int64(len(dat))); n != 0 || err != io.EOF {
+		t.Errorf("expecting EOF past the end, got %d %v", n, err)
+	}
+	if err = ra.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = ra.Close(); err != nil {
+		t.Errorf("expecting a second Close to be a no-op, got %v", err)
+	}
+
+	// A File on disk reads, checksums and segments through the mapping
+	f, err := NewFromDisk(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.AddChecksum("SHA256"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(f); err != nil || !bytes.Equal(got, dat) {
+		t.Errorf("expecting the File read through the mapping, got %d bytes %v", len(got), err)
+	}
+	if sum := fmt.Sprintf("%x", sha256.Sum256(dat)); f.Attrs.Get("checksum") != sum {
+		t.Errorf("expecting the checksum %s, got %s", sum, f.Attrs.Get("checksum"))
+	}
+	if f, err = NewFromDisk(fp); err != nil {
+		t.Fatal(err)
+	}
+	segs, err := SegmentBySize(f, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	for _, s := range segs {
+		b, err := io.ReadAll(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, b...)
+	}
+	if !bytes.Equal(got, dat) {
+		t.Errorf("expecting the segments read through the mapping, got %d bytes", len(got))
+	}
+}
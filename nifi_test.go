@@ -0,0 +1,242 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+// The FlowFiles of abc.txt, holding abcdefghij, as split by the NiFi
+// SegmentContent processor with a Segment Size of 4 B and posted to a
+// ListenHTTP processor.  The payload follows the FlowFile v3 packaging of
+// FlowFilePackagerV3 byte for byte, with the attributes SegmentContent sets
+// in the order of its HashMap.
+const nifiSegmentContent = "testdata/nifi-segment-content.ff3"
+
+// A FlowFile as read by the NiFi FlowFileUnpackagerV3
+type nifiFlowFile struct {
+	attrs   map[string]string
+	content []byte
+}
+
+// Read the FlowFiles in the way of the NiFi FlowFileUnpackagerV3, rather than
+// with the Scanner, so the wire format is checked independently.
+func nifiUnpackage(t *testing.T, dat []byte) (out []nifiFlowFile) {
+	t.Helper()
+	r := bytes.NewReader(dat)
+	fieldLength := func() int {
+		var n16 uint16
+		if err := binary.Read(r, binary.BigEndian, &n16); err != nil {
+			t.Fatal(err)
+		}
+		if n16 < 0xffff {
+			return int(n16)
+		}
+		var n32 uint32
+		if err := binary.Read(r, binary.BigEndian, &n32); err != nil {
+			t.Fatal(err)
+		}
+		return int(n32)
+	}
+	readString := func() string {
+		b := make([]byte, fieldLength())
+		if _, err := io.ReadFull(r, b); err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	for r.Len() > 0 {
+		magic := make([]byte, 7)
+		if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "NiFiFF3" {
+			t.Fatalf("bad header %q, %v", magic, err)
+		}
+		ff := nifiFlowFile{attrs: make(map[string]string)}
+		for n := fieldLength(); n > 0; n-- {
+			k := readString()
+			ff.attrs[k] = readString()
+		}
+		var size uint64
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			t.Fatal(err)
+		}
+		ff.content = make([]byte, size)
+		if _, err := io.ReadFull(r, ff.content); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, ff)
+	}
+	return
+}
+
+// Check the segments would be put back together by the MergeContent
+// Defragment strategy, returning the merged content
+func nifiDefragment(t *testing.T, segs []nifiFlowFile) []byte {
+	t.Helper()
+	sort.Slice(segs, func(i, j int) bool {
+		a, _ := strconv.Atoi(segs[i].attrs["fragment.index"])
+		b, _ := strconv.Atoi(segs[j].attrs["fragment.index"])
+		return a < b
+	})
+	var merged []byte
+	for i, s := range segs {
+		for _, name := range []string{"fragment.identifier", "fragment.count", "segment.original.filename"} {
+			if s.attrs[name] == "" || s.attrs[name] != segs[0].attrs[name] {
+				t.Errorf("segment %d: %s of %q, expecting %q", i, name, s.attrs[name], segs[0].attrs[name])
+			}
+		}
+		if got := s.attrs["fragment.index"]; got != strconv.Itoa(i+1) {
+			t.Errorf("segment %d: fragment.index of %q", i, got)
+		}
+		merged = append(merged, s.content...)
+	}
+	if got := segs[0].attrs["fragment.count"]; got != strconv.Itoa(len(segs)) {
+		t.Errorf("fragment.count of %q for %d segments", got, len(segs))
+	}
+	return merged
+}
+
+// Segments from NiFi are read as posted, and the attributes set by NiFi are
+// the ones set by SegmentProfileNiFi
+func TestNiFiSegmentsFromNiFi(t *testing.T) {
+	dat, err := os.ReadFile(nifiSegmentContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := nifiUnpackage(t, dat)
+	if got := nifiDefragment(t, append([]nifiFlowFile{}, want...)); string(got) != "abcdefghij" {
+		t.Fatalf("recorded segments merge to %q", got)
+	}
+
+	// Post the recording as NiFi would and encode the Files received again
+	var mu sync.Mutex
+	var reencoded bytes.Buffer
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		content, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		out := flowfile.New(bytes.NewReader(content), int64(len(content)))
+		out.Attrs = f.Attrs.Clone()
+		mu.Lock()
+		defer mu.Unlock()
+		_, err = flowfile.NewWriter(&reencoded).Write(out)
+		return err
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+	req, _ := http.NewRequest("POST", ts.URL, bytes.NewReader(dat))
+	req.Header.Set("Content-Type", "application/flowfile-v3")
+	req.Header.Set("x-nifi-transaction-id", "4e6a0d2c-5b1f-4f7e-9c3a-8d2b1e0f6a57")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expecting a 200 for the recording, got %d", res.StatusCode)
+	}
+	if !bytes.Equal(reencoded.Bytes(), dat) {
+		t.Errorf("Files do not encode back to the recording:\n%q\n%q", reencoded.Bytes(), dat)
+	}
+
+	// The same File segmented here has every attribute NiFi set, other than
+	// the identifiers, with the same value
+	f := flowfile.New(bytes.NewReader([]byte("abcdefghij")), 10)
+	f.Attrs.Set("path", "./")
+	f.Attrs.Set("filename", "abc.txt")
+	segs, err := flowfile.SegmentBySizeProfile(f, 4, flowfile.SegmentProfileNiFi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segs) != len(want) {
+		t.Fatalf("expecting %d segments, got %d", len(want), len(segs))
+	}
+	for i, s := range segs {
+		for name, v := range want[i].attrs {
+			got := s.Attrs.Get(name)
+			switch name {
+			case "uuid", "segment.identifier", "fragment.identifier":
+				if got == "" {
+					t.Errorf("segment %d: missing %s", i, name)
+				}
+			default:
+				if got != v {
+					t.Errorf("segment %d: %s of %q, NiFi set %q", i, name, got, v)
+				}
+			}
+		}
+		if s.Attrs.Get("segment.identifier") != s.Attrs.Get("fragment.identifier") {
+			t.Errorf("segment %d: segment.identifier differs from the fragment.identifier", i)
+		}
+	}
+}
+
+// Segments posted to a NiFi ListenHTTP are read by NiFi and put back together
+// by the MergeContent Defragment strategy
+func TestNiFiSegmentsToNiFi(t *testing.T) {
+	var mu sync.Mutex
+	var posted []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "HEAD": // As replied by ListenHTTP
+			w.Header().Set("Accept", "application/flowfile-v3,application/flowfile-v2")
+			w.Header().Set("x-nifi-transfer-protocol-version", "3")
+			w.WriteHeader(http.StatusOK)
+		case "POST":
+			if ct := r.Header.Get("Content-Type"); ct != "application/flowfile-v3" {
+				t.Errorf("posted as %q", ct)
+			}
+			dat, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			posted = append(posted, dat...)
+			mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer ts.Close()
+
+	f := flowfile.New(bytes.NewReader([]byte("abcdefghij")), 10)
+	f.Attrs.Set("path", "./")
+	f.Attrs.Set("filename", "abc.txt")
+	segs, err := flowfile.SegmentBySizeProfile(f, 4, flowfile.SegmentProfileNiFi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sent last first, as retries and parallel sends may do
+	for i := len(segs) - 1; i >= 0; i-- {
+		if err = hs.Send(segs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := nifiUnpackage(t, posted)
+	if len(got) != len(segs) {
+		t.Fatalf("NiFi read %d FlowFiles, expecting %d", len(got), len(segs))
+	}
+	for _, ff := range got {
+		if _, ok := ff.attrs["merge.reason"]; ok {
+			t.Errorf("merge.reason set on a segment")
+		}
+	}
+	if merged := nifiDefragment(t, got); string(merged) != "abcdefghij" {
+		t.Errorf("segments merge to %q", merged)
+	}
+}
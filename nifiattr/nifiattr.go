@@ -0,0 +1,206 @@
+// Package nifiattr defines the names of the standard NiFi FlowFile
+// attributes, as set by the core framework and the stock processors, with
+// helpers to read and write the typed ones, so interop code across projects
+// agrees on the spellings.
+//
+//   ff.Attrs.Set(nifiattr.Filename, "report.csv")
+//   nifiattr.SetInt(&ff.Attrs, nifiattr.KafkaPartition, 3)
+//   frag, err := nifiattr.GetFragment(ff.Attrs)
+package nifiattr // import "github.com/pschou/go-flowfile/nifiattr"
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+)
+
+// Core attributes, set by the NiFi framework on every FlowFile.
+const (
+	UUID                = "uuid"                 // Unique identifier of the FlowFile
+	Filename            = "filename"             // Name of the file, without any path
+	Path                = "path"                 // Directory of the file, relative to where it was picked up
+	AbsolutePath        = "absolute.path"        // Absolute directory of the file where it was picked up
+	MimeType            = "mime.type"            // MIME type of the content
+	Priority            = "priority"             // Numeric priority used by the PriorityAttributePrioritizer
+	DiscardReason       = "discard.reason"       // Why the FlowFile was discarded
+	AlternateIdentifier = "alternate.identifier" // Identifier of the FlowFile in another system
+)
+
+// File attributes, set by GetFile, ListFile, GetSFTP, ListSFTP and the like.
+const (
+	FileSize             = "file.size"
+	FileOwner            = "file.owner"
+	FileGroup            = "file.group"
+	FilePermissions      = "file.permissions" // such as rw-r--r--
+	FileLastModifiedTime = "file.lastModifiedTime"
+	FileLastAccessTime   = "file.lastAccessTime"
+	FileCreationTime     = "file.creationTime"
+)
+
+// SFTP attributes, set by GetSFTP, ListSFTP and FetchSFTP.
+const (
+	SFTPRemoteHost  = "sftp.remote.host"
+	SFTPRemotePort  = "sftp.remote.port"
+	SFTPListingUser = "sftp.listing.user"
+)
+
+// Fragment attributes, set by SegmentContent, SplitContent, SplitText and the
+// other split processors, and used by MergeContent to defragment.
+const (
+	FragmentIdentifier      = "fragment.identifier" // Shared by all fragments of the original
+	FragmentIndex           = "fragment.index"      // Position of the fragment, starting at 1 (0 for some split processors)
+	FragmentCount           = "fragment.count"      // Number of fragments of the original
+	FragmentOffset          = "fragment.offset"     // Byte offset of the fragment in the original
+	SegmentOriginalFilename = "segment.original.filename"
+)
+
+// Merge attributes, set by MergeContent and MergeRecord.
+const (
+	MergeCount  = "merge.count"   // Number of FlowFiles merged
+	MergeBinAge = "merge.bin.age" // Milliseconds the bin was open
+	MergeUUID   = "merge.uuid"    // UUID of the merged FlowFile, set on the originals
+	MergeReason = "merge.reason"  // Why the bin was merged
+)
+
+// Kafka attributes, set by ConsumeKafka and read by PublishKafka.
+const (
+	KafkaKey       = "kafka.key"
+	KafkaTopic     = "kafka.topic"
+	KafkaPartition = "kafka.partition"
+	KafkaOffset    = "kafka.offset"
+	KafkaTimestamp = "kafka.timestamp" // Milliseconds since the epoch
+	KafkaCount     = "kafka.count"     // Number of messages in a demarcated FlowFile
+	KafkaTombstone = "kafka.tombstone" // Set to true for a message without a value
+)
+
+// ErrorMissing is returned when a required attribute is not set.
+var ErrorMissing = errors.New("Missing attribute")
+
+// GetInt parses an integer attribute, returning false when it is not set or
+// not a number.
+func GetInt(a flowfile.Attributes, name string) (int64, bool) {
+	v, err := strconv.ParseInt(a.Get(name), 10, 64)
+	return v, err == nil
+}
+
+// SetInt sets an integer attribute.
+func SetInt(a *flowfile.Attributes, name string, v int64) {
+	a.Set(name, strconv.FormatInt(v, 10))
+}
+
+// GetTime parses a time attribute, such as file.lastModifiedTime, in the
+// RFC3339 form written by this library, the yyyy-MM-dd'T'HH:mm:ssZ form
+// written by NiFi or the other forms flowfile.ParseTime accepts.  False is
+// returned when it is not set or not a time.
+func GetTime(a flowfile.Attributes, name string) (time.Time, bool) {
+	return a.GetTime(name)
+}
+
+// SetTime sets a time attribute in the RFC3339 form.
+func SetTime(a *flowfile.Attributes, name string, t time.Time) {
+	a.Set(name, t.Format(time.RFC3339))
+}
+
+// GetMillis parses a time attribute given in milliseconds since the epoch,
+// such as kafka.timestamp.
+func GetMillis(a flowfile.Attributes, name string) (time.Time, bool) {
+	ms, ok := GetInt(a, name)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
+
+// A Fragment is where a FlowFile fits among the fragments of the original it
+// was split from.
+type Fragment struct {
+	Identifier string
+	Index      int
+	Count      int
+	Offset     int64 // -1 when not set, as not all split processors set it
+
+	OriginalFilename string
+}
+
+// GetFragment reads the fragment attributes, the identifier, index and count
+// must be set.
+func GetFragment(a flowfile.Attributes) (frag Fragment, err error) {
+	if frag.Identifier = a.Get(FragmentIdentifier); frag.Identifier == "" {
+		return frag, fmt.Errorf("%w %s", ErrorMissing, FragmentIdentifier)
+	}
+	for _, p := range []struct {
+		name string
+		v    *int
+	}{{FragmentIndex, &frag.Index}, {FragmentCount, &frag.Count}} {
+		v, ok := GetInt(a, p.name)
+		if !ok {
+			return frag, fmt.Errorf("%w %s", ErrorMissing, p.name)
+		}
+		*p.v = int(v)
+	}
+	frag.Offset = -1
+	if v, ok := GetInt(a, FragmentOffset); ok {
+		frag.Offset = v
+	}
+	frag.OriginalFilename = a.Get(SegmentOriginalFilename)
+	return
+}
+
+// SetFragment writes the fragment attributes, the offset and original
+// filename are left unset when negative or empty.
+func SetFragment(a *flowfile.Attributes, frag Fragment) {
+	a.Set(FragmentIdentifier, frag.Identifier)
+	SetInt(a, FragmentIndex, int64(frag.Index))
+	SetInt(a, FragmentCount, int64(frag.Count))
+	if frag.Offset >= 0 {
+		SetInt(a, FragmentOffset, frag.Offset)
+	}
+	if frag.OriginalFilename != "" {
+		a.Set(SegmentOriginalFilename, frag.OriginalFilename)
+	}
+}
+
+// A KafkaRecord is the position and metadata of a Kafka message.
+type KafkaRecord struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       string
+	Timestamp time.Time // zero when not set
+}
+
+// GetKafka reads the kafka attributes, the topic, partition and offset must
+// be set.
+func GetKafka(a flowfile.Attributes) (rec KafkaRecord, err error) {
+	if rec.Topic = a.Get(KafkaTopic); rec.Topic == "" {
+		return rec, fmt.Errorf("%w %s", ErrorMissing, KafkaTopic)
+	}
+	p, ok := GetInt(a, KafkaPartition)
+	if !ok {
+		return rec, fmt.Errorf("%w %s", ErrorMissing, KafkaPartition)
+	}
+	rec.Partition = int32(p)
+	if rec.Offset, ok = GetInt(a, KafkaOffset); !ok {
+		return rec, fmt.Errorf("%w %s", ErrorMissing, KafkaOffset)
+	}
+	rec.Key = a.Get(KafkaKey)
+	rec.Timestamp, _ = GetMillis(a, KafkaTimestamp)
+	return
+}
+
+// SetKafka writes the kafka attributes, the key and timestamp are left unset
+// when empty.
+func SetKafka(a *flowfile.Attributes, rec KafkaRecord) {
+	a.Set(KafkaTopic, rec.Topic)
+	SetInt(a, KafkaPartition, int64(rec.Partition))
+	SetInt(a, KafkaOffset, rec.Offset)
+	if rec.Key != "" {
+		a.Set(KafkaKey, rec.Key)
+	}
+	if !rec.Timestamp.IsZero() {
+		SetInt(a, KafkaTimestamp, rec.Timestamp.UnixMilli())
+	}
+}
@@ -0,0 +1,74 @@
+package nifiattr_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/nifiattr"
+)
+
+func TestFragment(t *testing.T) {
+	var a flowfile.Attributes
+	want := nifiattr.Fragment{Identifier: "id", Index: 2, Count: 3, Offset: 40, OriginalFilename: "a.bin"}
+	nifiattr.SetFragment(&a, want)
+	if got, err := nifiattr.GetFragment(a); err != nil || got != want {
+		t.Errorf("expecting %+v, got %+v %v", want, got, err)
+	}
+
+	// The offset is optional, as not all split processors set it
+	a.Unset(nifiattr.FragmentOffset)
+	if got, err := nifiattr.GetFragment(a); err != nil || got.Offset != -1 {
+		t.Errorf("expecting no offset, got %+v %v", got, err)
+	}
+	a.Unset(nifiattr.FragmentCount)
+	if _, err := nifiattr.GetFragment(a); !errors.Is(err, nifiattr.ErrorMissing) {
+		t.Errorf("expecting ErrorMissing without the count, got %v", err)
+	}
+
+	// The segments made by the library read back
+	f := flowfile.New(strings.NewReader("abcdefghij"), 10)
+	f.Attrs.Set(nifiattr.Filename, "a.bin")
+	segs, err := flowfile.SegmentBySize(f, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := nifiattr.GetFragment(segs[2].Attrs); err != nil || got.Index != 3 || got.Count != 3 || got.Offset != 8 {
+		t.Errorf("unexpected fragment of the last segment, %+v %v", got, err)
+	}
+}
+
+func TestKafka(t *testing.T) {
+	var a flowfile.Attributes
+	want := nifiattr.KafkaRecord{Topic: "events", Partition: 3, Offset: 1234, Key: "k",
+		Timestamp: time.UnixMilli(1709647629123)}
+	nifiattr.SetKafka(&a, want)
+	if a.Get(nifiattr.KafkaTimestamp) != "1709647629123" {
+		t.Errorf("expecting the timestamp in milliseconds, got %q", a.Get(nifiattr.KafkaTimestamp))
+	}
+	if got, err := nifiattr.GetKafka(a); err != nil || got != want {
+		t.Errorf("expecting %+v, got %+v %v", want, got, err)
+	}
+	a.Unset(nifiattr.KafkaOffset)
+	if _, err := nifiattr.GetKafka(a); !errors.Is(err, nifiattr.ErrorMissing) {
+		t.Errorf("expecting ErrorMissing without the offset, got %v", err)
+	}
+}
+
+func TestTime(t *testing.T) {
+	var a flowfile.Attributes
+	a.Set(nifiattr.FileLastModifiedTime, "2024-03-05T14:07:09+0000") // As written by NiFi
+	want := time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)
+	if got, ok := nifiattr.GetTime(a, nifiattr.FileLastModifiedTime); !ok || !got.Equal(want) {
+		t.Errorf("expecting %v, got %v", want, got)
+	}
+	nifiattr.SetTime(&a, nifiattr.FileCreationTime, want)
+	if got, ok := nifiattr.GetTime(a, nifiattr.FileCreationTime); !ok || !got.Equal(want) {
+		t.Errorf("expecting %v back, got %v", want, got)
+	}
+	if _, ok := nifiattr.GetInt(a, nifiattr.FileSize); ok {
+		t.Errorf("expecting no file.size")
+	}
+}
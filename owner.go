@@ -0,0 +1,164 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// An OwnerMap chooses the owner of the Files saved by a Saver from an identity
+// in their attributes, such as the subject of the client certificate recorded
+// in the custody chain or a username set by the sender, so Files land in a
+// multi-user landing zone owned by the user they are for rather than the
+// service account.  It is only applied when the process runs as root.
+//
+// The rules are tried in order and the first with a matching pattern gives
+// the user and group, which are names or numeric ids and may refer to the
+// groups of the pattern, such as $1.  When the group is empty the primary
+// group of the user is used.
+//
+//   {
+//     "attribute": "custodyChain.0.user.dn",
+//     "rules": [
+//       {"match": "^CN=([a-z]+),OU=Staff,", "user": "$1"},
+//       {"match": "^CN=builder,", "user": "ci", "group": "release"}
+//     ],
+//     "default": {"user": "nobody", "group": "nogroup"}
+//   }
+type OwnerMap struct {
+	// Attribute holding the identity, defaults to the user.dn of the most
+	// recent hop of the custody chain, as stamped by an HTTPReceiver with
+	// StampAttributes set.
+	Attribute string       `json:"attribute,omitempty"`
+	Rules     []*OwnerRule `json:"rules"`
+
+	// Owner of Files matching no rule, when nil they are left owned by the
+	// process unless Required is set, in which case they are refused with an
+	// ErrorNoOwner.
+	Default  *OwnerRule `json:"default,omitempty"`
+	Required bool       `json:"required,omitempty"`
+}
+
+// An OwnerRule maps identities matching the pattern to a user and group.
+type OwnerRule struct {
+	Match string `json:"match,omitempty"`
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Parse a JSON document into an OwnerMap.
+func ParseOwnerMap(dat []byte) (*OwnerMap, error) {
+	m := &OwnerMap{}
+	if err := json.Unmarshal(dat, m); err != nil {
+		return nil, err
+	}
+	if err := m.Compile(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Compile prepares the patterns of a map built in code, this is done by
+// ParseOwnerMap and must be called before the map is used otherwise.
+func (m *OwnerMap) Compile() (err error) {
+	for i, rule := range m.Rules {
+		if rule.re, err = regexp.Compile(rule.Match); err != nil {
+			return fmt.Errorf("Owner rule %d: %w", i, err)
+		}
+		if !strings.Contains(rule.User+rule.Group, "$") {
+			if _, _, err = rule.resolve(nil, ""); err != nil {
+				return fmt.Errorf("Owner rule %d: %w", i, err)
+			}
+		}
+	}
+	if m.Default != nil {
+		if _, _, err = m.Default.resolve(nil, ""); err != nil {
+			return fmt.Errorf("Default owner: %w", err)
+		}
+	}
+	return nil
+}
+
+// Owner returns the uid and gid the File with the attributes is to be owned
+// by, -1 leaves the owner as is.
+func (m *OwnerMap) Owner(attrs Attributes) (uid, gid int, err error) {
+	name := m.Attribute
+	if name == "" {
+		name = custodyKey(0, "user.dn")
+	}
+	if id, ok := attrs.lookup(name); ok && id != "" {
+		for _, rule := range m.Rules {
+			if match := rule.re.FindStringSubmatchIndex(id); match != nil {
+				return rule.resolve(match, id)
+			}
+		}
+	}
+	switch {
+	case m.Default != nil:
+		return m.Default.resolve(nil, "")
+	case m.Required:
+		return -1, -1, ErrorNoOwner
+	}
+	return -1, -1, nil
+}
+
+// Look up the user and group, expanding references to the match
+func (rule *OwnerRule) resolve(match []int, id string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	expand := func(s string) string {
+		if match == nil {
+			return s
+		}
+		return string(rule.re.ExpandString(nil, s, id, match))
+	}
+	if name := expand(rule.User); name != "" {
+		u, err := lookupUser(name)
+		if err != nil {
+			return -1, -1, err
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+		gid, _ = strconv.Atoi(u.Gid)
+	}
+	if name := expand(rule.Group); name != "" {
+		if gid, err = strconv.Atoi(name); err != nil {
+			var g *user.Group
+			if g, err = user.LookupGroup(name); err != nil {
+				return -1, -1, err
+			}
+			gid, err = strconv.Atoi(g.Gid)
+		}
+	}
+	return
+}
+
+// Look up a user by name or id, an id without an account is taken as is
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+		return &user.User{Uid: name, Gid: "-1"}, nil
+	}
+	return user.Lookup(name)
+}
+
+// Change the owner of a saved output, links are changed rather than their
+// targets.  Nothing is done unless running as root on a filesystem which has
+// owners.
+func (s *Saver) chown(name string, uid, gid int) error {
+	if uid < 0 && gid < 0 || os.Geteuid() != 0 {
+		return nil
+	}
+	if c, ok := s.fsys().(chownFS); ok {
+		if err := c.Lchown(name, uid, gid); err != nil && !os.IsNotExist(err) {
+			return err // A link which was passed over does not exist
+		}
+	}
+	return nil
+}
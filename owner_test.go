@@ -0,0 +1,55 @@
+package flowfile_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+// Ids without an account, so the map does not depend on the users of the host
+const ownerMapJSON = `{
+  "rules": [
+    {"match": "^CN=u([0-9]+),OU=Staff,", "user": "$1"},
+    {"match": "^CN=builder,", "user": "4000", "group": "4001"}
+  ],
+  "default": {"user": "4100", "group": "4101"}
+}`
+
+func TestOwnerMap(t *testing.T) {
+	m, err := flowfile.ParseOwnerMap([]byte(ownerMapJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dn := "custodyChain.0.user.dn"
+	for _, tc := range []struct {
+		attrs    flowfile.Attributes
+		uid, gid int
+	}{
+		{attrs(dn, "CN=u4200,OU=Staff,O=Example"), 4200, -1},
+		{attrs(dn, "CN=builder,OU=CI,O=Example"), 4000, 4001},
+		{attrs(dn, "CN=other,O=Example"), 4100, 4101},
+		{attrs("custodyChain.1.user.dn", "CN=builder,OU=CI"), 4100, 4101}, // Not the most recent hop
+	} {
+		if uid, gid, err := m.Owner(tc.attrs); err != nil || uid != tc.uid || gid != tc.gid {
+			t.Errorf("%v: expecting %d:%d, got %d:%d %v", tc.attrs, tc.uid, tc.gid, uid, gid, err)
+		}
+	}
+
+	m.Attribute = "username"
+	if uid, _, _ := m.Owner(attrs("username", "CN=builder,")); uid != 4000 {
+		t.Errorf("expecting the owner from the Attribute, got %d", uid)
+	}
+	m.Default = nil
+	if uid, gid, err := m.Owner(attrs()); err != nil || uid != -1 || gid != -1 {
+		t.Errorf("expecting the owner left as is, got %d:%d %v", uid, gid, err)
+	}
+	m.Required = true
+	if _, _, err := m.Owner(attrs()); !errors.Is(err, flowfile.ErrorNoOwner) {
+		t.Errorf("expecting ErrorNoOwner, got %v", err)
+	}
+
+	if _, err = flowfile.ParseOwnerMap([]byte(`{"rules": [{"match": "(", "user": "1"}]}`)); err == nil {
+		t.Errorf("expecting an error for a bad pattern")
+	}
+}
@@ -0,0 +1,38 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package flowfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestSaveOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("owners are only set when running as root")
+	}
+	m, err := flowfile.ParseOwnerMap([]byte(ownerMapJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saver := flowfile.NewSaver(t.TempDir())
+	saver.Owners = m
+	f := stringFiles("abc")[0]
+	f.AddChecksum("SHA256")
+	f.Attrs.Set("custodyChain.0.user.dn", "CN=builder,OU=CI")
+	out, err := saver.Save(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(filepath.Join(saver.BaseDir, "abc.txt"))
+	if err != nil {
+		t.Fatal(err, out)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && (st.Uid != 4000 || st.Gid != 4001) {
+		t.Errorf("expecting the File owned by 4000:4001, got %d:%d", st.Uid, st.Gid)
+	}
+}
@@ -0,0 +1,116 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// PinSPKI restricts the TLS config to servers presenting a key with one of the
+// given SPKI hashes, in addition to the normal certificate verification, for
+// environments where a CA compromise is in the threat model.  The hashes are
+// the SHA-256 of the DER encoded SubjectPublicKeyInfo, given in base64 (with
+// or without a "sha256/" prefix) or hex, and may pin any certificate in the
+// verified chain, such as the leaf or an intermediate.
+//
+//   cfg := &tls.Config{RootCAs: pool}
+//   err := flowfile.PinSPKI(cfg, "sha256/AAAA...=")
+//   hs, err := flowfile.NewHTTPTransaction(url, cfg)
+func PinSPKI(cfg *tls.Config, hashes ...string) error {
+	pins, err := parsePins(hashes)
+	if err != nil {
+		return err
+	}
+	addVerify(cfg, func(cs tls.ConnectionState) error {
+		for _, cert := range pinCandidates(cs) {
+			if pinned(pins, sha256.Sum256(cert.RawSubjectPublicKeyInfo)) {
+				return nil
+			}
+		}
+		return ErrorPinMismatch
+	})
+	return nil
+}
+
+// PinCertificate restricts the TLS config to servers whose leaf certificate
+// has one of the given SHA-256 fingerprints, in hex with optional colons or in
+// base64, in addition to the normal certificate verification.
+func PinCertificate(cfg *tls.Config, fingerprints ...string) error {
+	pins, err := parsePins(fingerprints)
+	if err != nil {
+		return err
+	}
+	addVerify(cfg, func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) > 0 && pinned(pins, sha256.Sum256(cs.PeerCertificates[0].Raw)) {
+			return nil
+		}
+		return ErrorPinMismatch
+	})
+	return nil
+}
+
+// SPKIHash returns the SPKI pin of a certificate, in the "sha256/<base64>"
+// form accepted by PinSPKI.
+func SPKIHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Chain the check after any VerifyConnection already set
+func addVerify(cfg *tls.Config, check func(tls.ConnectionState) error) {
+	prev := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if prev != nil {
+			if err := prev(cs); err != nil {
+				return err
+			}
+		}
+		return check(cs)
+	}
+}
+
+// The certificates a pin may match, the verified chains when the normal
+// verification was done, and otherwise only the leaf
+func pinCandidates(cs tls.ConnectionState) (certs []*x509.Certificate) {
+	for _, chain := range cs.VerifiedChains {
+		certs = append(certs, chain...)
+	}
+	if len(certs) == 0 && len(cs.PeerCertificates) > 0 {
+		certs = cs.PeerCertificates[:1]
+	}
+	return
+}
+
+func pinned(pins [][]byte, sum [32]byte) bool {
+	for _, p := range pins {
+		if bytes.Equal(p, sum[:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePins(list []string) (pins [][]byte, err error) {
+	for _, s := range list {
+		v := strings.TrimPrefix(strings.TrimSpace(s), "sha256/")
+		var b []byte
+		if h := strings.ReplaceAll(v, ":", ""); len(h) == 2*sha256.Size {
+			b, err = hex.DecodeString(h)
+		} else {
+			b, err = base64.StdEncoding.DecodeString(v)
+		}
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("%w: %q", ErrorInvalidPin, s)
+		}
+		pins = append(pins, b)
+	}
+	if len(pins) == 0 {
+		return nil, ErrorInvalidPin
+	}
+	return
+}
@@ -0,0 +1,64 @@
+package flowfile_test
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestPinning(t *testing.T) {
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	ts := httptest.NewTLSServer(rcv)
+	defer ts.Close()
+	cert := ts.Certificate()
+
+	// The fingerprint in hex with colons, as printed by openssl
+	sum := sha256.Sum256(cert.Raw)
+	var hexPairs []string
+	for _, b := range sum {
+		hexPairs = append(hexPairs, fmt.Sprintf("%02X", b))
+	}
+	fingerprint := strings.Join(hexPairs, ":")
+	other := "sha256/" + strings.Repeat("A", 43) + "="
+
+	handshake := func(pin func(*tls.Config) error) error {
+		pool := x509.NewCertPool()
+		pool.AddCert(cert)
+		cfg := &tls.Config{RootCAs: pool}
+		if err := pin(cfg); err != nil {
+			t.Fatal(err)
+		}
+		_, err := flowfile.NewHTTPTransaction(ts.URL, cfg)
+		return err
+	}
+	for _, tc := range []struct {
+		name string
+		pin  func(*tls.Config) error
+		err  error
+	}{
+		{"spki", func(c *tls.Config) error { return flowfile.PinSPKI(c, other, flowfile.SPKIHash(cert)) }, nil},
+		{"spki mismatch", func(c *tls.Config) error { return flowfile.PinSPKI(c, other) }, flowfile.ErrorPinMismatch},
+		{"certificate", func(c *tls.Config) error { return flowfile.PinCertificate(c, fingerprint) }, nil},
+		{"certificate mismatch", func(c *tls.Config) error { return flowfile.PinCertificate(c, other) }, flowfile.ErrorPinMismatch},
+	} {
+		if err := handshake(tc.pin); !errors.Is(err, tc.err) || (tc.err == nil) != (err == nil) {
+			t.Errorf("%s: expecting %v, got %v", tc.name, tc.err, err)
+		}
+	}
+
+	for _, bad := range []string{"", "sha256/short", strings.Repeat("zz", 32)} {
+		if err := flowfile.PinSPKI(&tls.Config{}, bad); !errors.Is(err, flowfile.ErrorInvalidPin) {
+			t.Errorf("pin %q: expecting ErrorInvalidPin, got %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import "io"
+
+// A PipeWriter writes Files to the Scanner at the other end of a Pipe.
+type PipeWriter struct {
+	w  *Writer
+	pw *io.PipeWriter
+}
+
+// Pipe creates an in-process pipe of Files, each File written to the
+// PipeWriter is parsed back out by the Scanner, without HTTP or binding to a
+// port, so handlers and routing logic can be tested directly.  The pipe is
+// synchronous, a Write blocks until the Scanner has read the File, so the two
+// ends are used from different goroutines.
+//
+// Closing the PipeWriter ends the scan, with the error given to
+// CloseWithError if any, and closing the Scanner fails any pending or later
+// Write with io.ErrClosedPipe.
+//
+//   s, w := flowfile.Pipe()
+//   go func() {
+//     w.Write(flowfile.New(strings.NewReader("test"), 4))
+//     w.Close()
+//   }()
+//   for s.Scan() {
+//     handle(s.File())
+//   }
+func Pipe() (*Scanner, *PipeWriter) {
+	pr, pw := io.Pipe()
+	s := NewScanner(pr)
+	s.closer = pr
+	return s, &PipeWriter{w: NewWriter(pw), pw: pw}
+}
+
+// Write a File to the pipe, returning the bytes written.
+func (p *PipeWriter) Write(f *File) (int64, error) {
+	return p.w.Write(f)
+}
+
+// Close the pipe, the Scanner ends once it has read the Files written.
+func (p *PipeWriter) Close() error {
+	return p.pw.Close()
+}
+
+// CloseWithError closes the pipe, the Scanner ends with the error once it has
+// read the Files written.
+func (p *PipeWriter) CloseWithError(err error) error {
+	return p.pw.CloseWithError(err)
+}
@@ -0,0 +1,49 @@
+package flowfile_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestPipe(t *testing.T) {
+	s, w := flowfile.Pipe()
+	go func() {
+		for _, f := range stringFiles("abc", "defgh") {
+			if _, err := w.Write(f); err != nil {
+				t.Error(err)
+			}
+		}
+		w.Close()
+	}()
+	var got []string
+	for s.Scan() {
+		f := s.File()
+		dat, _ := io.ReadAll(f)
+		got = append(got, f.Attrs.Get("filename")+"="+string(dat))
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "abc.txt=abc,defgh.txt=defgh" {
+		t.Errorf("unexpected Files from the pipe, %q", got)
+	}
+}
+
+func TestPipeClose(t *testing.T) {
+	broken := errors.New("broken")
+	s, w := flowfile.Pipe()
+	go w.CloseWithError(broken)
+	if s.Scan() || !errors.Is(s.Err(), broken) {
+		t.Errorf("expecting the error from CloseWithError, got %v", s.Err())
+	}
+
+	s, w = flowfile.Pipe()
+	s.Close()
+	if _, err := w.Write(stringFiles("abc")[0]); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("expecting io.ErrClosedPipe writing to a closed Scanner, got %v", err)
+	}
+}
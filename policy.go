@@ -0,0 +1,150 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// A PolicyEffect is what a matching PolicyRule does with a File.
+type PolicyEffect int
+
+const (
+	PolicyDeny PolicyEffect = iota
+	PolicyAllow
+)
+
+func (e PolicyEffect) String() string {
+	if e == PolicyAllow {
+		return "allow"
+	}
+	return "deny"
+}
+
+// A PolicyRule matches Files by the identity of the client and by their
+// attributes, every condition set must match for the rule to apply.
+type PolicyRule struct {
+	Name   string // Given in the decisions and rejections
+	Effect PolicyEffect
+
+	// Subject of the client certificate, such as "CN=sender,O=Example", nil
+	// matches any client, including one without a certificate.
+	UserDN *regexp.Regexp
+
+	// Networks the client address must be in, empty matches any.
+	Networks []*net.IPNet
+
+	// Attributes which must be set with a value matching the pattern.
+	Attributes map[string]*regexp.Regexp
+}
+
+// NewPolicyRule builds a PolicyRule from patterns, userDN may be empty to
+// match any client and each attribute condition is given as name=pattern.
+//
+//   rule, err := flowfile.NewPolicyRule("projectA", flowfile.PolicyAllow,
+//     "^CN=sender-a,", "project=^A$", "path=^incoming/")
+func NewPolicyRule(name string, effect PolicyEffect, userDN string, attributes ...string) (rule PolicyRule, err error) {
+	rule = PolicyRule{Name: name, Effect: effect}
+	if userDN != "" {
+		if rule.UserDN, err = regexp.Compile(userDN); err != nil {
+			return
+		}
+	}
+	for _, cond := range attributes {
+		k, pattern, ok := strings.Cut(cond, "=")
+		if !ok || k == "" {
+			return rule, fmt.Errorf("Invalid attribute condition %q, expecting name=pattern", cond)
+		}
+		if rule.Attributes == nil {
+			rule.Attributes = make(map[string]*regexp.Regexp)
+		}
+		if rule.Attributes[k], err = regexp.Compile(pattern); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Does the rule apply to the File from the client
+func (rule *PolicyRule) match(attrs Attributes, userDN string, ip net.IP) bool {
+	if rule.UserDN != nil && !rule.UserDN.MatchString(userDN) {
+		return false
+	}
+	if len(rule.Networks) > 0 {
+		var in bool
+		for _, n := range rule.Networks {
+			if ip != nil && n.Contains(ip) {
+				in = true
+				break
+			}
+		}
+		if !in {
+			return false
+		}
+	}
+	for name, re := range rule.Attributes {
+		if v, ok := attrs.lookup(name); !ok || !re.MatchString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// A Policy decides which clients may send which Files to an HTTPReceiver.  The
+// rules are evaluated in order and the first matching rule decides, when none
+// match the Default applies, which denies unless set otherwise.
+type Policy struct {
+	Rules   []PolicyRule
+	Default PolicyEffect
+
+	// Called with every decision made, for logging.
+	OnDecision func(*PolicyDecision)
+}
+
+// A PolicyDecision is the outcome of evaluating a Policy for a File.
+type PolicyDecision struct {
+	Effect     PolicyEffect
+	Rule       string // Name of the matching rule, empty for the Default
+	UserDN     string
+	RemoteAddr string
+	Attrs      Attributes
+}
+
+func (d *PolicyDecision) Allowed() bool { return d.Effect == PolicyAllow }
+
+// A PolicyError is the reason a File was rejected by a Policy.
+type PolicyError struct {
+	Rule string // Name of the denying rule, empty for the Default
+}
+
+func (e *PolicyError) Error() string {
+	if e.Rule == "" {
+		return "Denied by default policy"
+	}
+	return fmt.Sprintf("Denied by policy rule %q", e.Rule)
+}
+
+// Evaluate the Policy for a File sent in the request.
+func (p *Policy) Evaluate(attrs Attributes, r *http.Request) *PolicyDecision {
+	d := &PolicyDecision{Effect: p.Default, RemoteAddr: r.RemoteAddr, Attrs: attrs}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		d.UserDN = certPKIXString(r.TLS.PeerCertificates[0].Subject, ",")
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	for i := range p.Rules {
+		if rule := &p.Rules[i]; rule.match(attrs, d.UserDN, ip) {
+			d.Effect, d.Rule = rule.Effect, rule.Name
+			break
+		}
+	}
+	if p.OnDecision != nil {
+		p.OnDecision(d)
+	}
+	return d
+}
@@ -0,0 +1,98 @@
+package flowfile_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestPolicyEvaluate(t *testing.T) {
+	denyTmp, err := flowfile.NewPolicyRule("no-tmp", flowfile.PolicyDeny, "", "path=^tmp/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderA, err := flowfile.NewPolicyRule("sender-a", flowfile.PolicyAllow, "^CN=sender-a,", "project=^A$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := flowfile.NewPolicyRule("local", flowfile.PolicyAllow, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, lan, _ := net.ParseCIDR("10.0.0.0/8")
+	local.Networks = []*net.IPNet{lan}
+	if _, err = flowfile.NewPolicyRule("bad", flowfile.PolicyAllow, "", "project"); err == nil {
+		t.Errorf("expecting an error for a condition without a pattern")
+	}
+
+	var decisions int
+	p := &flowfile.Policy{
+		Rules:      []flowfile.PolicyRule{denyTmp, senderA, local},
+		OnDecision: func(*flowfile.PolicyDecision) { decisions++ },
+	}
+	request := func(addr, cn string) *http.Request {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.RemoteAddr = addr
+		if cn != "" {
+			// The subject as parsed from a certificate, O=Example then CN
+			subject := pkix.Name{Names: []pkix.AttributeTypeAndValue{
+				{Type: asn1.ObjectIdentifier{2, 5, 4, 10}, Value: "Example"},
+				{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: cn},
+			}}
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: subject}}}
+		}
+		return r
+	}
+	attrs := func(kv ...string) (a flowfile.Attributes) {
+		for i := 1; i < len(kv); i += 2 {
+			a.Set(kv[i-1], kv[i])
+		}
+		return
+	}
+
+	for _, tc := range []struct {
+		name  string
+		attrs flowfile.Attributes
+		r     *http.Request
+		allow bool
+		rule  string
+	}{
+		{"client and attribute", attrs("project", "A"), request("192.0.2.1:443", "sender-a"), true, "sender-a"},
+		{"other project", attrs("project", "B"), request("192.0.2.1:443", "sender-a"), false, ""},
+		{"other client", attrs("project", "A"), request("192.0.2.1:443", "sender-b"), false, ""},
+		{"network", attrs("project", "B"), request("10.1.2.3:443", ""), true, "local"},
+		{"first rule decides", attrs("project", "A", "path", "tmp/x"), request("10.1.2.3:443", "sender-a"), false, "no-tmp"},
+	} {
+		d := p.Evaluate(tc.attrs, tc.r)
+		if d.Allowed() != tc.allow || d.Rule != tc.rule {
+			t.Errorf("%s: expecting %v by %q, got %v by %q", tc.name, tc.allow, tc.rule, d.Allowed(), d.Rule)
+		}
+	}
+	if decisions != 5 {
+		t.Errorf("expecting OnDecision for each evaluation, got %d", decisions)
+	}
+}
+
+func TestPolicyReceiver(t *testing.T) {
+	rcv, ts := newReadingReceiver(t)
+	rule, err := flowfile.NewPolicyRule("projectA", flowfile.PolicyAllow, "", "project=^A$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcv.Policy = &flowfile.Policy{Rules: []flowfile.PolicyRule{rule}}
+
+	ff := stringFiles("a", "b")
+	ff[0].Attrs.Set("project", "A")
+	ff[1].Attrs.Set("project", "B")
+	if res := postRaw(t, ts.URL, ff[0]); res.StatusCode != http.StatusOK {
+		t.Errorf("expecting a 200 for an allowed File, got %d", res.StatusCode)
+	}
+	expectReject(t, postRaw(t, ts.URL, ff[1]), http.StatusForbidden, "policy")
+}
@@ -0,0 +1,64 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// A prefetchReader reads ahead from a reader into memory, up to a limit, until
+// the first Read is made.  This lets the writing side of a pipe make progress
+// while the reading side is still busy, such as waiting on a handshake.
+type prefetchReader struct {
+	r     io.Reader
+	mu    sync.Mutex
+	cond  *sync.Cond
+	buf   bytes.Buffer
+	err   error
+	stop  bool // set by the first Read
+	done  bool // the read-ahead goroutine has exited
+	limit int
+}
+
+func newPrefetchReader(r io.Reader, limit int) *prefetchReader {
+	p := &prefetchReader{r: r, limit: limit}
+	p.cond = sync.NewCond(&p.mu)
+	go p.fill()
+	return p
+}
+
+func (p *prefetchReader) fill() {
+	tmp := make([]byte, 32<<10)
+	for {
+		n, err := p.r.Read(tmp)
+		p.mu.Lock()
+		p.buf.Write(tmp[:n])
+		p.err = err
+		if err != nil || p.stop || p.buf.Len() >= p.limit {
+			p.done = true
+			p.cond.Broadcast()
+			p.mu.Unlock()
+			return
+		}
+		p.cond.Broadcast()
+		p.mu.Unlock()
+	}
+}
+
+func (p *prefetchReader) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	p.stop = true
+	for p.buf.Len() == 0 && !p.done {
+		p.cond.Wait()
+	}
+	if p.buf.Len() > 0 {
+		defer p.mu.Unlock()
+		return p.buf.Read(b)
+	}
+	err := p.err
+	p.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return p.r.Read(b)
+}
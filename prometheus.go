@@ -0,0 +1,109 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	promContentType        = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// Writes the exposition format, either Prometheus text or OpenMetrics
+type expoWriter struct {
+	w           io.Writer
+	openMetrics bool
+}
+
+func (e *expoWriter) Write(p []byte) (int, error) { return e.w.Write(p) }
+
+// Write the HELP and TYPE lines of a metric family, OpenMetrics names a
+// counter family without the _total suffix of the samples
+func (e *expoWriter) header(name, kind, help string) {
+	if e.openMetrics && kind == "counter" {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	fmt.Fprintf(e.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Does the Accept header ask for OpenMetrics
+func acceptsOpenMetrics(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		if strings.TrimSpace(params[0]) != "application/openmetrics-text" {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && k == "q" {
+				q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+		if q > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Escape a label value for the exposition format
+func promEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// Make a valid metric name, replacing any invalid characters with _
+func promName(name string) string {
+	return promSanitize(name, true)
+}
+
+func promSanitize(name string, colon bool) string {
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_',
+			c >= '0' && c <= '9' && i > 0, c == ':' && colon:
+		default:
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+// Format key value pairs as labels, without the braces
+func promLabels(keyValuePairs ...string) string {
+	var parts []string
+	for i := 1; i < len(keyValuePairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"",
+			promSanitize(keyValuePairs[i-1], false), promEscape(keyValuePairs[i])))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Write out the histogram series in the exposition format, with the
+// millisecond timestamp on each line when tm is set
+func (h *Histogram) writeProm(w io.Writer, name, lblAdd string, tm int64) {
+	if h == nil {
+		return
+	}
+	var ts string
+	if tm != 0 {
+		ts = fmt.Sprintf(" %d", tm)
+	}
+	buckets, cumulative, sum, count := h.Snapshot()
+	for i, v := range cumulative {
+		bk := "+Inf"
+		if i < len(buckets) {
+			bk = fmt.Sprintf("%g", buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"%s} %d%s\n", name, bk, lblAdd, v, ts)
+	}
+	lbl := labelsBraced(strings.TrimPrefix(lblAdd, ","))
+	fmt.Fprintf(w, "%s_sum%s %g%s\n", name, lbl, sum, ts)
+	fmt.Fprintf(w, "%s_count%s %d%s\n", name, lbl, count, ts)
+}
@@ -0,0 +1,52 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+)
+
+// The sidecar written alongside a quarantined File
+type quarantineRecord struct {
+	Attrs Attributes `json:"attributes"`
+	Error string     `json:"error"`
+	Path  string     `json:"path"` // where the File would have been saved
+	Time  time.Time  `json:"time"`
+}
+
+// Move a File which failed verification from src into the QuarantineDir and
+// write the sidecar, returning the new location and the original error.
+func (s *Saver) quarantine(f *File, src, outputFile string, verr error) (string, error) {
+	fsys := s.fsys()
+	if err := mkdirAllFS(fsys, s.QuarantineDir, 0700); err != nil {
+		return outputFile, err
+	}
+	_, filename := path.Split(outputFile)
+	// The uuid is from the sender, only its last element is used
+	if id := path.Base(f.Attrs.Get("uuid")); id != "." && id != ".." && id != "/" {
+		filename = id + "-" + filename
+	}
+	dst := path.Join(s.QuarantineDir, filename)
+	if !withinDir(s.QuarantineDir, dst) || withinDir(dst, s.QuarantineDir) {
+		return outputFile, fmt.Errorf("%w %q, outside of the quarantine directory", ErrorInvalidPath, filename)
+	}
+	if err := fsys.Rename(src, dst); err != nil {
+		return outputFile, err
+	}
+
+	dat, err := json.MarshalIndent(quarantineRecord{
+		Attrs: f.Attrs,
+		Error: verr.Error(),
+		Path:  outputFile,
+		Time:  s.clock().Now(),
+	}, "", "  ")
+	if err == nil {
+		err = writeFileFS(fsys, dst+".json", dat, 0600)
+	}
+	if err != nil {
+		return dst, err
+	}
+	s.debugf("Quarantined %q to %q: %s", outputFile, dst, verr)
+	return dst, verr
+}
@@ -0,0 +1,106 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+
+	"github.com/pschou/go-unixmode"
+)
+
+// NewFromFS creates a new File struct from a file within a fs.FS, such as an
+// embed.FS, a zip.Reader or a fstest.MapFS.  Like NewFromDisk the file is not
+// opened until the content is read, and the metadata the fs.FS provides is
+// captured in the attributes.
+//
+// Note: Files which are neither an io.ReaderAt nor an io.Seeker, such as the
+// compressed entries of a zip, are read fully into memory when opened.
+func NewFromFS(fsys fs.FS, name string) (*File, error) {
+	fi, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{fsys: fsys, filePath: name, fileInfo: fi}
+
+	dn, fn := path.Split(name)
+	if dn == "" {
+		dn = "./"
+	}
+	f.Attrs.add("path", dn)
+	f.Attrs.add("filename", fn)
+	if mt := fi.ModTime(); !mt.IsZero() {
+		f.Attrs.add("file.lastModifiedTime", FormatTime(mt))
+		f.Attrs.add("file.creationTime", FormatTime(mt))
+	}
+	f.Attrs.GenerateUUID()
+
+	switch mode := fi.Mode(); {
+	case mode.IsRegular():
+		f.Size = fi.Size()
+		f.n = f.Size
+		f.Attrs.add("file.permissions", unixmode.FileModePermString(mode))
+	case mode.IsDir():
+		f.Attrs.add("kind", "dir")
+		f.Attrs.add("file.permissions", unixmode.FileModePermString(mode))
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrorInvalidFile, name)
+	}
+	return f, nil
+}
+
+// Open the file behind a File created with NewFromDisk or NewFromFS
+func (f *File) openFile() (readerAtCloser, error) {
+	if f.fsys == nil {
+		return openFile(f.filePath)
+	}
+	return openFS(f.fsys, f.filePath)
+}
+
+// Open a file within a fs.FS for reading at offsets
+func openFS(fsys fs.FS, name string) (readerAtCloser, error) {
+	fh, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	switch t := fh.(type) {
+	case readerAtCloser:
+		return t, nil
+	case io.ReadSeeker:
+		return &seekReaderAt{f: fh, rs: t}, nil
+	}
+	defer fh.Close()
+	dat, err := io.ReadAll(fh)
+	if err != nil {
+		return nil, err
+	}
+	return nopReaderAtCloser{bytes.NewReader(dat)}, nil
+}
+
+// seekReaderAt turns a ReadSeeker into a ReaderAt, serializing the reads
+type seekReaderAt struct {
+	mu sync.Mutex
+	f  fs.File
+	rs io.ReadSeeker
+}
+
+func (s *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.rs.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(s.rs, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (s *seekReaderAt) Close() error { return s.f.Close() }
+
+type nopReaderAtCloser struct{ io.ReaderAt }
+
+func (nopReaderAtCloser) Close() error { return nil }
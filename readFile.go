@@ -7,7 +7,6 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/djherbis/times"
 	"github.com/pschou/go-unixmode"
@@ -18,20 +17,60 @@ func (f File) FilePath() string {
 	return f.filePath
 }
 
+// A SymlinkPolicy selects how symlinks are handled by NewFromDiskSymlinks and
+// by a Saver.
+type SymlinkPolicy int
+
+const (
+	// Send the symlink itself as a File of kind link, and recreate the link on
+	// Save when the target stays within the destination tree.
+	SymlinkAsLink SymlinkPolicy = iota
+
+	// Send the target of the symlink as though it were the file itself.  On
+	// Save this is the same as SymlinkAsLink as there is no content to follow.
+	SymlinkFollow
+
+	// Leave symlinks out, NewFromDiskSymlinks returns a nil File and no error,
+	// and Save passes over Files of kind link.
+	SymlinkSkip
+
+	// Refuse symlinks with an ErrorSymlink.
+	SymlinkError
+)
+
 // NewFromDisk creates a new File struct from a file on disk.  One should add
 // attributes before writing it to a stream.
 //
 // Note that the file is not opened to keep the opened file pointers on the
 // system at a minimum.  However, once a file is used, the file handle remains
 // open until Close() is called.  It is recommended that a checksum is done on
-// the file before sending.
+// the file before sending.  Sparse files are given a sparse.extents attribute
+// so Save can recreate the holes.
 func NewFromDisk(filename string) (*File, error) {
+	return NewFromDiskSymlinks(filename, SymlinkAsLink)
+}
+
+// NewFromDiskSymlinks is like NewFromDisk with the handling of a symlink
+// selected by the policy.
+func NewFromDiskSymlinks(filename string, policy SymlinkPolicy) (*File, error) {
 	f := &File{filePath: filename}
 	var err error
 	f.fileInfo, err = os.Lstat(filename)
 	if err != nil {
 		return nil, err
 	}
+	if f.fileInfo.Mode()&fs.ModeSymlink != 0 {
+		switch policy {
+		case SymlinkFollow:
+			if f.fileInfo, err = os.Stat(filename); err != nil {
+				return nil, err
+			}
+		case SymlinkSkip:
+			return nil, nil
+		case SymlinkError:
+			return nil, fmt.Errorf("%w: %q", ErrorSymlink, filename)
+		}
+	}
 
 	dn, fn := path.Split(filename)
 	if dn == "" {
@@ -39,11 +78,11 @@ func NewFromDisk(filename string) (*File, error) {
 	}
 	f.Attrs.add("path", dn)
 	f.Attrs.add("filename", fn)
-	f.Attrs.add("file.lastModifiedTime", f.fileInfo.ModTime().Format(time.RFC3339))
+	f.Attrs.add("file.lastModifiedTime", FormatTime(f.fileInfo.ModTime()))
 	if ts, err := times.Stat(filename); err == nil && ts.HasBirthTime() {
-		f.Attrs.add("file.creationTime", ts.BirthTime().Format(time.RFC3339))
+		f.Attrs.add("file.creationTime", FormatTime(ts.BirthTime()))
 	} else {
-		f.Attrs.add("file.creationTime", f.fileInfo.ModTime().Format(time.RFC3339))
+		f.Attrs.add("file.creationTime", FormatTime(f.fileInfo.ModTime()))
 	}
 	f.Attrs.GenerateUUID()
 
@@ -52,6 +91,11 @@ func NewFromDisk(filename string) (*File, error) {
 		f.Size = f.fileInfo.Size()
 		f.n = f.Size
 		f.Attrs.add("file.permissions", unixmode.FileModePermString(mode))
+		if maybeSparse(f.fileInfo) {
+			if extents, ok := dataExtents(filename, f.Size); ok {
+				f.Attrs.add("sparse.extents", formatExtents(extents))
+			}
+		}
 	case mode.IsDir():
 		f.Attrs.add("kind", "dir")
 		f.Attrs.add("file.permissions", unixmode.FileModePermString(mode))
@@ -74,7 +118,7 @@ func NewFromDisk(filename string) (*File, error) {
 		f.Attrs.add("kind", "link")
 		f.Attrs.add("target", target)
 	default:
-		return nil, fmt.Errorf("Invalid file: %q", filename)
+		return nil, fmt.Errorf("%w: %q", ErrorInvalidFile, filename)
 	}
 	return f, nil
 }
@@ -0,0 +1,45 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package flowfile_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestNewFromDiskSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "abc.txt"), []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink("abc.txt", link); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := flowfile.NewFromDisk(link)
+	if err != nil || f.Attrs.Get("kind") != "link" || f.Attrs.Get("target") != "abc.txt" || f.Size != 0 {
+		t.Errorf("expecting the link itself, got %v %v", f, err)
+	}
+	f, err = flowfile.NewFromDiskSymlinks(link, flowfile.SymlinkFollow)
+	if err != nil || f.Attrs.Get("kind") != "" || f.Attrs.Get("filename") != "link.txt" || f.Size != 3 {
+		t.Errorf("expecting the target as the file, got %v %v", f, err)
+	}
+	f.Close()
+	if f, err = flowfile.NewFromDiskSymlinks(link, flowfile.SymlinkSkip); f != nil || err != nil {
+		t.Errorf("expecting the link skipped, got %v %v", f, err)
+	}
+	if _, err = flowfile.NewFromDiskSymlinks(link, flowfile.SymlinkError); !errors.Is(err, flowfile.ErrorSymlink) {
+		t.Errorf("expecting ErrorSymlink, got %v", err)
+	}
+
+	// Regular files are the same under any policy
+	if f, err = flowfile.NewFromDiskSymlinks(filepath.Join(dir, "abc.txt"), flowfile.SymlinkError); err != nil || f.Size != 3 {
+		t.Errorf("expecting the regular file, got %v %v", f, err)
+	}
+	f.Close()
+}
@@ -0,0 +1,129 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// A ReceiverMux sends each File in a POST to the handler registered for it,
+// so several handlers can be served behind one contentListener URL.  The
+// predicates are tried in the order the handlers were registered and the first
+// one matching picks the handler, Files matching none go to the Default
+// handler, or are refused with ErrorNoHandler when there is none.
+//
+//   mux := &flowfile.ReceiverMux{Default: saveUnsorted}
+//   mux.Handle(flowfile.AttributeIs("project", "A"), saveProjectA)
+//   mux.Handle(flowfile.MimeType("image/*"), saveImages)
+//   mux.Handle(flowfile.PathPrefix("incoming/reports"), saveReports)
+//   http.Handle("/contentListener", flowfile.NewHTTPFileReceiver(mux.HandleFile))
+type ReceiverMux struct {
+	Default func(*File, http.ResponseWriter, *http.Request) error
+	routes  []muxRoute
+}
+
+type muxRoute struct {
+	match   Predicate
+	handler func(*File, http.ResponseWriter, *http.Request) error
+}
+
+// A Predicate decides whether a File with the attributes is for a handler of
+// a ReceiverMux.
+type Predicate func(Attributes) bool
+
+// Handle registers a handler for the Files matching the predicate.
+func (m *ReceiverMux) Handle(match Predicate, handler func(*File, http.ResponseWriter, *http.Request) error) {
+	m.routes = append(m.routes, muxRoute{match: match, handler: handler})
+}
+
+// HandleFile sends the File to the first handler with a matching predicate,
+// this is intended to be given to NewHTTPFileReceiver.
+func (m *ReceiverMux) HandleFile(f *File, w http.ResponseWriter, r *http.Request) error {
+	for _, rt := range m.routes {
+		if rt.match(f.Attrs) {
+			return rt.handler(f, w, r)
+		}
+	}
+	if m.Default != nil {
+		return m.Default(f, w, r)
+	}
+	return ErrorNoHandler
+}
+
+// AttributeIs matches Files with the attribute set to the value.
+func AttributeIs(name, value string) Predicate {
+	return func(attrs Attributes) bool {
+		v, ok := attrs.lookup(name)
+		return ok && v == value
+	}
+}
+
+// AttributeMatch matches Files with the attribute set to a value matching
+// the pattern.
+func AttributeMatch(name string, pattern *regexp.Regexp) Predicate {
+	return func(attrs Attributes) bool {
+		v, ok := attrs.lookup(name)
+		return ok && pattern.MatchString(v)
+	}
+}
+
+// MimeType matches Files by the mime.type attribute, ignoring any parameters
+// and case.  A subtype of * matches any subtype, such as "image/*".
+func MimeType(mimeType string) Predicate {
+	want := strings.ToLower(mimeType)
+	return func(attrs Attributes) bool {
+		v, ok := attrs.lookup("mime.type")
+		if !ok {
+			return false
+		}
+		got, _, err := mime.ParseMediaType(v)
+		if err != nil {
+			return false
+		}
+		if strings.HasSuffix(want, "/*") {
+			return strings.HasPrefix(got, want[:len(want)-1])
+		}
+		return got == want
+	}
+}
+
+// PathPrefix matches Files with a path attribute in or below the directory,
+// by whole path elements so "incoming" does not match "incoming2/".
+func PathPrefix(prefix string) Predicate {
+	prefix = cleanRel(prefix)
+	return func(attrs Attributes) bool {
+		p := cleanRel(attrs.Get("path"))
+		return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+}
+
+// AllOf matches Files matching every one of the predicates.
+func AllOf(preds ...Predicate) Predicate {
+	return func(attrs Attributes) bool {
+		for _, p := range preds {
+			if !p(attrs) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AnyOf matches Files matching at least one of the predicates.
+func AnyOf(preds ...Predicate) Predicate {
+	return func(attrs Attributes) bool {
+		for _, p := range preds {
+			if p(attrs) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Clean a path attribute for comparison, dropping the leading ./ and /
+func cleanRel(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
@@ -0,0 +1,84 @@
+package flowfile_test
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func attrs(kv ...string) (h flowfile.Attributes) {
+	for i := 0; i < len(kv); i += 2 {
+		h.Set(kv[i], kv[i+1])
+	}
+	return
+}
+
+func TestPredicates(t *testing.T) {
+	txt := flowfile.AttributeMatch("filename", regexp.MustCompile(`\.txt$`))
+	for _, tc := range []struct {
+		name  string
+		match flowfile.Predicate
+		attrs flowfile.Attributes
+		want  bool
+	}{
+		{"is", flowfile.AttributeIs("project", "A"), attrs("project", "A"), true},
+		{"is other", flowfile.AttributeIs("project", "A"), attrs("project", "B"), false},
+		{"is unset", flowfile.AttributeIs("project", ""), attrs(), false},
+		{"match", txt, attrs("filename", "a.txt"), true},
+		{"match other", txt, attrs("filename", "a.png"), false},
+		{"mime", flowfile.MimeType("text/plain"), attrs("mime.type", "Text/Plain; charset=utf-8"), true},
+		{"mime wildcard", flowfile.MimeType("Image/*"), attrs("mime.type", "image/PNG"), true},
+		{"mime other", flowfile.MimeType("image/*"), attrs("mime.type", "text/plain"), false},
+		{"mime unset", flowfile.MimeType("image/*"), attrs(), false},
+		{"path", flowfile.PathPrefix("incoming"), attrs("path", "./incoming/x/"), true},
+		{"path dir", flowfile.PathPrefix("incoming/"), attrs("path", "/incoming"), true},
+		{"path sibling", flowfile.PathPrefix("incoming"), attrs("path", "incoming2/"), false},
+		{"path below", flowfile.PathPrefix("incoming"), attrs("path", "x/incoming"), false},
+		{"path root", flowfile.PathPrefix("./"), attrs("path", "x"), true},
+		{"all", flowfile.AllOf(flowfile.AttributeIs("project", "A"), txt), attrs("project", "A", "filename", "a.txt"), true},
+		{"all but one", flowfile.AllOf(flowfile.AttributeIs("project", "A"), txt), attrs("project", "A"), false},
+		{"any", flowfile.AnyOf(flowfile.AttributeIs("project", "A"), txt), attrs("filename", "a.txt"), true},
+		{"any of none", flowfile.AnyOf(), attrs(), false},
+	} {
+		if got := tc.match(tc.attrs); got != tc.want {
+			t.Errorf("%s: expecting %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestReceiverMux(t *testing.T) {
+	var got []string
+	handler := func(name string) func(*flowfile.File, http.ResponseWriter, *http.Request) error {
+		return func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+			got = append(got, name+" "+f.Attrs.Get("filename"))
+			return nil
+		}
+	}
+	mux := &flowfile.ReceiverMux{}
+	mux.Handle(flowfile.AttributeIs("project", "A"), handler("a"))
+	mux.Handle(flowfile.MimeType("image/*"), handler("image"))
+
+	ff := stringFiles("a", "b", "c")
+	ff[0].Attrs.Set("project", "A")
+	ff[0].Attrs.Set("mime.type", "image/png") // The first match wins
+	ff[1].Attrs.Set("mime.type", "image/png")
+	for _, f := range ff[:2] {
+		if err := mux.HandleFile(f, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mux.HandleFile(ff[2], nil, nil); !errors.Is(err, flowfile.ErrorNoHandler) {
+		t.Errorf("expecting ErrorNoHandler without a Default, got %v", err)
+	}
+	mux.Default = handler("default")
+	if err := mux.HandleFile(ff[2], nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(got, ", "); s != "a a.txt, image b.txt, default c.txt" {
+		t.Errorf("unexpected routing, %s", s)
+	}
+}
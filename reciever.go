@@ -1,29 +1,217 @@
 package flowfile // import "github.com/pschou/go-flowfile"
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Implements http.Handler and can be used with the GoLang built-in http module:
 //   https://pkg.go.dev/net/http#Handler
 type HTTPReceiver struct {
-	Server           string
+	Server           string // Server header to reply with, AboutString when empty
 	MaxPartitionSize int64
 
+	// Additional features advertised in the reply to the handshake.
+	Capabilities Capabilities
+
 	connections    int
 	MaxConnections int
 
+	// The Retry-After hint given when replying with a 503 as MaxConnections
+	// has been met or the receiver is paused, one second when zero.  The number of connections is also
+	// given in the X-Queue-Depth header, so senders can back off.
+	RetryAfter time.Duration
+
 	Metrics *Metrics
+
+	// When set, metric events are also sent to the sink, such as a
+	// PrometheusSink.
+	MetricsSink MetricsSink
+
+	// The source of time for the custody chain stamps, expiry checks and
+	// transfer records, defaults to the DefaultClock.
+	Clock Clock
+
 	handler func(*Scanner, http.ResponseWriter, *http.Request)
+
+	// When VerifyChecksum is set, the checksum of each File is initialized
+	// before it is handed to the handler and verified once the handler is done.
+	// A File failing verification stops the scan and the POST is replied to
+	// with a 406.  Files without a checksum are accepted, but OnVerify will be
+	// called with ErrorChecksumMissing.
+	VerifyChecksum bool
+	OnVerify       func(f *File, err error)
+
+	// Attributes which must be set on every File, Files missing any of these
+	// are rejected with a 406 before the handler is called.
+	RequiredAttributes []RequiredAttribute
+
+	// When set, the attributes of every File are validated against the schema
+	// and a File breaking it is rejected with a 406, with the violations given
+	// in a JSON reply.
+	Schema *AttributeSchema
+
+	// When set, every File is checked against the policy before the handler is
+	// called, a denied File is rejected with a 403.
+	Policy *Policy
+
+	// Called with each File rejected as it is past its expiry, see
+	// ExpiryAttribute.  The POST is replied to with a 410.
+	OnExpired func(*File, *http.Request)
+
+	// When set, each File is stamped with the receive details before the
+	// handler is called, as with CustodyChainShift, CustodyChainAddListen and
+	// CustodyChainAddHTTP, so relays keep the provenance of the Files.
+	StampAttributes bool
+
+	// The custody chain settings the Files are stamped with, the package
+	// CustodyChainPrefix, CustodyChainProvider, CustodyChainMaxDepth and
+	// TrustedProxies are used for those left unset.  A CustodyChainMaxDepth
+	// below zero keeps every hop.
+	CustodyChainPrefix   string
+	CustodyChainProvider func() []Attribute
+	CustodyChainMaxDepth int
+	TrustedProxies       []*net.IPNet
+
+	// How long a receiver created with NewHTTPAckReceiver waits for the Files
+	// of a POST to be acked before replying with a 503, zero waits as long as
+	// the client stays connected.
+	AckTimeout time.Duration
+
+	// Called once each POST has been replied to with a summary of the
+	// transfer, for writing audit logs in the format of choice.
+	OnTransfer func(*TransferRecord)
+
+	// When set, the receiver rebuilds the Files sent as deltas against the
+	// content DeltaBasis opens for a File, such as with Saver.DeltaBasis.  A
+	// DeltaBlockSize of 0 sizes the blocks by the size of the content.
+	DeltaBasis     func(Attributes) (DeltaBasisFile, error)
+	DeltaBlockSize int
+
+	// Offer delta transfers, serving the block signatures of the content held
+	// for a File to senders.  The signatures let a client confirm guesses of
+	// the content held, so a request for them goes through the same Policy,
+	// RequiredAttributes and Schema checks as a File in a POST.
+	DeltaSignatures bool
+
+	// When set, a POST which succeeds is replied to with a JSON Manifest of
+	// the Files processed, unless the handler writes a body of its own.
+	Manifest bool
+
+	// Passed to the Scanner of each POST, see Scanner.BufferThreshold.
+	BufferThreshold int64
+
+	// Merge the attribute sidecars sent ahead of Files back into them, see
+	// Scanner.Sidecars.
+	Sidecars bool
+
+	// Debug output for this receiver, also given to the Scanner of each POST
+	DebugLog
+
+	health healthState
+	pause  pauseState
+	events eventHub
+}
+
+type pauseState struct {
+	mu     sync.Mutex
+	paused bool
+	reason string
+}
+
+// Pause stops the receiver taking in Files, such as during maintenance,
+// without tearing down the HTTP server.  While paused each POST is replied to
+// with a 503 giving the reason, and the handshake still succeeds but
+// advertises the receiver as paused, see Capabilities.Paused.
+func (f *HTTPReceiver) Pause(reason string) {
+	f.pause.mu.Lock()
+	defer f.pause.mu.Unlock()
+	if reason == "" {
+		reason = "paused"
+	}
+	f.pause.paused, f.pause.reason = true, reason
+	f.debugln("Pausing receiver:", reason)
+}
+
+// Resume takes in Files again after a Pause.
+func (f *HTTPReceiver) Resume() {
+	f.pause.mu.Lock()
+	defer f.pause.mu.Unlock()
+	if f.pause.paused {
+		f.debugln("Resuming receiver")
+	}
+	f.pause.paused, f.pause.reason = false, ""
+}
+
+// Paused returns whether the receiver is paused and the reason given.
+func (f *HTTPReceiver) Paused() (reason string, paused bool) {
+	f.pause.mu.Lock()
+	defer f.pause.mu.Unlock()
+	return f.pause.reason, f.pause.paused
+}
+
+// A RequiredAttribute names an attribute which must be present on a File, and
+// optionally a pattern which the value must match.
+type RequiredAttribute struct {
+	Name    string
+	Pattern *regexp.Regexp
 }
 
+// Require adds an attribute to the list of RequiredAttributes, if pattern is
+// not empty the value of the attribute must also match the regular expression.
+func (f *HTTPReceiver) Require(name, pattern string) error {
+	req := RequiredAttribute{Name: name}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		req.Pattern = re
+	}
+	f.RequiredAttributes = append(f.RequiredAttributes, req)
+	return nil
+}
+
+// An AttributeError describes an attribute which did not meet a requirement.
+type AttributeError struct {
+	Name    string
+	Pattern string // the pattern not matched, empty when the attribute is missing
+}
+
+func (e *AttributeError) Error() string {
+	if e.Pattern == "" {
+		return fmt.Sprintf("Attribute %q is missing", e.Name)
+	}
+	return fmt.Sprintf("Attribute %q does not match %q", e.Name, e.Pattern)
+}
+
+// A RejectError is returned from the Scanner when the HTTPReceiver refuses a
+// File, such as one failing checksum verification.  The POST is then replied
+// to with the StatusCode and Reason, regardless of what the handler replies.
+type RejectError struct {
+	StatusCode int
+	Reason     string
+	Err        error
+}
+
+func (e *RejectError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("Rejected %s", e.Reason)
+	}
+	return fmt.Sprintf("Rejected %s: %s", e.Reason, e.Err)
+}
+
+func (e *RejectError) Unwrap() error { return e.Err }
+
 // NewHTTPReceiver interfaces with the built-in HTTP Handler and parses out the
 // FlowFile stream and provids a FlowFile scanner to a FlowFile handler.
 func NewHTTPReceiver(handler func(*Scanner, http.ResponseWriter, *http.Request)) *HTTPReceiver {
@@ -70,6 +258,37 @@ func (f *HTTPReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	rw := &responseWriter{ResponseWriter: w}
+	w = rw
+	var scanErr error
+	transfer := newTransferRecord(r, f.clock().Now())
+	defer func() {
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK // Nothing written is an implicit 200
+		}
+		var rej *RejectError
+		switch {
+		case scanErr != nil && !errors.As(scanErr, &rej):
+			f.health.record(false, scanErr)
+		case status >= 500:
+			f.health.record(false, fmt.Errorf("Replied with %d %s", status, http.StatusText(status)))
+		case r.Method == "POST":
+			f.health.record(false, nil)
+		}
+		f.Metrics.CountResponse(status, rw.reason)
+		sink := sinkOrNop(f.MetricsSink)
+		sink.Counter("flowfiles_http_responses_total", 1, "code", strconv.Itoa(status))
+		if rw.reason != "" {
+			sink.Counter("flowfiles_rejects_total", 1, "reason", rw.reason)
+		}
+		if f.OnTransfer != nil && r.Method == "POST" {
+			transfer.Duration = f.clock().Now().Sub(transfer.Start)
+			transfer.StatusCode, transfer.Reason, transfer.Err = status, rw.reason, scanErr
+			f.OnTransfer(transfer)
+		}
+	}()
+
 	f.Metrics.MetricsThreadsQueued += 1
 	var once sync.Once
 	var active bool
@@ -92,9 +311,8 @@ func (f *HTTPReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	f.connections++
 	defer func() { f.connections-- }()
 	if f.MaxConnections > 0 && f.connections >= f.MaxConnections {
-		if Debug {
-			log.Println("Denying connection as MaxConnections has been met")
-		}
+		f.debugln("Denying connection as MaxConnections has been met")
+		f.busy(w)
 		http.Error(w, "503 too busy", http.StatusServiceUnavailable)
 		return
 	}
@@ -108,51 +326,284 @@ func (f *HTTPReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			hdr.Set("max-partition-size", fmt.Sprintf("%d", f.MaxPartitionSize))
 		}
 		hdr.Set("x-nifi-transfer-protocol-version", "3")
+		f.capabilities().writeHeader(hdr)
 		hdr.Set("Content-Length", "0")
-		hdr.Set("Server", AboutString)
-		if f.Server != "" {
-			hdr.Set("Server", f.Server)
-		}
+		hdr.Set("Server", f.server())
 		w.WriteHeader(http.StatusOK)
 
+	case "GET":
+		if f.offersDelta() && r.Header.Get(DeltaSignatureHeader) != "" {
+			f.serveSignature(w, r)
+		}
+
 	case "POST":
+		if reason, paused := f.Paused(); paused {
+			f.debugln("Denying POST as the receiver is paused:", reason)
+			f.busy(w)
+			hdr.Set("x-flowfile-reject-reason", "paused")
+			rw.reason = "paused"
+			http.Error(w, "503 paused: "+reason, http.StatusServiceUnavailable)
+			return
+		}
+
 		// Handle the post request method
+		defer func(start time.Time) {
+			secs := f.clock().Now().Sub(start).Seconds()
+			f.Metrics.MetricsPostDuration.Observe(secs)
+			sinkOrNop(f.MetricsSink).Observe("flowfiles_received_post_duration_seconds", secs)
+		}(f.clock().Now())
 		Body := r.Body
 		defer func() {
-			io.Copy(ioutil.Discard, Body)
+			copyBuffer(ioutil.Discard, Body)
 			Body.Close()
 			hdr.Set("Content-Type", "text/plain")
 			hdr.Set("Content-Length", "0")
-			if f.Server != "" {
-				hdr.Set("Server", f.Server)
-			}
+			hdr.Set("Server", f.server())
 		}()
 
-		switch ct := strings.ToLower(r.Header.Get("Content-Type")); ct {
-		case "application/flowfile-v3":
-			reader := &Scanner{r: Body, every: func(ff *File) {
+		var fileStart time.Time
+		reader := &Scanner{
+			every: func(ff *File) {
 				once.Do(doOnce)
-				f.Metrics.BucketCounter(ff.Size)
-			}}
-			f.handler(reader, w, r)
-			reader.Close()
-			if reader.err != nil {
-				if Debug && reader.Err() != nil {
-					log.Printf("Scanner Error: %s", reader.err)
+				fileStart = f.clock().Now()
+				transfer.Files++
+				transfer.Bytes += ff.Size
+				f.Metrics.ObserveTransfer(ff, ff.Size)
+				sink := sinkOrNop(f.MetricsSink)
+				sink.Counter("flowfiles_received_total", 1)
+				sink.Counter("flowfiles_received_bytes_total", float64(ff.Size))
+			},
+			check: func(ff *File) error {
+				err := f.checkFile(ff, r)
+				if err != nil {
+					f.fileEvent(ff, err)
+				}
+				return err
+			},
+			done: func(ff *File) error {
+				defer func() {
+					secs := f.clock().Now().Sub(fileStart).Seconds()
+					f.Metrics.MetricsFileDuration.Observe(secs)
+					sinkOrNop(f.MetricsSink).Observe("flowfiles_received_file_duration_seconds", secs)
+				}()
+				err := f.fileDone(ff)
+				f.fileEvent(ff, err)
+				if err == nil && rw.manifest != nil {
+					rw.manifest.add(ff)
 				}
+				return err
+			},
+			BufferThreshold: f.BufferThreshold,
+			Sidecars:        f.Sidecars,
+			DebugLog:        f.DebugLog,
+		}
+
+		encoding := r.Header.Get("Content-Encoding")
+		switch ct := strings.ToLower(r.Header.Get("Content-Type")); ct {
+		case "application/flowfile-v3":
+			in, err := f.decodeBody(encoding, Body)
+			if err != nil {
+				f.debugln("Unable to decode body:", err)
+				http.Error(w, "415 unsupported content encoding", http.StatusUnsupportedMediaType)
 				return
 			}
+			defer in.Close()
+			reader.r = in
 		default:
-			if N, err := strconv.ParseUint(r.Header.Get("Content-Length"), 10, 64); err == nil {
-				ch := make(chan *File, 1)
-				ch <- &File{r: Body, n: int64(N)}
-				reader := &Scanner{ch: ch, every: func(ff *File) {
-					once.Do(doOnce)
-					f.Metrics.BucketCounter(ff.Size)
-				}}
-				f.handler(reader, w, r)
-				reader.Close()
+			if encoding != "" && !strings.EqualFold(encoding, "identity") {
+				// The size of the content is only known from the Content-Length
+				http.Error(w, "415 unsupported content encoding", http.StatusUnsupportedMediaType)
+				return
 			}
+			N, err := strconv.ParseUint(r.Header.Get("Content-Length"), 10, 64)
+			if err != nil {
+				return
+			}
+			ch := make(chan *File, 1)
+			ch <- &File{r: Body, n: int64(N), Size: int64(N)}
+			close(ch)
+			reader.ch = ch
+		}
+
+		if want, ok := parseBatchCount(r.Header); ok {
+			reader.end = func() error { return f.checkBatch(want, transfer, hdr) }
+		}
+
+		rw.s = reader
+		if f.Manifest {
+			rw.manifest = &Manifest{}
+		}
+		f.handler(reader, rw, r)
+		reader.Close()
+		rw.finish()
+		if scanErr = reader.Err(); scanErr != nil {
+			f.debugf("Scanner Error: %s", reader.err)
+		}
+	}
+}
+
+// Wrap the body with the codec for the content coding, which must be one
+// advertised in the handshake
+func (f *HTTPReceiver) decodeBody(encoding string, body io.ReadCloser) (io.ReadCloser, error) {
+	if encoding == "" || strings.EqualFold(encoding, "identity") {
+		return body, nil
+	}
+	codec := LookupCodec(encoding)
+	if codec == nil || !f.capabilities().HasCodec(encoding) {
+		return nil, fmt.Errorf("%w %q", ErrorUnsupportedEncoding, encoding)
+	}
+	return codec.NewReader(body)
+}
+
+// Set the back off hints for a busy reply
+func (f *HTTPReceiver) busy(w http.ResponseWriter) {
+	secs := int64((f.RetryAfter + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	w.Header().Set("X-Queue-Depth", strconv.Itoa(f.connections))
+}
+
+func (f *HTTPReceiver) clock() Clock {
+	return clockOr(f.Clock)
+}
+
+// The custody chain settings of the receiver over the package defaults
+func (f *HTTPReceiver) custodyChain() custodyChain {
+	c := defaultCustodyChain()
+	if f.CustodyChainPrefix != "" {
+		c.prefix = f.CustodyChainPrefix
+	}
+	if f.CustodyChainProvider != nil {
+		c.provider = f.CustodyChainProvider
+	}
+	if f.CustodyChainMaxDepth != 0 {
+		c.maxDepth = f.CustodyChainMaxDepth
+	}
+	if f.TrustedProxies != nil {
+		c.proxies = f.TrustedProxies
+	}
+	return c
+}
+
+// TrustProxies adds the given addresses or CIDR ranges to the TrustedProxies
+// of the receiver.
+func (f *HTTPReceiver) TrustProxies(cidrs ...string) (err error) {
+	f.TrustedProxies, err = appendProxies(f.TrustedProxies, cidrs...)
+	return
+}
+
+func (f *HTTPReceiver) server() string {
+	if f.Server != "" {
+		return f.Server
+	}
+	return AboutString
+}
+
+// Checks done on each File before it is handed to the handler
+func (f *HTTPReceiver) checkFile(ff *File, r *http.Request) error {
+	if err := f.checkPolicy(ff.Attrs, r); err != nil {
+		return err
+	}
+	if _, ok := ff.Attrs.lookup(DeltaSizeAttribute); ok {
+		if err := f.applyDelta(ff); err != nil {
+			f.debugln("Rejecting file", ff.Attrs.Get("filename"), err)
+			return &RejectError{StatusCode: http.StatusConflict, Reason: "delta", Err: err}
+		}
+	}
+	if err := f.checkRequired(ff.Attrs); err != nil {
+		return err
+	}
+	if ff.ExpiredAt(f.clock().Now()) {
+		if f.OnExpired != nil {
+			f.OnExpired(ff, r)
+		}
+		err := fmt.Errorf("%w at %s", ErrorExpired, ff.Attrs.Get(ExpiryAttribute))
+		f.debugln("Rejecting file", ff.Attrs.Get("filename"), err)
+		return &RejectError{StatusCode: http.StatusGone, Reason: "expired", Err: err}
+	}
+	if err := f.checkSchema(ff.Attrs); err != nil {
+		return err
+	}
+	if f.VerifyChecksum && ff.cksumStatus == cksumPreinit {
+		if err := fipsChecksum(ff.Attrs.Get("checksumType")); err != nil {
+			f.debugln("Rejecting file", ff.Attrs.Get("filename"), err)
+			return &RejectError{StatusCode: http.StatusNotAcceptable, Reason: "checksum", Err: err}
+		}
+		ff.ChecksumInit()
+	}
+	if f.StampAttributes {
+		chain := f.custodyChain()
+		chain.shift(&ff.Attrs, f.clock().Now())
+		if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			chain.addListen(&ff.Attrs, addr.String())
+		}
+		chain.addHTTP(&ff.Attrs, r)
+	}
+	return nil
+}
+
+// Check the client may send a File with the attributes
+func (f *HTTPReceiver) checkPolicy(attrs Attributes, r *http.Request) error {
+	if f.Policy == nil {
+		return nil
+	}
+	d := f.Policy.Evaluate(attrs, r)
+	sinkOrNop(f.MetricsSink).Counter("flowfiles_policy_decisions_total", 1, "decision", d.Effect.String(), "rule", d.Rule)
+	if !d.Allowed() {
+		err := &PolicyError{Rule: d.Rule}
+		f.debugln("Rejecting file", attrs.Get("filename"), "from", d.UserDN, d.RemoteAddr, err)
+		return &RejectError{StatusCode: http.StatusForbidden, Reason: "policy", Err: err}
+	}
+	return nil
+}
+
+// Check the attributes have the RequiredAttributes
+func (f *HTTPReceiver) checkRequired(attrs Attributes) error {
+	for _, req := range f.RequiredAttributes {
+		var err error
+		if v, ok := attrs.lookup(req.Name); !ok {
+			err = &AttributeError{Name: req.Name}
+		} else if req.Pattern != nil && !req.Pattern.MatchString(v) {
+			err = &AttributeError{Name: req.Name, Pattern: req.Pattern.String()}
+		}
+		if err != nil {
+			f.debugln("Rejecting file", attrs.Get("filename"), err)
+			return &RejectError{StatusCode: http.StatusNotAcceptable, Reason: "required-attribute", Err: err}
+		}
+	}
+	return nil
+}
+
+// Check the attributes against the Schema
+func (f *HTTPReceiver) checkSchema(attrs Attributes) error {
+	if err := f.Schema.Check(attrs); err != nil {
+		f.debugln("Rejecting file", attrs.Get("filename"), err)
+		return &RejectError{StatusCode: http.StatusNotAcceptable, Reason: "schema", Err: err}
+	}
+	return nil
+}
+
+// Checks done on each File after the handler is done with it
+func (f *HTTPReceiver) fileDone(ff *File) (err error) {
+	if f.VerifyChecksum && ff.Size > 0 {
+		if ff.cksumStatus == cksumInit && ff.n > 0 {
+			// Make sure the whole payload has gone through the checksum
+			if _, err = copyBuffer(ioutil.Discard, ff); err != nil {
+				return
+			}
+		}
+		err = ff.Verify()
+		if f.OnVerify != nil {
+			f.OnVerify(ff, err)
+		}
+		if err == ErrorChecksumMissing {
+			return nil
+		} else if err != nil {
+			f.debugln("Rejecting file", ff.Attrs.Get("filename"), ff.VerifyDetails())
+			return &RejectError{StatusCode: http.StatusNotAcceptable, Reason: "checksum", Err: err}
 		}
 	}
+	return
 }
@@ -0,0 +1,362 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+)
+
+// A receiver whose handler reads each File through, with the server closed
+// at the end of the test
+func newReadingReceiver(t *testing.T) (*flowfile.HTTPReceiver, *httptest.Server) {
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		_, err := io.Copy(io.Discard, f)
+		return err
+	})
+	ts := httptest.NewServer(rcv)
+	t.Cleanup(ts.Close)
+	return rcv, ts
+}
+
+// Post the Files in one POST, returning the reply with the body closed
+func postRaw(t *testing.T, url string, ff ...*flowfile.File) *http.Response {
+	t.Helper()
+	var body bytes.Buffer
+	for _, f := range ff {
+		if _, err := flowfile.NewWriter(&body).Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := http.Post(url, "application/flowfile-v3", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res
+}
+
+// Check the reply is the rejection expected
+func expectReject(t *testing.T, res *http.Response, code int, reason string) {
+	t.Helper()
+	if res.StatusCode != code || res.Header.Get("x-flowfile-reject-reason") != reason {
+		t.Errorf("expecting a %d %q, got %d %q", code, reason,
+			res.StatusCode, res.Header.Get("x-flowfile-reject-reason"))
+	}
+}
+
+func TestReceiverVerifyChecksum(t *testing.T) {
+	rcv, ts := newReadingReceiver(t)
+	rcv.VerifyChecksum = true
+	var verified []error
+	rcv.OnVerify = func(f *flowfile.File, err error) { verified = append(verified, err) }
+
+	dat := []byte("abcdefghij")
+	if res := postRaw(t, ts.URL, checksummed(t, dat, "")); res.StatusCode != http.StatusOK {
+		t.Errorf("expecting a 200 for a matching checksum, got %d", res.StatusCode)
+	}
+	expectReject(t, postRaw(t, ts.URL, checksummed(t, dat, "00")), http.StatusNotAcceptable, "checksum")
+	if len(verified) != 2 || verified[0] != nil || verified[1] == nil {
+		t.Errorf("expecting OnVerify with a pass and a failure, got %v", verified)
+	}
+
+	// Without the option the content is taken as is
+	rcv.VerifyChecksum = false
+	if res := postRaw(t, ts.URL, checksummed(t, dat, "00")); res.StatusCode != http.StatusOK {
+		t.Errorf("expecting a 200 without VerifyChecksum, got %d", res.StatusCode)
+	}
+}
+
+func TestReceiverRequiredAttributes(t *testing.T) {
+	rcv, ts := newReadingReceiver(t)
+	if err := rcv.Require("classification", "^(U|C)$"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rcv.Require("bad", "("); err == nil {
+		t.Errorf("expecting an error for an invalid pattern")
+	}
+
+	for _, tc := range []struct {
+		value string
+		code  int
+	}{
+		{"", http.StatusNotAcceptable},
+		{"S", http.StatusNotAcceptable},
+		{"U", http.StatusOK},
+	} {
+		f := stringFiles("abc")[0]
+		if tc.value != "" {
+			f.Attrs.Set("classification", tc.value)
+		}
+		if tc.code == http.StatusOK {
+			if res := postRaw(t, ts.URL, f); res.StatusCode != http.StatusOK {
+				t.Errorf("expecting a 200 with the attribute, got %d", res.StatusCode)
+			}
+			continue
+		}
+		expectReject(t, postRaw(t, ts.URL, f), tc.code, "required-attribute")
+	}
+}
+
+func TestReceiverPause(t *testing.T) {
+	rcv, ts := newReadingReceiver(t)
+	rcv.RetryAfter = 30 * time.Second
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcv.Pause("maintenance")
+	if reason, paused := rcv.Paused(); !paused || reason != "maintenance" {
+		t.Errorf("expecting paused for maintenance, got %v %q", paused, reason)
+	}
+	err = hs.Send(stringFiles("abc")...)
+	var se *flowfile.SendError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusServiceUnavailable ||
+		se.Header.Get("x-flowfile-reject-reason") != "paused" || !strings.Contains(se.Error(), "maintenance") {
+		t.Fatalf("expecting a 503 paused, got %v", err)
+	}
+	if d := se.RetryAfter(); d != 30*time.Second {
+		t.Errorf("expecting a Retry-After of 30s, got %v", d)
+	}
+
+	// The pause is advertised in the handshake
+	if err = hs.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if !hs.Capabilities.Paused {
+		t.Errorf("expecting the pause advertised")
+	}
+
+	rcv.Resume()
+	if _, paused := rcv.Paused(); paused {
+		t.Errorf("expecting resumed")
+	}
+	if err = hs.Send(stringFiles("abc")...); err != nil {
+		t.Errorf("expecting the send to pass once resumed, got %v", err)
+	}
+}
+
+func TestReceiverBusy(t *testing.T) {
+	rcv, ts := newReadingReceiver(t)
+	rcv.MaxConnections = 1
+	rcv.RetryAfter = 1500 * time.Millisecond
+	res := postRaw(t, ts.URL, stringFiles("abc")...)
+	if res.StatusCode != http.StatusServiceUnavailable || res.Header.Get("Retry-After") != "2" ||
+		res.Header.Get("X-Queue-Depth") == "" {
+		t.Errorf("expecting a 503 with the back off hints, got %d %v", res.StatusCode, res.Header)
+	}
+}
+
+func TestReceiverStampAttributes(t *testing.T) {
+	var got flowfile.Attributes
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		got = f.Attrs.Clone()
+		return nil
+	})
+	rcv.StampAttributes = true
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+
+	f := stringFiles("abc")[0]
+	f.Attrs.Set("custodyChain.0.time", "2024-01-01T00:00:00Z") // From the hop before
+	postRaw(t, ts.URL+"/contentListener", f)
+
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+	for name, want := range map[string]string{
+		"custodyChain.1.time":        "2024-01-01T00:00:00Z",
+		"custodyChain.0.source.host": "127.0.0.1",
+		"custodyChain.0.local.port":  port,
+		"custodyChain.0.request.uri": "/contentListener",
+		"custodyChain.0.protocol":    "HTTP",
+	} {
+		if v := got.Get(name); v != want {
+			t.Errorf("expecting %s of %q, got %q", name, want, v)
+		}
+	}
+	if _, ok := got.GetTime("custodyChain.0.time"); !ok {
+		t.Errorf("expecting the time received stamped, got %v", got)
+	}
+
+	rcv.StampAttributes = false
+	postRaw(t, ts.URL, stringFiles("abc")...)
+	if v := got.Get("custodyChain.0.time"); v != "" {
+		t.Errorf("expecting no stamp without StampAttributes, got %q", v)
+	}
+}
+
+func TestReceiverCustodyChainSettings(t *testing.T) {
+	var got flowfile.Attributes
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		got = f.Attrs.Clone()
+		return nil
+	})
+	rcv.StampAttributes = true
+	rcv.CustodyChainPrefix = "chain"
+	rcv.CustodyChainMaxDepth = 2
+	rcv.CustodyChainProvider = func() []flowfile.Attribute {
+		return []flowfile.Attribute{{Name: "site", Value: "east"}}
+	}
+	if err := rcv.TrustProxies("127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+
+	f := stringFiles("abc")[0]
+	f.Attrs.Set("chain.0.time", "t1")
+	f.Attrs.Set("chain.1.time", "t0")
+	req, err := http.NewRequest("POST", ts.URL, writeStream(t, f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/flowfile-v3")
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	for name, want := range map[string]string{
+		"chain.0.site":            "east",
+		"chain.0.source.host":     "203.0.113.9",
+		"chain.0.proxy.host":      "127.0.0.1",
+		"chain.1.time":            "t1",
+		"chain.2.time":            "",
+		"chain.dropped.hops":      "1",
+		"custodyChain.0.time":     "",
+		"custodyChain.0.site":     "",
+		"chain.dropped.firstTime": "t0",
+	} {
+		if v := got.Get(name); v != want {
+			t.Errorf("expecting %s of %q, got %q", name, want, v)
+		}
+	}
+	if flowfile.CustodyChainPrefix != "custodyChain" || flowfile.CustodyChainMaxDepth != 0 || len(flowfile.TrustedProxies) != 0 {
+		t.Errorf("expecting the package defaults left as they were")
+	}
+}
+
+func TestReceiverOnTransfer(t *testing.T) {
+	rcv, ts := newReadingReceiver(t)
+	var records []flowfile.TransferRecord
+	rcv.OnTransfer = func(rec *flowfile.TransferRecord) { records = append(records, *rec) }
+
+	hs, err := flowfile.NewHTTPTransaction(ts.URL+"/contentListener", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("expecting no record of the handshake, got %+v", records)
+	}
+	if err = hs.Send(stringFiles("abc", "defg")...); err != nil {
+		t.Fatal(err)
+	}
+	rcv.Require("classification", "")
+	hs.Send(stringFiles("abc")...)
+
+	if len(records) != 2 {
+		t.Fatalf("expecting a record of each POST, got %d", len(records))
+	}
+	rec := records[0]
+	if rec.Files != 2 || rec.Bytes != 7 || rec.StatusCode != http.StatusOK || rec.Reason != "" || rec.Err != nil {
+		t.Errorf("unexpected record of the sent POST, %+v", rec)
+	}
+	if rec.TransactionID == "" || rec.TransactionID != hs.TransactionID || rec.RequestURI != "/contentListener" ||
+		!strings.HasPrefix(rec.RemoteAddr, "127.0.0.1:") || rec.UserAgent == "" || rec.Start.IsZero() {
+		t.Errorf("unexpected request details, %+v", rec)
+	}
+	if rec = records[1]; rec.StatusCode != http.StatusNotAcceptable || rec.Reason != "required-attribute" {
+		t.Errorf("expecting the rejected POST recorded, got %+v", rec)
+	}
+}
+
+func TestReceiverDebugLog(t *testing.T) {
+	var logged []string
+	logf := func(format string, v ...interface{}) { logged = append(logged, fmt.Sprintf(format, v...)) }
+
+	quiet, qts := newReadingReceiver(t)
+	quiet.MaxConnections, quiet.Logf = 1, logf
+	loud, lts := newReadingReceiver(t)
+	loud.MaxConnections, loud.Logf, loud.Debug = 1, logf, true
+
+	postRaw(t, qts.URL, stringFiles("abc")...)
+	if len(logged) != 0 {
+		t.Errorf("expecting nothing logged without Debug, got %q", logged)
+	}
+	postRaw(t, lts.URL, stringFiles("abc")...)
+	if len(logged) != 1 || logged[0] != "Denying connection as MaxConnections has been met" {
+		t.Errorf("expecting the debug output of the one receiver, got %q", logged)
+	}
+
+	// The package wide Debug turns on every component
+	defer func(old bool) { flowfile.Debug = old }(flowfile.Debug)
+	flowfile.Debug = true
+	postRaw(t, qts.URL, stringFiles("abc")...)
+	if len(logged) != 2 {
+		t.Errorf("expecting the debug output with the package Debug, got %q", logged)
+	}
+}
+
+func TestServerUserAgent(t *testing.T) {
+	var agents []string
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		agents = append(agents, r.UserAgent())
+		_, err := io.Copy(io.Discard, f)
+		return err
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+
+	// The package defaults, when not set on the instances
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hs.Server != flowfile.AboutString {
+		t.Errorf("expecting the Server %q, got %q", flowfile.AboutString, hs.Server)
+	}
+	hs.Send(stringFiles("abc")...)
+
+	rcv.Server = "Relay East"
+	hs.UserAgent = "Collector 7"
+	if err = hs.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if hs.Server != "Relay East" {
+		t.Errorf("expecting the Server of the receiver, got %q", hs.Server)
+	}
+	hs.Send(stringFiles("abc")...)
+	if len(agents) != 2 || agents[0] != flowfile.UserAgent || agents[1] != "Collector 7" {
+		t.Errorf("expecting the User-Agent of each transaction, got %q", agents)
+	}
+}
+
+func TestReceiverMalformed(t *testing.T) {
+	_, ts := newReadingReceiver(t)
+	var buf bytes.Buffer
+	flowfile.NewWriter(&buf).Write(stringFiles("abcdefgh")[0])
+	for name, body := range map[string][]byte{
+		"bad magic":        []byte("NiFiFF2\x00\x00"),
+		"short attribute":  buf.Bytes()[:12],
+		"truncated header": buf.Bytes()[:4],
+	} {
+		res, err := http.Post(ts.URL, "application/flowfile-v3", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest || res.Header.Get("x-flowfile-reject-reason") != "malformed" {
+			t.Errorf("%s: expecting a 400 malformed, got %d", name, res.StatusCode)
+		}
+	}
+}
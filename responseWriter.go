@@ -0,0 +1,92 @@
+package flowfile
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// responseWriter wraps the http.ResponseWriter handed to a receiver handler
+// so the status can be tracked and overridden when a File has been rejected.
+type responseWriter struct {
+	http.ResponseWriter
+	s      *Scanner // set once a POST is being scanned
+	status int
+	reason string // rejection reason, if any
+
+	manifest *Manifest // replied with on success, when set
+	wrote    bool      // the handler wrote a body
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	var rej *RejectError
+	if w.s != nil && errors.As(w.s.err, &rej) {
+		hdr := w.Header()
+		hdr.Set("Content-Type", "text/plain")
+		hdr.Set("x-flowfile-reject-reason", rej.Reason)
+		hdr.Del("Content-Length")
+		w.status, w.reason = rej.StatusCode, rej.Reason
+		var schemaErr *SchemaError
+		if errors.As(rej, &schemaErr) {
+			// Give the violations in a machine readable form
+			hdr.Set("Content-Type", "application/json")
+			w.ResponseWriter.WriteHeader(rej.StatusCode)
+			json.NewEncoder(w.ResponseWriter).Encode(schemaErr)
+			return
+		}
+		w.ResponseWriter.WriteHeader(rej.StatusCode)
+		io.WriteString(w.ResponseWriter, rej.Error()+"\n")
+		return
+	}
+	if code == http.StatusInternalServerError && w.s != nil && isMalformed(w.s.err) {
+		// The stream sent was malformed, so this is the fault of the client
+		hdr := w.Header()
+		hdr.Set("x-flowfile-reject-reason", "malformed")
+		code, w.reason = http.StatusBadRequest, "malformed"
+	}
+	if code == http.StatusOK && w.manifest != nil {
+		hdr := w.Header()
+		if hdr.Get("Content-Type") == "" {
+			hdr.Set("Content-Type", "application/json")
+		}
+		hdr.Del("Content-Length")
+	}
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.wrote = true
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush enables streaming replies when the underlying writer supports it
+func (w *responseWriter) Flush() {
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		fl.Flush()
+	}
+}
+
+// Make sure a rejection is replied to, even if the handler wrote nothing, and
+// that a success carries the manifest when one is kept
+func (w *responseWriter) finish() {
+	if w.status == 0 && w.s != nil {
+		var rej *RejectError
+		if errors.As(w.s.err, &rej) {
+			w.WriteHeader(rej.StatusCode)
+		} else if w.manifest != nil {
+			w.WriteHeader(http.StatusOK) // The implicit 200, made now for the body
+		}
+	}
+	if w.status == http.StatusOK && w.manifest != nil && !w.wrote &&
+		w.Header().Get("Content-Type") == "application/json" {
+		json.NewEncoder(w.ResponseWriter).Encode(w.manifest)
+	}
+}
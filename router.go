@@ -0,0 +1,54 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+)
+
+var ErrorNoHandler = errors.New("No handler found for File")
+
+// A SizeRouter picks a File handler based upon the size given in the File
+// header, so small-message and bulk paths can be tuned independently.  The
+// handler registered with the smallest MaxSize that can still hold the File is
+// used, and larger Files go to the Default handler.
+//
+//   router := &flowfile.SizeRouter{Default: spoolToDisk}
+//   router.Handle(1<<20, handleInline) // Files up to 1MB
+//   http.Handle("/contentListener", flowfile.NewHTTPFileReceiver(router.HandleFile))
+type SizeRouter struct {
+	Default func(*File, http.ResponseWriter, *http.Request) error
+	routes  []sizeRoute
+}
+
+type sizeRoute struct {
+	maxSize int64
+	handler func(*File, http.ResponseWriter, *http.Request) error
+}
+
+// Handle registers a handler for Files up to and including maxSize bytes.
+// Registering the same size again replaces the previous handler.
+func (sr *SizeRouter) Handle(maxSize int64, handler func(*File, http.ResponseWriter, *http.Request) error) {
+	for i := range sr.routes {
+		if sr.routes[i].maxSize == maxSize {
+			sr.routes[i].handler = handler
+			return
+		}
+	}
+	sr.routes = append(sr.routes, sizeRoute{maxSize: maxSize, handler: handler})
+	sort.Slice(sr.routes, func(i, j int) bool { return sr.routes[i].maxSize < sr.routes[j].maxSize })
+}
+
+// HandleFile sends the File to the handler matching its size, this is intended
+// to be given to NewHTTPFileReceiver.
+func (sr *SizeRouter) HandleFile(f *File, w http.ResponseWriter, r *http.Request) error {
+	for _, rt := range sr.routes {
+		if f.Size <= rt.maxSize {
+			return rt.handler(f, w, r)
+		}
+	}
+	if sr.Default != nil {
+		return sr.Default(f, w, r)
+	}
+	return ErrorNoHandler
+}
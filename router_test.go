@@ -0,0 +1,38 @@
+package flowfile_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestSizeRouter(t *testing.T) {
+	var got string
+	route := func(name string) func(*flowfile.File, http.ResponseWriter, *http.Request) error {
+		return func(*flowfile.File, http.ResponseWriter, *http.Request) error {
+			got = name
+			return nil
+		}
+	}
+	router := &flowfile.SizeRouter{}
+	router.Handle(100, route("medium"))
+	router.Handle(10, route("old"))
+	router.Handle(10, route("small")) // Replaces the old handler
+
+	handle := func(size int) error {
+		got = ""
+		return router.HandleFile(stringFiles(strings.Repeat("x", size))[0], nil, nil)
+	}
+	if err := handle(101); !errors.Is(err, flowfile.ErrorNoHandler) {
+		t.Errorf("expecting ErrorNoHandler without a Default, got %v", err)
+	}
+	router.Default = route("large")
+	for size, want := range map[int]string{0: "small", 10: "small", 11: "medium", 100: "medium", 101: "large"} {
+		if err := handle(size); err != nil || got != want {
+			t.Errorf("size %d: expecting the %s handler, got %q %v", size, want, got, err)
+		}
+	}
+}
@@ -1,59 +1,155 @@
 package flowfile // import "github.com/pschou/go-flowfile"
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/pschou/go-unixmode"
-	"github.com/relvacode/iso8601"
 )
 
 // Save will save the flowfile to a given directory, reconstructing the
 // original directory tree with files in it while doing checksums on each file
-// as they are layed down.  It is up to the calling function to determine
-// whether to delete or keep the file after an unsuccessful send.
+// as they are layed down.  A File failing its checksum is still saved and
+// ErrorChecksumMismatch is returned, as is ErrorChecksumMissing for a File
+// without a checksum, it is up to the calling function to determine whether to
+// delete or keep the file after an unsuccessful save.  See the QuarantineDir and
+// RemoveMismatched of a Saver to have this done on a mismatch.
+//
+// Segments are written in place through the DefaultAssembler, and the segment
+// which completes the File also has the original checksum verified, with the
+// result given to DefaultAssembler.OnComplete.
 func (f *File) Save(baseDir string) (outputFile string, err error) {
+	return NewSaver(baseDir).Save(f)
+}
 
-	fpath := f.Attrs.Get("path")
-	dir := filepath.Clean(fpath)
-	if strings.HasPrefix(dir, "..") {
-		err = fmt.Errorf("Invalid path %q", dir)
+// A Saver lays down Files under a base directory, with options for how they
+// are saved.
+type Saver struct {
+	BaseDir string
+
+	// When set, Files which fail checksum verification are moved into this
+	// directory, along with a sidecar JSON file holding the attributes and the
+	// error, rather than being left in the destination tree.
+	QuarantineDir string
+
+	// When set, Files which fail checksum verification are removed rather
+	// than left in the destination tree, when there is no QuarantineDir.
+	RemoveMismatched bool
+
+	// Used for reassembling segments, defaults to the DefaultAssembler.
+	Assembler *Assembler
+
+	// Modes used when creating directories and files, the process umask is
+	// applied to these unless IgnoreUmask is set.  A file.permissions
+	// attribute on the File still takes precedence for the File itself.
+	DirMode     os.FileMode
+	FileMode    os.FileMode
+	IgnoreUmask bool
+
+	// Maps the attributes of a File to the destination path, relative to the
+	// BaseDir, in place of the path and filename attributes.  The result is
+	// still checked against the path policies below.
+	PathMapper func(Attributes) (string, error)
+
+	// Stricter path policies, Files breaking these are refused with an
+	// ErrorInvalidPath.  Paths leading out of the BaseDir are always refused.
+	RejectAbsolutePaths bool // Refuse a path attribute starting with a /
+	RejectDotFiles      bool // Refuse any path element starting with a .
+	RejectSymlinks      bool // Refuse paths through an existing symlink
+
+	// How Files of kind link are restored, and whether a link may point to an
+	// absolute target.  Relative targets leading out of the BaseDir are always
+	// passed over.
+	Symlinks              SymlinkPolicy
+	AllowAbsoluteSymlinks bool
+
+	// Set the setgid bit on created directories, so the Files saved within
+	// inherit the group of the directory.
+	Setgid bool
+
+	// The filesystem to save into, such as an OSFS rooted at a directory or a
+	// MemFS, defaults to the OS filesystem.  The BaseDir and QuarantineDir are
+	// names within it.
+	FS WritableFS
+
+	// When set, content already saved with the same checksum is linked to
+	// rather than written again, see ContentStore.
+	ContentStore *ContentStore
+
+	// When set and running as root, the Files are given the owner mapped
+	// from their attributes, see OwnerMap.
+	Owners *OwnerMap
+
+	// The source of time for the temporary file names and quarantine records,
+	// defaults to the DefaultClock.
+	Clock Clock
+
+	// Debug output for this saver
+	DebugLog
+}
+
+// Create a new Saver for the given base directory.
+func NewSaver(baseDir string) *Saver {
+	return &Saver{
+		BaseDir:   baseDir,
+		Assembler: DefaultAssembler,
+		DirMode:   0755,
+		FileMode:  0666,
+	}
+}
+
+// Save will save the flowfile under the base directory, see File.Save.
+func (s *Saver) Save(f *File) (outputFile string, err error) {
+	kind := f.Attrs.Get("kind")
+	switch kind {
+	case "metrics", "attributes":
+		return // Nothing is written for these
+	}
+
+	var dir string
+	if dir, outputFile, err = s.outputPath(f.Attrs); err != nil {
 		return
 	}
-	dir = path.Join(baseDir, dir)
-	if _, err = os.Stat(dir); os.IsNotExist(err) {
-		if err = os.MkdirAll(dir, 0755); err != nil {
-			return
-		}
+	if err = s.mkdirAll(dir); err != nil {
+		return
 	}
 
-	_, filename := path.Split(f.Attrs.Get("filename"))
-	outputFile = path.Join(dir, filename)
+	fsys := s.fsys()
 
-	kind := f.Attrs.Get("kind")
+	uid, gid := -1, -1
+	if s.Owners != nil {
+		if uid, gid, err = s.Owners.Owner(f.Attrs); err != nil {
+			return
+		}
+	}
 
 	defer func() {
 		if err == nil {
+			switch kind {
+			case "dir", "file", "", "link":
+				// Ahead of the mode as a change of owner clears the setuid bits
+				err = s.chown(outputFile, uid, gid)
+			}
 			switch kind {
 			case "dir", "file", "":
 				if fm := f.Attrs.Get("file.permissions"); len(fm) >= 9 && runtime.GOOS != "windows" {
 					if mode, err := unixmode.Parse(fm); err == nil {
-						unixmode.Chmod(outputFile, mode)
+						fsys.Chmod(outputFile, mode.FileMode())
 					}
 				}
 
 				// Update file time from sender
 				if mt := f.Attrs.Get("file.lastModifiedTime"); mt != "" {
-					if fileTime, err := iso8601.ParseString(mt); err == nil {
-						os.Chtimes(outputFile, fileTime, fileTime)
+					if fileTime, err := ParseTime(mt); err == nil {
+						fsys.Chtimes(outputFile, fileTime, fileTime)
 					}
 				}
 			}
@@ -61,107 +157,299 @@ func (f *File) Save(baseDir string) (outputFile string, err error) {
 	}()
 
 	switch kind {
-	case "metrics":
 	case "file", "":
-		err = f.saveRegular(outputFile)
+		var final bool
+		var tmp string
+		final, tmp, err = s.saveRegular(f, outputFile)
+		mismatch := final && errors.Is(err, ErrorChecksumMismatch)
+		quarantine := mismatch && s.QuarantineDir != ""
+		switch {
+		case tmp != "" && quarantine:
+			outputFile, err = s.quarantine(f, tmp, outputFile, err)
+		case tmp != "" && mismatch && !s.RemoveMismatched:
+			// Left in place for the caller to decide on
+			if rerr := fsys.Rename(tmp, outputFile); rerr != nil {
+				fsys.Remove(tmp)
+				err = rerr
+			}
+		case tmp != "":
+			fsys.Remove(tmp) // The content never made it into place
+		case quarantine:
+			outputFile, err = s.quarantine(f, outputFile, outputFile, err)
+		case mismatch && s.RemoveMismatched:
+			fsys.Remove(outputFile)
+		}
 	case "dir":
-		err = os.MkdirAll(outputFile, 0755)
+		err = s.mkdirAll(outputFile)
 	case "link":
-		if target := f.Attrs.Get("target"); target != "" && !strings.HasPrefix(target, "/") {
-			cleanedTarget := filepath.Clean(path.Join(dir, target))
-			if !strings.HasPrefix(cleanedTarget, "..") {
-				err = os.Symlink(target, outputFile)
-				if err != nil {
-					// If the creation of the symlink fails, continue
-					if Debug {
-						log.Println("Symlink creation err:", err)
-					}
-					err = nil
-				}
-			} else if Debug {
-				fmt.Println("invalid relative link", target, outputFile)
-			}
+		switch s.Symlinks {
+		case SymlinkSkip:
+		case SymlinkError:
+			err = fmt.Errorf("%w: %q", ErrorSymlink, outputFile)
+		default:
+			s.saveLink(dir, outputFile, f.Attrs.Get("target"))
 		}
 	default:
-		err = fmt.Errorf("Unknown kind %q", kind)
+		err = fmt.Errorf("%w %q", ErrorUnknownKind, kind)
 	}
 	return
 }
 
-func (f *File) saveRegular(outputFile string) (err error) {
-	var fh *os.File
-
-	if sz := f.Attrs.Get("segment.original.size"); sz == "" {
-		// Open a file for whole writeout, write the file, then checksum
-		if fh, err = os.Create(outputFile); err != nil {
+// The path a File with the attributes is saved to, checked against the path
+// policies
+func (s *Saver) outputPath(attrs Attributes) (dir, outputFile string, err error) {
+	fpath := attrs.Get("path")
+	_, filename := path.Split(attrs.Get("filename"))
+	if s.PathMapper != nil {
+		var p string
+		if p, err = s.PathMapper(attrs); err != nil {
 			return
 		}
-		defer fh.Close() // Make sure file is closed at the end of the function
+		fpath, filename = path.Split(p)
+	}
+	if err = s.checkPath(fpath, filename); err != nil {
+		return
+	}
 
-		// Write out file contents
-		if _, err = io.Copy(fh, f); err != nil {
-			return
-		}
-		if f.Size > 0 {
-			err = f.Verify() // Return the verification of the checksum
+	dir = path.Join(s.BaseDir, filepath.Clean(fpath))
+	outputFile = path.Join(dir, filename)
+	for _, p := range []string{outputFile, partialPath(outputFile, s.clock().Now())} {
+		if !withinDir(s.BaseDir, p) {
+			return "", "", fmt.Errorf("%w %q, outside of the base directory", ErrorInvalidPath, path.Join(fpath, filename))
 		}
-	} else {
-		var parentSize, offset uint64
-		if parentSize, err = strconv.ParseUint(sz, 10, 64); err != nil {
+	}
+	if c := s.ContentStore; c != nil && c.holds(outputFile) {
+		return "", "", fmt.Errorf("%w %q, within the content store", ErrorInvalidPath, path.Join(fpath, filename))
+	}
+	if s.RejectSymlinks {
+		err = s.checkSymlinks(s.BaseDir, outputFile)
+	}
+	return
+}
+
+// Recreate a symlink, targets which are not allowed are passed over
+func (s *Saver) saveLink(dir, outputFile, target string) {
+	switch {
+	case target == "":
+		return
+	case strings.HasPrefix(target, "/"):
+		if !s.AllowAbsoluteSymlinks {
+			s.debugln("absolute link not allowed", target, outputFile)
 			return
 		}
-		if offset, err = strconv.ParseUint(f.Attrs.Get("fragment.offset"), 10, 64); err != nil {
-			return
+	case !withinDir(s.BaseDir, path.Join(dir, target)):
+		s.debugln("invalid relative link", target, outputFile)
+		return
+	}
+	// If the creation of the symlink fails, continue
+	if err := s.fsys().Symlink(target, outputFile); err != nil {
+		s.debugln("Symlink creation err:", err)
+	}
+}
+
+// Save the content, final is set when the output file is whole.  A whole File
+// is written to a temporary file next to the output, with the checksum
+// computed as the content is written, and only renamed into place once it has
+// been verified, otherwise the temporary file is returned in tmp.
+func (s *Saver) saveRegular(f *File, outputFile string) (final bool, tmp string, err error) {
+	var fh WritableFile
+
+	if _, err = f.SegmentInfo(); err == ErrorNotSegment {
+		if s.ContentStore != nil && f.Size > 0 {
+			if key, ok := contentKey(f.Attrs); ok && s.ContentStore.verified(key, f.Size) {
+				return s.saveStored(f, key, outputFile)
+			}
 		}
-		// Make sure the target file is in place and has the right size:
-		fh, err = os.OpenFile(outputFile, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
-		if err == nil {
-			io.Copy(fh, &zeros{n: parentSize})
-			fh.Truncate(int64(parentSize))
-			fh.Close()
+		final = true
+		tmp = partialPath(outputFile, s.clock().Now())
+		if fh, err = s.create(tmp, os.O_RDWR|os.O_CREATE|os.O_EXCL); err != nil {
+			return final, "", err
 		}
 
-		var stat os.FileInfo
-		stat, err = os.Stat(outputFile)
-		for i := 0; err != nil && i < 10 || uint64(stat.Size()) < parentSize; i++ {
-			time.Sleep(3 * time.Second)
-			stat, err = os.Stat(outputFile)
-		}
-		if uint64(stat.Size()) == parentSize {
-			if fh, err = os.OpenFile(outputFile, os.O_RDWR, 0600); err != nil {
+		// Write out file contents, leaving any holes unallocated
+		var w io.Writer = fh
+		if holes := parseHoles(f.Attrs.Get("sparse.extents"), f.Size); len(holes) > 0 {
+			if err = fh.Truncate(f.Size); err != nil {
+				fh.Close()
 				return
 			}
-			defer fh.Close() // Make sure file is closed at the end of the function
+			w = &sparseWriter{w: fh, holes: holes}
+		}
 
-			var newOffset int64
-			if newOffset, err = fh.Seek(int64(offset), io.SeekStart); err != nil {
-				return
-			} else if uint64(newOffset) != offset {
-				err = fmt.Errorf("Not able to seek to correct offset %d != %d", newOffset, offset)
+		// Hash the content on the way to disk, regardless of whether the
+		// checksum of the File was initialized
+		h := f.Attrs.NewChecksumHash()
+		if h != nil {
+			w = io.MultiWriter(w, h)
+		}
+		_, err = copyBuffer(w, f)
+		if cerr := fh.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return
+		}
+
+		var verr error
+		if f.Size > 0 {
+			if h == nil {
+				verr = ErrorChecksumMissing
+			} else if fmt.Sprintf("%0x", h.Sum(nil)) == f.Attrs.Get("checksum") {
+				f.cksum, f.cksumStatus = h, cksumPassed
+			} else {
+				f.cksum, f.cksumStatus = h, cksumFailed
+				err = ErrorChecksumMismatch
 				return
 			}
-
-			// Write out the segment contents
-			if _, err = io.Copy(fh, f); err != nil {
+		}
+		if err = s.fsys().Rename(tmp, outputFile); err != nil {
+			return
+		}
+		s.storeContent(f, outputFile)
+		return final, "", verr
+	} else if err == nil {
+		asm := s.Assembler
+		if asm == nil {
+			asm = DefaultAssembler
+		}
+		if s.ContentStore != nil {
+			if err = s.ContentStore.release(outputFile); err != nil {
 				return
 			}
 		}
-		fh.Truncate(int64(parentSize))
+		// Create the output ahead of the assembler so the mode is applied
+		if fh, err = s.create(outputFile, os.O_RDWR|os.O_CREATE); err != nil {
+			return
+		}
+		fh.Close()
+		final, err = asm.WriteSegmentFS(s.fsys(), f, outputFile)
 	}
 	return
 }
 
-type zeros struct {
-	n uint64
+func (s *Saver) fsys() WritableFS {
+	if s.FS == nil {
+		return OSFS("")
+	}
+	return s.FS
 }
 
-// Zero implements the io.Reader interface to enable reading zeros
-func (z *zeros) Read(p []byte) (n int, err error) {
-	if uint64(len(p)) < uint64(z.n) {
-		z.n, n = z.n-uint64(len(p)), len(p)
-	} else {
-		n, z.n = int(z.n), 0
-		err = io.EOF
+func (s *Saver) fileMode() os.FileMode {
+	if s.FileMode == 0 {
+		return 0666
 	}
-	return
+	return s.FileMode
+}
+
+// Open a file for writing, creating it with the FileMode
+func (s *Saver) create(name string, flag int) (WritableFile, error) {
+	fsys := s.fsys()
+	_, statErr := fsys.Lstat(name)
+	fh, err := fsys.OpenFile(name, flag, s.fileMode())
+	if err == nil && os.IsNotExist(statErr) && s.IgnoreUmask {
+		err = fsys.Chmod(name, s.fileMode())
+	}
+	return fh, err
+}
+
+// Create a directory along with any missing parents, using the DirMode
+func (s *Saver) mkdirAll(dir string) error {
+	fsys := s.fsys()
+	if fi, err := fsys.Stat(dir); err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if parent := path.Dir(dir); parent != dir {
+		if err := s.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+
+	mode := s.DirMode
+	if mode == 0 {
+		mode = 0755
+	}
+	if err := fsys.Mkdir(dir, mode); err != nil {
+		if fi, statErr := fsys.Stat(dir); statErr == nil && fi.IsDir() {
+			return nil // Created by someone else in the mean time
+		}
+		return err
+	}
+	if !s.Setgid && !s.IgnoreUmask {
+		return nil
+	}
+	if !s.IgnoreUmask {
+		// Keep the umask applied permissions, only adding the setgid bit
+		if fi, err := fsys.Stat(dir); err == nil {
+			mode = fi.Mode().Perm()
+		}
+	}
+	if s.Setgid {
+		mode |= os.ModeSetgid
+	}
+	return fsys.Chmod(dir, mode)
+}
+
+func (s *Saver) clock() Clock {
+	return clockOr(s.Clock)
+}
+
+// The temporary file the content is written to before being renamed into
+// place as the outputFile
+func partialPath(outputFile string, now time.Time) string {
+	dir, filename := path.Split(outputFile)
+	return path.Join(dir, fmt.Sprintf(".%s.%d.partial", filename, now.UnixNano()))
+}
+
+// Check the destination against the path policies
+func (s *Saver) checkPath(fpath, filename string) error {
+	switch {
+	case filename == "", filename == ".", filename == "..", strings.Contains(filename, "/"):
+		return fmt.Errorf("%w %q, invalid filename", ErrorInvalidPath, filename)
+	}
+	dir := filepath.Clean(fpath)
+	if dir == ".." || strings.HasPrefix(dir, "../") {
+		return fmt.Errorf("%w %q", ErrorInvalidPath, dir)
+	}
+	if s.RejectAbsolutePaths && path.IsAbs(fpath) {
+		return fmt.Errorf("%w %q, absolute path", ErrorInvalidPath, fpath)
+	}
+	if s.RejectDotFiles {
+		for _, elem := range append(strings.Split(dir, "/"), filename) {
+			if strings.HasPrefix(elem, ".") && elem != "." {
+				return fmt.Errorf("%w %q, dot file", ErrorInvalidPath, path.Join(fpath, filename))
+			}
+		}
+	}
+	return nil
+}
+
+// Make sure no element of the target below the base directory is a symlink
+func (s *Saver) checkSymlinks(baseDir, target string) error {
+	rel, err := filepath.Rel(baseDir, target)
+	if err != nil {
+		return err
+	}
+	cur := baseDir
+	for _, elem := range strings.Split(filepath.ToSlash(rel), "/") {
+		cur = path.Join(cur, elem)
+		fi, err := s.fsys().Lstat(cur)
+		if os.IsNotExist(err) {
+			return nil // Nothing further down can exist
+		} else if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w %q, symlink in path", ErrorInvalidPath, cur)
+		}
+	}
+	return nil
+}
+
+// Is the target the base directory or below it
+func withinDir(baseDir, target string) bool {
+	rel, err := filepath.Rel(filepath.Clean(baseDir), filepath.Clean(target))
+	return err == nil && rel != ".." && !strings.HasPrefix(filepath.ToSlash(rel), "../")
 }
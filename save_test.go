@@ -0,0 +1,311 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/flowfiletest"
+)
+
+// A File of abc.txt with the checksum given, or the right one when empty
+func checksummed(t *testing.T, dat []byte, checksum string) *flowfile.File {
+	t.Helper()
+	f := flowfile.New(bytes.NewReader(dat), int64(len(dat)))
+	f.Attrs.Set("filename", "abc.txt")
+	if err := f.AddChecksum("SHA256"); err != nil {
+		t.Fatal(err)
+	}
+	if checksum != "" {
+		f.Attrs.Set("checksum", checksum)
+	}
+	return f
+}
+
+func TestSaveChecksumMismatch(t *testing.T) {
+	dat := []byte("abcdefghij")
+	bad := "0000000000000000000000000000000000000000000000000000000000000000"
+	for _, tc := range []struct {
+		name     string
+		checksum string
+		remove   bool
+		err      error
+		kept     bool
+	}{
+		{name: "verified", kept: true},
+		{name: "mismatch", checksum: bad, err: flowfile.ErrorChecksumMismatch, kept: true},
+		{name: "remove mismatched", checksum: bad, remove: true, err: flowfile.ErrorChecksumMismatch},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := flowfile.NewMemFS()
+			saver := flowfile.NewSaver("data")
+			saver.FS = fsys
+			saver.RemoveMismatched = tc.remove
+
+			out, err := saver.Save(checksummed(t, dat, tc.checksum))
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("expecting %v, got %v", tc.err, err)
+			}
+			got, rerr := fs.ReadFile(fsys, out)
+			if tc.kept && (rerr != nil || !bytes.Equal(got, dat)) {
+				t.Errorf("expecting %s left in place, got %q %v", out, got, rerr)
+			} else if !tc.kept && rerr == nil {
+				t.Errorf("expecting %s removed", out)
+			}
+
+			// No temporary file is left behind either way
+			entries, _ := fs.ReadDir(fsys, "data")
+			for _, e := range entries {
+				if e.Name() != "abc.txt" {
+					t.Errorf("unexpected file %q left in data", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestSaveQuarantine(t *testing.T) {
+	fsys := flowfile.NewMemFS()
+	saver := flowfile.NewSaver("data")
+	saver.FS = fsys
+	saver.QuarantineDir = "quarantine"
+	saver.Clock = flowfiletest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	f := checksummed(t, []byte("abcdefghij"), "00")
+	f.Attrs.Set("uuid", "1234")
+	out, err := saver.Save(f)
+	if !errors.Is(err, flowfile.ErrorChecksumMismatch) {
+		t.Fatalf("expecting ErrorChecksumMismatch, got %v", err)
+	}
+	if out != "quarantine/1234-abc.txt" {
+		t.Errorf("expecting the File in quarantine, got %q", out)
+	}
+	var rec struct{ Time time.Time }
+	if dat, err := fs.ReadFile(fsys, out+".json"); err != nil {
+		t.Errorf("expecting the quarantine record, %v", err)
+	} else if json.Unmarshal(dat, &rec); !rec.Time.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expecting the record stamped by the saver Clock, got %v", rec.Time)
+	}
+	if _, err = fs.Stat(fsys, "data/abc.txt"); err == nil {
+		t.Errorf("expecting nothing saved in data")
+	}
+}
+
+func TestSaveQuarantineUUID(t *testing.T) {
+	for uuid, want := range map[string]string{
+		"../../escaped": "quarantine/escaped-abc.txt",
+		"a/..":          "quarantine/abc.txt",
+		"..":            "quarantine/abc.txt",
+		"/":             "quarantine/abc.txt",
+	} {
+		fsys := flowfile.NewMemFS()
+		saver := flowfile.NewSaver("data")
+		saver.FS = fsys
+		saver.QuarantineDir = "quarantine"
+
+		f := checksummed(t, []byte("abcdefghij"), "00")
+		f.Attrs.Set("uuid", uuid)
+		out, err := saver.Save(f)
+		if !errors.Is(err, flowfile.ErrorChecksumMismatch) || out != want {
+			t.Errorf("uuid %q: expecting %q, got %q %v", uuid, want, out, err)
+		}
+		// Only the quarantine and data directories are made
+		entries, _ := fs.ReadDir(fsys, ".")
+		for _, e := range entries {
+			if e.Name() != "data" && e.Name() != "quarantine" {
+				t.Errorf("uuid %q: unexpected %q outside of the quarantine", uuid, e.Name())
+			}
+		}
+		if _, err = fs.Stat(fsys, want+".json"); err != nil {
+			t.Errorf("uuid %q: expecting the quarantine record, %v", uuid, err)
+		}
+	}
+}
+
+func TestSaveSymlinks(t *testing.T) {
+	link := func(name, target string) *flowfile.File {
+		f := flowfile.New(strings.NewReader(""), 0)
+		f.Attrs.Set("path", "sub/")
+		f.Attrs.Set("filename", name)
+		f.Attrs.Set("kind", "link")
+		f.Attrs.Set("target", target)
+		return f
+	}
+	isLink := func(fsys *flowfile.MemFS, name string) bool {
+		fi, err := fsys.Lstat("data/sub/" + name)
+		return err == nil && fi.Mode()&fs.ModeSymlink != 0
+	}
+
+	var logged []string
+	fsys := flowfile.NewMemFS()
+	saver := flowfile.NewSaver("data")
+	saver.FS = fsys
+	saver.Debug, saver.Logf = true, func(format string, v ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, v...))
+	}
+	for _, f := range []*flowfile.File{link("rel", "abc.txt"), link("up", "../abc.txt"),
+		link("out", "../../abc.txt"), link("abs", "/etc/passwd")} {
+		if _, err := saver.Save(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]bool{"rel": true, "up": true, "out": false, "abs": false} {
+		if isLink(fsys, name) != want {
+			t.Errorf("%s: expecting a link %v", name, want)
+		}
+	}
+	if len(logged) != 2 || !strings.HasPrefix(logged[0], "invalid relative link") ||
+		!strings.HasPrefix(logged[1], "absolute link not allowed") {
+		t.Errorf("expecting the passed over links in the saver debug output, got %q", logged)
+	}
+
+	saver.AllowAbsoluteSymlinks = true
+	if _, err := saver.Save(link("abs", "/etc/passwd")); err != nil || !isLink(fsys, "abs") {
+		t.Errorf("expecting the absolute link allowed, %v", err)
+	}
+
+	saver.Symlinks = flowfile.SymlinkSkip
+	if _, err := saver.Save(link("skip", "abc.txt")); err != nil || isLink(fsys, "skip") {
+		t.Errorf("expecting the link passed over, %v", err)
+	}
+	saver.Symlinks = flowfile.SymlinkError
+	if _, err := saver.Save(link("refuse", "abc.txt")); !errors.Is(err, flowfile.ErrorSymlink) || isLink(fsys, "refuse") {
+		t.Errorf("expecting ErrorSymlink, got %v", err)
+	}
+}
+
+func TestSavePathPolicy(t *testing.T) {
+	file := func(fpath string) *flowfile.File {
+		f := checksummed(t, []byte("abc"), "")
+		f.Attrs.Set("path", fpath)
+		return f
+	}
+	fsys := flowfile.NewMemFS()
+	saver := flowfile.NewSaver("data")
+	saver.FS = fsys
+
+	// The destination can be mapped from the attributes
+	errNoProject := errors.New("no project")
+	saver.PathMapper = func(a flowfile.Attributes) (string, error) {
+		if a.Get("project") == "" {
+			return "", errNoProject
+		}
+		return path.Join("projects", a.Get("project"), a.Get("path"), a.Get("filename")), nil
+	}
+	f := file("in/")
+	f.Attrs.Set("project", "A")
+	if out, err := saver.Save(f); err != nil || out != "data/projects/A/in/abc.txt" {
+		t.Errorf("expecting the mapped path, got %q %v", out, err)
+	}
+	if _, err := saver.Save(file("in/")); !errors.Is(err, errNoProject) {
+		t.Errorf("expecting the mapper error, got %v", err)
+	}
+	f = file("../../../../out/")
+	f.Attrs.Set("project", "A")
+	if _, err := saver.Save(f); !errors.Is(err, flowfile.ErrorInvalidPath) {
+		t.Errorf("expecting a mapped path out of the BaseDir refused, got %v", err)
+	}
+	for _, mapped := range []string{"a/../..", "a/../../", "a/../../b/c.txt", ".."} {
+		saver.PathMapper = func(flowfile.Attributes) (string, error) { return mapped, nil }
+		if _, err := saver.Save(file("in/")); !errors.Is(err, flowfile.ErrorInvalidPath) {
+			t.Errorf("%s: expecting a mapped path out of the BaseDir refused, got %v", mapped, err)
+		}
+	}
+	saver.PathMapper = nil
+
+	fsys.Symlink("/etc", "data/link")
+	for _, tc := range []struct {
+		path   string
+		policy func(bool)
+	}{
+		{"/abs/", func(on bool) { saver.RejectAbsolutePaths = on }},
+		{"in/.hidden/", func(on bool) { saver.RejectDotFiles = on }},
+		{"link/", func(on bool) { saver.RejectSymlinks = on }},
+	} {
+		tc.policy(true)
+		if _, err := saver.Save(file(tc.path)); !errors.Is(err, flowfile.ErrorInvalidPath) {
+			t.Errorf("%s: expecting ErrorInvalidPath, got %v", tc.path, err)
+		}
+		tc.policy(false)
+		if tc.path == "link/" {
+			continue // Not to be followed out of the MemFS
+		}
+		if _, err := saver.Save(file(tc.path)); err != nil {
+			t.Errorf("%s: expecting the path allowed without the policy, got %v", tc.path, err)
+		}
+	}
+	if _, err := saver.Save(file("../")); !errors.Is(err, flowfile.ErrorInvalidPath) {
+		t.Errorf("expecting a path out of the BaseDir refused, got %v", err)
+	}
+
+	// The filename must name a file within the path
+	for _, filename := range []string{"", ".", ".."} {
+		f = file("in/")
+		f.Attrs.Set("filename", filename)
+		if _, err := saver.Save(f); !errors.Is(err, flowfile.ErrorInvalidPath) {
+			t.Errorf("filename %q: expecting ErrorInvalidPath, got %v", filename, err)
+		}
+	}
+	if ents, _ := fsys.ReadDir("."); len(ents) != 1 || ents[0].Name() != "data" {
+		t.Errorf("expecting nothing written outside of the BaseDir, got %v", ents)
+	}
+}
+
+func TestSaveQuarantineSegments(t *testing.T) {
+	fsys := flowfile.NewMemFS()
+	saver := flowfile.NewSaver("data")
+	saver.FS = fsys
+	saver.Assembler = flowfile.NewAssembler()
+	saver.QuarantineDir = "quarantine"
+
+	f := flowfile.New(bytes.NewReader([]byte("abcdefghij")), 10)
+	f.Attrs.Set("filename", "abc.txt")
+	f.Attrs.Set("uuid", "1234")
+	f.AddChecksum("SHA256")
+	segs, err := flowfile.SegmentBySize(f, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, seg := range segs {
+		seg.Attrs.Set("segment.original.checksum", "00")
+		out, err := saver.Save(seg)
+		if i < len(segs)-1 {
+			// Only the whole File is verified
+			if err != nil || out != "data/abc.txt" {
+				t.Fatalf("segment %d: expecting it saved in place, got %q %v", i, out, err)
+			}
+			continue
+		}
+		if !errors.Is(err, flowfile.ErrorChecksumMismatch) {
+			t.Fatalf("expecting ErrorChecksumMismatch, got %v", err)
+		}
+		if !strings.HasPrefix(out, "quarantine/") || !strings.HasSuffix(out, "-abc.txt") {
+			t.Errorf("expecting the File in quarantine, got %q", out)
+		}
+		if got, _ := fs.ReadFile(fsys, out); string(got) != "abcdefghij" {
+			t.Errorf("expecting the whole File quarantined, got %q", got)
+		}
+		var rec struct {
+			Attrs flowfile.Attributes `json:"attributes"`
+			Error string              `json:"error"`
+			Path  string              `json:"path"`
+		}
+		dat, _ := fs.ReadFile(fsys, out+".json")
+		if err = json.Unmarshal(dat, &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Path != "data/abc.txt" || !strings.Contains(rec.Error, "checksum") || rec.Attrs.Get("segment.original.filename") != "abc.txt" {
+			t.Errorf("unexpected quarantine record %s", dat)
+		}
+	}
+	if _, err = fs.Stat(fsys, "data/abc.txt"); err == nil {
+		t.Errorf("expecting nothing left in data")
+	}
+}
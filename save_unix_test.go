@@ -0,0 +1,54 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package flowfile_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestSaveModes(t *testing.T) {
+	old := syscall.Umask(022)
+	defer syscall.Umask(old)
+
+	for _, tc := range []struct {
+		ignoreUmask, setgid bool
+		dir, file           os.FileMode
+	}{
+		{false, false, 0750, 0644},
+		{true, false, 0772, 0666},
+		{false, true, 0750 | os.ModeSetgid, 0644},
+	} {
+		saver := flowfile.NewSaver(t.TempDir())
+		saver.DirMode, saver.FileMode = 0772, 0666
+		if !tc.ignoreUmask {
+			saver.DirMode = 0750
+		}
+		saver.IgnoreUmask, saver.Setgid = tc.ignoreUmask, tc.setgid
+		f := checksummed(t, []byte("abc"), "")
+		f.Attrs.Set("path", "sub/")
+		out, err := saver.Save(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		di, err := os.Stat(filepath.Join(saver.BaseDir, "sub"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := di.Mode() & (os.ModePerm | os.ModeSetgid); got != tc.dir {
+			t.Errorf("umask ignored %v, setgid %v: expecting the directory %v, got %v", tc.ignoreUmask, tc.setgid, tc.dir, got)
+		}
+		if got := fi.Mode().Perm(); got != tc.file {
+			t.Errorf("umask ignored %v: expecting the file %v, got %v", tc.ignoreUmask, tc.file, got)
+		}
+	}
+}
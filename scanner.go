@@ -1,6 +1,8 @@
 package flowfile // import "github.com/pschou/go-flowfile"
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 )
 
@@ -11,6 +13,30 @@ type Scanner struct {
 	last  *File
 	ch    chan *File
 	every func(*File)
+
+	closer io.Closer // closed along with the Scanner, such as the end of a Pipe
+
+	// Merge the oversized attributes sent in sidecars back into the File
+	// following each, see MaxAttributeValueSize.  When not set, sidecars are
+	// handed out as Files of their own.
+	Sidecars bool
+
+	sidecar   Attributes // oversized attributes held for the next File
+	sidecarID string
+
+	// Files with content up to this size are read into memory as they are
+	// scanned, so the handler gets a File which can be Reset and, when a
+	// checksum was sent, has already been verified.  Larger Files are left to
+	// be streamed.
+	BufferThreshold int64
+
+	// Debug output for this scanner
+	DebugLog
+
+	// Hooks used by the HTTPReceiver for enforcing policy on each File
+	check func(*File) error // called before a File is handed out, an error stops the scan
+	done  func(*File) error // called after the handler is done with a File
+	end   func() error      // called when the stream ends cleanly, an error replaces the io.EOF
 }
 
 // Create a new FlowFile reader, wrapping io.Reader for reading consecutive
@@ -45,15 +71,45 @@ func NewScannerSlice(ff ...*File) *Scanner {
 func (r *Scanner) Close() (err error) {
 	if r.last != nil {
 		// Make sure last reader has been closed out
-		if err = r.last.Close(); err != nil && err != io.EOF {
+		if err = r.closeFile(r.last); err != nil && err != io.EOF && r.err == nil {
 			r.err = err
 		}
 		r.last = nil
 	}
 	r.r = nil
+	if r.closer != nil {
+		r.closer.Close()
+		r.closer = nil
+	}
 	return r.Err()
 }
 
+// Close out a File which has been handed out, calling the done hook first
+func (r *Scanner) closeFile(f *File) (err error) {
+	if r.done != nil && r.err == nil {
+		err = r.done(f)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return
+}
+
+// Run the hooks on a newly read File, returning false if it was refused
+func (r *Scanner) accept(f *File) bool {
+	f.debug = &r.DebugLog
+	if r.every != nil {
+		r.every(f)
+	}
+	if r.check != nil {
+		if err := r.check(f); err != nil {
+			r.err = err
+			return false
+		}
+	}
+	return true
+}
+
 // If a scan was not able to proceed due to an error, get the last error seen.
 func (r *Scanner) Err() error {
 	if r.err == io.EOF {
@@ -76,12 +132,15 @@ func (r *Scanner) Scan() (more bool) {
 	if r.r == nil {
 		if r.ch != nil {
 			if r.last != nil {
-				r.last.Close()
+				if err := r.closeFile(r.last); err != nil && err != io.EOF {
+					r.err, r.last = err, nil
+					return
+				}
 			}
 
 			r.last, more = <-r.ch
-			if more && r.every != nil {
-				r.every(r.last)
+			if more {
+				more = r.accept(r.last) && r.buffer(r.last)
 			}
 		}
 		return
@@ -92,7 +151,7 @@ func (r *Scanner) Scan() (more bool) {
 		var last *File
 		last, r.last = r.last, nil
 		// Make sure last reader has been closed out
-		if r.err = last.Close(); r.err == io.EOF {
+		if r.err = r.closeFile(last); r.err == io.EOF {
 			return
 		}
 	}
@@ -102,12 +161,80 @@ func (r *Scanner) Scan() (more bool) {
 		return
 	}
 
-	// Read a File from the reader
-	r.last, r.err = parseOne(r.r)
-	if r.last != nil && r.every != nil {
-		r.every(r.last)
+	// Read a File from the reader, taking in any sidecars ahead of it
+	for {
+		if r.last, r.err = parseOne(r.r); r.last == nil {
+			if r.err == io.EOF && r.end != nil {
+				if err := r.end(); err != nil {
+					r.err = err
+				}
+			}
+			if r.err != io.EOF {
+				r.debugln("Scanner error:", r.err)
+			}
+			return false
+		}
+		if !r.Sidecars || !isSidecar(r.last) {
+			break
+		}
+		if r.err = r.readSidecar(r.last); r.err != nil {
+			r.debugln("Sidecar error:", r.err)
+			r.last = nil
+			return false
+		}
+		r.debugln("Read attribute sidecar", r.last.Attrs.Get("uuid"))
+	}
+	if r.Sidecars {
+		if r.err = r.mergeSidecar(r.last); r.err != nil {
+			r.last = nil
+			return false
+		}
+	}
+	return r.accept(r.last) && r.buffer(r.last)
+}
+
+// Read a small streamed File into memory, returning false on a read error
+func (r *Scanner) buffer(f *File) bool {
+	if f.Size < 0 {
+		r.err = fmt.Errorf("%w: size of %d bytes", ErrorInvalidFile, f.Size)
+		return false
+	}
+	if f.Size == 0 || f.Size > r.BufferThreshold || f.ra != nil || f.filePath != "" || f.n != f.Size {
+		return true
+	}
+	if f.cksumStatus == cksumPreinit {
+		f.ChecksumInit()
 	}
-	return r.last != nil
+	buf := make([]byte, f.Size)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		r.err = truncated(err, ErrorTruncatedStream)
+		r.debugln("Buffer error:", r.err)
+		return false
+	}
+	if f.cksumStatus == cksumInit {
+		f.Verify() // The result is kept for the handler
+	}
+	f.r, f.ra, f.i, f.n = nil, bytes.NewReader(buf), 0, f.Size
+	return true
+}
+
+// Skip discards the content of the current File without reading it, seeking
+// past it when the underlying reader is an io.Seeker, so filtering Files by
+// their attributes is nearly free on disk backed streams.  The File is not
+// handed to the checks done after a handler, such as checksum verification.
+func (r *Scanner) Skip() (err error) {
+	if r.last == nil {
+		return nil
+	}
+	f := r.last
+	r.last = nil
+	if err = f.Close(); err == io.EOF {
+		err = nil
+	}
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return
 }
 
 // File returns the most recent token generated by a call to Scan.
@@ -0,0 +1,179 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestScanNegativeSize(t *testing.T) {
+	// A MultiReader hides the ReaderAt so the Scanner buffers the content
+	f := flowfile.New(io.MultiReader(strings.NewReader("abc")), -1)
+	s := flowfile.NewScannerSlice(f)
+	s.BufferThreshold = 1 << 20
+	if s.Scan() {
+		t.Fatal("scanned a File with a negative size")
+	}
+	if err := s.Err(); !errors.Is(err, flowfile.ErrorInvalidFile) {
+		t.Errorf("expecting ErrorInvalidFile, got %v", err)
+	}
+}
+
+// A seekable stream counting the bytes read through Read
+type countingReader struct {
+	*bytes.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestScanSkip(t *testing.T) {
+	var buf bytes.Buffer
+	ff := stringFiles(strings.Repeat("x", 1<<20), "abc")
+	ff[0].Attrs.Set("filename", "big.txt")
+	flowfile.SendFiles(&buf, ff, nil)
+
+	seekable := &countingReader{Reader: bytes.NewReader(buf.Bytes())}
+	for _, in := range []io.Reader{seekable, io.MultiReader(bytes.NewReader(buf.Bytes()))} {
+		s := flowfile.NewScanner(in)
+		if !s.Scan() {
+			t.Fatal(s.Err())
+		}
+		if err := s.Skip(); err != nil {
+			t.Fatal(err)
+		}
+		if !s.Scan() {
+			t.Fatal(s.Err())
+		}
+		if dat, _ := io.ReadAll(s.File()); string(dat) != "abc" {
+			t.Errorf("%T: expecting the File after the skipped one, got %q", in, dat)
+		}
+		if s.Scan() || s.Err() != nil {
+			t.Errorf("%T: expecting the end of the stream, got %v", in, s.Err())
+		}
+	}
+	if seekable.read >= 1<<20 {
+		t.Errorf("expecting the skipped content seeked past, read %d bytes", seekable.read)
+	}
+}
+
+func TestScanBufferThreshold(t *testing.T) {
+	ff := stringFiles("abc", "0123456789abcdef")
+	ff[0].AddChecksum("SHA256")
+	var buf bytes.Buffer
+	flowfile.SendFiles(&buf, ff, nil)
+	dat := buf.Bytes()
+
+	// A MultiReader hides the ReaderAt so the content is streamed
+	s := flowfile.NewScanner(io.MultiReader(bytes.NewReader(dat)))
+	s.BufferThreshold = 10
+	if !s.Scan() {
+		t.Fatal(s.Err())
+	}
+	small := s.File()
+	if err := small.Verify(); err != nil {
+		t.Errorf("expecting the buffered File already verified, got %v", err)
+	}
+	io.ReadAll(small)
+	if err := small.Reset(); err != nil {
+		t.Fatalf("expecting the buffered File to Reset, got %v", err)
+	}
+	if got, _ := io.ReadAll(small); string(got) != "abc" {
+		t.Errorf("expecting the content again, got %q", got)
+	}
+
+	if !s.Scan() {
+		t.Fatal(s.Err())
+	}
+	large := s.File()
+	io.ReadFull(large, make([]byte, 4))
+	if err := large.Reset(); !errors.Is(err, flowfile.ErrorNotResettable) {
+		t.Errorf("expecting the File over the threshold streamed, got %v", err)
+	}
+
+	// A buffered File cut short stops the scan
+	s = flowfile.NewScanner(io.MultiReader(bytes.NewReader(dat[:len(dat)-3])))
+	s.BufferThreshold = 1 << 20
+	for s.Scan() {
+	}
+	if err := s.Err(); !errors.Is(err, flowfile.ErrorTruncatedStream) {
+		t.Errorf("expecting ErrorTruncatedStream, got %v", err)
+	}
+}
+
+// A stream failing part way with a transport error
+type brokenReader struct {
+	io.Reader
+	err error
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		err = b.err
+	}
+	return n, err
+}
+
+func TestScanTruncatedContent(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := flowfile.NewWriter(&buf).Write(stringFiles("abcdefgh")[0]); err != nil {
+		t.Fatal(err)
+	}
+	whole := buf.Bytes()
+
+	// The content ends before the stated size
+	s := flowfile.NewScanner(io.MultiReader(bytes.NewReader(whole[:len(whole)-3])))
+	if !s.Scan() {
+		t.Fatalf("expecting the header scanned, got %v", s.Err())
+	}
+	if _, err := io.ReadAll(s.File()); !errors.Is(err, flowfile.ErrorTruncatedStream) {
+		t.Errorf("expecting ErrorTruncatedStream reading, got %v", err)
+	}
+	s = flowfile.NewScanner(io.MultiReader(bytes.NewReader(whole[:len(whole)-3])))
+	s.Scan()
+	if err := s.File().Close(); !errors.Is(err, flowfile.ErrorTruncatedStream) {
+		t.Errorf("expecting ErrorTruncatedStream skipping, got %v", err)
+	}
+
+	// A failure of the transport is passed through as is
+	errReset := errors.New("connection reset")
+	s = flowfile.NewScanner(&brokenReader{Reader: bytes.NewReader(whole[:20]), err: errReset})
+	if s.Scan() || !errors.Is(s.Err(), errReset) || errors.Is(s.Err(), flowfile.ErrorShortAttribute) {
+		t.Errorf("expecting the transport error, got %v", s.Err())
+	}
+}
+
+func TestScanDebugLog(t *testing.T) {
+	ff := stringFiles("abc")
+	ff[0].AddChecksum("SHA256")
+	ff[0].Attrs.Set("checksum", "00")
+	var buf bytes.Buffer
+	flowfile.SendFiles(&buf, ff, nil)
+
+	// The output of the Files goes to the Scanner which read them
+	var logged []string
+	s := flowfile.NewScanner(&buf)
+	s.Debug = true
+	s.Logf = func(format string, v ...interface{}) { logged = append(logged, fmt.Sprintf(format, v...)) }
+	if !s.Scan() {
+		t.Fatal(s.Err())
+	}
+	f := s.File()
+	io.Copy(io.Discard, f)
+	if err := f.Verify(); !errors.Is(err, flowfile.ErrorChecksumMismatch) {
+		t.Fatalf("expecting a checksum mismatch, got %v", err)
+	}
+	if len(logged) != 2 || logged[0] != "Checksum init for abc.txt" || !strings.HasPrefix(logged[1], "checksum:") {
+		t.Errorf("expecting the checksum init and mismatch logged, got %q", logged)
+	}
+}
@@ -0,0 +1,230 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"context"
+	"sync"
+)
+
+// A Scheduler queues Files for one destination in named queues and feeds them
+// to a bounded number of concurrent POSTs, so bulk traffic cannot starve
+// interactive or alert traffic sharing the destination.  Queues with a higher
+// priority are always served first, and queues of the same priority share the
+// POSTs in proportion to their weights.
+//
+//	sched := flowfile.NewScheduler(hs, 4)
+//	sched.AddQueue("alerts", 10, 1)
+//	sched.AddQueue("interactive", 0, 4)
+//	sched.AddQueue("bulk", 0, 1)
+//	go sched.Run(ctx)
+//	sched.Enqueue("bulk", ff...)
+//	...
+//	sched.Close() // Run returns once the queues are drained
+type Scheduler struct {
+	// Most Files sent in one POST, the POST is made with what is queued when
+	// fewer are waiting.
+	BatchSize int
+
+	// Called with each File once the POST carrying it is done, err is nil
+	// when it was delivered.
+	OnResult func(f *File, err error)
+
+	hs      *HTTPTransaction
+	workers int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queues map[string]*schedQueue
+	order  []*schedQueue
+	closed bool
+}
+
+type schedQueue struct {
+	name             string
+	priority, weight int
+	current          int // for the smooth weighted round robin
+	files            []*File
+}
+
+// NewScheduler creates a Scheduler sending through the transaction with up to
+// workers POSTs at once.
+func NewScheduler(hs *HTTPTransaction, workers int) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	s := &Scheduler{
+		BatchSize: 100,
+		hs:        hs,
+		workers:   workers,
+		queues:    make(map[string]*schedQueue),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// AddQueue adds a queue, or updates the priority and weight of an existing
+// one.  Queues which are not added have a priority of 0 and a weight of 1.
+func (s *Scheduler) AddQueue(name string, priority, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.queue(name)
+	q.priority, q.weight = priority, weight
+}
+
+// Find or create a queue, must hold the lock
+func (s *Scheduler) queue(name string) *schedQueue {
+	q, ok := s.queues[name]
+	if !ok {
+		q = &schedQueue{name: name, weight: 1}
+		s.queues[name] = q
+		s.order = append(s.order, q)
+	}
+	return q
+}
+
+// Enqueue adds Files to the end of the named queue.  The Files must be
+// resettable if the transaction retries, see Send.
+func (s *Scheduler) Enqueue(name string, ff ...*File) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrorSchedulerClosed
+	}
+	q := s.queue(name)
+	q.files = append(q.files, ff...)
+	s.cond.Broadcast()
+	return nil
+}
+
+// Len returns the number of Files waiting in the named queue.
+func (s *Scheduler) Len(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.queues[name]; ok {
+		return len(q.files)
+	}
+	return 0
+}
+
+// Close stops new Files from being queued, Run returns once the Files already
+// queued have been sent.
+func (s *Scheduler) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrorSchedulerClosed
+	}
+	s.closed = true
+	s.cond.Broadcast()
+	return nil
+}
+
+// Run sends the queued Files until the Scheduler is closed and drained, or the
+// context is done.  Files left queued when the context is done are reported
+// to OnResult with the context error.
+func (s *Scheduler) Run(ctx context.Context) error {
+	// Wake the waiting workers when the context is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		case <-done:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				batch := s.next(ctx)
+				if batch == nil {
+					return
+				}
+				err := s.hs.SendContext(ctx, batch...)
+				if s.OnResult != nil {
+					for _, f := range batch {
+						s.OnResult(f, err)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		s.mu.Lock()
+		var left []*File
+		for _, q := range s.order {
+			left, q.files = append(left, q.files...), nil
+		}
+		s.mu.Unlock()
+		if s.OnResult != nil {
+			for _, f := range left {
+				s.OnResult(f, err)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Wait for Files and take the next batch, nil when there is nothing more to
+// send
+func (s *Scheduler) next(ctx context.Context) (batch []*File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		for len(batch) < s.BatchSize || s.BatchSize <= 0 && len(batch) == 0 {
+			q := s.pick()
+			if q == nil {
+				break
+			}
+			batch = append(batch, q.files[0])
+			q.files[0] = nil
+			q.files = q.files[1:]
+		}
+		if len(batch) > 0 || s.closed {
+			return
+		}
+		s.cond.Wait()
+	}
+}
+
+// Pick the queue to take the next File from, the highest priority queues with
+// Files waiting are chosen between by smooth weighted round robin.  Must hold
+// the lock.
+func (s *Scheduler) pick() (best *schedQueue) {
+	top, total := 0, 0
+	for _, q := range s.order {
+		if len(q.files) > 0 && (total == 0 || q.priority > top) {
+			top, total = q.priority, 1
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	total = 0
+	for _, q := range s.order {
+		if len(q.files) == 0 || q.priority != top {
+			continue
+		}
+		q.current += q.weight
+		total += q.weight
+		if best == nil || q.current > best.current {
+			best = q
+		}
+	}
+	best.current -= total
+	return
+}
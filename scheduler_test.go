@@ -0,0 +1,83 @@
+package flowfile_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestSchedulerOrder(t *testing.T) {
+	var got []string
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		got = append(got, f.Attrs.Get("filename"))
+		return nil
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sched := flowfile.NewScheduler(hs, 1)
+	sched.BatchSize = 1
+	sched.AddQueue("alerts", 10, 1)
+	sched.AddQueue("interactive", 0, 3)
+	sched.AddQueue("bulk", 0, 1)
+	sched.Enqueue("bulk", stringFiles("b", "b")...)
+	sched.Enqueue("interactive", stringFiles("i", "i", "i", "i", "i", "i")...)
+	sched.Enqueue("alerts", stringFiles("a", "a")...)
+	var delivered int
+	sched.OnResult = func(f *flowfile.File, err error) {
+		if err == nil {
+			delivered++
+		}
+	}
+	sched.Close()
+	if err = sched.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The alerts first, then interactive and bulk shared three to one
+	order := strings.ReplaceAll(strings.Join(got, ""), ".txt", "")
+	if order != "aaiibiiibi" {
+		t.Errorf("sent in the order %s", order)
+	}
+	if delivered != 10 {
+		t.Errorf("expecting 10 delivered, got %d", delivered)
+	}
+	if err = sched.Enqueue("bulk", stringFiles("b")...); !errors.Is(err, flowfile.ErrorSchedulerClosed) {
+		t.Errorf("expecting ErrorSchedulerClosed, got %v", err)
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	_, ts := newTestReceiver(t)
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched := flowfile.NewScheduler(hs, 2)
+	sched.Enqueue("bulk", stringFiles("a", "b", "c")...)
+
+	// The Files left queued are handed back with the context error
+	var left int
+	sched.OnResult = func(f *flowfile.File, err error) {
+		if errors.Is(err, context.Canceled) {
+			left++
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = sched.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expecting context.Canceled, got %v", err)
+	}
+	if left != 3 || sched.Len("bulk") != 0 {
+		t.Errorf("expecting 3 Files handed back, got %d with %d queued", left, sched.Len("bulk"))
+	}
+}
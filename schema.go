@@ -0,0 +1,236 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/relvacode/iso8601"
+)
+
+// An AttributeSchema validates Attributes against a subset of JSON Schema, so
+// a metadata contract can be enforced when sending and receiving.  As all the
+// attribute values are strings, the type keyword describes what the string
+// must parse as.  The supported keywords are:
+//
+//   Top level: properties, patternProperties, required, additionalProperties
+//   Per attribute: type (string, integer, number, boolean), enum, const,
+//     pattern, minLength, maxLength, minimum, maximum, format (date-time, uuid)
+//
+// Other keywords, such as $schema, title and description, are ignored.
+type AttributeSchema struct {
+	Properties           map[string]*AttributeRule `json:"properties,omitempty"`
+	PatternProperties    map[string]*AttributeRule `json:"patternProperties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties *bool                     `json:"additionalProperties,omitempty"`
+
+	patterns []patternRule
+}
+
+// An AttributeRule is the schema for the value of one attribute.
+type AttributeRule struct {
+	Type      string   `json:"type,omitempty"`
+	Enum      []string `json:"enum,omitempty"`
+	Const     *string  `json:"const,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	MinLength *int     `json:"minLength,omitempty"`
+	MaxLength *int     `json:"maxLength,omitempty"`
+	Minimum   *float64 `json:"minimum,omitempty"`
+	Maximum   *float64 `json:"maximum,omitempty"`
+	Format    string   `json:"format,omitempty"`
+
+	re *regexp.Regexp
+}
+
+type patternRule struct {
+	re   *regexp.Regexp
+	rule *AttributeRule
+}
+
+// A SchemaViolation describes one way in which Attributes broke the schema.
+type SchemaViolation struct {
+	Attribute string `json:"attribute"`
+	Keyword   string `json:"keyword"` // the schema keyword which failed
+	Message   string `json:"message"`
+}
+
+var ErrorSchemaViolation = errors.New("Attributes do not match the schema")
+
+// A SchemaError is returned when Attributes fail validation, with the list of
+// violations.
+type SchemaError struct {
+	Violations []SchemaViolation `json:"violations"`
+}
+
+func (e *SchemaError) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		parts[i] = fmt.Sprintf("%q %s", v.Attribute, v.Message)
+	}
+	return fmt.Sprintf("%s: %s", ErrorSchemaViolation, strings.Join(parts, ", "))
+}
+
+func (e *SchemaError) Unwrap() error { return ErrorSchemaViolation }
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Parse a JSON Schema document into an AttributeSchema.
+func ParseAttributeSchema(dat []byte) (*AttributeSchema, error) {
+	s := &AttributeSchema{}
+	if err := json.Unmarshal(dat, s); err != nil {
+		return nil, err
+	}
+	if err := s.Compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Compile prepares the patterns of a schema built in code, this is done by
+// ParseAttributeSchema and must be called before Validate otherwise.
+func (s *AttributeSchema) Compile() (err error) {
+	for name, rule := range s.Properties {
+		if err = rule.compile(); err != nil {
+			return fmt.Errorf("Attribute %q: %w", name, err)
+		}
+	}
+	s.patterns = nil
+	for pattern, rule := range s.PatternProperties {
+		pr := patternRule{rule: rule}
+		if pr.re, err = regexp.Compile(pattern); err != nil {
+			return err
+		}
+		if err = rule.compile(); err != nil {
+			return fmt.Errorf("Pattern %q: %w", pattern, err)
+		}
+		s.patterns = append(s.patterns, pr)
+	}
+	sort.Slice(s.patterns, func(i, j int) bool { return s.patterns[i].re.String() < s.patterns[j].re.String() })
+	return nil
+}
+
+func (r *AttributeRule) compile() (err error) {
+	switch r.Type {
+	case "", "string", "integer", "number", "boolean":
+	default:
+		return fmt.Errorf("Unsupported type %q", r.Type)
+	}
+	switch r.Format {
+	case "", "date-time", "uuid":
+	default:
+		return fmt.Errorf("Unsupported format %q", r.Format)
+	}
+	if r.Pattern != "" {
+		r.re, err = regexp.Compile(r.Pattern)
+	}
+	return
+}
+
+// Validate the Attributes against the schema, returning nil when they match.
+func (s *AttributeSchema) Validate(h Attributes) (violations []SchemaViolation) {
+	add := func(name, keyword, format string, args ...interface{}) {
+		violations = append(violations, SchemaViolation{
+			Attribute: name, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+	for _, name := range s.Required {
+		if _, ok := h.lookup(name); !ok {
+			add(name, "required", "is required")
+		}
+	}
+	for _, a := range h {
+		matched := false
+		if rule, ok := s.Properties[a.Name]; ok {
+			matched = true
+			rule.validate(a, add)
+		}
+		for _, pr := range s.patterns {
+			if pr.re.MatchString(a.Name) {
+				matched = true
+				pr.rule.validate(a, add)
+			}
+		}
+		if !matched && s.AdditionalProperties != nil && !*s.AdditionalProperties {
+			add(a.Name, "additionalProperties", "is not allowed")
+		}
+	}
+	return
+}
+
+// Validate the Attributes and return a SchemaError when they do not match.
+func (s *AttributeSchema) Check(h Attributes) error {
+	if s == nil {
+		return nil
+	}
+	if v := s.Validate(h); len(v) > 0 {
+		return &SchemaError{Violations: v}
+	}
+	return nil
+}
+
+func (r *AttributeRule) validate(a Attribute, add func(name, keyword, format string, args ...interface{})) {
+	v := a.Value
+	var num float64
+	var isNum bool
+	switch r.Type {
+	case "integer":
+		if i, err := strconv.ParseInt(v, 10, 64); err != nil {
+			add(a.Name, "type", "must be an integer")
+		} else {
+			num, isNum = float64(i), true
+		}
+	case "number":
+		if f, err := strconv.ParseFloat(v, 64); err != nil {
+			add(a.Name, "type", "must be a number")
+		} else {
+			num, isNum = f, true
+		}
+	case "boolean":
+		if v != "true" && v != "false" {
+			add(a.Name, "type", "must be true or false")
+		}
+	}
+	if len(r.Enum) > 0 {
+		found := false
+		for _, e := range r.Enum {
+			if e == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add(a.Name, "enum", "must be one of %q", r.Enum)
+		}
+	}
+	if r.Const != nil && *r.Const != v {
+		add(a.Name, "const", "must be %q", *r.Const)
+	}
+	if r.re != nil && !r.re.MatchString(v) {
+		add(a.Name, "pattern", "does not match %q", r.Pattern)
+	}
+	if n := utf8.RuneCountInString(v); r.MinLength != nil && n < *r.MinLength {
+		add(a.Name, "minLength", "is shorter than %d", *r.MinLength)
+	} else if r.MaxLength != nil && n > *r.MaxLength {
+		add(a.Name, "maxLength", "is longer than %d", *r.MaxLength)
+	}
+	if isNum && r.Minimum != nil && num < *r.Minimum {
+		add(a.Name, "minimum", "is less than %v", *r.Minimum)
+	}
+	if isNum && r.Maximum != nil && num > *r.Maximum {
+		add(a.Name, "maximum", "is greater than %v", *r.Maximum)
+	}
+	switch r.Format {
+	case "date-time":
+		if _, err := iso8601.ParseString(v); err != nil {
+			add(a.Name, "format", "must be a date-time")
+		}
+	case "uuid":
+		if !uuidPattern.MatchString(v) {
+			add(a.Name, "format", "must be a uuid")
+		}
+	}
+}
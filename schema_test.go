@@ -0,0 +1,82 @@
+package flowfile_test
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+const testSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "required": ["filename", "classification"],
+  "properties": {
+    "filename": {"type": "string", "maxLength": 16},
+    "classification": {"enum": ["U", "C"]},
+    "priority": {"type": "integer", "minimum": 1, "maximum": 5},
+    "uuid": {"format": "uuid"},
+    "created": {"format": "date-time"}
+  },
+  "patternProperties": {"^custodyChain\\.": {"type": "string"}},
+  "additionalProperties": false
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	s, err := flowfile.ParseAttributeSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var good flowfile.Attributes
+	good.Set("filename", "abc.txt")
+	good.Set("classification", "U")
+	good.Set("priority", "3")
+	good.Set("uuid", "6d1c1f52-8c0a-4bde-9a6f-2b5a3f0e7c41")
+	good.Set("created", "2024-01-02T03:04:05Z")
+	good.Set("custodyChain.0.host", "relay")
+	if v := s.Validate(good); len(v) != 0 {
+		t.Errorf("expecting no violations, got %+v", v)
+	}
+
+	var bad flowfile.Attributes
+	bad.Set("filename", "a-very-long-filename.txt")
+	bad.Set("priority", "9")
+	bad.Set("uuid", "not-a-uuid")
+	bad.Set("created", "yesterday")
+	bad.Set("extra", "1")
+	var got []string
+	for _, v := range s.Validate(bad) {
+		got = append(got, v.Attribute+":"+v.Keyword)
+	}
+	sort.Strings(got)
+	want := "classification:required,created:format,extra:additionalProperties,filename:maxLength,priority:maximum,uuid:format"
+	if strings.Join(got, ",") != want {
+		t.Errorf("expecting the violations\n%s\ngot\n%s", want, strings.Join(got, ","))
+	}
+	if err = s.Check(bad); !errors.Is(err, flowfile.ErrorSchemaViolation) {
+		t.Errorf("expecting ErrorSchemaViolation, got %v", err)
+	}
+
+	for _, unsupported := range []string{`{"properties":{"a":{"type":"array"}}}`, `{"properties":{"a":{"format":"email"}}}`} {
+		if _, err = flowfile.ParseAttributeSchema([]byte(unsupported)); err == nil {
+			t.Errorf("expecting an error for %s", unsupported)
+		}
+	}
+}
+
+func TestSchemaReceiver(t *testing.T) {
+	rcv, ts := newReadingReceiver(t)
+	s, err := flowfile.ParseAttributeSchema([]byte(`{"required":["classification"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rcv.Schema = s
+	ff := stringFiles("a", "b")
+	ff[0].Attrs.Set("classification", "U")
+	if res := postRaw(t, ts.URL, ff[0]); res.StatusCode != http.StatusOK {
+		t.Errorf("expecting a 200 for valid attributes, got %d", res.StatusCode)
+	}
+	expectReject(t, postRaw(t, ts.URL, ff[1]), http.StatusNotAcceptable, "schema")
+}
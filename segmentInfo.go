@@ -0,0 +1,75 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var (
+	ErrorNotSegment     = errors.New("File is not a segment")
+	ErrorInvalidSegment = errors.New("Invalid segment attributes")
+)
+
+// SegmentInfo describes where a segment made by Segment or SegmentBySize fits
+// within the original File.
+type SegmentInfo struct {
+	Identifier string // fragment.identifier, shared by all segments of a File
+	Index      int    // fragment.index, starting at 1
+	Count      int    // fragment.count
+	Offset     int64  // fragment.offset, where this segment starts
+
+	OriginalSize         int64 // segment.original.size
+	OriginalFilename     string
+	OriginalChecksumType string
+	OriginalChecksum     string
+}
+
+// SegmentInfo parses and validates the fragment and segment attributes of a
+// File.  ErrorNotSegment is returned if the File is not a segment, and
+// ErrorInvalidSegment if the attributes are missing, malformed, or do not
+// agree with each other.
+func (f *File) SegmentInfo() (*SegmentInfo, error) {
+	sz, ok := f.Attrs.lookup("segment.original.size")
+	if !ok {
+		return nil, ErrorNotSegment
+	}
+	s := &SegmentInfo{
+		Identifier:           f.Attrs.Get("fragment.identifier"),
+		OriginalFilename:     f.Attrs.Get("segment.original.filename"),
+		OriginalChecksumType: f.Attrs.Get("segment.original.checksumType"),
+		OriginalChecksum:     f.Attrs.Get("segment.original.checksum"),
+	}
+	var err error
+	parse := func(name string, set func(int64)) {
+		if err != nil {
+			return
+		}
+		var v int64
+		if v, err = strconv.ParseInt(f.Attrs.Get(name), 10, 64); err != nil {
+			err = fmt.Errorf("%w: %s %q", ErrorInvalidSegment, name, f.Attrs.Get(name))
+			return
+		}
+		set(v)
+	}
+	s.OriginalSize, err = strconv.ParseInt(sz, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: segment.original.size %q", ErrorInvalidSegment, sz)
+	}
+	parse("fragment.offset", func(v int64) { s.Offset = v })
+	parse("fragment.index", func(v int64) { s.Index = int(v) })
+	parse("fragment.count", func(v int64) { s.Count = int(v) })
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case s.Identifier == "":
+		return nil, fmt.Errorf("%w: missing fragment.identifier", ErrorInvalidSegment)
+	case s.OriginalSize < 0, s.Offset < 0, s.Offset > s.OriginalSize, f.Size > s.OriginalSize-s.Offset:
+		return nil, fmt.Errorf("%w: %d bytes at offset %d outside of size %d", ErrorInvalidSegment, f.Size, s.Offset, s.OriginalSize)
+	case s.Count < 1, s.Index < 1, s.Index > s.Count:
+		return nil, fmt.Errorf("%w: index %d of %d", ErrorInvalidSegment, s.Index, s.Count)
+	}
+	return s, nil
+}
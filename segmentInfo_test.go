@@ -0,0 +1,61 @@
+package flowfile_test
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestSegmentInfo(t *testing.T) {
+	dat := []byte("abcdefghij")
+	segs := segments(t, dat, 4)
+	for i, seg := range segs {
+		info, err := seg.SegmentInfo()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Index != i+1 || info.Count != 3 || info.Offset != int64(i*4) || info.OriginalSize != 10 ||
+			info.Identifier == "" || info.OriginalFilename != "abc.txt" || info.OriginalChecksumType != "SHA256" {
+			t.Errorf("segment %d: unexpected info %+v", i, info)
+		}
+	}
+
+	if _, err := stringFiles("abc")[0].SegmentInfo(); err != flowfile.ErrorNotSegment {
+		t.Errorf("expecting ErrorNotSegment, got %v", err)
+	}
+	for name, value := range map[string]string{
+		"segment.original.size": "ten",
+		"fragment.offset":       "",
+		"fragment.index":        "4",
+		"fragment.count":        "0",
+		"fragment.identifier":   "",
+	} {
+		seg := flowfile.New(nil, segs[2].Size)
+		seg.Attrs = segs[2].Attrs.Clone()
+		seg.Attrs.Set(name, value)
+		if _, err := seg.SegmentInfo(); !errors.Is(err, flowfile.ErrorInvalidSegment) {
+			t.Errorf("%s of %q: expecting ErrorInvalidSegment, got %v", name, value, err)
+		}
+	}
+
+	// The segment must fit within the original
+	seg := flowfile.New(nil, segs[2].Size+1)
+	seg.Attrs = segs[2].Attrs.Clone()
+	if _, err := seg.SegmentInfo(); !errors.Is(err, flowfile.ErrorInvalidSegment) {
+		t.Errorf("expecting a segment past the original size refused, got %v", err)
+	}
+
+	// Without overflowing on the largest offsets
+	for _, offset := range []int64{math.MaxInt64, math.MaxInt64 - 1} {
+		seg = flowfile.New(nil, segs[2].Size)
+		seg.Attrs = segs[2].Attrs.Clone()
+		seg.Attrs.Set("segment.original.size", strconv.FormatInt(math.MaxInt64, 10))
+		seg.Attrs.Set("fragment.offset", strconv.FormatInt(offset, 10))
+		if _, err := seg.SegmentInfo(); !errors.Is(err, flowfile.ErrorInvalidSegment) {
+			t.Errorf("offset %d: expecting a segment past the original size refused, got %v", offset, err)
+		}
+	}
+}
@@ -2,7 +2,7 @@ package flowfile // import "github.com/pschou/go-flowfile"
 
 import (
 	"fmt"
-	"os"
+	"io"
 )
 
 // Splits up a flowfile into count number of segments.  The intended purpose
@@ -22,8 +22,27 @@ func Segment(in *File, count int64) (out []*File, err error) {
 // avoid having to replay sending a whole file in case a connection gets
 // dropped.
 func SegmentBySize(in *File, segmentSize int64) (out []*File, err error) {
+	return SegmentBySizeProfile(in, segmentSize, SegmentProfileDefault)
+}
+
+// A SegmentProfile selects the attributes placed on the segments.
+type SegmentProfile int
+
+const (
+	// The fragment and segment.original attributes used by this library, along
+	// with a merge.reason.
+	SegmentProfileDefault SegmentProfile = iota
+
+	// Exactly the attributes which the NiFi MergeContent Defragment strategy
+	// expects, as set by the NiFi SegmentContent processor, so the segments can
+	// be reassembled by a stock NiFi flow.
+	SegmentProfileNiFi
+)
+
+// Like SegmentBySize, but with the attributes set by the given profile.
+func SegmentBySizeProfile(in *File, segmentSize int64, profile SegmentProfile) (out []*File, err error) {
 	if in.ra == nil && in.filePath == "" {
-		return nil, fmt.Errorf("Must have a reader with ReadAt capabilities to segment")
+		return nil, fmt.Errorf("%w to segment", ErrorNeedReadAt)
 	}
 
 	size := in.Size
@@ -60,7 +79,7 @@ func SegmentBySize(in *File, segmentSize int64) (out []*File, err error) {
 
 		if in.fileAutoOpen { // Make sure the file is closed if auto opened
 			in.fileAutoOpen = false
-			fh := in.ra.(*os.File)
+			fh := in.ra.(io.Closer)
 			in.ra = nil
 			fh.Close()
 		}
@@ -68,6 +87,7 @@ func SegmentBySize(in *File, segmentSize int64) (out []*File, err error) {
 		f := &File{
 			ra:       in.ra,
 			filePath: in.filePath,
+			fsys:     in.fsys,
 			i:        st,
 			Size:     en - st,
 			n:        en - st,
@@ -77,6 +97,13 @@ func SegmentBySize(in *File, segmentSize int64) (out []*File, err error) {
 		f.Attrs.Set("fragment.offset", fmt.Sprintf("%d", st))
 		f.Attrs.Set("fragment.index", fmt.Sprintf("%d", i+1))
 		f.Attrs.Set("fragment.count", fmt.Sprintf("%d", count))
+		if profile == SegmentProfileNiFi {
+			// NiFi sets the merge.reason on the merge output, not the input
+			f.Attrs.Unset("merge.reason")
+			f.Attrs.Set("segment.identifier", f.Attrs.Get("fragment.identifier"))
+			f.Attrs.Set("segment.index", f.Attrs.Get("fragment.index"))
+			f.Attrs.Set("segment.count", f.Attrs.Get("fragment.count"))
+		}
 		f.Attrs.GenerateUUID()
 		out = append(out, f)
 	}
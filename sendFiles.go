@@ -0,0 +1,75 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// SendOptions control how SendFiles writes Files to a stream, a nil
+// SendOptions writes unbuffered with no marker or callbacks.
+type SendOptions struct {
+	// Buffer the stream with this many bytes, flushed once the Files are
+	// written or on the first error, zero writes directly to the stream.
+	BufferSize int
+
+	// Write the NiFiEOF marker after the last File, so the reader stops at the
+	// marker rather than needing the stream to be closed.
+	EOFMarker bool
+
+	// Fail with io.ErrShortWrite when less content was written than the size
+	// given in the header, such as for a File which was partially read before,
+	// rather than leaving a corrupt stream behind.
+	ErrorOnShortWrite bool
+
+	// Called after each File is written with the bytes written, header
+	// included, and any error.
+	OnFile func(f *File, n int64, err error)
+}
+
+// SendFiles writes the Files to out in the flowfile-v3 format, for building
+// transports other than HTTP, returning the bytes written for each File.  On
+// an error the counts are given for the Files written so far, including the
+// one which failed.
+//
+//   counts, err := flowfile.SendFiles(conn, ff, &flowfile.SendOptions{
+//     BufferSize: 64 << 10,
+//     EOFMarker:  true,
+//   })
+func SendFiles(out io.Writer, ff []*File, opts *SendOptions) (counts []int64, err error) {
+	if opts == nil {
+		opts = &SendOptions{}
+	}
+	var bw *bufio.Writer
+	if opts.BufferSize > 0 {
+		bw = bufio.NewWriterSize(out, opts.BufferSize)
+		out = bw
+		defer func() {
+			if ferr := bw.Flush(); err == nil {
+				err = ferr
+			}
+		}()
+	}
+
+	w := NewWriter(out)
+	counts = make([]int64, 0, len(ff))
+	for _, f := range ff {
+		remaining := f.n
+		var n int64
+		n, err = w.Write(f)
+		if written := remaining - f.n; err == nil && opts.ErrorOnShortWrite && written != f.Size {
+			err = fmt.Errorf("%w, %d of %d bytes of content", io.ErrShortWrite, written, f.Size)
+		}
+		counts = append(counts, n)
+		if opts.OnFile != nil {
+			opts.OnFile(f, n, err)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if opts.EOFMarker {
+		_, err = io.WriteString(out, FlowFileEOF)
+	}
+	return
+}
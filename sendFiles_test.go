@@ -0,0 +1,60 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestSendFiles(t *testing.T) {
+	var buf bytes.Buffer
+	var seen []string
+	counts, err := flowfile.SendFiles(&buf, stringFiles("abc", "defgh"), &flowfile.SendOptions{
+		BufferSize: 16,
+		EOFMarker:  true,
+		OnFile: func(f *flowfile.File, n int64, err error) {
+			seen = append(seen, f.Attrs.Get("filename"))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 2 || counts[0]+counts[1]+int64(len(flowfile.FlowFileEOF)) != int64(buf.Len()) {
+		t.Errorf("expecting the counts to add up to %d bytes, got %v", buf.Len(), counts)
+	}
+	if strings.Join(seen, ",") != "abc.txt,defgh.txt" {
+		t.Errorf("expecting OnFile for each File, got %q", seen)
+	}
+	if !bytes.HasSuffix(buf.Bytes(), []byte(flowfile.FlowFileEOF)) {
+		t.Errorf("expecting the stream to end with the EOF marker")
+	}
+
+	// The marker stops the Scanner ahead of whatever follows
+	buf.WriteString("trailing")
+	var got []string
+	s := flowfile.NewScanner(&buf)
+	for s.Scan() {
+		dat, _ := io.ReadAll(s.File())
+		got = append(got, string(dat))
+	}
+	if err = s.Err(); err != nil || strings.Join(got, ",") != "abc,defgh" {
+		t.Errorf("unexpected Files read back, %q %v", got, err)
+	}
+}
+
+func TestSendFilesShortWrite(t *testing.T) {
+	ff := stringFiles("abc", "defgh")
+	io.ReadFull(ff[1], make([]byte, 2)) // Partially read before
+
+	counts, err := flowfile.SendFiles(io.Discard, ff, &flowfile.SendOptions{ErrorOnShortWrite: true})
+	if !errors.Is(err, io.ErrShortWrite) || len(counts) != 2 {
+		t.Errorf("expecting io.ErrShortWrite on the second File, got %v after %d", err, len(counts))
+	}
+	if _, err = flowfile.SendFiles(io.Discard, stringFiles("abc"), nil); err != nil {
+		t.Errorf("expecting no error without options, got %v", err)
+	}
+}
@@ -2,15 +2,17 @@ package flowfile // import "github.com/pschou/go-flowfile"
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,24 +22,128 @@ import (
 // endpoint is listening and compatible with the current flow file format.
 type HTTPTransaction struct {
 	url           string
-	Server        string
+	Server        string // Server header of the remote, from the handshake
 	TransactionID string
 	lastSend      time.Time
 
+	// User-Agent header sent in requests, UserAgent when empty
+	UserAgent string
+
+	// When set, the HEAD and POST requests are signed before being sent, such
+	// as with a SigV4Signer.
+	Signer Signer
+
 	RetryCount int // When using a ReadAt reader, attempt multiple retries
 	RetryDelay time.Duration
 	OnRetry    func(ff []*File, retry int, err error)
 
+	// When set, the send and all its retries must complete within this window,
+	// no new attempt is started after it has elapsed.
+	MaxRetryDuration time.Duration
+
+	// Called with the Files and final error when a Send has failed and all the
+	// retries are exhausted, or the MaxRetryDuration has run out, giving relays
+	// a place to divert undeliverable Files.  It is not called when RetryCount
+	// is zero, when the caller's context is cancelled, or when a File cannot be
+	// reset for a retry.  The error is still returned from Send.
+	OnDeadLetter func(ff []*File, err error)
+
+	// Called with each File passed over as it is past its expiry, see
+	// ExpiryAttribute.  Send leaves these out, while HTTPPostWriter.Write
+	// returns ErrorExpired for them.
+	OnExpired func(*File)
+
+	// When set, Files delivered within the window are passed over when sent
+	// again, and OnSuppressed is called with each.  Send leaves these out,
+	// while HTTPPostWriter.Write returns ErrorSuppressed for them.
+	Suppress     *SuppressWindow
+	OnSuppressed func(*File)
+
+	// When set and the receiver offers delta transfers, Files of at least
+	// DeltaMinSize bytes (DefaultDeltaMinSize when 0) which can be read again
+	// are sent as only the blocks which differ from the content the receiver
+	// holds for them.  Files in a Batch are always sent whole.
+	Delta        bool
+	DeltaMinSize int64
+
+	// When set, each Send is made as one all-or-nothing batch POST, carrying
+	// the number of Files and content bytes in the BatchCountHeader and
+	// BatchBytesHeader.  The receiver fails a POST which parsed otherwise, and
+	// its echo of what it parsed is checked, giving a BatchMismatchError.
+	Batch bool
+
+	// When set, the bytes sent in the POSTs are shaped by the Limiter, which
+	// may be shared with other transactions.
+	Limiter *Limiter
+
+	// Called after each handshake and POST with details of the connection
+	// used, such as whether it was reused and the DNS, connect and TLS timings.
+	OnTrace func(*ConnTrace)
+
 	tlsConfig *tls.Config
 	client    *http.Client
 
 	// Non-standard NiFi entities supported by this library
 	MaxPartitionSize int64  // Maximum partition size for partitioned file
-	CheckSumType     string // What kind of CheckSum to use for sent files
+	CheckSumType     string // What kind of CheckSum to use for sent files, see Capabilities
+
+	// Content codings to compress POSTs with, in order of preference, such as
+	// "gzip".  The first one the remote also advertises in the handshake is
+	// set in ContentEncoding and used for the POSTs opened after it, when there
+	// is none in common the POSTs are sent uncompressed.
+	Compression     []string
+	ContentEncoding string
+
+	// Features advertised by the remote in the handshake.  When checksum
+	// types are advertised, the CheckSumType is set to the first supported
+	// one, unless it is already among them.  The handshake results are only
+	// set here by Handshake, the lazy handshake made by the first POST of a
+	// transaction created without one keeps them for the POSTs, see Health.
+	Capabilities Capabilities
 
 	MetricsHandshakeLatency time.Duration
 
-	hold *bool
+	// When set, the sizes and durations of the sent Files and POSTs are
+	// recorded, create with NewMetrics.
+	Metrics *Metrics
+
+	// When set, metric events are also sent to the sink, such as a
+	// PrometheusSink.
+	MetricsSink MetricsSink
+
+	// The source of time for the retries, hold offs and expiry checks,
+	// defaults to the DefaultClock.
+	Clock Clock
+
+	// When set, Files are recorded in the journal as they are written to a
+	// POST and removed once the POST has been accepted.
+	Journal *Journal
+
+	// When set, the attributes of every File are validated against the schema
+	// before it is written, a File breaking it is refused with a SchemaError.
+	Schema *AttributeSchema
+
+	// Debug output for this transaction
+	DebugLog
+
+	hold          *bool
+	closed        int32
+	handshakeLock sync.Mutex
+	agreed        *handshakeResult // of the last handshake, guarded by handshakeLock
+	health        healthState
+}
+
+// What was agreed in a handshake.  The POSTs take the last one as they start
+// and it is never changed after, so a handshake in flight, such as the lazy
+// one made by the first POST, does not change the settings of a POST being
+// written.
+type handshakeResult struct {
+	url, transactionID, server    string
+	maxPartitionSize              int64
+	capabilities                  Capabilities
+	checkSumType, contentEncoding string
+	latency                       time.Duration
+	at                            time.Time
 }
 
 // Create the HTTP sender and verify that the remote side is listening.
@@ -45,6 +151,9 @@ func NewHTTPTransactionWithTransport(url string, cfg *http.Transport) (*HTTPTran
 	var transportConfig *http.Transport
 	if cfg != nil {
 		transportConfig = cfg.Clone() // Create a copy for immutability
+		if FIPSMode {
+			transportConfig.TLSClientConfig = FIPSTLSConfig(transportConfig.TLSClientConfig)
+		}
 	}
 
 	hs := &HTTPTransaction{
@@ -64,12 +173,38 @@ func NewHTTPTransactionWithTransport(url string, cfg *http.Transport) (*HTTPTran
 	return hs, nil
 }
 
+// Create the HTTP sender over any http.RoundTripper, such as one layering
+// retries, tracing, or a service mesh over an http.Transport, and verify that
+// the remote side is listening.  The RoundTripper is used as is, so it may be
+// shared with other clients.
+func NewHTTPTransactionWithRoundTripper(url string, rt http.RoundTripper) (*HTTPTransaction, error) {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	hs := &HTTPTransaction{
+		url:    url,
+		client: &http.Client{Transport: rt},
+	}
+	if t, ok := rt.(*http.Transport); ok {
+		hs.tlsConfig = t.TLSClientConfig
+	}
+
+	err := hs.Handshake()
+	if err != nil {
+		return nil, err
+	}
+	return hs, nil
+}
+
 // Create the HTTP sender and verify that the remote side is listening.
 func NewHTTPTransaction(url string, cfg *tls.Config) (*HTTPTransaction, error) {
 	var tlsConfig *tls.Config
 	if cfg != nil {
 		tlsConfig = cfg.Clone() // Create a copy for immutability
 	}
+	if FIPSMode {
+		tlsConfig = FIPSTLSConfig(tlsConfig)
+	}
 
 	hs := &HTTPTransaction{
 		url:       url,
@@ -105,6 +240,9 @@ func NewHTTPTransactionNoHandshake(url string, cfg *tls.Config) *HTTPTransaction
 	if cfg != nil {
 		tlsConfig = cfg.Clone() // Create a copy for immutability
 	}
+	if FIPSMode {
+		tlsConfig = FIPSTLSConfig(tlsConfig)
+	}
 
 	hs := &HTTPTransaction{
 		url:       url,
@@ -134,39 +272,106 @@ func NewHTTPTransactionNoHandshake(url string, cfg *tls.Config) *HTTPTransaction
 // process of transferring flowfiles.  This is a blocking call so no new files
 // will be sent until this is completed.
 func (hs *HTTPTransaction) Handshake() error {
-	req, err := http.NewRequest("HEAD", hs.url, nil)
+	return hs.HandshakeContext(context.Background())
+}
+
+// HandshakeContext is like Handshake, but the request is bound to the given
+// context so a slow or unresponsive endpoint can be abandoned by cancelling
+// the context or setting a deadline.
+func (hs *HTTPTransaction) HandshakeContext(ctx context.Context) error {
+	hs.handshakeLock.Lock()
+	defer hs.handshakeLock.Unlock()
+	res, err := hs.handshake(ctx)
 	if err != nil {
 		return err
 	}
+	hs.agreed = res
+	hs.url, hs.TransactionID, hs.Server = res.url, res.transactionID, res.server
+	hs.MaxPartitionSize, hs.Capabilities = res.maxPartitionSize, res.capabilities
+	hs.CheckSumType, hs.ContentEncoding = res.checkSumType, res.contentEncoding
+	hs.MetricsHandshakeLatency, hs.lastSend = res.latency, res.at
+	return nil
+}
+
+// Handshake only if no transaction has been established yet, for lazy
+// initialization.  This is called from the goroutine of a POST, so the result
+// is only kept for the POSTs and not set in the fields of the transaction.
+func (hs *HTTPTransaction) ensureHandshake(ctx context.Context) error {
+	hs.handshakeLock.Lock()
+	defer hs.handshakeLock.Unlock()
+	if hs.agreed != nil {
+		return nil
+	}
+	res, err := hs.handshake(ctx)
+	if err == nil {
+		hs.agreed = res
+	}
+	return err
+}
+
+// The result of the last handshake, or the settings of the transaction when
+// none has been made, in which case the transactionID is empty
+func (hs *HTTPTransaction) session() *handshakeResult {
+	hs.handshakeLock.Lock()
+	defer hs.handshakeLock.Unlock()
+	if hs.agreed != nil {
+		return hs.agreed
+	}
+	return &handshakeResult{url: hs.url, checkSumType: hs.CheckSumType}
+}
+
+// Make the handshake, must hold the handshakeLock
+func (hs *HTTPTransaction) handshake(ctx context.Context) (res *handshakeResult, err error) {
+	defer func() { hs.health.record(true, err) }()
+	if hs.isClosed() {
+		return nil, ErrorTransactionClosed
+	}
+	var trace *ConnTrace
+	if hs.OnTrace != nil {
+		ctx, trace = newConnTrace(ctx, "HEAD")
+	}
+	req, err := http.NewRequestWithContext(ctx, "HEAD", hs.url, nil)
+	if err != nil {
+		return nil, err
+	}
 
 	txid := uuid.New().String()
 	req.Header.Set("x-nifi-transaction-id", txid)
 	req.Header.Set("Connection", "Keep-alive")
-	req.Header.Set("User-Agent", UserAgent)
-	tick := time.Now()
-	res, err := hs.client.Do(req)
+	req.Header.Set("User-Agent", hs.userAgent())
+	if hs.Signer != nil {
+		if err = hs.Signer.Sign(req); err != nil {
+			return nil, err
+		}
+	}
+	tick := hs.clock().Now()
+	resp, err := hs.client.Do(req)
+	hs.doneConnTrace(trace, err)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	hs.MetricsHandshakeLatency = time.Now().Sub(tick)
-
-	if Debug {
-		log.Printf("Result on query: %#v\n", res)
+	resp.Body.Close()
+	if err = fipsConnection(resp.TLS); err != nil {
+		return nil, err
 	}
+	latency := hs.clock().Now().Sub(tick)
+	sinkOrNop(hs.MetricsSink).Gauge("flowfiles_handshake_latency_seconds", latency.Seconds())
 
-	switch res.StatusCode {
+	hs.debugf("Result on query: %#v", resp)
+
+	switch resp.StatusCode {
 	case 200: // Success
 	case 405:
-		return fmt.Errorf("Method not allowed, make sure the remote server accepts flowfile-v3")
+		return nil, &HandshakeError{URL: hs.url, StatusCode: resp.StatusCode, Err: ErrorMethodNotAllowed}
 	default:
-		return fmt.Errorf("Unexpected status code %d", res.StatusCode)
+		return nil, &HandshakeError{URL: hs.url, StatusCode: resp.StatusCode, Err: ErrorUnexpectedStatus}
 	}
 
 	// If the initial post was redirected, we'll want to stick with the final URL
-	hs.url = res.Request.URL.String()
+	r := &handshakeResult{url: resp.Request.URL.String(), latency: latency}
 
 	{ // Check for Accept types
-		types := strings.Split(res.Header.Get("Accept"), ",")
+		types := strings.Split(resp.Header.Get("Accept"), ",")
 		var hasFF bool
 		for _, t := range types {
 			if strings.HasPrefix(t, "application/flowfile-v3") {
@@ -175,80 +380,120 @@ func (hs *HTTPTransaction) Handshake() error {
 			}
 		}
 		if !hasFF {
-			return fmt.Errorf("Server does not support flowfile-v3")
+			return nil, ErrorNoFlowFileSupport
 		}
-		hs.lastSend = time.Now()
+		r.at = hs.clock().Now()
 	}
 
 	// Check for protocol version
-	switch v := res.Header.Get("x-nifi-transfer-protocol-version"); v {
+	switch v := resp.Header.Get("x-nifi-transfer-protocol-version"); v {
 	case "3": // Add more versions after verifying support is there
 	default:
-		return fmt.Errorf("Unknown NiFi TransferVersion %q", v)
+		return nil, fmt.Errorf("%w %q", ErrorProtocolVersion, v)
 	}
 
 	// Parse out non-standard fields
-	if v := res.Header.Get("Max-Partition-Size"); v != "" {
+	if v := resp.Header.Get("Max-Partition-Size"); v != "" {
 		maxPartitionSize, err := strconv.ParseUint(v, 10, 64)
 		if err == nil {
-			hs.MaxPartitionSize = int64(maxPartitionSize)
+			r.maxPartitionSize = int64(maxPartitionSize)
 		} else {
-			if Debug {
-				log.Println("Unable to parse Max-Partition-Size", err)
-			}
+			hs.debugln("Unable to parse Max-Partition-Size", err)
 		}
-	} else {
-		hs.MaxPartitionSize = 0
 	}
 
-	hs.TransactionID, hs.Server = txid, res.Header.Get("Server")
+	r.capabilities = parseCapabilities(resp.Header)
+	r.checkSumType = r.capabilities.selectChecksum(hs.CheckSumType)
+	if r.checkSumType != hs.CheckSumType {
+		hs.debugln("Using checksum type", r.checkSumType, "advertised by the remote")
+	}
+	r.contentEncoding = r.capabilities.selectCodec(hs.Compression)
+	r.transactionID, r.server = txid, resp.Header.Get("Server")
+	return r, nil
+}
+
+func (hs *HTTPTransaction) clock() Clock {
+	return clockOr(hs.Clock)
+}
+
+func (hs *HTTPTransaction) userAgent() string {
+	if hs.UserAgent != "" {
+		return hs.UserAgent
+	}
+	return UserAgent
+}
+
+// Close releases the idle connections held by the underlying transport and
+// invalidates the transaction.  Any POSTs already in flight are allowed to
+// finish, but new handshakes, sends, and writes will return
+// ErrorTransactionClosed.
+func (hs *HTTPTransaction) Close() error {
+	if !atomic.CompareAndSwapInt32(&hs.closed, 0, 1) {
+		return ErrorTransactionClosed
+	}
+	hs.client.CloseIdleConnections()
 	return nil
 }
 
+func (hs *HTTPTransaction) isClosed() bool {
+	return atomic.LoadInt32(&hs.closed) != 0
+}
+
 // Send one or more flow files to the remote server and return any errors back.
 // A nil return for error is a successful send.
 //
 // This method of sending will make one POST-per-file which is not recommended
 // for small files.  To increase throughput on smaller files one should
 // consider using either NewHTTPPostWriter or NewHTTPBufferedPostWriter.
-func (hs *HTTPTransaction) doSend(ff ...*File) (err error) {
+func (hs *HTTPTransaction) doSend(ctx context.Context, ff ...*File) (err error) {
 	httpWriter := hs.NewHTTPBufferedPostWriter()
-	err = fmt.Errorf("File did not send, no response")
+	httpWriter.SetContext(ctx)
+	err = ErrorNoResponse
 	defer func() {
 		if httpWriter.w == nil {
 			return
 		}
 		httpWriter.Close() // make sure everything is closed up
 	}()
-	for i, f := range ff {
-		if Debug {
-			fmt.Printf("  sending item #%d\n", i)
+	if hs.Batch {
+		// Leave out the Files passed over so the count is of what is sent
+		var batch []*File
+		for _, f := range ff {
+			if hs.passOver(f, httpWriter.session.checkSumType) == nil {
+				batch = append(batch, f)
+			}
 		}
+		if ff = batch; len(ff) == 0 {
+			httpWriter.Close()
+			return nil
+		}
+		httpWriter.batch = newBatchCount(ff)
+		httpWriter.batch.writeHeader(httpWriter.Header)
+	}
+	for i, f := range ff {
+		hs.debugf("  sending item #%d", i)
 		_, err = httpWriter.Write(f)
+		if errors.Is(err, ErrorExpired) || errors.Is(err, ErrorSuppressed) {
+			err = nil // Passed over, see OnExpired and OnSuppressed
+			continue
+		}
 		if err != nil {
-			if Debug {
-				fmt.Println("write err:", err)
-			}
+			hs.debugln("write err:", err)
 			httpWriter.Terminate()
 			return
 		}
 	}
-	if err = httpWriter.Close(); err != nil {
-		return
-	}
-	if httpWriter.Response == nil {
-		err = fmt.Errorf("File did not send, no response")
-	} else if httpWriter.Response.StatusCode != 200 {
-		err = fmt.Errorf("File did not send successfully, code %d", httpWriter.Response.StatusCode)
-	}
-	return
+	return httpWriter.Close()
 }
 
 // Send one or more flow files to the remote server and return any errors back.
 // A nil return for error is a successful send.
 //
 // A failed send will be retried if HTTPTransaction.RetryCount is set and the File
-// uses a ReadAt reader, a (1+retries) attempts will be made with a HTTPTransaction.RetryDelay between retries.
+// uses a ReadAt reader, a (1+retries) attempts will be made with a HTTPTransaction.RetryDelay between retries,
+// or the Retry-After given by a busy receiver when longer.
+// If HTTPTransaction.MaxRetryDuration is set, the attempts are also bounded by
+// that wall-clock budget.
 //
 //   // With one or more files:
 //   err = hs.Send(file1)
@@ -260,9 +505,20 @@ func (hs *HTTPTransaction) doSend(ff ...*File) (err error) {
 // for small files.  To increase throughput on smaller files one should
 // consider using either NewHTTPPostWriter or NewHTTPBufferedPostWriter.
 func (hs *HTTPTransaction) Send(ff ...*File) (err error) {
+	return hs.SendContext(context.Background(), ff...)
+}
+
+// SendContext is like Send, but the POSTs, the re-handshakes, and the delays
+// between retries are all bound to the given context.  When the context is
+// cancelled, the pending attempt is abandoned and the context error is
+// returned.
+func (hs *HTTPTransaction) SendContext(ctx context.Context, ff ...*File) (err error) {
 	if len(ff) == 0 {
 		return
 	}
+	if hs.isClosed() {
+		return ErrorTransactionClosed
+	}
 
 	// If retries are enabled, verify that the payload is resettable, error out early
 	if hs.RetryCount > 0 {
@@ -273,46 +529,118 @@ func (hs *HTTPTransaction) Send(ff ...*File) (err error) {
 		}
 	}
 
+	// Bound all the attempts by the retry budget
+	parent := ctx
+	var deadline time.Time
+	if hs.MaxRetryDuration > 0 {
+		deadline = hs.clock().Now().Add(hs.MaxRetryDuration)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hs.MaxRetryDuration)
+		defer cancel()
+	}
+
+	// Once the caller's context is done return its error, but when only the
+	// retry budget has run out return the error from the last attempt
+	var exhausted bool
+	stopped := func() error {
+		if parent.Err() != nil {
+			return parent.Err()
+		}
+		if ctx.Err() != nil || !deadline.IsZero() && !hs.clock().Now().Before(deadline) {
+			hs.debugln("Retry budget exhausted, err:", err)
+			exhausted = true
+			return err
+		}
+		return nil
+	}
+
+	// Only Files which used up the retry budget are handed to OnDeadLetter
+	defer func() {
+		if exhausted && err != nil && hs.OnDeadLetter != nil {
+			hs.OnDeadLetter(ff, err)
+		}
+	}()
+
+	// Wait out a delay, false when it would run past the retry budget or the
+	// context is done first
+	holdOff := func(d time.Duration) bool {
+		if !deadline.IsZero() && hs.clock().Now().Add(d).After(deadline) {
+			return false
+		}
+		t := hs.clock().NewTimer(d)
+		select {
+		case <-t.C():
+			return true
+		case <-ctx.Done():
+			t.Stop()
+			return false
+		}
+	}
+
 	// do the work, give up after first try if retry is not enabled
-	if err = hs.doSend(ff...); err == nil || hs.RetryCount <= 0 {
+	if err = hs.doSend(ctx, ff...); err == nil || hs.RetryCount <= 0 {
 		return
 	}
+	failedAt := hs.clock().Now()
 
 	// Loop over our tries
 	for try := 1; try <= hs.RetryCount; try++ {
+		if stopErr := stopped(); stopErr != nil {
+			return stopErr
+		}
+
+		// Hold off for what is left of the Retry-After of a busy receiver
+		var se *SendError
+		if errors.As(err, &se) {
+			if wait := se.retryAfter(hs.clock().Now()) - hs.clock().Now().Sub(failedAt); wait > 0 && !holdOff(wait) {
+				if stopErr := stopped(); stopErr != nil {
+					return stopErr
+				}
+				exhausted = true
+				return
+			}
+		}
+
 		// For sanity, we should handshake to get a new transaction id
-		hs.Handshake()
+		hs.HandshakeContext(ctx)
+		if stopErr := stopped(); stopErr != nil {
+			return stopErr
+		}
 
-		// Reset all the readers
+		// Reset all the readers, keeping the send error when one cannot be
 		for _, f := range ff {
 			if resetErr := f.Reset(); resetErr != nil {
-				return resetErr
+				return fmt.Errorf("%w, unable to retry: %w", err, resetErr)
 			}
 		}
 
-		if Debug {
-			log.Println("Retrying send,", try, "err:", err)
-		}
+		hs.debugln("Retrying send,", try, "err:", err)
 
 		if hs.OnRetry != nil {
 			hs.OnRetry(ff, try, err) // Call preamble function
 		}
 
 		// do the work
-		err = hs.doSend(ff...)
+		err = hs.doSend(ctx, ff...)
+		failedAt = hs.clock().Now()
 
-		if Debug {
-			log.Println("Send came back with,", err)
-		}
+		hs.debugln("Send came back with,", err)
 
-		if err == nil {
+		if err == nil || try == hs.RetryCount {
 			break
 		}
 
 		// hold off, handshake, and retry
-		time.Sleep(hs.RetryDelay)
+		if !holdOff(hs.RetryDelay) {
+			if stopErr := stopped(); stopErr != nil {
+				return stopErr
+			}
+			exhausted = true
+			return
+		}
 	}
 
+	exhausted = true
 	return
 }
 
@@ -334,18 +662,67 @@ func (hs *HTTPTransaction) Send(ff ...*File) (err error) {
 //   w.Write(ff2)
 //   err = w.Close() // Finalize the POST
 type HTTPPostWriter struct {
-	Header        http.Header
+	Header http.Header
+	Sent   int64
+
+	// Buffered writers flush once the writes have been idle for FlushIdle, so
+	// small Files go out right away, while under sustained writes the flush is
+	// held off for up to FlushInterval to build larger packets.  A full buffer
+	// of BufferSize bytes is always flushed.  These must be set before the
+	// first Write.
 	FlushInterval time.Duration
-	Sent          int64
-	hs            *HTTPTransaction
-	w             io.WriteCloser
-	pw            *io.PipeWriter
+	FlushIdle     time.Duration
+	BufferSize    int
+
+	// When either threshold is reached, the current POST is closed out and a
+	// new POST is transparently started for the following Files.  This keeps
+	// long-running streaming loops from building unbounded transactions.
+	MaxFilesPerPost int
+	MaxBytesPerPost int64
+
+	// Content coding for the POSTs of this writer, such as "gzip", in place of
+	// the ContentEncoding of the transaction, or "identity" to send them
+	// uncompressed.  The coding must be one the remote advertised in the
+	// handshake, otherwise the POSTs are sent uncompressed.  A change takes
+	// effect from the next POST.
+	Compression string
+
+	// When set, the POSTs of this writer are also shaped by the Limiter, on
+	// top of the Limiter of the transaction.  This must be set before the first
+	// Write.
+	Limiter *Limiter
+
+	// The entries kept for the Transcript of a long-running writer, those of
+	// the oldest POSTs are dropped past this, DefaultTranscriptSize when zero.
+	TranscriptSize int
+
+	hs       *HTTPTransaction
+	w        io.WriteCloser
+	pw       *io.PipeWriter
+	buffered bool
+	encoding string           // Content-Encoding of the current POST
+	session  *handshakeResult // Handshake the current POST was opened under
 
 	client    *http.Client
 	clientErr chan error
 	Response  *http.Response
+	Manifest  *Manifest // replied to the last POST, when the receiver sent one
 	err       error
 
+	postFiles int       // Files written to the current POST
+	postBytes int64     // Bytes written to the current POST
+	posts     int       // POSTs opened by this writer
+	postStart time.Time // When the current POST was started
+
+	transcript []TranscriptEntry
+	postEntry  int         // Index of the first transcript entry of the current POST
+	journaled  []*File     // Files in the current POST awaiting a Commit
+	batch      *batchCount // Expected in the reply to a batch POST
+
+	parent context.Context // context given by SetContext
+	ctx    context.Context
+	cancel context.CancelFunc
+
 	writeLock sync.Mutex
 	init      func()
 }
@@ -360,31 +737,142 @@ func (hw *HTTPPostWriter) Write(f *File) (n int64, err error) {
 	defer hw.writeLock.Unlock()
 
 	defer func() {
-		if Debug && err != nil {
-			fmt.Println("write err:", err)
+		if err != nil {
+			hw.hs.debugln("write err:", err)
 		}
 	}()
 
+	if hw.client == nil || hw.w == nil || hw.hs.isClosed() {
+		err = ErrorTransactionClosed
+		return
+	}
+	if err = hw.context().Err(); err != nil {
+		return
+	}
+	if err = hw.hs.passOver(f, hw.session.checkSumType); err != nil {
+		return
+	}
+	if err = hw.hs.Schema.Check(f.Attrs); err != nil {
+		return
+	}
+	if err = fipsChecksum(hw.session.checkSumType); err != nil {
+		return
+	}
+
 	// On first write, initaite the POST
 	if hw.init != nil {
 		hw.init()
 		hw.init = nil
 	}
 
-	if hw.client == nil {
-		err = fmt.Errorf("HTTPTransaction Closed")
-		return
+	if j := hw.hs.Journal; j != nil {
+		if err = j.Begin(f); err != nil {
+			return
+		}
+		hw.journaled = append(hw.journaled, f)
 	}
 
-	if f.Size > 0 && f.Attrs.Get("checksumType") == "" {
-		f.AddChecksum(hw.hs.CheckSumType)
+	defer func(start time.Time) {
+		if err != nil {
+			return
+		}
+		secs := hw.hs.clock().Now().Sub(start).Seconds()
+		if m := hw.hs.Metrics; m != nil {
+			m.MetricsFileDuration.Observe(secs)
+			m.ObserveTransfer(f, n)
+		}
+		sink := sinkOrNop(hw.hs.MetricsSink)
+		sink.Counter("flowfiles_sent_total", 1)
+		sink.Counter("flowfiles_sent_bytes_total", float64(n))
+		sink.Observe("flowfiles_sent_file_duration_seconds", secs)
+	}(hw.hs.clock().Now())
+
+	var tee bool
+	if cksum := hw.session.checkSumType; f.Size > 0 && f.Attrs.Get("checksumType") == "" && cksum != "" {
+		if f.AddChecksum(cksum) != nil && f.cksumStatus != cksumInit {
+			// The payload cannot be read ahead of time, so compute the checksum
+			// as the content streams out and record it after the fact
+			if new := getChecksumFunc(cksum); new != nil {
+				f.cksum, f.cksumStatus, tee = new(), cksumInit, true
+			}
+		}
 	}
-	w := &Writer{w: hw.w}
-	n, err = w.Write(f)
+	inHeader := f.Attrs.Get("checksumType") != ""
+
+	src := f
+	if d, cleanup := hw.delta(f); d != nil {
+		if cleanup != nil {
+			defer cleanup()
+		}
+		src = d
+	}
+
+	w := &Writer{w: hw.w, MetricsSink: hw.hs.MetricsSink, DebugLog: hw.hs.DebugLog}
+	n, err = w.Write(src)
+	if tee && err == nil {
+		f.Attrs.Set("checksumType", hw.session.checkSumType)
+		f.Attrs.Set("checksum", fmt.Sprintf("%0x", f.cksum.Sum(nil)))
+		f.cksumStatus = cksumPassed
+	}
+	hw.transcript = append(hw.transcript, TranscriptEntry{
+		Post:             hw.posts - 1,
+		UUID:             f.Attrs.Get("uuid"),
+		Filename:         f.Attrs.Get("filename"),
+		Offset:           hw.postBytes,
+		Length:           n,
+		ChecksumType:     f.Attrs.Get("checksumType"),
+		Checksum:         f.Attrs.Get("checksum"),
+		ChecksumInHeader: inHeader,
+		Err:              err,
+	})
 	hw.Sent += n
+	hw.postFiles++
+	hw.postBytes += n
+	if err != nil {
+		if ctxErr := hw.context().Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return
+	}
+
+	// Roll over to a new POST if a threshold has been met, including one set
+	// by the receiver, a batch is always kept to one POST
+	if hw.batch != nil {
+		return
+	}
+	maxFiles := hw.MaxFilesPerPost
+	if m := hw.session.capabilities.MaxFilesPerPost; m > 0 && (maxFiles <= 0 || m < maxFiles) {
+		maxFiles = m
+	}
+	if (maxFiles > 0 && hw.postFiles >= maxFiles) ||
+		(hw.MaxBytesPerPost > 0 && hw.postBytes >= hw.MaxBytesPerPost) {
+		hw.hs.debugln("POST threshold reached, starting a new POST")
+		if err = hw.closePost(); err != nil {
+			return
+		}
+		hw.open()
+	}
 	return
 }
 
+// Check if the File is to be passed over, as it is expired or suppressed
+// under the checksum type of the POST
+func (hs *HTTPTransaction) passOver(f *File, cksum string) error {
+	if f.ExpiredAt(hs.clock().Now()) {
+		if hs.OnExpired != nil {
+			hs.OnExpired(f)
+		}
+		return fmt.Errorf("%w at %s", ErrorExpired, f.Attrs.Get(ExpiryAttribute))
+	}
+	if hs.Suppress.suppressed(f, cksum) {
+		if hs.OnSuppressed != nil {
+			hs.OnSuppressed(f)
+		}
+		return ErrorSuppressed
+	}
+	return nil
+}
+
 // Close the HTTPPostWriter and flush the data to the stream
 func (hw *HTTPPostWriter) Close() (err error) {
 	if hw.err != nil {
@@ -393,6 +881,66 @@ func (hw *HTTPPostWriter) Close() (err error) {
 
 	hw.writeLock.Lock()
 	defer hw.writeLock.Unlock()
+	err = hw.closePost()
+	if err == nil {
+		// A context which ran out before anything was sent still fails the Close
+		if err = hw.context().Err(); err != nil {
+			hw.err = err
+		}
+	}
+	if hw.cancel != nil {
+		hw.cancel()
+		hw.ctx, hw.cancel = hw.parent, nil
+	}
+	return
+}
+
+// SetContext attaches a context to the HTTPPostWriter.  When the context is
+// done, any pending Write or Close will fail and the POST is torn down instead
+// of hanging on a stalled receiver.  This must be called before the first
+// Write.
+func (hw *HTTPPostWriter) SetContext(ctx context.Context) {
+	if hw.cancel != nil {
+		hw.cancel()
+		hw.cancel = nil
+	}
+	hw.parent, hw.ctx = ctx, ctx
+}
+
+// SetDeadline bounds the time the HTTPPostWriter may take to send all the
+// Files and receive a reply.  This must be called before the first Write.
+func (hw *HTTPPostWriter) SetDeadline(t time.Time) {
+	parent := hw.parent
+	if parent == nil {
+		parent = context.Background()
+	}
+	if hw.cancel != nil {
+		hw.cancel()
+	}
+	hw.ctx, hw.cancel = context.WithDeadline(parent, t)
+}
+
+func (hw *HTTPPostWriter) context() context.Context {
+	if hw.ctx == nil {
+		return context.Background()
+	}
+	return hw.ctx
+}
+
+// Finish the current POST and wait for the reply, must hold the writeLock.
+func (hw *HTTPPostWriter) closePost() error {
+	if hw.w == nil {
+		return hw.err
+	}
+	if hw.init != nil {
+		// Nothing was written since the POST was opened, so there is no reply
+		// to wait on
+		hw.init = nil
+		hw.pw.Close()
+		hw.w = nil
+		return hw.context().Err()
+	}
+
 	if hw.w == hw.pw {
 		hw.w.Close()
 		hw.w = nil
@@ -403,23 +951,115 @@ func (hw *HTTPPostWriter) Close() (err error) {
 		hw.pw = nil
 	}
 
-	if Debug {
-		log.Println("closed channel, waiting for post reply")
-	}
+	hw.hs.debugln("closed channel, waiting for post reply")
 	hw.err = <-hw.clientErr
-	if Debug {
-		log.Println("replied!", hw.err, hw.Response)
+	hw.hs.debugln("replied!", hw.err, hw.Response)
+	if ctxErr := hw.context().Err(); ctxErr != nil && hw.err != nil {
+		hw.err = ctxErr // The POST was torn down by the context
+	}
+	if hw.err == nil {
+		if hw.Response == nil {
+			hw.err = ErrorNoResponse
+		} else if err := hw.batch.verifyReply(hw.Response); err != nil {
+			hw.err = err
+		} else if hw.Response.StatusCode != 200 {
+			s := hw.hs.session()
+			hw.err = &SendError{URL: s.url, TransactionID: s.transactionID, StatusCode: hw.Response.StatusCode,
+				Header: hw.Response.Header, Body: readErrorBody(hw.Response.Body)}
+		}
+	}
+	if hw.err == nil {
+		if hw.Manifest = readManifest(hw.Response); hw.Manifest != nil {
+			hw.Manifest.accept(hw.transcript[hw.postEntry:], hw.posts-1)
+		}
+	}
+	if hw.Response != nil {
+		// Drain what is left of the reply so the connection can be reused
+		io.CopyN(io.Discard, hw.Response.Body, 64<<10)
+		hw.Response.Body.Close()
+	}
+	if hw.err == nil {
+		secs := hw.hs.clock().Now().Sub(hw.postStart).Seconds()
+		if m := hw.hs.Metrics; m != nil {
+			m.MetricsPostDuration.Observe(secs)
+		}
+		sinkOrNop(hw.hs.MetricsSink).Observe("flowfiles_sent_post_duration_seconds", secs)
+	} else {
+		sinkOrNop(hw.hs.MetricsSink).Counter("flowfiles_post_errors_total", 1)
+	}
+	hw.hs.health.record(false, hw.err)
+	if j := hw.hs.Journal; j != nil && hw.err == nil {
+		hw.err = j.Commit(hw.journaled...)
+	}
+	if hw.hs.Suppress != nil && hw.err == nil {
+		hw.hs.Suppress.delivered(hw.transcript[hw.postEntry:])
 	}
+	hw.journaled = nil
+	hw.trimTranscript()
 
 	return hw.err
 }
 
+// DefaultTranscriptSize is the number of entries an HTTPPostWriter keeps for
+// its Transcript when no TranscriptSize is set.
+var DefaultTranscriptSize = 4096
+
+// Drop the entries of the oldest POSTs once there are twice the
+// TranscriptSize, and start the entries of the next POST
+func (hw *HTTPPostWriter) trimTranscript() {
+	size := hw.TranscriptSize
+	if size <= 0 {
+		size = DefaultTranscriptSize
+	}
+	if len(hw.transcript) >= 2*size {
+		n := copy(hw.transcript, hw.transcript[len(hw.transcript)-size:])
+		clear(hw.transcript[n:])
+		hw.transcript = hw.transcript[:n]
+	}
+	hw.postEntry = len(hw.transcript)
+}
+
+// A TranscriptEntry records the byte range a File occupied in the body of a
+// POST, so senders can log exactly what went over the wire for reconciliation.
+type TranscriptEntry struct {
+	Post     int    // Index of the POST, increases when MaxFilesPerPost or MaxBytesPerPost roll over
+	UUID     string // UUID attribute of the File
+	Filename string // Filename attribute of the File
+	Offset   int64  // Offset of the FlowFile header in the POST body
+	Length   int64  // Bytes written for the File, header included
+	Err      error  // Error seen while writing the File, if any
+
+	// The checksum of the File content.  For a streamed File which could not
+	// be read ahead of time, the checksum is computed while sending and is not
+	// in the FlowFile header, but it is still recorded here and set on the
+	// File attributes once the Write completes.
+	ChecksumType     string
+	Checksum         string
+	ChecksumInHeader bool
+
+	// Set once the POST is closed when the File is listed in the Manifest
+	// replied by the receiver, with the content bytes the receiver was given.
+	Accepted     bool
+	AcceptedSize int64
+}
+
+// Transcript returns a record of the Files written, in order, with the
+// offsets each occupied in the POST body.  The entry for a File is available
+// as soon as Write returns, however whether the POST was successful is only
+// known once Close returns.  A writer rolling over to new POSTs keeps at least
+// the last TranscriptSize entries, along with those of the current POST.
+func (hw *HTTPPostWriter) Transcript() []TranscriptEntry {
+	hw.writeLock.Lock()
+	defer hw.writeLock.Unlock()
+	return append([]TranscriptEntry{}, hw.transcript...)
+}
+
 // Terminate the HTTPPostWriter
 func (hw *HTTPPostWriter) Terminate() {
 	if mlw, ok := hw.w.(*maxLatencyWriter); ok {
 		mlw.dst.Reset(nil)
 	}
-	hw.pw.CloseWithError(fmt.Errorf("Post Terminated"))
+	hw.pw.CloseWithError(ErrorPostTerminated)
 }
 
 // NewHTTPPostWriter creates a POST to a NiFi listening endpoint and allows
@@ -431,22 +1071,14 @@ func (hw *HTTPPostWriter) Terminate() {
 // files will be marked as failed if the the HTTP POST is not a success.
 func (hs *HTTPTransaction) NewHTTPPostWriter() (httpWriter *HTTPPostWriter) {
 
-	if Debug {
-		log.Printf("HTTP.Client: %#v\n", *hs.client)
-	}
+	hs.debugf("HTTP.Client: %#v", *hs.client)
 
-	r, w := io.Pipe()
 	httpWriter = &HTTPPostWriter{
-		Header:    make(http.Header),
-		pw:        w,
-		w:         w,
-		hs:        hs,
-		client:    hs.client,
-		clientErr: make(chan error),
-	}
-	httpWriter.init = func() {
-		go httpWriter.doPost(hs, r)
+		Header: make(http.Header),
+		hs:     hs,
+		client: hs.client,
 	}
+	httpWriter.open()
 	return
 }
 
@@ -459,43 +1091,131 @@ func (hs *HTTPTransaction) NewHTTPPostWriter() (httpWriter *HTTPPostWriter) {
 // However, HTTPPostWriter increases the chances of failures as all the sent
 // files will be marked as failed if the the HTTP POST is not a success.
 func (hs *HTTPTransaction) NewHTTPBufferedPostWriter() (httpWriter *HTTPPostWriter) {
-	r, w := io.Pipe()
-	mlw := &maxLatencyWriter{
-		dst:  bufio.NewWriter(w),
-		c:    w,
-		done: make(chan bool),
-	}
-
 	httpWriter = &HTTPPostWriter{
 		Header:        make(http.Header),
-		pw:            w,
-		w:             mlw,
 		hs:            hs,
 		FlushInterval: 400 * time.Millisecond,
+		FlushIdle:     10 * time.Millisecond,
+		BufferSize:    64 << 10,
 		client:        hs.client,
-		clientErr:     make(chan error),
+		buffered:      true,
+	}
+	httpWriter.open()
+	return
+}
+
+// Setup the pipe for a new POST, the POST itself is started on the first write.
+func (hw *HTTPPostWriter) open() {
+	r, pw := io.Pipe()
+	hw.pw, hw.w = pw, pw
+	hw.clientErr = make(chan error)
+	hw.postFiles, hw.postBytes = 0, 0
+	hw.posts++
+
+	hw.encoding = ""
+	hw.session = hw.hs.session()
+
+	if !hw.buffered {
+		hw.init = func() {
+			hw.postStart = hw.hs.clock().Now()
+			hw.w = hw.compress(hw.limit(pw))
+			go hw.doPost(hw.hs, r)
+		}
+		return
 	}
 
-	httpWriter.init = func() {
-		mlw.latency = httpWriter.FlushInterval
+	hw.init = func() {
+		hw.postStart = hw.hs.clock().Now()
+		w := hw.compress(hw.limit(pw))
+		mlw := &maxLatencyWriter{
+			dst:     bufio.NewWriterSize(w, hw.BufferSize),
+			c:       w,
+			latency: hw.FlushInterval,
+			idle:    hw.FlushIdle,
+			done:    make(chan bool),
+			clock:   hw.hs.clock(),
+		}
+		hw.w = mlw
 		go mlw.flushLoop()
-		go httpWriter.doPost(hs, r)
+		go hw.doPost(hw.hs, r)
 	}
-	return
 }
 
-func (httpWriter *HTTPPostWriter) doPost(hs *HTTPTransaction, r io.ReadCloser) {
-	err := fmt.Errorf("POST did not complete")
+// Shape the body of the POST with the Limiters of the writer and transaction
+func (hw *HTTPPostWriter) limit(pw *io.PipeWriter) io.WriteCloser {
+	return newLimitWriter(hw.context(), pw, hw.hs.Limiter, hw.Limiter)
+}
+
+// Wrap the body of the POST with the codec of the writer, or the one agreed on
+// in the handshake, and set the encoding for the Content-Encoding header.
+func (hw *HTTPPostWriter) compress(pw io.WriteCloser) io.WriteCloser {
+	name := hw.session.contentEncoding
+	switch {
+	case strings.EqualFold(hw.Compression, "identity"):
+		return pw
+	case hw.Compression != "":
+		if !hw.session.capabilities.HasCodec(hw.Compression) {
+			hw.hs.debugln("Sending uncompressed as the remote does not support", hw.Compression)
+			return pw
+		}
+		name = hw.Compression
+	}
+	codec := LookupCodec(name)
+	if codec == nil {
+		return pw
+	}
+	cw, err := codec.NewWriter(pw)
+	if err != nil {
+		hw.hs.debugln("Unable to compress with", codec.Name, err)
+		return pw
+	}
+	hw.encoding = codec.Name
+	return cw
+}
+
+func (httpWriter *HTTPPostWriter) doPost(hs *HTTPTransaction, r *io.PipeReader) {
+	err := ErrorPostIncomplete
 	defer func() {
 		r.Close() // Make sure pipe is terminated
 		httpWriter.clientErr <- err
 	}()
 
-	if hs.TransactionID == "" { // Lazy init
-		hs.Handshake()
+	ctx := httpWriter.context()
+	if ctx.Done() != nil {
+		// Tear down the pipe when the context is done so a blocked Write fails
+		// instead of waiting on a stalled receiver
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				r.CloseWithError(ctx.Err())
+			case <-stop:
+			}
+		}()
 	}
 
-	req, _ := http.NewRequest("POST", hs.url, r)
+	// Lazy init, the start of the POST is buffered while the handshake is in
+	// flight so the first File isn't held up by the extra round trip.  The
+	// body was started without one, so it is sent as it was written, without
+	// a codec and with the configured checksum.
+	var body io.Reader = r
+	s := httpWriter.session
+	if s.transactionID == "" {
+		limit := httpWriter.BufferSize
+		if limit <= 0 {
+			limit = 64 << 10
+		}
+		body = newPrefetchReader(r, limit)
+		hs.ensureHandshake(ctx)
+		s = hs.session()
+	}
+
+	var trace *ConnTrace
+	if hs.OnTrace != nil {
+		ctx, trace = newConnTrace(ctx, "POST")
+	}
+	req, _ := http.NewRequestWithContext(ctx, "POST", s.url, body)
 	// We shouldn't get an error here as the session would have already
 	// established the connection details.
 
@@ -510,15 +1230,22 @@ func (httpWriter *HTTPPostWriter) doPost(hs *HTTPTransaction, r io.ReadCloser) {
 
 	req.Header.Set("Content-Type", "application/flowfile-v3")
 	req.Header.Set("x-nifi-transfer-protocol-version", "3")
-	req.Header.Set("x-nifi-transaction-id", hs.TransactionID)
+	req.Header.Set("x-nifi-transaction-id", s.transactionID)
 	req.Header.Set("Transfer-Encoding", "chunked")
+	if httpWriter.encoding != "" {
+		req.Header.Set("Content-Encoding", httpWriter.encoding)
+	}
 	req.Header.Set("Connection", "Keep-alive")
-	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("User-Agent", hs.userAgent())
+	if hs.Signer != nil {
+		if err = hs.Signer.Sign(req); err != nil {
+			return
+		}
+	}
 	//if Debug {
 	//	log.Println("doing request", req)
 	//}
 	httpWriter.Response, err = httpWriter.client.Do(req)
-	if Debug {
-		log.Println("POST response:", httpWriter.Response, err)
-	}
+	hs.doneConnTrace(trace, err)
+	hs.debugln("POST response:", httpWriter.Response, err)
 }
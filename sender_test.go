@@ -1,12 +1,24 @@
 package flowfile_test
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
 
 	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/flowfiletest"
 )
 
 var tlsConfig *tls.Config
@@ -108,6 +120,41 @@ func ExampleNewHTTPFileReceiver() {
 	http.ListenAndServe(":8080", nil)
 }
 
+func ExampleReceiverMux() {
+	mux := &flowfile.ReceiverMux{Default: func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		fmt.Println("unsorted", f.Attrs.Get("filename"))
+		return nil
+	}}
+	mux.Handle(flowfile.AttributeIs("project", "A"), func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		fmt.Println("project A", f.Attrs.Get("filename"))
+		return nil
+	})
+	mux.Handle(flowfile.MimeType("image/*"), func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		fmt.Println("image", f.Attrs.Get("filename"))
+		return nil
+	})
+
+	for _, attrs := range [][]string{
+		{"filename", "a.txt", "project", "A"},
+		{"filename", "b.png", "mime.type", "image/png"},
+		{"filename", "c.txt", "project", "B"},
+	} {
+		f := flowfile.New(strings.NewReader(""), 0)
+		for i := 0; i < len(attrs); i += 2 {
+			f.Attrs.Set(attrs[i], attrs[i+1])
+		}
+		mux.HandleFile(f, nil, nil)
+	}
+
+	// In a server, the mux is given to a receiver:
+	//   http.Handle("/contentListener", flowfile.NewHTTPFileReceiver(mux.HandleFile))
+
+	// Output:
+	// project A a.txt
+	// image b.png
+	// unsorted c.txt
+}
+
 func ExampleNewHTTPReceiver() {
 	ffReceiver := flowfile.NewHTTPReceiver(func(fs *flowfile.Scanner, w http.ResponseWriter, r *http.Request) {
 		// Loop over all the files in the post payload
@@ -150,3 +197,829 @@ func ExampleHTTPPostWriter() {
 	w.Write(ff2)
 	err = w.Close() // Finalize the POST
 }
+
+// A receiver taking in every File, replying with a Manifest
+func newTestReceiver(t *testing.T) (*flowfile.HTTPReceiver, *httptest.Server) {
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	rcv.Manifest = true
+	ts := httptest.NewServer(rcv)
+	t.Cleanup(ts.Close)
+	return rcv, ts
+}
+
+func TestHTTPPostWriterTranscriptSize(t *testing.T) {
+	_, ts := newTestReceiver(t)
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := hs.NewHTTPPostWriter()
+	w.MaxFilesPerPost = 1
+	w.TranscriptSize = 3
+	for i := 0; i < 20; i++ {
+		f := flowfile.New(strings.NewReader("abc"), 3)
+		f.Attrs.GenerateUUID()
+		if _, err = w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := w.Transcript()
+	if len(tr) < 3 || len(tr) >= 2*3 {
+		t.Fatalf("expecting 3 to 5 entries kept, got %d", len(tr))
+	}
+	for i, e := range tr {
+		if want := 20 - len(tr) + i; e.Post != want {
+			t.Errorf("entry %d: expecting POST %d, got %d", i, want, e.Post)
+		}
+		if !e.Accepted {
+			t.Errorf("entry %d: POST %d not accepted by the manifest", i, e.Post)
+		}
+	}
+}
+
+func TestHTTPPostWriterSuppress(t *testing.T) {
+	_, ts := newTestReceiver(t)
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := flowfiletest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	hs.Suppress = flowfile.NewSuppressWindow(time.Hour)
+	hs.Suppress.Clock = clk
+	w := hs.NewHTTPPostWriter()
+	w.MaxFilesPerPost = 2
+	var uuids []string
+	for i := 0; i < 7; i++ {
+		f := flowfile.New(strings.NewReader("abc"), 3)
+		uuids = append(uuids, f.Attrs.GenerateUUID())
+		if _, err = w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+		// Each POST closed is remembered once, as it is closed
+		if got, want := hs.Suppress.Len(), (i+1)/2*2; got != want {
+			t.Errorf("after %d Files: expecting %d remembered, got %d", i+1, want, got)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := hs.Suppress.Len(); got != 7 {
+		t.Errorf("expecting 7 remembered, got %d", got)
+	}
+
+	w = hs.NewHTTPPostWriter()
+	f := flowfile.New(strings.NewReader("abc"), 3)
+	f.Attrs.Set("uuid", uuids[0])
+	if _, err = w.Write(f); !errors.Is(err, flowfile.ErrorSuppressed) {
+		t.Errorf("expecting ErrorSuppressed, got %v", err)
+	}
+	w.Close()
+
+	// Once the window has passed on the Clock, the File goes out again
+	clk.Advance(time.Hour)
+	w = hs.NewHTTPPostWriter()
+	f = flowfile.New(strings.NewReader("abc"), 3)
+	f.Attrs.Set("uuid", uuids[0])
+	if _, err = w.Write(f); err != nil {
+		t.Errorf("expecting the File sent after the window, got %v", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendDeadLetter(t *testing.T) {
+	var posts int
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		posts++
+		return errors.New("disk full")
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dead int
+	hs.OnDeadLetter = func(ff []*flowfile.File, err error) { dead++ }
+
+	send := func(ctx context.Context) error {
+		f := flowfile.New(strings.NewReader("abc"), 3)
+		f.Attrs.Set("filename", "abc.txt")
+		return hs.SendContext(ctx, f)
+	}
+
+	// Without retries there is no budget to exhaust
+	if err = send(context.Background()); err == nil {
+		t.Fatal("expecting the send to fail")
+	}
+	if dead != 0 {
+		t.Errorf("OnDeadLetter called without retries")
+	}
+
+	// A cancelled send is left to the caller
+	hs.RetryCount = 2
+	ctx, cancel := context.WithCancel(context.Background())
+	hs.OnRetry = func([]*flowfile.File, int, error) { cancel() }
+	if err = send(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expecting context.Canceled, got %v", err)
+	}
+	if dead != 0 {
+		t.Errorf("OnDeadLetter called for a cancelled send")
+	}
+
+	// Once every retry has failed the Files are dead letters
+	hs.OnRetry = nil
+	posts = 0
+	if err = send(context.Background()); err == nil {
+		t.Fatal("expecting the send to fail")
+	}
+	if posts != 3 || dead != 1 {
+		t.Errorf("expecting 3 attempts and one dead letter, got %d and %d", posts, dead)
+	}
+}
+
+// A Seeker which fails once the seeks allowed are used up
+type limitedSeeker struct {
+	io.ReadSeeker
+	seeks int
+}
+
+func (s *limitedSeeker) Seek(offset int64, whence int) (int64, error) {
+	if s.seeks--; s.seeks < 0 {
+		return 0, errors.New("seek failed")
+	}
+	return s.ReadSeeker.Seek(offset, whence)
+}
+
+func TestSendNotResettable(t *testing.T) {
+	var posts int
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		posts++
+		io.Copy(io.Discard, f)
+		return errors.New("disk full")
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.RetryCount = 2
+
+	// A stream cannot be retried, so it is refused before the first attempt
+	f := flowfile.New(io.MultiReader(strings.NewReader("abc")), 3)
+	f.Attrs.Set("filename", "abc.txt")
+	if err = hs.Send(f); !errors.Is(err, flowfile.ErrorNotResettable) || posts != 0 {
+		t.Errorf("expecting ErrorNotResettable without a POST, got %v after %d", err, posts)
+	}
+
+	// A failed rewind keeps the error of the send, the seeks of New and the
+	// early check pass but the rewind for the retry fails
+	f = flowfile.New(&limitedSeeker{ReadSeeker: strings.NewReader("abc"), seeks: 2}, 3)
+	f.Attrs.Set("filename", "abc.txt")
+	err = hs.Send(f)
+	var se *flowfile.SendError
+	if !errors.As(err, &se) || !errors.Is(err, flowfile.ErrorNotResettable) || posts != 1 {
+		t.Errorf("expecting the send error and ErrorNotResettable after one POST, got %v after %d", err, posts)
+	}
+}
+
+func TestSendRetryAfter(t *testing.T) {
+	var mu sync.Mutex
+	var posts int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept", "application/flowfile-v3")
+		w.Header().Set("x-nifi-transfer-protocol-version", "3")
+		if r.Method != "POST" {
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if posts++; posts == 1 {
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	clk := flowfiletest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.Clock = clk
+	hs.RetryCount = 1
+	done := make(chan error, 1)
+	go func() { done <- hs.Send(stringFiles("abc")...) }()
+
+	// The retry holds off for the Retry-After of the busy receiver
+	clk.BlockUntil(1)
+	clk.Advance(4 * time.Second)
+	select {
+	case err = <-done:
+		t.Fatalf("retried before the Retry-After, %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	clk.Advance(time.Second)
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if posts != 2 {
+		t.Errorf("expecting 2 POSTs, got %d", posts)
+	}
+}
+
+func TestSendMaxRetryDuration(t *testing.T) {
+	var mu sync.Mutex
+	var posts int
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		mu.Lock()
+		defer mu.Unlock()
+		posts++
+		return errors.New("disk full")
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+
+	clk := flowfiletest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.Clock = clk
+	hs.RetryCount = 5
+	hs.RetryDelay = 10 * time.Second
+	hs.MaxRetryDuration = 25 * time.Second
+	var dead int
+	hs.OnDeadLetter = func(ff []*flowfile.File, err error) { dead++ }
+	done := make(chan error, 1)
+	go func() { done <- hs.Send(stringFiles("abc")...) }()
+
+	// The first retry is straight away, two delays fit in the budget and the
+	// third would run past it
+	for i := 0; i < 2; i++ {
+		clk.BlockUntil(1)
+		clk.Advance(hs.RetryDelay)
+	}
+	err = <-done
+	var se *flowfile.SendError
+	if !errors.As(err, &se) || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expecting the error of the last attempt, got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if posts != 4 || dead != 1 {
+		t.Errorf("expecting 4 attempts and one dead letter, got %d and %d", posts, dead)
+	}
+}
+
+func TestSendErrorBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept", "application/flowfile-v3")
+		w.Header().Set("x-nifi-transfer-protocol-version", "3")
+		if r.Method != "POST" {
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("X-Reason", "storage")
+		http.Error(w, "disk full\nretry later", http.StatusInsufficientStorage)
+	}))
+	defer ts.Close()
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(old int64) { flowfile.MaxErrorBodySize = old }(flowfile.MaxErrorBodySize)
+
+	for _, tc := range []struct {
+		max  int64
+		body string
+		msg  string
+	}{
+		{4 << 10, "disk full\nretry later\n", "code 507: disk full"},
+		{4, "disk", "code 507: disk"},
+		{0, "", "code 507"},
+	} {
+		flowfile.MaxErrorBodySize = tc.max
+		err = hs.Send(stringFiles("abc")...)
+		var se *flowfile.SendError
+		if !errors.As(err, &se) || se.StatusCode != http.StatusInsufficientStorage {
+			t.Fatalf("expecting a SendError, got %v", err)
+		}
+		if string(se.Body) != tc.body || se.Header.Get("X-Reason") != "storage" {
+			t.Errorf("max %d: expecting the body %q, got %q", tc.max, tc.body, se.Body)
+		}
+		if !strings.HasSuffix(se.Error(), tc.msg) {
+			t.Errorf("max %d: expecting the message to end %q, got %q", tc.max, tc.msg, se.Error())
+		}
+	}
+}
+
+// A RoundTripper tagging and counting the requests it carries
+type taggingTransport struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (rt *taggingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	rt.requests++
+	rt.mu.Unlock()
+	r = r.Clone(r.Context())
+	r.Header.Set("X-Mesh", "tagged")
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestSendRoundTripper(t *testing.T) {
+	var tags []string
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		tags = append(tags, r.Header.Get("X-Mesh"))
+		_, err := io.Copy(io.Discard, f)
+		return err
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+
+	rt := &taggingTransport{}
+	hs, err := flowfile.NewHTTPTransactionWithRoundTripper(ts.URL, rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt.requests != 1 {
+		t.Errorf("expecting the handshake over the RoundTripper, got %d requests", rt.requests)
+	}
+	if err = hs.Send(stringFiles("abc")...); err != nil {
+		t.Fatal(err)
+	}
+	if rt.requests != 2 || len(tags) != 1 || tags[0] != "tagged" {
+		t.Errorf("expecting the POST over the RoundTripper, got %d requests and %q", rt.requests, tags)
+	}
+
+	// Without one the default transport is used
+	hs, err = flowfile.NewHTTPTransactionWithRoundTripper(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = hs.Send(stringFiles("abc")...); err != nil || len(tags) != 2 || tags[1] != "" {
+		t.Errorf("expecting the POST over the default transport, got %q %v", tags, err)
+	}
+}
+
+func TestSendLazyHandshake(t *testing.T) {
+	rcv, rts := newReadingReceiver(t)
+	release := make(chan struct{})
+	var heads int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			heads++
+			<-release // A slow handshake
+		}
+		rcv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	var once sync.Once
+	defer once.Do(func() { close(release) }) // Not to hang the server on a failure
+	rts.Close()
+
+	hs := flowfile.NewHTTPTransactionNoHandshake(ts.URL, nil)
+	w := hs.NewHTTPPostWriter()
+	written := make(chan error, 1)
+	go func() {
+		_, err := w.Write(stringFiles("abc")[0])
+		written <- err
+	}()
+
+	// The File is taken in while the handshake is still in flight
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write held up by the handshake")
+	}
+	once.Do(func() { close(release) })
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if h := hs.Health(); heads != 1 || h.TransactionID == "" || rcv.Metrics.MetricsFlowFileTransferredCount != 1 {
+		t.Errorf("expecting one handshake and the File received, got %d %q %d", heads, h.TransactionID,
+			rcv.Metrics.MetricsFlowFileTransferredCount)
+	}
+}
+
+func TestSendConnTrace(t *testing.T) {
+	_, ts := newReadingReceiver(t)
+	hs := flowfile.NewHTTPTransactionNoHandshake(ts.URL, nil)
+	var mu sync.Mutex
+	var traces []flowfile.ConnTrace
+	hs.OnTrace = func(ct *flowfile.ConnTrace) {
+		mu.Lock()
+		defer mu.Unlock()
+		traces = append(traces, *ct)
+	}
+	if err := hs.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := hs.Send(stringFiles("abc")...); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	got := traces
+	traces = nil
+	mu.Unlock()
+	if len(got) != 2 {
+		t.Fatalf("expecting a trace for the handshake and the POST, got %+v", got)
+	}
+	addr := ts.Listener.Addr().String()
+	if ct := got[0]; ct.Method != "HEAD" || ct.Reused || ct.RemoteAddr != addr || ct.Total <= 0 || ct.Err != nil {
+		t.Errorf("expecting a new connection for the handshake, got %+v", ct)
+	}
+	if ct := got[1]; ct.Method != "POST" || !ct.Reused || ct.Connect != 0 || ct.RemoteAddr != addr {
+		t.Errorf("expecting the connection reused for the POST, got %+v", ct)
+	}
+
+	// A failed connection is traced with the error
+	ts.Close()
+	hs.Handshake()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(traces) != 1 || traces[0].Err == nil {
+		t.Errorf("expecting the failed handshake traced, got %+v", traces)
+	}
+}
+
+func TestSendClose(t *testing.T) {
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		_, err := io.Copy(io.Discard, f)
+		return err
+	})
+	closed := make(chan struct{}, 1)
+	ts := httptest.NewUnstartedServer(rcv)
+	ts.Config.ConnState = func(c net.Conn, s http.ConnState) {
+		if s == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = hs.Send(stringFiles("abc")...); err != nil {
+		t.Fatal(err)
+	}
+	hw := hs.NewHTTPPostWriter()
+	if err = hs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The idle connection is let go
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expecting the idle connection closed")
+	}
+
+	if err = hs.Close(); !errors.Is(err, flowfile.ErrorTransactionClosed) {
+		t.Errorf("expecting a second Close refused, got %v", err)
+	}
+	if err = hs.Handshake(); !errors.Is(err, flowfile.ErrorTransactionClosed) {
+		t.Errorf("expecting the handshake refused, got %v", err)
+	}
+	if err = hs.Send(stringFiles("abc")...); !errors.Is(err, flowfile.ErrorTransactionClosed) {
+		t.Errorf("expecting the send refused, got %v", err)
+	}
+	if _, err = hw.Write(stringFiles("abc")[0]); !errors.Is(err, flowfile.ErrorTransactionClosed) {
+		t.Errorf("expecting the write refused, got %v", err)
+	}
+	hw.Close()
+}
+
+func TestSendContext(t *testing.T) {
+	var stall atomic.Bool
+	release := make(chan struct{})
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		return errors.New("disk full")
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if stall.Load() {
+			<-release // An unresponsive endpoint
+			return
+		}
+		rcv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	defer close(release) // Let go of the stalled requests
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		stall bool
+		call  func(ctx context.Context) error
+	}{
+		{"handshake", true, hs.HandshakeContext},
+		{"POST", true, func(ctx context.Context) error { return hs.SendContext(ctx, stringFiles("abc")...) }},
+		{"retry delay", false, func(ctx context.Context) error {
+			hs.RetryCount, hs.RetryDelay = 3, time.Hour
+			return hs.SendContext(ctx, stringFiles("abc")...)
+		}},
+	} {
+		stall.Store(tc.stall)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		done := make(chan error, 1)
+		go func() { done <- tc.call(ctx) }()
+		select {
+		case err = <-done:
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("%s: expecting context.DeadlineExceeded, got %v", tc.name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: the deadline was not kept", tc.name)
+		}
+		cancel()
+	}
+}
+
+func TestHTTPPostWriterStreamChecksum(t *testing.T) {
+	var mu sync.Mutex
+	got := map[string]string{}
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got[f.Attrs.Get("filename")] = f.Attrs.Get("checksum")
+		_, err := io.Copy(io.Discard, f)
+		return err
+	})
+	rcv.VerifyChecksum = true
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.CheckSumType = "SHA256"
+
+	const sum = "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" // SHA256 of abc
+	readAt := flowfile.New(strings.NewReader("abc"), 3)
+	readAt.Attrs.Set("filename", "readAt.txt")
+	stream := flowfile.New(io.MultiReader(strings.NewReader("abc")), 3)
+	stream.Attrs.Set("filename", "stream.txt")
+	w := hs.NewHTTPPostWriter()
+	for _, f := range []*flowfile.File{readAt, stream} {
+		if _, err = w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The streamed File has the checksum recorded after the fact
+	tr := w.Transcript()
+	for i, inHeader := range []bool{true, false} {
+		if e := tr[i]; e.ChecksumType != "SHA256" || e.Checksum != sum || e.ChecksumInHeader != inHeader {
+			t.Errorf("%s: expecting the checksum in the header %v, got %+v", e.Filename, inHeader, e)
+		}
+	}
+	if stream.Attrs.Get("checksum") != sum || stream.Attrs.Get("checksumType") != "SHA256" {
+		t.Errorf("expecting the checksum set on the streamed File, got %v", stream.Attrs)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got["readAt.txt"] != sum || got["stream.txt"] != "" {
+		t.Errorf("expecting only the read ahead File sent with a checksum, got %v", got)
+	}
+}
+
+func TestHTTPPostWriterFlushIdle(t *testing.T) {
+	got := make(chan string, 10)
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		got <- f.Attrs.Get("filename")
+		_, err := io.Copy(io.Discard, f)
+		return err
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+	clk := flowfiletest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.Clock = clk
+
+	expect := func(name string) {
+		t.Helper()
+		select {
+		case n := <-got:
+			if n != name {
+				t.Fatalf("expecting %s flushed, got %s", name, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expecting %s flushed", name)
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case n := <-got:
+			t.Fatalf("expecting the buffer held, got %s", n)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	write := func(w *flowfile.HTTPPostWriter, name string) {
+		t.Helper()
+		if _, err := w.Write(stringFiles(name)[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Once idle the buffer is flushed
+	w := hs.NewHTTPBufferedPostWriter()
+	defer w.Close() // Not to hang the server on a failure
+	w.FlushIdle, w.FlushInterval = 10*time.Second, time.Minute
+	write(w, "a")
+	clk.BlockUntil(1)
+	clk.Advance(5 * time.Second)
+	expectNone()
+	clk.Advance(5 * time.Second)
+	expect("a.txt")
+	w.Close()
+
+	// Under sustained writes the flush waits up to the FlushInterval
+	w = hs.NewHTTPBufferedPostWriter()
+	defer w.Close()
+	w.FlushIdle, w.FlushInterval = 10*time.Second, 20*time.Second
+	write(w, "b")
+	clk.BlockUntil(1)
+	clk.Advance(5 * time.Second)
+	write(w, "c")
+	clk.Advance(5 * time.Second)
+	expectNone()
+	clk.Advance(5 * time.Second)
+	write(w, "d")
+	clk.Advance(5 * time.Second)
+	expect("b.txt")
+	expect("c.txt")
+	expect("d.txt")
+	w.Close()
+
+	// A full buffer goes out right away
+	w = hs.NewHTTPBufferedPostWriter()
+	defer w.Close()
+	w.FlushIdle, w.FlushInterval, w.BufferSize = time.Minute, time.Minute, 16
+	write(w, "a long name for the File to fill the buffer")
+	expect("a long name for the File to fill the buffer.txt")
+	w.Close()
+}
+
+func TestHTTPPostWriterDeadline(t *testing.T) {
+	release, posted := make(chan struct{}), make(chan struct{}, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept", "application/flowfile-v3")
+		w.Header().Set("x-nifi-transfer-protocol-version", "3")
+		if r.Method == "POST" {
+			posted <- struct{}{}
+			<-release // A stalled receiver, not reading the body
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A done context fails the Write up front
+	w := hs.NewHTTPPostWriter()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.SetContext(ctx)
+	if _, err = w.Write(stringFiles("abc")[0]); !errors.Is(err, context.Canceled) {
+		t.Errorf("expecting context.Canceled, got %v", err)
+	}
+
+	// A deadline which passed with nothing written fails the Close
+	w = hs.NewHTTPPostWriter()
+	w.SetDeadline(time.Now().Add(-time.Second))
+	if err = w.Close(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expecting context.DeadlineExceeded from the Close, got %v", err)
+	}
+
+	// The deadline tears down a Write blocked on the stalled receiver, the
+	// deadline is only reached once the receiver has the POST
+	w = hs.NewHTTPPostWriter()
+	expire := newManualDeadline()
+	w.SetContext(expire)
+	dat := make([]byte, 32<<20)
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write(flowfile.New(bytes.NewReader(dat), int64(len(dat))))
+		done <- err
+	}()
+	<-posted
+	expire.expire()
+	select {
+	case err = <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expecting context.DeadlineExceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the Write was not torn down at the deadline")
+	}
+	if err = w.Close(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expecting the POST to have failed with the deadline, got %v", err)
+	}
+}
+
+// A context reaching its deadline only when expire is called
+type manualDeadline struct {
+	context.Context
+	once sync.Once
+	done chan struct{}
+}
+
+func newManualDeadline() *manualDeadline {
+	return &manualDeadline{Context: context.Background(), done: make(chan struct{})}
+}
+
+func (d *manualDeadline) expire()               { d.once.Do(func() { close(d.done) }) }
+func (d *manualDeadline) Done() <-chan struct{} { return d.done }
+func (d *manualDeadline) Err() error {
+	select {
+	case <-d.done:
+		return context.DeadlineExceeded
+	default:
+		return nil
+	}
+}
+
+func TestHTTPPostWriterRollover(t *testing.T) {
+	var mu sync.Mutex
+	var posts []int
+	rcv := flowfile.NewHTTPReceiver(func(s *flowfile.Scanner, w http.ResponseWriter, r *http.Request) {
+		var n int
+		for s.Scan() {
+			io.Copy(io.Discard, s.File())
+			n++
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		posts = append(posts, n)
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dat := strings.Repeat("x", 1000) // About 1030 bytes with the header
+	for _, tc := range []struct {
+		name   string
+		setup  func(w *flowfile.HTTPPostWriter)
+		writes int
+		want   []int
+	}{
+		{"files", func(w *flowfile.HTTPPostWriter) { w.MaxFilesPerPost = 2 }, 5, []int{2, 2, 1}},
+		{"bytes", func(w *flowfile.HTTPPostWriter) { w.MaxBytesPerPost = 2500 }, 4, []int{3, 1}},
+		{"exact", func(w *flowfile.HTTPPostWriter) { w.MaxFilesPerPost = 2 }, 4, []int{2, 2}},
+	} {
+		mu.Lock()
+		posts = nil
+		mu.Unlock()
+		w := hs.NewHTTPPostWriter()
+		tc.setup(w)
+		for i := 0; i < tc.writes; i++ {
+			f := flowfile.New(strings.NewReader(dat), int64(len(dat)))
+			f.Attrs.Set("filename", "x.txt")
+			if _, err = w.Write(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		if fmt.Sprint(posts) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: expecting Files per POST of %v, got %v", tc.name, tc.want, posts)
+		}
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,112 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Attribute values longer than MaxAttributeValueSize are spilled into a
+// sidecar File of kind attributes, holding the oversized attributes as JSON,
+// which is sent just ahead of the File itself.  The File then carries an
+// attributes.sidecar attribute with the uuid of the sidecar, and a Scanner
+// with Sidecars set merges the values back in on the receiving side.  A
+// value is written with a 16 bit length, where 0xFFFF marks a longer length
+// to follow, so values are kept to 65534 bytes.  Leaving this at 0 has a File
+// with a longer value refused with ErrorAttributeSize, and a receiver which
+// does not merge sidecars would hand the sidecar on as a File of its own.
+var MaxAttributeValueSize = 0
+
+// MaxSidecarSize is the largest sidecar a Scanner will hold in memory, a
+// larger one stops the scan with ErrorInvalidSidecar.
+var MaxSidecarSize int64 = 16 << 20
+
+// The attribute marking a File as a sidecar, with the version of the format
+const sidecarMarker = "attributes.sidecar.version"
+
+var ErrorInvalidSidecar = errors.New("Invalid attribute sidecar")
+
+// Is the File a sidecar, rather than a File which happens to be of kind
+// attributes
+func isSidecar(f *File) bool {
+	return f.Attrs.Get("kind") == "attributes" && f.Attrs.Get(sidecarMarker) == "1"
+}
+
+// Split out the oversized attributes into a sidecar File, returning nil when
+// all the attributes fit.
+func splitSidecar(h Attributes) (sidecar *File, rest Attributes) {
+	limit := MaxAttributeValueSize
+	if limit <= 0 {
+		return nil, nil
+	}
+	if limit > 0xFFFE {
+		limit = 0xFFFE
+	}
+	var big Attributes
+	for _, a := range h {
+		if len(a.Value) > limit {
+			big = append(big, a)
+		} else {
+			rest = append(rest, a)
+		}
+	}
+	if len(big) == 0 {
+		return nil, nil
+	}
+
+	dat, _ := big.MarshalJSON()
+	sidecar = New(bytes.NewReader(dat), int64(len(dat)))
+	sidecar.Attrs.Set("kind", "attributes")
+	sidecar.Attrs.Set("mime.type", "application/json")
+	sidecar.Attrs.Set(sidecarMarker, "1")
+	rest.Set("attributes.sidecar", sidecar.Attrs.GenerateUUID())
+	return
+}
+
+// Read in a sidecar File and hold the attributes for the File following it.
+// Only one sidecar is held at a time, as each is sent just ahead of the File
+// referencing it.
+func (r *Scanner) readSidecar(f *File) error {
+	if r.sidecar != nil {
+		return fmt.Errorf("%w: %q sent without a File", ErrorInvalidSidecar, r.sidecarID)
+	}
+	if f.Size < 0 || f.Size > MaxSidecarSize {
+		return fmt.Errorf("%w: %d bytes", ErrorInvalidSidecar, f.Size)
+	}
+	dat, err := io.ReadAll(f)
+	if cerr := f.Close(); err == nil && cerr != io.EOF {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	var attrs Attributes
+	if err = attrs.UnmarshalJSON(dat); err != nil {
+		return fmt.Errorf("%w: %s", ErrorInvalidSidecar, err)
+	}
+	r.sidecar, r.sidecarID = attrs, f.Attrs.Get("uuid")
+	return nil
+}
+
+// Merge in the attributes from the sidecar a File references, a held sidecar
+// the File does not reference is dropped.
+func (r *Scanner) mergeSidecar(f *File) error {
+	attrs, held := r.sidecar, r.sidecarID
+	r.sidecar, r.sidecarID = nil, ""
+	id := f.Attrs.Get("attributes.sidecar")
+	if id == "" {
+		if attrs != nil {
+			r.debugln("Dropping unreferenced sidecar", held)
+		}
+		return nil
+	}
+	if attrs == nil || id != held {
+		return fmt.Errorf("%w: %q not found", ErrorInvalidSidecar, id)
+	}
+	f.Attrs.Unset("attributes.sidecar")
+	for _, a := range attrs {
+		f.Attrs.Add(a.Name, a.Value) // Keep any repeated names
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+// Write the Files to a stream
+func writeStream(t *testing.T, ff ...*flowfile.File) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w := flowfile.NewWriter(buf)
+	for _, f := range ff {
+		if _, err := w.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf
+}
+
+// Scan the Files from a stream, returning the attributes of each
+func scanStream(buf *bytes.Buffer, sidecars bool) (out []flowfile.Attributes, err error) {
+	s := flowfile.NewScanner(buf)
+	s.Sidecars = sidecars
+	for s.Scan() {
+		out = append(out, s.File().Attrs)
+	}
+	return out, s.Err()
+}
+
+// A File of kind attributes with JSON content, marked as a sidecar or not
+func attributesFile(uuid, json string, marked bool) *flowfile.File {
+	f := flowfile.New(strings.NewReader(json), int64(len(json)))
+	f.Attrs.Set("kind", "attributes")
+	f.Attrs.Set("uuid", uuid)
+	if marked {
+		f.Attrs.Set("attributes.sidecar.version", "1")
+	}
+	return f
+}
+
+func TestSidecarRoundTrip(t *testing.T) {
+	defer func(old int) { flowfile.MaxAttributeValueSize = old }(flowfile.MaxAttributeValueSize)
+	flowfile.MaxAttributeValueSize = 0xFFFF
+
+	big := strings.Repeat("x", 0x10000)
+	f := flowfile.New(strings.NewReader("abc"), 3)
+	f.Attrs.Set("filename", "a.txt")
+	f.Attrs.Set("signature", big)
+
+	out, err := scanStream(writeStream(t, f), true)
+	if err != nil || len(out) != 1 {
+		t.Fatalf("expecting 1 File, got %d %v", len(out), err)
+	}
+	if out[0].Get("signature") != big || out[0].Get("attributes.sidecar") != "" {
+		t.Errorf("sidecar not merged: %d bytes of signature", len(out[0].Get("signature")))
+	}
+
+	// Without merging, the sidecar is a File of its own
+	f = flowfile.New(strings.NewReader("abc"), 3)
+	f.Attrs.Set("signature", big)
+	if out, err = scanStream(writeStream(t, f), false); err != nil || len(out) != 2 {
+		t.Fatalf("expecting 2 Files, got %d %v", len(out), err)
+	}
+	if out[0].Get("kind") != "attributes" || out[1].Get("attributes.sidecar") == "" {
+		t.Errorf("expecting the sidecar ahead of the File, got %v", out)
+	}
+}
+
+func TestSidecarOptIn(t *testing.T) {
+	if flowfile.MaxAttributeValueSize != 0 {
+		t.Fatalf("sidecars are on by default, MaxAttributeValueSize %d", flowfile.MaxAttributeValueSize)
+	}
+	f := flowfile.New(strings.NewReader("abc"), 3)
+	f.Attrs.Set("note", strings.Repeat("x", 0x100))
+	if out, err := scanStream(writeStream(t, f), true); err != nil || len(out) != 1 {
+		t.Errorf("expecting 1 File, got %d %v", len(out), err)
+	}
+}
+
+func TestSidecarEscapeLength(t *testing.T) {
+	defer func(old int) { flowfile.MaxAttributeValueSize = old }(flowfile.MaxAttributeValueSize)
+	flowfile.MaxAttributeValueSize = 0xFFFF
+
+	// A length of 0xFFFF marks an extended length, so such a value is spilled
+	for size, want := range map[int]int{0xFFFE: 1, 0xFFFF: 2} {
+		f := flowfile.New(strings.NewReader("abc"), 3)
+		f.Attrs.Set("signature", strings.Repeat("x", size))
+		if out, err := scanStream(writeStream(t, f), false); err != nil || len(out) != want {
+			t.Errorf("%d byte value, expecting %d Files, got %d %v", size, want, len(out), err)
+		}
+	}
+}
+
+func TestSidecarOffRefused(t *testing.T) {
+	f := flowfile.New(strings.NewReader("abc"), 3)
+	f.Attrs.Set("signature", strings.Repeat("x", 0xFFFF))
+	var buf bytes.Buffer
+	if _, err := flowfile.NewWriter(&buf).Write(f); !errors.Is(err, flowfile.ErrorAttributeSize) {
+		t.Errorf("expecting ErrorAttributeSize without sidecars, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expecting nothing written, got %d bytes", buf.Len())
+	}
+}
+
+func TestSidecarUnmarked(t *testing.T) {
+	// A File of kind attributes without the marker is an ordinary File
+	a := attributesFile("1", `{"filename":"taken-over"}`, false)
+	b := flowfile.New(strings.NewReader("abc"), 3)
+	b.Attrs.Set("filename", "b.txt")
+	b.Attrs.Set("attributes.sidecar", "1")
+	out, err := scanStream(writeStream(t, a, b), true)
+	if !errors.Is(err, flowfile.ErrorInvalidSidecar) {
+		t.Errorf("expecting ErrorInvalidSidecar for the reference, got %v", err)
+	}
+	if len(out) != 1 || out[0].Get("kind") != "attributes" {
+		t.Errorf("expecting the unmarked File to be handed out, got %v", out)
+	}
+}
+
+func TestSidecarLimits(t *testing.T) {
+	defer func(old int64) { flowfile.MaxSidecarSize = old }(flowfile.MaxSidecarSize)
+	flowfile.MaxSidecarSize = 16
+
+	a := attributesFile("1", `{"signature":"too long for the limit"}`, true)
+	if _, err := scanStream(writeStream(t, a), true); !errors.Is(err, flowfile.ErrorInvalidSidecar) {
+		t.Errorf("expecting ErrorInvalidSidecar over MaxSidecarSize, got %v", err)
+	}
+
+	// Only one sidecar is held at a time
+	a = attributesFile("1", `{"a":"1"}`, true)
+	b := attributesFile("2", `{"b":"2"}`, true)
+	if _, err := scanStream(writeStream(t, a, b), true); !errors.Is(err, flowfile.ErrorInvalidSidecar) {
+		t.Errorf("expecting ErrorInvalidSidecar for back to back sidecars, got %v", err)
+	}
+
+	// A sidecar the next File does not reference is dropped
+	a = attributesFile("1", `{"a":"1"}`, true)
+	c := flowfile.New(strings.NewReader("abc"), 3)
+	c.Attrs.Set("filename", "c.txt")
+	d := flowfile.New(strings.NewReader("abc"), 3)
+	d.Attrs.Set("attributes.sidecar", "1")
+	out, err := scanStream(writeStream(t, a, c, d), true)
+	if len(out) != 1 || out[0].Get("a") != "" || !errors.Is(err, flowfile.ErrorInvalidSidecar) {
+		t.Errorf("expecting the sidecar dropped after c.txt, got %v %v", out, err)
+	}
+}
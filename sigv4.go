@@ -0,0 +1,160 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Signer adds authentication to the HEAD and POST requests of an
+// HTTPTransaction, it is called once all the other headers have been set.
+type Signer interface {
+	Sign(*http.Request) error
+}
+
+// SigV4Signer signs requests with AWS Signature Version 4, so FlowFiles can be
+// sent to endpoints behind an API Gateway or ALB requiring IAM auth.  As the
+// POST bodies are streamed, the payload is not signed and is sent with the
+// UNSIGNED-PAYLOAD content hash.
+//
+//   hs.Signer = flowfile.NewSigV4SignerFromEnv("us-east-1", "execute-api")
+type SigV4Signer struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // for temporary credentials
+
+	Region  string // such as "us-east-1"
+	Service string // such as "execute-api" or "s3"
+
+	// When set, the credentials are fetched for each request, so rotating
+	// credentials can be used.  This takes precedence over the keys above.
+	Credentials func() (accessKeyID, secretAccessKey, sessionToken string, err error)
+
+	// The source of time for the signatures, defaults to the DefaultClock.
+	Clock Clock
+}
+
+// NewSigV4SignerFromEnv creates a SigV4Signer with the credentials from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment
+// variables.  When region is empty, AWS_REGION is used.
+func NewSigV4SignerFromEnv(region, service string) *SigV4Signer {
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	return &SigV4Signer{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Region:          region,
+		Service:         service,
+	}
+}
+
+// Sign the request, setting the X-Amz-Date and Authorization headers.
+func (s *SigV4Signer) Sign(req *http.Request) error {
+	ak, sk, token := s.AccessKeyID, s.SecretAccessKey, s.SessionToken
+	if s.Credentials != nil {
+		var err error
+		if ak, sk, token, err = s.Credentials(); err != nil {
+			return err
+		}
+	}
+	if ak == "" || sk == "" {
+		return ErrorSignerCredentials
+	}
+	if token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	s.sign(req, ak, sk, clockOr(s.Clock).Now(), "UNSIGNED-PAYLOAD")
+	return nil
+}
+
+func (s *SigV4Signer) sign(req *http.Request, ak, sk string, now time.Time, payloadHash string) {
+	now = now.UTC()
+	amzDate, date := now.Format("20060102T150405Z"), now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	// Sign the host, the content type and all the x-amz headers
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") || k == "content-type" {
+			for i := range v {
+				v[i] = strings.Join(strings.Fields(v[i]), " ")
+			}
+			headers[k] = strings.Join(v, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if s.Service != "s3" {
+		// All but S3 expect the path segments to be encoded twice
+		path = sigv4Escape(path, false)
+	}
+
+	var query []string
+	for k, vs := range req.URL.Query() {
+		for _, v := range vs {
+			query = append(query, sigv4Escape(k, true)+"="+sigv4Escape(v, true))
+		}
+	}
+	sort.Strings(query)
+
+	canonical := strings.Join([]string{req.Method, path, strings.Join(query, "&"),
+		canonHeaders.String(), signed, payloadHash}, "\n")
+	scope := date + "/" + s.Region + "/" + s.Service + "/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + sk)
+	for _, part := range []string{date, s.Region, s.Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		ak, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// Escape all but the unreserved characters, and the slashes in a path
+func sigv4Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
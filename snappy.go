@@ -0,0 +1,30 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/s2"
+)
+
+func init() {
+	RegisterCodec(NewSnappyCodec())
+}
+
+// NewSnappyCodec creates a Codec for the Snappy framing format, which trades
+// wire savings for very low CPU cost, for latency sensitive flows where gzip
+// and zstd are too slow.  Blocks are kept to 64KB so a flushed POST is not
+// held up building large blocks.
+func NewSnappyCodec() Codec {
+	return Codec{
+		Name: "snappy",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return s2.NewWriter(w, s2.WriterSnappyCompat(), s2.WriterConcurrency(1),
+				s2.WriterBlockSize(64<<10)), nil
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(s2.NewReader(r)), nil
+		},
+		MimeType:  "application/x-snappy-framed",
+		Extension: ".sz",
+	}
+}
@@ -0,0 +1,108 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Sparse files, such as VM images and database preallocations, are sent with
+// a sparse.extents attribute listing the ranges holding data as
+// "offset:length" pairs separated by commas.  The holes are still sent as
+// zeros, as the content of a FlowFile is always whole, but Save passes over
+// the zeros within the holes so the saved file is sparse again.
+
+// Format the data extents for the sparse.extents attribute
+func formatExtents(extents []byteRange) string {
+	if len(extents) == 0 {
+		return "0:0" // All hole
+	}
+	parts := make([]string, len(extents))
+	for i, e := range extents {
+		parts[i] = fmt.Sprintf("%d:%d", e.start, e.end-e.start)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Parse the sparse.extents attribute into the holes between the data extents,
+// returning nil when the attribute is missing or not valid for the size.
+func parseHoles(attr string, size int64) (holes []byteRange) {
+	if attr == "" {
+		return nil
+	}
+	var last int64
+	for _, part := range strings.Split(attr, ",") {
+		o, l, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil
+		}
+		off, err1 := strconv.ParseInt(o, 10, 64)
+		n, err2 := strconv.ParseInt(l, 10, 64)
+		if err1 != nil || err2 != nil || off < last || n < 0 || off+n > size {
+			return nil
+		}
+		if n == 0 {
+			continue
+		}
+		if off > last {
+			holes = append(holes, byteRange{last, off})
+		}
+		last = off + n
+	}
+	if last < size {
+		holes = append(holes, byteRange{last, size})
+	}
+	return
+}
+
+// sparseWriter writes at offsets, passing over the zeros within the holes so
+// they stay unallocated in an output file which is already of full size.
+type sparseWriter struct {
+	w     io.WriterAt
+	off   int64
+	holes []byteRange
+}
+
+func (s *sparseWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		// Find how much of p is in or out of the next hole
+		take, inHole := int64(len(p)), false
+		for _, h := range s.holes {
+			if s.off < h.start {
+				if take > h.start-s.off {
+					take = h.start - s.off
+				}
+				break
+			}
+			if s.off < h.end {
+				inHole = true
+				if take > h.end-s.off {
+					take = h.end - s.off
+				}
+				break
+			}
+		}
+		if !inHole || !isZeros(p[:take]) {
+			var nw int
+			nw, err = s.w.WriteAt(p[:take], s.off)
+			take = int64(nw)
+		}
+		n += int(take)
+		s.off += take
+		p = p[take:]
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func isZeros(p []byte) bool {
+	for _, b := range p {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package flowfile
+
+import "os"
+
+func maybeSparse(fi os.FileInfo) bool { return false }
+
+func dataExtents(filename string, size int64) ([]byteRange, bool) { return nil, false }
@@ -0,0 +1,8 @@
+//go:build linux || freebsd || dragonfly
+
+package flowfile
+
+const (
+	seekData = 3
+	seekHole = 4
+)
@@ -0,0 +1,6 @@
+package flowfile
+
+const (
+	seekHole = 3
+	seekData = 4
+)
@@ -0,0 +1,46 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package flowfile
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Quick check on the allocated blocks, to avoid opening files which are not
+// sparse.
+func maybeSparse(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int64(st.Blocks)*512 < fi.Size()
+}
+
+// Find the data extents of a file with SEEK_DATA and SEEK_HOLE, returning ok
+// as false when the filesystem cannot tell.
+func dataExtents(filename string, size int64) (extents []byteRange, ok bool) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, false
+	}
+	defer fh.Close()
+	for off := int64(0); off < size; {
+		start, err := fh.Seek(off, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			break // No more data
+		} else if err != nil {
+			return nil, false
+		}
+		end, err := fh.Seek(start, seekHole)
+		if err != nil {
+			return nil, false
+		}
+		if end > size {
+			end = size
+		}
+		if end > start {
+			extents = append(extents, byteRange{start, end})
+		}
+		off = end
+	}
+	return extents, true
+}
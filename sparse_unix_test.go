@@ -0,0 +1,62 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package flowfile_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestSaveSparse(t *testing.T) {
+	// A file with data at 1M in a 4M hole
+	const size = 4 << 20
+	src := filepath.Join(t.TempDir(), "disk.img")
+	fh, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fh.WriteAt([]byte("data"), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	if err = fh.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	fh.Close()
+
+	f, err := flowfile.NewFromDisk(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	extents := f.Attrs.Get("sparse.extents")
+	if extents == "" {
+		t.Skip("the filesystem does not report holes")
+	}
+	if err = f.AddChecksum("SHA256"); err != nil {
+		t.Fatal(err)
+	}
+
+	saver := flowfile.NewSaver(t.TempDir())
+	out, err := saver.Save(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	want := make([]byte, size)
+	copy(want[1<<20:], "data")
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("saved content does not match, %d bytes, %v", len(got), err)
+	}
+	fi, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := fi.Sys().(*syscall.Stat_t); int64(st.Blocks)*512 >= size {
+		t.Errorf("expecting the holes of %s left unallocated, %d blocks allocated", extents, st.Blocks)
+	}
+}
@@ -0,0 +1,88 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// SpoolMemoryLimit is the size up to which SendStream holds a stream in
+// memory, longer streams are spooled to a temporary file.
+var SpoolMemoryLimit int64 = 1 << 20
+
+// SpoolStream reads a stream of unknown length, such as stdin or a pipe, until
+// EOF and returns it as a File with the size known, as the flowfile-v3 header
+// requires.  Streams up to memoryLimit bytes are held in memory, longer ones
+// are spooled to a temporary file in tempDir (os.TempDir() when empty).  The
+// content is a ReaderAt, so checksums can be added and the File segmented.
+//
+// The cleanup function, when not nil, must be called to remove the temporary
+// file once the File has been sent.
+func SpoolStream(r io.Reader, memoryLimit int64, tempDir string) (f *File, cleanup func() error, err error) {
+	content, size, cleanup, err := spoolAll(r, memoryLimit, tempDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return New(content, size), cleanup, nil
+}
+
+// Spool the stream until EOF, the content returned is also a ReaderAt
+func spoolAll(r io.Reader, memoryLimit int64, tempDir string) (content io.Reader, size int64, cleanup func() error, err error) {
+	buf := &bytes.Buffer{}
+	var n int64
+	if n, err = io.CopyN(buf, r, memoryLimit+1); err == io.EOF {
+		return bytes.NewReader(buf.Bytes()), n, nil, nil
+	} else if err != nil {
+		return
+	}
+
+	// Over the limit, move what has been read so far to disk and continue
+	var fh *os.File
+	if fh, err = os.CreateTemp(tempDir, "flowfile-spool-*"); err != nil {
+		return
+	}
+	cleanup = func() error {
+		fh.Close()
+		return os.Remove(fh.Name())
+	}
+	var rest int64
+	if _, err = fh.Write(buf.Bytes()); err == nil {
+		rest, err = copyBuffer(fh, r)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return io.NewSectionReader(fh, 0, n+rest), n+rest, cleanup, nil
+}
+
+// SendStream sends the content of a stream of unknown length with the given
+// attributes, by spooling it with SpoolStream and SpoolMemoryLimit first.
+// When segmentSize is set, the content is sent as segments of that size, so a
+// dropped connection only has to replay a segment.
+//
+//   err := hs.SendStream(os.Stdin, attrs, 10<<20)
+func (hs *HTTPTransaction) SendStream(r io.Reader, attrs Attributes, segmentSize int64) error {
+	f, cleanup, err := SpoolStream(r, SpoolMemoryLimit, "")
+	if err != nil {
+		return err
+	}
+	if cleanup != nil {
+		defer cleanup()
+	}
+	f.Attrs = attrs.Clone()
+
+	ff := []*File{f}
+	if segmentSize > 0 && f.Size > segmentSize {
+		if cksum := hs.session().checkSumType; cksum != "" {
+			// Give the segments the checksum of the whole stream
+			if err = f.AddChecksum(cksum); err != nil {
+				return err
+			}
+		}
+		if ff, err = SegmentBySize(f, segmentSize); err != nil {
+			return err
+		}
+	}
+	return hs.Send(ff...)
+}
@@ -0,0 +1,79 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/flowfiletest"
+)
+
+func TestSpoolStream(t *testing.T) {
+	dir := t.TempDir()
+	dat := make([]byte, 3000)
+	rand.New(rand.NewSource(1)).Read(dat)
+	for _, tc := range []struct {
+		name    string
+		limit   int64
+		spooled bool
+	}{
+		{"in memory", 3000, false},
+		{"spooled", 1000, true},
+	} {
+		// A MultiReader hides the length of the stream
+		f, cleanup, err := flowfile.SpoolStream(io.MultiReader(bytes.NewReader(dat)), tc.limit, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		temps, _ := filepath.Glob(filepath.Join(dir, "*"))
+		if (cleanup != nil) != tc.spooled || len(temps) > 0 != tc.spooled {
+			t.Errorf("%s: expecting spooled to disk %v, got %d files", tc.name, tc.spooled, len(temps))
+		}
+		if err = f.AddChecksum("SHA256"); err != nil {
+			t.Errorf("%s: expecting a checksum can be added, got %v", tc.name, err)
+		}
+		if got, _ := io.ReadAll(f); f.Size != 3000 || !bytes.Equal(got, dat) {
+			t.Errorf("%s: expecting the content back, got %d of %d bytes", tc.name, len(got), f.Size)
+		}
+		if cleanup != nil {
+			cleanup()
+		}
+		if temps, _ = filepath.Glob(filepath.Join(dir, "*")); len(temps) > 0 {
+			t.Errorf("%s: expecting the spool removed, got %q", tc.name, temps)
+		}
+	}
+}
+
+func TestSendStream(t *testing.T) {
+	defer func(old int64) { flowfile.SpoolMemoryLimit = old }(flowfile.SpoolMemoryLimit)
+	flowfile.SpoolMemoryLimit = 4
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	srv := flowfiletest.NewServer()
+	defer srv.Close()
+	hs, err := srv.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var attrs flowfile.Attributes
+	attrs.Set("filename", "stdin")
+	if err = hs.SendStream(strings.NewReader("0123456789"), attrs, 4); err != nil {
+		t.Fatal(err)
+	}
+	srv.AssertReceived(t, 3)
+	for i, want := range []string{"0123", "4567", "89"} {
+		srv.AssertContent(t, i, want)
+		srv.AssertAttribute(t, i, "segment.original.filename", "stdin")
+	}
+	if files := srv.Files(); len(files) > 0 && files[0].Attrs.Get("checksum") == "" {
+		t.Errorf("expecting the segments to carry the checksum of the stream")
+	}
+	if temps, _ := filepath.Glob(filepath.Join(tmp, "*")); len(temps) > 0 {
+		t.Errorf("expecting the spool removed, got %q", temps)
+	}
+}
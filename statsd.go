@@ -0,0 +1,93 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// StatsDSink pushes the metric events to a StatsD or DogStatsD endpoint over
+// UDP, for setups without a Prometheus pull infrastructure.  Durations, the
+// histograms ending in _seconds, are sent as timers in milliseconds.
+//
+//   sink, err := flowfile.NewStatsDSink("127.0.0.1:8125")
+//   sink.DogStatsD = true
+//   hs.MetricsSink = sink
+type StatsDSink struct {
+	// Prepended to every metric name, such as "myapp."
+	Prefix string
+
+	// Send the labels as DogStatsD tags, otherwise the label values are
+	// appended to the metric name as dotted parts.
+	DogStatsD bool
+
+	// Called when a packet cannot be sent, as the events are fire and forget.
+	OnError func(error)
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// Create a new StatsDSink sending to the given host:port.
+func NewStatsDSink(addr string) (*StatsDSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsDSink{conn: conn}, nil
+}
+
+func (s *StatsDSink) Counter(name string, value float64, labels ...string) {
+	s.send(name, fmt.Sprintf("%g|c", value), labels)
+}
+
+func (s *StatsDSink) Gauge(name string, value float64, labels ...string) {
+	s.send(name, fmt.Sprintf("%g|g", value), labels)
+}
+
+func (s *StatsDSink) Observe(name string, value float64, labels ...string) {
+	if strings.HasSuffix(name, "_seconds") {
+		s.send(strings.TrimSuffix(name, "_seconds"), fmt.Sprintf("%g|ms", value*1e3), labels)
+		return
+	}
+	kind := "ms"
+	if s.DogStatsD {
+		kind = "h"
+	}
+	s.send(name, fmt.Sprintf("%g|%s", value, kind), labels)
+}
+
+// Close the connection to the endpoint.
+func (s *StatsDSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.Close()
+}
+
+func (s *StatsDSink) send(name, value string, labels []string) {
+	var tags []string
+	for i := 1; i < len(labels); i += 2 {
+		if s.DogStatsD {
+			tags = append(tags, statsdClean(labels[i-1])+":"+statsdClean(labels[i]))
+		} else {
+			name += "." + statsdClean(labels[i])
+		}
+	}
+	line := s.Prefix + statsdClean(name) + ":" + value
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+
+	s.mu.Lock()
+	_, err := s.conn.Write([]byte(line))
+	s.mu.Unlock()
+	if err != nil && s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+// Replace the characters with special meaning in the line protocol
+var statsdReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", "\n", "_")
+
+func statsdClean(v string) string { return statsdReplacer.Replace(v) }
@@ -0,0 +1,64 @@
+package flowfile_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestStatsDSink(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	recv := func() string {
+		t.Helper()
+		buf := make([]byte, 512)
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	sink, err := flowfile.NewStatsDSink(pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	sink.Prefix = "app."
+
+	for _, tc := range []struct {
+		dog  bool
+		emit func()
+		want string
+	}{
+		{false, func() { sink.Counter("flowfiles_sent_total", 2, "code", "200") }, "app.flowfiles_sent_total.200:2|c"},
+		{false, func() { sink.Gauge("flowfiles_queued", 3) }, "app.flowfiles_queued:3|g"},
+		{false, func() { sink.Observe("flowfiles_post_duration_seconds", 0.25) }, "app.flowfiles_post_duration:250|ms"},
+		{false, func() { sink.Observe("flowfiles_size_bytes", 10) }, "app.flowfiles_size_bytes:10|ms"},
+		{true, func() { sink.Observe("flowfiles_size_bytes", 10) }, "app.flowfiles_size_bytes:10|h"},
+		{true, func() { sink.Counter("flowfiles_rejects_total", 1, "reason", "a:b|c", "code", "406") },
+			"app.flowfiles_rejects_total:1|c|#reason:a_b_c,code:406"},
+		{false, func() { sink.Counter("flowfiles_rejects_total", 1, "reason", "a:b") }, "app.flowfiles_rejects_total.a_b:1|c"},
+	} {
+		sink.DogStatsD = tc.dog
+		tc.emit()
+		if got := recv(); got != tc.want {
+			t.Errorf("expecting %q, got %q", tc.want, got)
+		}
+	}
+
+	// Failures are handed to OnError
+	var failed error
+	sink.OnError = func(err error) { failed = err }
+	sink.Close()
+	sink.Gauge("flowfiles_queued", 1)
+	if failed == nil {
+		t.Errorf("expecting the write on a closed sink reported")
+	}
+}
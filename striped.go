@@ -0,0 +1,78 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"context"
+	"sync"
+)
+
+// SendStriped sends a large File as segments of segmentSize, POSTed
+// concurrently over up to stripes connections to the same receiver, to get
+// past the throughput ceiling of a single TCP stream.  The receiver puts the
+// segments back together, as with a Saver and its Assembler.  When stripes is
+// zero, 4 are used.
+//
+// The File needs a ReadAt reader, such as a File from NewFromDisk, and when
+// CheckSumType is set the checksum of the whole File is added first so the
+// reassembled File can be verified.  Each segment is retried as with Send, and
+// once a segment has failed the segments not yet started are abandoned and the
+// first error is returned.
+func (hs *HTTPTransaction) SendStriped(f *File, segmentSize int64, stripes int) error {
+	return hs.SendStripedContext(context.Background(), f, segmentSize, stripes)
+}
+
+// SendStripedContext is like SendStriped, with the POSTs bound to the context.
+// When the context is done before every segment is sent, its error is
+// returned.
+func (hs *HTTPTransaction) SendStripedContext(ctx context.Context, f *File, segmentSize int64, stripes int) (err error) {
+	if stripes <= 0 {
+		stripes = 4
+	}
+	if cksum := hs.session().checkSumType; cksum != "" && f.Attrs.Get("checksumType") == "" {
+		if err = f.AddChecksum(cksum); err != nil {
+			return
+		}
+	}
+	segments, err := SegmentBySize(f, segmentSize)
+	if err != nil {
+		return
+	}
+	if len(segments) < stripes {
+		stripes = len(segments)
+	}
+
+	sendCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		next    = make(chan *File)
+	)
+	for i := 0; i < stripes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seg := range next {
+				if serr := hs.SendContext(sendCtx, seg); serr != nil {
+					errOnce.Do(func() { err = serr })
+					cancel()
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, seg := range segments {
+		select {
+		case next <- seg:
+		case <-sendCtx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	if err == nil {
+		// The segments not yet started were abandoned
+		err = ctx.Err()
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package flowfile_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+	"github.com/pschou/go-flowfile/flowfiletest"
+)
+
+func TestSendStriped(t *testing.T) {
+	fsys := flowfile.NewMemFS()
+	saver := flowfile.NewSaver("data")
+	saver.FS = fsys
+	saver.Assembler = flowfile.NewAssembler()
+	var posts int32
+	rcv := flowfile.NewHTTPFileReceiver(func(f *flowfile.File, w http.ResponseWriter, r *http.Request) error {
+		atomic.AddInt32(&posts, 1)
+		_, err := saver.Save(f)
+		return err
+	})
+	ts := httptest.NewServer(rcv)
+	defer ts.Close()
+
+	dat := make([]byte, 100<<10)
+	rand.New(rand.NewSource(1)).Read(dat)
+	f := flowfile.New(bytes.NewReader(dat), int64(len(dat)))
+	f.Attrs.Set("filename", "a.bin")
+	hs, err := flowfile.NewHTTPTransaction(ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = hs.SendStriped(f, 10<<10, 3); err != nil {
+		t.Fatal(err)
+	}
+	if posts := atomic.LoadInt32(&posts); posts != 10 {
+		t.Errorf("expecting a POST for each of the 10 segments, got %d", posts)
+	}
+	if got, err := fs.ReadFile(fsys, "data/a.bin"); err != nil || !bytes.Equal(got, dat) {
+		t.Errorf("reassembled content does not match, %d of %d bytes, %v", len(got), len(dat), err)
+	}
+}
+
+func TestSendStripedFailure(t *testing.T) {
+	srv := flowfiletest.NewServer()
+	defer srv.Close()
+	srv.FailNext(1, http.StatusInternalServerError)
+	hs, err := srv.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dat := make([]byte, 100<<10)
+	f := flowfile.New(bytes.NewReader(dat), int64(len(dat)))
+	f.Attrs.Set("filename", "a.bin")
+	if err = hs.SendStriped(f, 1<<10, 2); err == nil {
+		t.Fatal("expecting the failed segment returned")
+	}
+	if n := len(srv.Files()); n >= 100 {
+		t.Errorf("expecting the segments not yet started abandoned, got %d received", n)
+	}
+}
+
+func TestSendStripedCanceled(t *testing.T) {
+	srv := flowfiletest.NewServer()
+	defer srv.Close()
+	hs, err := srv.NewTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dat := make([]byte, 100<<10)
+	f := flowfile.New(bytes.NewReader(dat), int64(len(dat)))
+	f.Attrs.Set("filename", "a.bin")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = hs.SendStripedContext(ctx, f, 1<<10, 2); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expecting the context error returned, got %v", err)
+	}
+	if n := len(srv.Files()); n >= 100 {
+		t.Errorf("expecting the segments abandoned, got %d received", n)
+	}
+}
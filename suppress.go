@@ -0,0 +1,99 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"sync"
+	"time"
+)
+
+// A SuppressWindow remembers the Files an HTTPTransaction delivered recently,
+// so a File sent again within the Window is passed over, guarding against
+// upstream sources which emit the same Files again.  Files are known by their
+// uuid attribute, or by their checksum when ByChecksum is set.
+//
+//	hs.Suppress = flowfile.NewSuppressWindow(10 * time.Minute)
+type SuppressWindow struct {
+	Window     time.Duration
+	ByChecksum bool
+
+	// The source of time for the window, defaults to the DefaultClock.
+	Clock Clock
+
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// NewSuppressWindow creates a SuppressWindow remembering the Files delivered
+// within the window.
+func NewSuppressWindow(window time.Duration) *SuppressWindow {
+	return &SuppressWindow{Window: window, seen: make(map[string]time.Time)}
+}
+
+// The key a File is known by, empty when it has none
+func (s *SuppressWindow) key(uuid, cksumType, cksum string) string {
+	if !s.ByChecksum {
+		return uuid
+	}
+	if cksum == "" {
+		return ""
+	}
+	return cksumType + ":" + cksum
+}
+
+// Was the File delivered within the window, the checksum is added first when
+// suppressing by checksum and the File does not have one
+func (s *SuppressWindow) suppressed(f *File, cksumType string) bool {
+	if s == nil {
+		return false
+	}
+	if s.ByChecksum && f.Attrs.Get("checksumType") == "" && cksumType != "" {
+		f.AddChecksum(cksumType)
+	}
+	k := s.key(f.Attrs.Get("uuid"), f.Attrs.Get("checksumType"), f.Attrs.Get("checksum"))
+	if k == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at, ok := s.seen[k]
+	return ok && s.clock().Now().Sub(at) < s.Window
+}
+
+// Remember the Files of a POST which was accepted
+func (s *SuppressWindow) delivered(entries []TranscriptEntry) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen == nil {
+		s.seen = make(map[string]time.Time)
+	}
+	now := s.clock().Now()
+	for _, e := range entries {
+		if k := s.key(e.UUID, e.ChecksumType, e.Checksum); k != "" && e.Err == nil {
+			s.seen[k] = now
+		}
+	}
+
+	// Forget the Files which have fallen out of the window
+	if now.Sub(s.pruned) > s.Window {
+		for k, at := range s.seen {
+			if now.Sub(at) >= s.Window {
+				delete(s.seen, k)
+			}
+		}
+		s.pruned = now
+	}
+}
+
+// Len returns the number of Files remembered.
+func (s *SuppressWindow) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.seen)
+}
+
+func (s *SuppressWindow) clock() Clock {
+	return clockOr(s.Clock)
+}
@@ -0,0 +1,86 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/relvacode/iso8601"
+)
+
+// TimeFormat is the layout time attributes are written in by FormatTime, such
+// as the file.lastModifiedTime of Files read from disk.  Set it to
+// NiFiTimeFormat for the Files to match those listed by stock NiFi
+// processors.
+var TimeFormat = time.RFC3339
+
+// NiFiTimeFormat is the yyyy-MM-dd'T'HH:mm:ssZ form NiFi writes the file time
+// attributes in, such as from GetFile and ListFile.
+const NiFiTimeFormat = "2006-01-02T15:04:05-0700"
+
+// TimeLayouts are the layouts ParseTime tries, in order, after which the value
+// is tried as milliseconds since the epoch and then as any ISO 8601 time.  More
+// can be added for the forms used by other systems.  As with time.Parse, a zone
+// abbreviation other than UTC or the local zone is taken as UTC.
+var TimeLayouts = []string{
+	time.RFC3339Nano,
+	NiFiTimeFormat,
+	"Mon Jan 02 15:04:05 MST 2006", // Java Date.toString, as from NiFi's now()
+	"01/02/2006 15:04:05.000 MST",  // The NiFi user interface
+	"2006-01-02 15:04:05.000",      // NiFi record timestamps
+	"2006-01-02 15:04:05",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// ParseTime parses a time attribute written by this library, NiFi or another
+// tool, see TimeLayouts.  An epoch value too small to be in milliseconds, one
+// before 1973, is taken as seconds.
+func ParseTime(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, ErrorInvalidTime
+	}
+	for _, layout := range TimeLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if n > -1e11 && n < 1e11 {
+			return time.Unix(n, 0), nil
+		}
+		return time.UnixMilli(n), nil
+	}
+	if t, err := iso8601.ParseString(v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, ErrorInvalidTime
+}
+
+// FormatTime writes a time for an attribute in the TimeFormat.
+func FormatTime(t time.Time) string {
+	return t.Format(TimeFormat)
+}
+
+// FormatMillis writes a time for an attribute as milliseconds since the
+// epoch, as used by NiFi for attributes such as entryDate.
+func FormatMillis(t time.Time) string {
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// GetTime returns the time in an attribute, false when it is not set or cannot
+// be parsed, see ParseTime.
+func (h Attributes) GetTime(name string) (time.Time, bool) {
+	v, ok := h.lookup(name)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := ParseTime(v)
+	return t, err == nil
+}
+
+// SetTime sets an attribute to the time in the TimeFormat.
+func (h *Attributes) SetTime(name string, t time.Time) *Attributes {
+	return h.Set(name, FormatTime(t))
+}
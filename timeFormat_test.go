@@ -0,0 +1,57 @@
+package flowfile_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)
+	for _, v := range []string{
+		"2024-03-05T14:07:09Z",
+		"2024-03-05T16:07:09+02:00",
+		"2024-03-05T14:07:09+0000",
+		"Tue Mar 05 14:07:09 UTC 2024",
+		"03/05/2024 14:07:09.000 UTC",
+		"2024-03-05 14:07:09.000",
+		"2024-03-05 14:07:09",
+		"Tue, 05 Mar 2024 14:07:09 +0000",
+		" 1709647629000 ",
+		"1709647629",
+		"2024-03-05T14:07:09,000Z",
+	} {
+		got, err := flowfile.ParseTime(v)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("%q: expecting %v, got %v %v", v, want, got, err)
+		}
+	}
+	for _, v := range []string{"", " ", "yesterday", "2024-13-45"} {
+		if _, err := flowfile.ParseTime(v); !errors.Is(err, flowfile.ErrorInvalidTime) {
+			t.Errorf("%q: expecting ErrorInvalidTime, got %v", v, err)
+		}
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	tm := time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)
+	if s := flowfile.FormatMillis(tm); s != "1709647629000" {
+		t.Errorf("unexpected millis %q", s)
+	}
+
+	defer func(old string) { flowfile.TimeFormat = old }(flowfile.TimeFormat)
+	flowfile.TimeFormat = flowfile.NiFiTimeFormat
+	var h flowfile.Attributes
+	h.SetTime("file.lastModifiedTime", tm)
+	if s := h.Get("file.lastModifiedTime"); s != "2024-03-05T14:07:09+0000" {
+		t.Errorf("unexpected NiFi time %q", s)
+	}
+	if got, ok := h.GetTime("file.lastModifiedTime"); !ok || !got.Equal(tm) {
+		t.Errorf("expecting the time back, got %v", got)
+	}
+	if _, ok := h.GetTime("unset"); ok {
+		t.Errorf("expecting no time for an unset attribute")
+	}
+}
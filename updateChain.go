@@ -8,55 +8,221 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// The prefix of the custody chain attributes, each hop is recorded as
+// <prefix>.<hop>.<field>, with hop 0 being the most recent.  This is the
+// default for receivers without HTTPReceiver.CustodyChainPrefix set.
+var CustodyChainPrefix = "custodyChain"
+
+// When set, CustodyChainShift also records the attributes returned for the new
+// hop, such as a site name, node ID, software version, or classification
+// marking.  The names are relative to the hop, so a name of "site" is recorded
+// as custodyChain.0.site, and replace the time or local.hostname if given.
+// This is the default for receivers without HTTPReceiver.CustodyChainProvider
+// set.
+var CustodyChainProvider func() []Attribute
+
+// When above zero, CustodyChainShift keeps only this many of the most recent
+// hops so the attributes don't grow without bound across long relay chains.
+// The older hops are summarized by <prefix>.dropped.hops, the number of hops
+// dropped, and <prefix>.dropped.firstTime, the time of the oldest hop.  This
+// is the default for receivers without HTTPReceiver.CustodyChainMaxDepth set.
+var CustodyChainMaxDepth int
+
+// The settings a custody chain is recorded with
+type custodyChain struct {
+	prefix   string
+	provider func() []Attribute
+	maxDepth int
+	proxies  []*net.IPNet
+}
+
+// The custody chain settings of the package defaults
+func defaultCustodyChain() custodyChain {
+	return custodyChain{
+		prefix:   CustodyChainPrefix,
+		provider: CustodyChainProvider,
+		maxDepth: CustodyChainMaxDepth,
+		proxies:  TrustedProxies,
+	}
+}
+
+// The name of a custody chain attribute for a given hop and field
+func custodyKey(hop int, field string) string {
+	return defaultCustodyChain().key(hop, field)
+}
+
+func (c custodyChain) key(hop int, field string) string {
+	if field == "" {
+		return fmt.Sprintf("%s.%d", c.prefix, hop)
+	}
+	return fmt.Sprintf("%s.%d.%s", c.prefix, hop, field)
+}
+
+// Split a custody chain attribute name into the hop and field
+func (c custodyChain) hop(name string) (hop int, field string, ok bool) {
+	prefix := c.prefix + "."
+	if !strings.HasPrefix(name, prefix) {
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(name, prefix), ".", 2)
+	var err error
+	if hop, err = strconv.Atoi(parts[0]); err != nil || hop < 0 {
+		return 0, "", false
+	}
+	if len(parts) == 2 {
+		field = parts[1]
+	}
+	return hop, field, true
+}
+
 // Update the custodyChain field to increment all the values one and add an additional time and hostname.
 func (h *Attributes) CustodyChainShift() {
-	var updated []Attribute
+	defaultCustodyChain().shift(h, DefaultClock.Now())
+}
+
+func (c custodyChain) shift(h *Attributes, now time.Time) {
+	var (
+		updated    Attributes
+		prefix     = c.prefix + "."
+		dropped    = map[int]bool{}
+		oldestHop  = -1
+		oldestTime string
+	)
 
 	// Shift the current chain:
 	for _, kv := range []Attribute(*h) {
-		if strings.HasPrefix(kv.Name, "custodyChain.") {
-			parts := strings.SplitN(strings.TrimPrefix(kv.Name, "custodyChain."), ".", 2)
-			if v, err := strconv.Atoi(parts[0]); err == nil {
-				if len(parts) == 2 {
-					kv.Name = fmt.Sprintf("custodyChain.%d.%s", v+1, parts[1])
-				} else {
-					kv.Name = fmt.Sprintf("custodyChain.%d", v+1)
+		if hop, field, ok := c.hop(kv.Name); ok {
+			if c.maxDepth > 0 && hop+1 >= c.maxDepth {
+				// Too deep, summarize instead
+				dropped[hop] = true
+				if field == "time" && hop > oldestHop {
+					oldestHop, oldestTime = hop, kv.Value
 				}
-				updated = append(updated, kv)
+				continue
 			}
-		} else {
+			kv.Name = c.key(hop+1, field)
+			updated = append(updated, kv)
+		} else if !strings.HasPrefix(kv.Name, prefix) || strings.HasPrefix(kv.Name, prefix+"dropped.") {
 			updated = append(updated, kv)
 		}
 	}
+	if len(dropped) > 0 {
+		count, _ := strconv.Atoi(updated.Get(prefix + "dropped.hops"))
+		updated.Set(prefix+"dropped.hops", strconv.Itoa(count+len(dropped)))
+		if _, ok := updated.lookup(prefix + "dropped.firstTime"); !ok && oldestTime != "" {
+			updated.Set(prefix+"dropped.firstTime", oldestTime)
+		}
+	}
 
 	// Set the current chain link
-	updated = append(updated, Attribute{"custodyChain.0.time", time.Now().Format(time.RFC3339Nano)})
+	updated = append(updated, Attribute{c.key(0, "time"), now.Format(time.RFC3339Nano)})
 	if hn, err := os.Hostname(); err == nil {
-		updated = append(updated, Attribute{"custodyChain.0.local.hostname", hn})
+		updated = append(updated, Attribute{c.key(0, "local.hostname"), hn})
+	}
+	if c.provider != nil {
+		for _, kv := range c.provider() {
+			updated.Set(c.key(0, kv.Name), kv.Value)
+		}
 	}
-	*h = Attributes(updated)
+	*h = updated
 }
 
 func (h *Attributes) CustodyChainAddListen(listen string) {
+	defaultCustodyChain().addListen(h, listen)
+}
+
+func (c custodyChain) addListen(h *Attributes, listen string) {
 	if listen != "" {
 		if host, port, err := net.SplitHostPort(listen); err == nil {
 			updated := []Attribute(*h)
 			if host != "" {
-				updated = append(updated, Attribute{"custodyChain.0.local.host", host})
+				updated = append(updated, Attribute{c.key(0, "local.host"), host})
 			}
-			updated = append(updated, Attribute{"custodyChain.0.local.port", port})
+			updated = append(updated, Attribute{c.key(0, "local.port"), port})
 			*h = Attributes(updated)
 		}
 	}
 }
 
+// Reverse proxies whose X-Forwarded-For and X-Real-IP headers are trusted by
+// CustodyChainAddHTTP, so the source.host records the true client instead of
+// the proxy, which is then recorded as the proxy.host.  Add to the list with
+// TrustProxies.  This is the default for receivers without
+// HTTPReceiver.TrustedProxies set.
+var TrustedProxies []*net.IPNet
+
+// TrustProxies adds the given addresses or CIDR ranges to the TrustedProxies.
+func TrustProxies(cidrs ...string) (err error) {
+	TrustedProxies, err = appendProxies(TrustedProxies, cidrs...)
+	return
+}
+
+// Parse the addresses or CIDR ranges onto the list of proxies
+func appendProxies(proxies []*net.IPNet, cidrs ...string) ([]*net.IPNet, error) {
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return proxies, err
+		}
+		proxies = append(proxies, n)
+	}
+	return proxies, nil
+}
+
+func (c custodyChain) isTrustedProxy(host string) bool {
+	ip := net.ParseIP(strings.TrimSpace(host))
+	if ip == nil {
+		return false
+	}
+	for _, n := range c.proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Find the client behind a trusted proxy, walking the X-Forwarded-For chain
+// from the nearest hop until an address which is not a trusted proxy is found.
+func (c custodyChain) forwardedClient(remote string, hdr http.Header) string {
+	if !c.isTrustedProxy(remote) {
+		return ""
+	}
+	var hops []string
+	for _, v := range hdr.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !c.isTrustedProxy(hops[i]) || i == 0 {
+			return hops[i]
+		}
+	}
+	return strings.TrimSpace(hdr.Get("X-Real-IP"))
+}
+
 // Add attributes related to an http request, such as remote host, request URI, and TLS details.
 func (h *Attributes) CustodyChainAddHTTP(r *http.Request) {
+	defaultCustodyChain().addHTTP(h, r)
+}
+
+func (c custodyChain) addHTTP(h *Attributes, r *http.Request) {
 	updated := []Attribute(*h)
 	var cert *x509.Certificate
 	if r.TLS != nil {
@@ -65,23 +231,34 @@ func (h *Attributes) CustodyChainAddHTTP(r *http.Request) {
 		}
 	}
 	if cert != nil {
-		updated = append(updated, Attribute{"custodyChain.0.user.dn", certPKIXString(cert.Subject, ",")})
-		updated = append(updated, Attribute{"custodyChain.0.issuer.dn", certPKIXString(cert.Issuer, ",")})
+		updated = append(updated, Attribute{c.key(0, "user.dn"), certPKIXString(cert.Subject, ",")})
+		updated = append(updated, Attribute{c.key(0, "issuer.dn"), certPKIXString(cert.Issuer, ",")})
 	}
 
 	if r.RequestURI != "" {
-		updated = append(updated, Attribute{"custodyChain.0.request.uri", r.RequestURI})
+		updated = append(updated, Attribute{c.key(0, "request.uri"), r.RequestURI})
+	}
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host, port = r.RemoteAddr, ""
 	}
-	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
-		updated = append(updated, Attribute{"custodyChain.0.source.host", host})
-		updated = append(updated, Attribute{"custodyChain.0.source.port", port})
+	if client := c.forwardedClient(host, r.Header); client != "" {
+		// The connection is from a trusted proxy, record the true client
+		updated = append(updated, Attribute{c.key(0, "source.host"), client})
+		updated = append(updated, Attribute{c.key(0, "proxy.host"), host})
+		if port != "" {
+			updated = append(updated, Attribute{c.key(0, "proxy.port"), port})
+		}
 	} else {
-		updated = append(updated, Attribute{"custodyChain.0.source.host", r.RemoteAddr})
+		updated = append(updated, Attribute{c.key(0, "source.host"), host})
+		if port != "" {
+			updated = append(updated, Attribute{c.key(0, "source.port"), port})
+		}
 	}
 	if r.TLS != nil {
-		updated = append(updated, Attribute{"custodyChain.0.protocol", "HTTPS"})
-		updated = append(updated, Attribute{"custodyChain.0.tls.cipher", tls.CipherSuiteName(r.TLS.CipherSuite)})
-		updated = append(updated, Attribute{"custodyChain.0.tls.host", r.TLS.ServerName})
+		updated = append(updated, Attribute{c.key(0, "protocol"), "HTTPS"})
+		updated = append(updated, Attribute{c.key(0, "tls.cipher"), tls.CipherSuiteName(r.TLS.CipherSuite)})
+		updated = append(updated, Attribute{c.key(0, "tls.host"), r.TLS.ServerName})
 		var v string
 		switch r.TLS.Version {
 		case tls.VersionTLS10:
@@ -95,9 +272,9 @@ func (h *Attributes) CustodyChainAddHTTP(r *http.Request) {
 		default:
 			v = fmt.Sprintf("0x%02x", r.TLS.Version)
 		}
-		updated = append(updated, Attribute{"custodyChain.0.tls.version", v})
+		updated = append(updated, Attribute{c.key(0, "tls.version"), v})
 	} else {
-		updated = append(updated, Attribute{"custodyChain.0.protocol", "HTTP"})
+		updated = append(updated, Attribute{c.key(0, "protocol"), "HTTP"})
 	}
 	*h = updated
 }
@@ -113,3 +290,80 @@ func certPKIXString(name pkix.Name, sep string) (out string) {
 	}
 	return
 }
+
+// A CustodyEvent is one hop of the custody chain, as recorded by
+// CustodyChainShift, CustodyChainAddListen and CustodyChainAddHTTP.
+type CustodyEvent struct {
+	Hop      int       // 0 is the most recent hop
+	Time     time.Time // zero if missing or unparsable
+	Hostname string    // local.hostname
+
+	LocalHost, LocalPort   string
+	SourceHost, SourcePort string
+	ProxyHost, ProxyPort   string // set when received through a trusted proxy
+	RequestURI             string
+	Protocol               string // HTTP or HTTPS
+	UserDN, IssuerDN       string // from the client certificate
+	TLSCipher, TLSHost     string
+	TLSVersion             string
+
+	// All the fields of the hop by name, including any added by the
+	// CustodyChainProvider
+	Fields map[string]string
+}
+
+// ParseCustodyChain returns the hops recorded in the custody chain attributes,
+// ordered from the most recent.
+func ParseCustodyChain(h Attributes) (events []CustodyEvent) {
+	chain := defaultCustodyChain()
+	byHop := map[int]*CustodyEvent{}
+	for _, kv := range h {
+		hop, field, ok := chain.hop(kv.Name)
+		if !ok || field == "" {
+			continue
+		}
+		e, ok := byHop[hop]
+		if !ok {
+			e = &CustodyEvent{Hop: hop, Fields: map[string]string{}}
+			byHop[hop] = e
+		}
+		e.Fields[field] = kv.Value
+		switch field {
+		case "time":
+			e.Time, _ = time.Parse(time.RFC3339Nano, kv.Value)
+		case "local.hostname":
+			e.Hostname = kv.Value
+		case "local.host":
+			e.LocalHost = kv.Value
+		case "local.port":
+			e.LocalPort = kv.Value
+		case "source.host":
+			e.SourceHost = kv.Value
+		case "source.port":
+			e.SourcePort = kv.Value
+		case "proxy.host":
+			e.ProxyHost = kv.Value
+		case "proxy.port":
+			e.ProxyPort = kv.Value
+		case "request.uri":
+			e.RequestURI = kv.Value
+		case "protocol":
+			e.Protocol = kv.Value
+		case "user.dn":
+			e.UserDN = kv.Value
+		case "issuer.dn":
+			e.IssuerDN = kv.Value
+		case "tls.cipher":
+			e.TLSCipher = kv.Value
+		case "tls.host":
+			e.TLSHost = kv.Value
+		case "tls.version":
+			e.TLSVersion = kv.Value
+		}
+	}
+	for _, e := range byHop {
+		events = append(events, *e)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Hop < events[j].Hop })
+	return
+}
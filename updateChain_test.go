@@ -0,0 +1,128 @@
+package flowfile_test
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pschou/go-flowfile"
+)
+
+func TestCustodyChainAddHTTPProxy(t *testing.T) {
+	defer func(old []*net.IPNet) { flowfile.TrustedProxies = old }(flowfile.TrustedProxies)
+	flowfile.TrustedProxies = nil
+	if err := flowfile.TrustProxies("10.0.0.0/8", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := flowfile.TrustProxies("10.0.0.0/33"); err == nil {
+		t.Errorf("expecting a bad CIDR refused")
+	}
+
+	for _, tc := range []struct {
+		name, remote, xff, realIP string
+		source, proxy             string
+	}{
+		{"direct", "198.51.100.7:5000", "", "", "198.51.100.7", ""},
+		{"untrusted proxy", "198.51.100.7:5000", "203.0.113.9", "", "198.51.100.7", ""},
+		{"trusted proxy", "192.0.2.1:5000", "203.0.113.9", "", "203.0.113.9", "192.0.2.1"},
+		{"proxy chain", "192.0.2.1:5000", "6.6.6.6, 203.0.113.9, 10.1.2.3", "", "203.0.113.9", "192.0.2.1"},
+		{"only proxies", "10.0.0.5:5000", "10.1.2.3, 10.4.5.6", "", "10.1.2.3", "10.0.0.5"},
+		{"real ip", "10.0.0.5:5000", "", "203.0.113.9", "203.0.113.9", "10.0.0.5"},
+	} {
+		r := httptest.NewRequest("POST", "/contentListener", nil)
+		r.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		var a flowfile.Attributes
+		a.CustodyChainAddHTTP(r)
+		ev := flowfile.ParseCustodyChain(a)
+		if len(ev) != 1 {
+			t.Fatalf("%s: expecting one hop, got %v", tc.name, a)
+		}
+		if ev[0].SourceHost != tc.source || ev[0].ProxyHost != tc.proxy {
+			t.Errorf("%s: expecting source %q and proxy %q, got %q and %q", tc.name,
+				tc.source, tc.proxy, ev[0].SourceHost, ev[0].ProxyHost)
+		}
+		if tc.proxy == "" && (ev[0].SourcePort != "5000" || ev[0].ProxyPort != "") {
+			t.Errorf("%s: expecting the source port, got %+v", tc.name, ev[0])
+		} else if tc.proxy != "" && (ev[0].ProxyPort != "5000" || ev[0].SourcePort != "") {
+			t.Errorf("%s: expecting the proxy port, got %+v", tc.name, ev[0])
+		}
+	}
+}
+
+func TestCustodyChainMaxDepth(t *testing.T) {
+	defer func(old int) { flowfile.CustodyChainMaxDepth = old }(flowfile.CustodyChainMaxDepth)
+	flowfile.CustodyChainMaxDepth = 3
+
+	var a flowfile.Attributes
+	a.Set("filename", "abc.txt")
+	times := []string{"t0", "t1", "t2", "t3", "t4"}
+	for i, tm := range times {
+		a.CustodyChainShift()
+		a.Set("custodyChain.0.time", tm)
+		want := i + 1
+		if want > 3 {
+			want = 3
+		}
+		if hops := len(flowfile.ParseCustodyChain(a)); hops != want {
+			t.Fatalf("after %d shifts, expecting %d hops, got %d", i+1, want, hops)
+		}
+	}
+	for name, want := range map[string]string{
+		"custodyChain.0.time":            "t4",
+		"custodyChain.2.time":            "t2",
+		"custodyChain.3.time":            "",
+		"custodyChain.dropped.hops":      "2",
+		"custodyChain.dropped.firstTime": "t0",
+		"filename":                       "abc.txt",
+	} {
+		if got := a.Get(name); got != want {
+			t.Errorf("expecting %s of %q, got %q", name, want, got)
+		}
+	}
+
+	// Without a limit the chain grows
+	flowfile.CustodyChainMaxDepth = 0
+	a.CustodyChainShift()
+	if hops := len(flowfile.ParseCustodyChain(a)); hops != 4 || a.Get("custodyChain.dropped.hops") != "2" {
+		t.Errorf("expecting 4 hops without a limit, got %d", hops)
+	}
+}
+
+func TestCustodyChainPrefixProvider(t *testing.T) {
+	defer func(prefix string, provider func() []flowfile.Attribute) {
+		flowfile.CustodyChainPrefix, flowfile.CustodyChainProvider = prefix, provider
+	}(flowfile.CustodyChainPrefix, flowfile.CustodyChainProvider)
+	flowfile.CustodyChainPrefix = "chain"
+	flowfile.CustodyChainProvider = func() []flowfile.Attribute {
+		return []flowfile.Attribute{{Name: "site", Value: "east"}, {Name: "local.hostname", Value: "relay1"}}
+	}
+
+	var a flowfile.Attributes
+	a.Set("chain.0.site", "west")
+	a.Set("custodyChain.0.time", "other") // Not of this prefix
+	a.CustodyChainShift()
+	a.CustodyChainAddListen("10.0.0.1:8080")
+
+	for name, want := range map[string]string{
+		"chain.0.site":           "east",
+		"chain.0.local.hostname": "relay1",
+		"chain.0.local.port":     "8080",
+		"chain.1.site":           "west",
+		"custodyChain.0.time":    "other",
+		"custodyChain.1.time":    "",
+	} {
+		if got := a.Get(name); got != want {
+			t.Errorf("expecting %s of %q, got %q", name, want, got)
+		}
+	}
+	ev := flowfile.ParseCustodyChain(a)
+	if len(ev) != 2 || ev[0].Fields["site"] != "east" || ev[0].Hostname != "relay1" || ev[0].Time.IsZero() {
+		t.Errorf("expecting the hops under the prefix, got %+v", ev)
+	}
+}
@@ -0,0 +1,133 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// A WritableFS is a filesystem which Files can be saved into, such as the OS
+// filesystem rooted at a directory (OSFS) or an in-memory filesystem for tests
+// (MemFS).  Names are slash separated, as with io/fs.
+type WritableFS interface {
+	OpenFile(name string, flag int, perm os.FileMode) (WritableFile, error)
+	Mkdir(name string, perm os.FileMode) error
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Rename(oldname, newname string) error
+	Remove(name string) error
+	Symlink(oldname, newname string) error
+}
+
+// A WritableFS which can make hard links, such as the OS filesystem and MemFS
+type linkFS interface {
+	Link(oldname, newname string) error
+}
+
+// A WritableFS which has owners, such as the OS filesystem
+type chownFS interface {
+	Lchown(name string, uid, gid int) error
+}
+
+// A WritableFile is an open file within a WritableFS.
+type WritableFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Writer
+	io.WriterAt
+	io.Closer
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+}
+
+// OSFS returns a WritableFS for the OS filesystem with all the names placed
+// below the root directory, names cannot lead out of the root.  An empty root
+// uses the names as given, relative to the working directory.
+func OSFS(root string) WritableFS {
+	return osFS{root: root}
+}
+
+type osFS struct {
+	root string
+}
+
+func (o osFS) path(name string) string {
+	if o.root == "" {
+		return filepath.FromSlash(name)
+	}
+	return filepath.Join(o.root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (o osFS) OpenFile(name string, flag int, perm os.FileMode) (WritableFile, error) {
+	return os.OpenFile(o.path(name), flag, perm)
+}
+func (o osFS) Mkdir(name string, perm os.FileMode) error { return os.Mkdir(o.path(name), perm) }
+func (o osFS) Stat(name string) (os.FileInfo, error)     { return os.Stat(o.path(name)) }
+func (o osFS) Lstat(name string) (os.FileInfo, error)    { return os.Lstat(o.path(name)) }
+func (o osFS) Chmod(name string, mode os.FileMode) error { return os.Chmod(o.path(name), mode) }
+func (o osFS) Remove(name string) error                  { return os.Remove(o.path(name)) }
+func (o osFS) Rename(oldname, newname string) error {
+	return os.Rename(o.path(oldname), o.path(newname))
+}
+func (o osFS) Symlink(oldname, newname string) error { return os.Symlink(oldname, o.path(newname)) }
+func (o osFS) Lchown(name string, uid, gid int) error {
+	return os.Lchown(o.path(name), uid, gid)
+}
+func (o osFS) Link(oldname, newname string) error {
+	return os.Link(o.path(oldname), o.path(newname))
+}
+func (o osFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(o.path(name), atime, mtime)
+}
+
+// The name as the filesystem resolves it, so names given relative and absolute
+// can be compared
+func fsAbs(fsys WritableFS, name string) string {
+	if o, ok := fsys.(osFS); ok && o.root == "" {
+		if abs, err := filepath.Abs(name); err == nil {
+			return abs
+		}
+		return name
+	}
+	return path.Clean("/" + name)
+}
+
+// Write a whole file into a WritableFS
+func writeFileFS(fsys WritableFS, name string, data []byte, perm os.FileMode) error {
+	fh, err := fsys.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(data)
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Create a directory along with any missing parents
+func mkdirAllFS(fsys WritableFS, dir string, perm os.FileMode) error {
+	if fi, err := fsys.Stat(dir); err == nil {
+		if !fi.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+		}
+		return nil
+	}
+	if parent := path.Dir(dir); parent != dir {
+		if err := mkdirAllFS(fsys, parent, perm); err != nil {
+			return err
+		}
+	}
+	if err := fsys.Mkdir(dir, perm); err != nil {
+		if fi, statErr := fsys.Stat(dir); statErr == nil && fi.IsDir() {
+			return nil // Created by someone else in the mean time
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package flowfile // import "github.com/pschou/go-flowfile"
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func init() {
+	RegisterCodec(NewZstdCodec(0, nil))
+}
+
+// NewZstdCodec creates a zstd Codec compressing at the level, from 1 to 22 as
+// with the zstd command line tool, or the default level when 0.  When a
+// dictionary is given, such as one made with "zstd --train", it is used for
+// both compressing and decompressing, so both ends must register the codec
+// with the same dictionary.
+//
+//   dict, _ := os.ReadFile("records.dict")
+//   flowfile.RegisterCodec(flowfile.NewZstdCodec(9, dict))
+func NewZstdCodec(level int, dict []byte) Codec {
+	wOpts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if level > 0 {
+		wOpts = append(wOpts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	rOpts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	if dict != nil {
+		wOpts = append(wOpts, zstd.WithEncoderDict(dict))
+		rOpts = append(rOpts, zstd.WithDecoderDicts(dict))
+	}
+	return Codec{
+		Name: "zstd",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, wOpts...)
+		},
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			d, err := zstd.NewReader(r, rOpts...)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+		MimeType:  "application/zstd",
+		Extension: ".zst",
+	}
+}